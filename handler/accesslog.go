@@ -0,0 +1,58 @@
+package handler
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	// FormatCLF outputs the transactions as the lines in the Common Log Format of Apache.
+	FormatCLF = "clf"
+	// FormatCombined outputs the transactions as the lines in the Combined Log Format of Apache.
+	FormatCombined = "combined"
+)
+
+// clfTimeLayout is the layout of the timestamp in the Common Log Format, like 10/Oct/2000:13:55:36 -0700.
+const clfTimeLayout = "02/Jan/2006:15:04:05 -0700"
+
+// AccessLogLine renders the transaction as a line in the Common Log Format,
+// with the referer and user agent appended in the Combined Log Format of Option.Format.
+// The client is the address of the client, size is the bytes of the response body.
+func (o *Option) AccessLogLine(t *Transaction, client string, size int64) string {
+	if host, _, err := net.SplitHostPort(client); err == nil {
+		client = host
+	}
+
+	user := "-"
+	if name, _, ok := (&http.Request{Header: t.ReqHeader}).BasicAuth(); ok && name != "" {
+		user = name
+	}
+
+	bytes := "-"
+	if size > 0 {
+		bytes = strconv.FormatInt(size, 10)
+	}
+
+	start := o.DisplayTime(t.Start)
+	if o.UTC {
+		start = start.UTC()
+	}
+
+	line := fmt.Sprintf("%s - %s [%s] %s %d %s", client, user, start.Format(clfTimeLayout),
+		clfQuote(t.Method+" "+t.URI+" "+t.Proto), t.Status, bytes)
+	if o.Format == FormatCombined {
+		line += " " + clfQuote(t.ReqHeader.Get("Referer")) + " " + clfQuote(t.ReqHeader.Get("User-Agent"))
+	}
+	return line
+}
+
+// clfQuote quotes the field, escaping the quotes and backslashes in it, or "-" if empty.
+func clfQuote(s string) string {
+	if s == "" {
+		return `"-"`
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
@@ -0,0 +1,35 @@
+package handler
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccessLogLine(t *testing.T) {
+	start := time.Date(2000, 10, 10, 13, 55, 36, 0, time.FixedZone("", -7*3600))
+	tx := &Transaction{Method: "GET", URI: "/apache_pb.gif", Proto: "HTTP/1.0", Status: 200, Start: start, ReqHeader: http.Header{
+		"Authorization": {"Basic ZnJhbms6cGFzcw=="}, // frank:pass
+		"Referer":       {"http://www.example.com/start.html"},
+		"User-Agent":    {`Mozilla/4.08 [en] (Win98; I ;Nav) "quoted"`},
+	}}
+
+	o := &Option{Format: FormatCLF}
+	assert.Equal(t, `127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2326`,
+		o.AccessLogLine(tx, "127.0.0.1:5000", 2326))
+
+	o = &Option{Format: FormatCombined, UTC: true}
+	assert.Equal(t, `127.0.0.1 - frank [10/Oct/2000:20:55:36 +0000] "GET /apache_pb.gif HTTP/1.0" 200 - `+
+		`"http://www.example.com/start.html" "Mozilla/4.08 [en] (Win98; I ;Nav) \"quoted\""`,
+		o.AccessLogLine(tx, "127.0.0.1:5000", 0))
+}
+
+func TestAccessLogStd(t *testing.T) {
+	c := newTestConn(&Option{SrcRatio: 1, Resp: 1, Format: FormatCLF, UTC: true})
+	c.requests("GET /a?b=1 HTTP/1.1\r\nHost: a.b\r\n\r\n")
+	c.responses("HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok")
+
+	assert.Regexp(t, `^127\.0\.0\.1 - - \[[^]]+ \+0000\] "GET /a\?b=1 HTTP/1\.1" 200 2\n$`, c.output())
+}
@@ -0,0 +1,34 @@
+package handler
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// The algorithms of Option.BodyHash.
+const (
+	BodyHashMD5    = "md5"
+	BodyHashSHA256 = "sha256"
+)
+
+// bodyHash returns the hash of the body as on the wire, decoded from chunks but not decompressed,
+// like sha256:<hex> by the algorithm, empty if no algorithm.
+func bodyHash(algorithm string, body []byte) string {
+	switch algorithm {
+	case BodyHashMD5:
+		sum := md5.Sum(body)
+		return algorithm + ":" + hex.EncodeToString(sum[:])
+	case BodyHashSHA256:
+		sum := sha256.Sum256(body)
+		return algorithm + ":" + hex.EncodeToString(sum[:])
+	}
+	return ""
+}
+
+func bodyHashField(hash string) string {
+	if hash == "" {
+		return ""
+	}
+	return " " + hash
+}
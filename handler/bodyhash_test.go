@@ -0,0 +1,21 @@
+package handler
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBodyHash(t *testing.T) {
+	assert.Equal(t, "md5:5d41402abc4b2a76b9719d911017c592", bodyHash(BodyHashMD5, []byte("hello")))
+	assert.Equal(t, "sha256:2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824",
+		bodyHash(BodyHashSHA256, []byte("hello")))
+	assert.Equal(t, "", bodyHash("", []byte("hello")))
+
+	c := newTestConn(&Option{SrcRatio: 1, BodyHash: BodyHashMD5})
+	c.requests("POST /a HTTP/1.1\r\nHost: a.b\r\nContent-Type: text/plain\r\nContent-Length: 5\r\n\r\nhello")
+
+	out := c.output()
+	assert.Contains(t, out, " md5:5d41402abc4b2a76b9719d911017c592\r\n")
+	assert.Contains(t, out, "\r\n\r\nhello")
+}
@@ -0,0 +1,102 @@
+package handler
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+
+	"github.com/bingoohuang/httpdump/util"
+)
+
+// bodyMatchMax is the max bytes of a body buffered in memory to be matched by a BodyMatcher,
+// the bytes after it are not matched.
+const bodyMatchMax = 1 << 20
+
+// BodyMatcher matches the http body by a substring, or a regexp.
+// A nil *BodyMatcher matches all.
+type BodyMatcher struct {
+	sub []byte
+	re  *regexp.Regexp
+}
+
+// NewBodyMatcher creates a BodyMatcher of the pattern, nil if the pattern is empty.
+func NewBodyMatcher(pattern string, isRegex bool) (*BodyMatcher, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+	if !isRegex {
+		return &BodyMatcher{sub: []byte(pattern)}, nil
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid body pattern %s: %w", pattern, err)
+	}
+	return &BodyMatcher{re: re}, nil
+}
+
+// Match tells if the body, decompressed by the Content-Encoding in the header, matches.
+func (m *BodyMatcher) Match(header http.Header, body []byte) bool {
+	if m == nil {
+		return true
+	}
+
+	if nr, ok := util.TryDecompress(header.Clone(), io.NopCloser(bytes.NewReader(body))); ok {
+		// the truncated body decompresses partially
+		body, _ = io.ReadAll(nr)
+	}
+	if m.re != nil {
+		return m.re.Match(body)
+	}
+	return bytes.Contains(body, m.sub)
+}
+
+// String returns the substring or the regexp to match.
+func (m *BodyMatcher) String() string {
+	if m.re != nil {
+		return m.re.String()
+	}
+	return string(m.sub)
+}
+
+type peekedReq struct {
+	Req
+	body io.ReadCloser
+}
+
+func (r *peekedReq) GetBody() io.ReadCloser { return r.body }
+func (r *peekedReq) WireBodySize() int64    { return wireBodySizeOf(r.Req) }
+
+func (r *peekedReq) StreamOffsets() (start, end int64) { return streamOffsetsOf(r.Req) }
+
+type peekedRsp struct {
+	Rsp
+	body io.ReadCloser
+}
+
+func (r *peekedRsp) GetBody() io.ReadCloser { return r.body }
+func (r *peekedRsp) WireBodySize() int64    { return wireBodySizeOf(r.Rsp) }
+
+func (r *peekedRsp) StreamOffsets() (start, end int64) { return streamOffsetsOf(r.Rsp) }
+
+// peekReqBody reads at most n bytes of the request body, and returns the request to read the whole body again.
+func peekReqBody(r Req, n int64) (Req, []byte) {
+	body, rest := peekBody(r.GetBody(), n)
+	return &peekedReq{Req: r, body: rest}, body
+}
+
+// peekRspBody reads at most n bytes of the response body, and returns the response to read the whole body again.
+func peekRspBody(r Rsp, n int64) (Rsp, []byte) {
+	body, rest := peekBody(r.GetBody(), n)
+	return &peekedRsp{Rsp: r, body: rest}, body
+}
+
+func peekBody(body io.ReadCloser, n int64) ([]byte, io.ReadCloser) {
+	peeked, _ := io.ReadAll(io.LimitReader(body, n))
+	return peeked, struct {
+		io.Reader
+		io.Closer
+	}{Reader: io.MultiReader(bytes.NewReader(peeked), body), Closer: body}
+}
@@ -0,0 +1,54 @@
+package handler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBodyMatcher(t *testing.T) {
+	m, err := NewBodyMatcher("order_id=42", false)
+	assert.Nil(t, err)
+	assert.True(t, m.Match(http.Header{}, []byte("a=1&order_id=42")))
+	assert.False(t, m.Match(http.Header{}, []byte("a=1&order_id=43")))
+
+	gz := &bytes.Buffer{}
+	w := gzip.NewWriter(gz)
+	_, _ = w.Write([]byte(`{"order_id":42}`))
+	_ = w.Close()
+	m, err = NewBodyMatcher(`"order_id":\s*42\b`, true)
+	assert.Nil(t, err)
+	assert.True(t, m.Match(http.Header{"Content-Encoding": {"gzip"}}, gz.Bytes()))
+
+	_, err = NewBodyMatcher("[", true)
+	assert.NotNil(t, err)
+
+	m, _ = NewBodyMatcher("", false)
+	assert.True(t, m.Match(http.Header{}, nil))
+}
+
+func TestPeekBody(t *testing.T) {
+	peeked, rest := peekBody(io.NopCloser(strings.NewReader("0123456789")), 4)
+	assert.Equal(t, "0123", string(peeked))
+	all, _ := io.ReadAll(rest)
+	assert.Equal(t, "0123456789", string(all))
+}
+
+func TestReqBodyMatcherStd(t *testing.T) {
+	m, _ := NewBodyMatcher("order_id=42", false)
+	c := newTestConn(&Option{SrcRatio: 1, Resp: 1, ReqBodyMatcher: m})
+	c.requests("POST /a HTTP/1.1\r\nHost: a.b\r\nContent-Length: 10\r\n\r\norder_id=1" +
+		"POST /b HTTP/1.1\r\nHost: a.b\r\nContent-Length: 11\r\n\r\norder_id=42")
+	c.responses("HTTP/1.1 400 Bad Request\r\nContent-Length: 0\r\n\r\nHTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n")
+
+	out := c.output()
+	assert.NotContains(t, out, "POST /a ")
+	assert.NotContains(t, out, "400 Bad Request") // the response of the request filtered out
+	assert.Contains(t, out, "POST /b ")
+	assert.Contains(t, out, "200 OK")
+}
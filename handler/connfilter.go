@@ -0,0 +1,104 @@
+package handler
+
+import "sync"
+
+// connFilter holds the output and the records of the connections in std mode, like the transactions handled
+// and the stats, until a connection is known to carry at least Option.MinRequestsPerConnection requests,
+// or drops them when the connection finishes with fewer.
+type connFilter struct {
+	sync.Mutex
+	min   int32
+	conns map[string]*heldConn // by the connection key in either direction
+}
+
+// heldConn is the output and the records held for a connection, shared by the streams of both directions.
+type heldConn struct {
+	sync.Mutex
+	held    []func()
+	passed  bool
+	dropped bool
+	streams int // the streams not finished yet, guarded by the connFilter
+}
+
+func newConnFilter(min int) *connFilter {
+	return &connFilter{min: int32(min), conns: map[string]*heldConn{}}
+}
+
+// sender returns the sender holding the output of the stream of the connection id.
+func (f *connFilter) sender(id string, sender Sender) *heldSender {
+	f.Lock()
+	defer f.Unlock()
+
+	c := f.conns[id]
+	if c == nil {
+		c = &heldConn{}
+		f.conns[id] = c
+	}
+	c.streams++
+	return &heldSender{Sender: sender, filter: f, id: id, conn: c}
+}
+
+// heldSender holds the messages and the records until the connection passes the filter.
+type heldSender struct {
+	Sender
+	filter *connFilter
+	id     string
+	conn   *heldConn
+
+	// requests returns the number of the requests parsed on the stream so far.
+	requests func() int32
+}
+
+func (s *heldSender) Send(msg string, countDiscards bool) {
+	s.hold(func() { s.Sender.Send(msg, countDiscards) })
+}
+
+// hold runs fn once the connection passes the filter, in the order held, or never if it is dropped.
+func (s *heldSender) hold(fn func()) {
+	c := s.conn
+	c.Lock()
+	defer c.Unlock()
+
+	switch {
+	case c.dropped:
+	case c.passed:
+		fn()
+	case s.requests != nil && s.requests() >= s.filter.min:
+		c.passed = true
+		for _, held := range c.held {
+			held()
+		}
+		c.held = nil
+		fn()
+	default:
+		c.held = append(c.held, fn)
+	}
+}
+
+// record runs fn to record the connection, like into the transaction handlers or the stats,
+// held by the filter of Option.MinRequestsPerConnection if any.
+func (h *Base) record(fn func()) {
+	if s, ok := h.sender.(*heldSender); ok {
+		s.hold(fn)
+		return
+	}
+	fn()
+}
+
+// finish finishes the stream, the connection is dropped if the request stream finishes before passing.
+func (s *heldSender) finish(isRequest bool) {
+	c := s.conn
+	c.Lock()
+	if isRequest && !c.passed {
+		c.dropped = true
+		c.held = nil
+	}
+	c.Unlock()
+
+	s.filter.Lock()
+	defer s.filter.Unlock()
+
+	if c.streams--; c.streams == 0 && s.filter.conns[s.id] == c {
+		delete(s.filter.conns, s.id)
+	}
+}
@@ -0,0 +1,79 @@
+package handler
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/tcpassembly"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnFilter(t *testing.T) {
+	out := &collectSender{}
+	f := newConnFilter(2)
+
+	// a single-shot connection is dropped
+	var requests int32
+	req, rsp := f.sender("a-b", out), f.sender("a-b", out)
+	req.requests = func() int32 { return requests }
+	requests = 1
+	req.Send("req1", true)
+	rsp.Send("rsp1", true)
+	rsp.finish(false)
+	req.finish(true)
+	assert.Empty(t, out.msgs)
+	assert.Empty(t, f.conns)
+
+	// the held output is flushed once the second request comes
+	req, rsp = f.sender("a-c", out), f.sender("a-c", out)
+	req.requests = func() int32 { return requests }
+	requests = 1
+	req.Send("req1", true)
+	rsp.Send("rsp1", true)
+	requests = 2
+	req.Send("req2", true)
+	rsp.Send("rsp2", true)
+	req.finish(true)
+	rsp.finish(false)
+	assert.Equal(t, []string{"req1", "rsp1", "req2", "rsp2"}, out.msgs)
+	assert.Empty(t, f.conns)
+}
+
+func TestConnFilterRecords(t *testing.T) {
+	r := &recordTransactions{}
+	stats := NewStats(nil)
+	option := &Option{SrcRatio: 1, Resp: 1, MinRequestsPerConnection: 2, Stats: stats,
+		TransactionHandlers: []TransactionHandler{r, stats}}
+	f := NewFactory(context.Background(), option, &collectSender{})
+	a := &TcpStdAssembler{Assembler: tcpassembly.NewAssembler(tcpassembly.NewStreamPool(f)), Factory: f}
+	start := time.Now()
+	for client, paths := range map[byte][]string{1: {"/single"}, 3: {"/a", "/b"}} {
+		flow := gopacket.NewFlow(layers.EndpointIPv4, net.IPv4(127, 0, 0, client).To4(), net.IPv4(127, 0, 0, 2).To4())
+		req := strings.Repeat("GET /x HTTP/1.1\r\nHost: a.b\r\n\r\n", len(paths))
+		for _, p := range paths {
+			req = strings.Replace(req, "/x", p, 1)
+		}
+		rsp := strings.Repeat("HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok", len(paths))
+		a.Assemble(flow, &layers.TCP{SrcPort: 5000, DstPort: 8080, SYN: true, Seq: 0}, start)
+		a.Assemble(flow.Reverse(), &layers.TCP{SrcPort: 8080, DstPort: 5000, SYN: true, ACK: true, Seq: 0, Ack: 1}, start)
+		a.Assemble(flow, &layers.TCP{SrcPort: 5000, DstPort: 8080, Seq: 1, BaseLayer: layers.BaseLayer{Payload: []byte(req)}}, start)
+		a.Assemble(flow.Reverse(), &layers.TCP{SrcPort: 8080, DstPort: 5000, Seq: 1,
+			BaseLayer: layers.BaseLayer{Payload: []byte(rsp)}}, start.Add(time.Millisecond))
+	}
+	a.FinishAll()
+	assert.Eventually(t, func() bool { return a.PendingConnections() == 0 }, time.Second, time.Millisecond)
+
+	var uris []string
+	for _, tr := range r.transactions() {
+		uris = append(uris, tr.URI)
+	}
+	assert.Equal(t, []string{"/a", "/b"}, uris) // the single-shot connection is dropped
+	summary := stats.Summary()
+	assert.Contains(t, summary, "Connections: 1, Requests: 2\n")
+	assert.Contains(t, summary, "Requests per connection min: 2, avg: 2.00, max: 2\n")
+}
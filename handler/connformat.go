@@ -0,0 +1,33 @@
+package handler
+
+import (
+	"fmt"
+	"hash/fnv"
+)
+
+// The formats of the connections in the output titles by Option.ConnFormat.
+const (
+	// ConnFormatDash is like 127.0.0.1:5000-127.0.0.1:8080, the default one parsed by the replay.
+	ConnFormatDash = "ip:port"
+	// ConnFormatArrow is like 127.0.0.1:5000->127.0.0.1:8080.
+	ConnFormatArrow = "ip:port->ip:port"
+	// ConnFormatHash is a compact hash like 1a2b3c4d, the same for both directions of the connection.
+	ConnFormatHash = "hash"
+)
+
+// FormatConn formats the connection from src to dst by ConnFormat.
+func (o *Option) FormatConn(src, dst string) string {
+	switch o.ConnFormat {
+	case ConnFormatArrow:
+		return src + "->" + dst
+	case ConnFormatHash:
+		if dst < src {
+			src, dst = dst, src
+		}
+		h := fnv.New32a()
+		_, _ = h.Write([]byte(src + "-" + dst))
+		return fmt.Sprintf("%08x", h.Sum32())
+	default:
+		return src + "-" + dst
+	}
+}
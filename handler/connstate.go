@@ -0,0 +1,99 @@
+package handler
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// connState is the state of a connection shared by the handling of its requests and its responses,
+// by the one Base of the connection in fast mode, or by the Bases of its two streams in std mode.
+type connState struct {
+	tunnel   *tunnel
+	pending  pendingTransactions
+	sessions connSessions
+	path     atomic.Value // path of the last request
+	repeated sync.Map     // seqs of the requests suppressed by Option.Dedup or Option.ReqBodyMatcher
+	client   atomic.Value // original client address from the PROXY protocol header
+	reqTimes sync.Map     // start times of the requests by seq, for the latency in the JSON output
+	upgraded int32
+
+	streams  int32           // the streams sharing the state in std mode
+	requests requestProgress // the requests handled, for the responses to wait for theirs in std mode
+}
+
+func newConnState() *connState { return &connState{tunnel: &tunnel{}} }
+
+// requestWaitMax is the max time a response waits for its request to be handled in std mode,
+// like the responses sent early before the requests complete.
+const requestWaitMax = 3 * time.Second
+
+// requestProgress tracks the seq of the last request handled on a connection, in std mode the streams
+// of the requests and the responses are read in their own goroutines, and a response may be parsed
+// before its request, then the response waits for its request for the pairing and the filtering.
+type requestProgress struct {
+	sync.Mutex
+	seq     int32
+	ended   bool
+	changed chan struct{} // closed and renewed on each change
+}
+
+// done records the request with seq handled.
+func (p *requestProgress) done(seq int32) {
+	p.Lock()
+	defer p.Unlock()
+
+	if seq > p.seq {
+		p.seq = seq
+		p.notify()
+	}
+}
+
+// end records the stream of the requests ended, no response waits any more.
+func (p *requestProgress) end() {
+	p.Lock()
+	defer p.Unlock()
+
+	p.ended = true
+	p.notify()
+}
+
+func (p *requestProgress) notify() {
+	if p.changed != nil {
+		close(p.changed)
+		p.changed = nil
+	}
+}
+
+// await waits for the request with seq handled, or the requests ended, at most requestWaitMax.
+func (p *requestProgress) await(seq int32) {
+	timer := time.NewTimer(requestWaitMax)
+	defer timer.Stop()
+
+	for {
+		p.Lock()
+		if p.seq >= seq || p.ended {
+			p.Unlock()
+			return
+		}
+		if p.changed == nil {
+			p.changed = make(chan struct{})
+		}
+		changed := p.changed
+		p.Unlock()
+
+		select {
+		case <-changed:
+		case <-timer.C:
+			return
+		}
+	}
+}
+
+// awaitRequest waits for the request of the response with seq handled,
+// when the requests and the responses are handled by the separate Bases in std mode.
+func (h *Base) awaitRequest(seq int32) {
+	if atomic.LoadInt32(&h.streams) > 1 {
+		h.requests.await(seq)
+	}
+}
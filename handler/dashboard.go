@@ -0,0 +1,146 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// dashboardRateWindow is the window to calculate the live requests per second.
+	dashboardRateWindow = 5 * time.Second
+	// dashboardRecentMax is the max number of the recent transactions kept for the scrolling pane.
+	dashboardRecentMax = 200
+)
+
+// Dashboard is the stats model of the live terminal dashboard,
+// which is fed by the paired transactions and rendered periodically.
+type Dashboard struct {
+	sync.Mutex
+
+	start    time.Time
+	total    int
+	arrivals []time.Time // arrival times within dashboardRateWindow
+	paths    map[string]int
+	statuses map[int]int
+	recent   []string
+
+	normalizer *PathNormalizer
+
+	// GroupByHeader partitions the top paths by the value of the request header, like X-Tenant-Id.
+	GroupByHeader string
+}
+
+// NewDashboard creates a new Dashboard, grouping the top paths by the normalizer.
+func NewDashboard(normalizer *PathNormalizer) *Dashboard {
+	return &Dashboard{start: time.Now(), paths: map[string]int{}, statuses: map[int]int{}, normalizer: normalizer}
+}
+
+// HandleTransaction feeds the transaction into the stats model.
+func (d *Dashboard) HandleTransaction(t *Transaction) {
+	now := time.Now()
+
+	d.Lock()
+	defer d.Unlock()
+
+	d.total++
+	d.arrivals = append(d.expire(now), now)
+	path := t.Method + " " + d.normalizer.Normalize(t.Path)
+	if d.GroupByHeader != "" {
+		path = "[" + groupOf(t.ReqHeader, d.GroupByHeader) + "] " + path
+	}
+	d.paths[path]++
+	d.statuses[t.Status]++
+	d.recent = append(d.recent, fmt.Sprintf("%s %d %s %s%s %s",
+		t.End.Format("15:04:05.000"), t.Status, t.Method, t.Host, t.URI, t.Duration().Round(time.Microsecond)))
+	if len(d.recent) > dashboardRecentMax {
+		d.recent = d.recent[len(d.recent)-dashboardRecentMax:]
+	}
+}
+
+// expire drops the arrivals out of the rate window.
+func (d *Dashboard) expire(now time.Time) []time.Time {
+	i := sort.Search(len(d.arrivals), func(i int) bool { return now.Sub(d.arrivals[i]) < dashboardRateWindow })
+	return d.arrivals[i:]
+}
+
+// Run renders the dashboard to w every interval until ctx is done.
+// size returns the current width and height of the terminal.
+func (d *Dashboard) Run(ctx context.Context, w io.Writer, interval time.Duration, size func() (int, int)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		width, height := size()
+		// move the cursor home and clear the screen before rendering the frame
+		_, _ = io.WriteString(w, "\033[H\033[2J"+d.Render(width, height))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Render renders a frame of the dashboard fitting in width x height.
+func (d *Dashboard) Render(width, height int) string {
+	d.Lock()
+	defer d.Unlock()
+
+	now := time.Now()
+	d.arrivals = d.expire(now)
+
+	var lines []string
+	lines = append(lines, fmt.Sprintf("httpdump live  uptime: %s  total: %d  rps: %.1f",
+		now.Sub(d.start).Round(time.Second), d.total, float64(len(d.arrivals))/dashboardRateWindow.Seconds()))
+
+	codes := make([]int, 0, len(d.statuses))
+	for code := range d.statuses {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	status := &strings.Builder{}
+	status.WriteString("status:")
+	for _, code := range codes {
+		fmt.Fprintf(status, "  %d: %d", code, d.statuses[code])
+	}
+	lines = append(lines, status.String(), "", "TOP PATHS")
+
+	paths := make([]string, 0, len(d.paths))
+	for p := range d.paths {
+		paths = append(paths, p)
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		if d.paths[paths[i]] != d.paths[paths[j]] {
+			return d.paths[paths[i]] > d.paths[paths[j]]
+		}
+		return paths[i] < paths[j]
+	})
+	if len(paths) > 10 {
+		paths = paths[:10]
+	}
+	for _, p := range paths {
+		lines = append(lines, fmt.Sprintf("%8d  %s", d.paths[p], p))
+	}
+	lines = append(lines, "", "RECENT")
+
+	// the recent pane scrolls in the rest rows, the newest at the bottom
+	recent := d.recent
+	if rest := height - len(lines) - 1; rest < len(recent) {
+		recent = recent[len(recent)-max(rest, 0):]
+	}
+	lines = append(lines, recent...)
+
+	for i, line := range lines {
+		if width > 0 && len(line) > width {
+			lines[i] = line[:width]
+		}
+	}
+
+	return strings.Join(lines, "\n") + "\n"
+}
@@ -0,0 +1,26 @@
+package handler
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDashboardRender(t *testing.T) {
+	d := NewDashboard(nil)
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		d.HandleTransaction(&Transaction{Method: "GET", Host: "a.b", URI: "/x", Path: "/x", Status: 200, Start: start, End: start})
+	}
+	d.HandleTransaction(&Transaction{Method: "POST", Host: "a.b", URI: "/y", Path: "/y", Status: 500, Start: start, End: start})
+
+	frame := d.Render(80, 12)
+	assert.Contains(t, frame, "total: 4")
+	assert.Contains(t, frame, "rps: 0.8")
+	assert.Contains(t, frame, "status:  200: 3  500: 1")
+	assert.Contains(t, frame, "       3  GET /x\n       1  POST /y\n")
+	assert.Contains(t, frame, "500 POST a.b/y")
+	assert.Equal(t, 11, strings.Count(frame, "\n")) // the recent pane is cut to fit the height
+}
@@ -0,0 +1,97 @@
+package handler
+
+import (
+	"container/list"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+// dedupMaxEntries is the max number of the recent fingerprints kept in the LRU.
+const dedupMaxEntries = 1024
+
+// Deduper suppresses the repeated requests of the same fingerprint (method, url and body hash)
+// within a time window, keeping a small LRU of the recent fingerprints.
+// All methods are safe to be called on a nil *Deduper, which suppresses nothing.
+type Deduper struct {
+	sync.Mutex
+
+	window  time.Duration
+	entries map[string]*list.Element
+	lru     *list.List // front is the most recent
+}
+
+type dedupEntry struct {
+	fingerprint string
+	title       string
+	last        time.Time
+	repeated    int
+}
+
+// NewDeduper creates a new Deduper with the time window.
+func NewDeduper(window time.Duration) *Deduper {
+	return &Deduper{window: window, entries: map[string]*list.Element{}, lru: list.New()}
+}
+
+// Fingerprint returns the fingerprint of the request.
+func Fingerprint(method, host, uri string, body []byte) string {
+	h := fnv.New64a()
+	_, _ = h.Write(body)
+	return fmt.Sprintf("%s %s%s %x", method, host, uri, h.Sum64())
+}
+
+// Seen reports whether the request of the fingerprint at t repeats one within the window,
+// with the markers of the fingerprints whose repetitions ended.
+func (d *Deduper) Seen(fingerprint, title string, t time.Time) (repeated bool, markers []string) {
+	if d == nil {
+		return false, nil
+	}
+
+	d.Lock()
+	defer d.Unlock()
+
+	// expire the oldest fingerprints out of the window, or out of the LRU capacity
+	for e := d.lru.Back(); e != nil; e = d.lru.Back() {
+		entry := e.Value.(*dedupEntry)
+		if t.Sub(entry.last) <= d.window && d.lru.Len() < dedupMaxEntries {
+			break
+		}
+		markers = d.remove(e, markers)
+	}
+
+	if e, ok := d.entries[fingerprint]; ok {
+		entry := e.Value.(*dedupEntry)
+		entry.repeated++
+		entry.last = t
+		d.lru.MoveToFront(e)
+		return true, markers
+	}
+
+	d.entries[fingerprint] = d.lru.PushFront(&dedupEntry{fingerprint: fingerprint, title: title, last: t})
+	return false, markers
+}
+
+// Flush returns the markers of all the fingerprints which repeated, and forgets them.
+func (d *Deduper) Flush() (markers []string) {
+	if d == nil {
+		return nil
+	}
+
+	d.Lock()
+	defer d.Unlock()
+
+	for e := d.lru.Back(); e != nil; e = d.lru.Back() {
+		markers = d.remove(e, markers)
+	}
+	return markers
+}
+
+func (d *Deduper) remove(e *list.Element, markers []string) []string {
+	entry := d.lru.Remove(e).(*dedupEntry)
+	delete(d.entries, entry.fingerprint)
+	if entry.repeated > 0 {
+		markers = append(markers, fmt.Sprintf("// %s repeated %d times\n", entry.title, entry.repeated))
+	}
+	return markers
+}
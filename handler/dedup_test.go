@@ -0,0 +1,52 @@
+package handler
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeduper(t *testing.T) {
+	d := NewDeduper(time.Second)
+	start := time.Now()
+	a := Fingerprint("GET", "a.b", "/x", nil)
+	b := Fingerprint("POST", "a.b", "/x", []byte("1"))
+	assert.NotEqual(t, b, Fingerprint("POST", "a.b", "/x", []byte("2")))
+
+	repeated, markers := d.Seen(a, "GET a.b/x", start)
+	assert.False(t, repeated)
+	assert.Empty(t, markers)
+	repeated, _ = d.Seen(a, "GET a.b/x", start.Add(500*time.Millisecond))
+	assert.True(t, repeated)
+	repeated, _ = d.Seen(a, "GET a.b/x", start.Add(1400*time.Millisecond))
+	assert.True(t, repeated)
+	repeated, _ = d.Seen(b, "POST a.b/x", start.Add(1500*time.Millisecond))
+	assert.False(t, repeated)
+
+	// the repetitions of a end after the window
+	repeated, markers = d.Seen(a, "GET a.b/x", start.Add(3*time.Second))
+	assert.False(t, repeated)
+	assert.Equal(t, []string{"// GET a.b/x repeated 2 times\n"}, markers)
+
+	repeated, _ = d.Seen(a, "GET a.b/x", start.Add(3*time.Second))
+	assert.True(t, repeated)
+	assert.Equal(t, []string{"// GET a.b/x repeated 1 times\n"}, d.Flush())
+
+	var nilDeduper *Deduper
+	repeated, markers = nilDeduper.Seen(a, "GET a.b/x", start)
+	assert.False(t, repeated)
+	assert.Empty(t, markers)
+}
+
+func TestDeduperStd(t *testing.T) {
+	c := newTestConn(&Option{SrcRatio: 1, Resp: 1, Dedup: NewDeduper(time.Minute)})
+	c.requests("GET /a HTTP/1.1\r\nHost: a.b\r\n\r\nGET /a HTTP/1.1\r\nHost: a.b\r\n\r\n")
+	c.responses("HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\nHTTP/1.1 404 Not Found\r\nContent-Length: 0\r\n\r\n")
+
+	out := c.output()
+	assert.Equal(t, 1, strings.Count(out, "GET /a "))
+	assert.Contains(t, out, "200 OK")
+	assert.NotContains(t, out, "404 Not Found") // the response of the repeated request
+}
@@ -0,0 +1,104 @@
+package handler
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DiffCollector collects transactions of one capture, keyed by method and path, to be compared with another.
+type DiffCollector struct {
+	sync.Mutex
+	entries map[string]map[int]int // key -> status -> count
+
+	normalizer *PathNormalizer
+}
+
+var _ TransactionHandler = (*DiffCollector)(nil)
+
+// NewDiffCollector creates a new DiffCollector, grouping the paths by the normalizer.
+func NewDiffCollector(normalizer *PathNormalizer) *DiffCollector {
+	return &DiffCollector{entries: make(map[string]map[int]int), normalizer: normalizer}
+}
+
+// HandleTransaction collects the transaction.
+func (c *DiffCollector) HandleTransaction(t *Transaction) {
+	key := t.Method + " " + c.normalizer.Normalize(t.Path)
+
+	c.Lock()
+	defer c.Unlock()
+
+	statuses, ok := c.entries[key]
+	if !ok {
+		statuses = make(map[int]int)
+		c.entries[key] = statuses
+	}
+	statuses[t.Status]++
+}
+
+// DiffReport reports the transactions added, removed, or with status changed from capture a to b.
+func DiffReport(nameA, nameB string, a, b *DiffCollector) string {
+	keys := make(map[string]bool)
+	for k := range a.entries {
+		keys[k] = true
+	}
+	for k := range b.entries {
+		keys[k] = true
+	}
+	sorted := make([]string, 0, len(keys))
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+
+	var added, removed, changed int
+	sb := &strings.Builder{}
+	fmt.Fprintf(sb, "\n### DIFF %s -> %s\n", nameA, nameB)
+	for _, k := range sorted {
+		sa, inA := a.entries[k]
+		sb2, inB := b.entries[k]
+		switch {
+		case !inA:
+			added++
+			fmt.Fprintf(sb, "+ %s status %s\n", k, formatStatuses(sb2))
+		case !inB:
+			removed++
+			fmt.Fprintf(sb, "- %s status %s\n", k, formatStatuses(sa))
+		case !sameStatuses(sa, sb2):
+			changed++
+			fmt.Fprintf(sb, "~ %s status %s -> %s\n", k, formatStatuses(sa), formatStatuses(sb2))
+		}
+	}
+	fmt.Fprintf(sb, "Added: %d, Removed: %d, Changed: %d\n", added, removed, changed)
+
+	return sb.String()
+}
+
+// sameStatuses tells if the statuses and their counts are the same.
+func sameStatuses(a, b map[int]int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for status, count := range a {
+		if n, ok := b[status]; !ok || n != count {
+			return false
+		}
+	}
+	return true
+}
+
+// formatStatuses formats statuses like 200:5,500:2.
+func formatStatuses(statuses map[int]int) string {
+	codes := make([]int, 0, len(statuses))
+	for code := range statuses {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+
+	parts := make([]string, 0, len(codes))
+	for _, code := range codes {
+		parts = append(parts, fmt.Sprintf("%d:%d", code, statuses[code]))
+	}
+	return strings.Join(parts, ",")
+}
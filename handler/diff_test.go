@@ -0,0 +1,79 @@
+package handler
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffReport(t *testing.T) {
+	type tx struct {
+		method, path string
+		status       int
+	}
+	normalizer, err := NewPathNormalizer(nil)
+	require.Nil(t, err)
+
+	cases := []struct {
+		name     string
+		a, b     []tx
+		expected string
+	}{
+		{name: "empty", expected: "Added: 0, Removed: 0, Changed: 0\n"},
+		{
+			name:     "same",
+			a:        []tx{{"GET", "/a", 200}, {"GET", "/a", 500}},
+			b:        []tx{{"GET", "/a", 500}, {"GET", "/a", 200}},
+			expected: "Added: 0, Removed: 0, Changed: 0\n",
+		},
+		{
+			name:     "added and removed",
+			a:        []tx{{"GET", "/a", 200}, {"GET", "/b", 200}},
+			b:        []tx{{"GET", "/b", 200}, {"POST", "/a", 201}},
+			expected: "- GET /a status 200:1\n+ POST /a status 201:1\nAdded: 1, Removed: 1, Changed: 0\n",
+		},
+		{
+			name:     "status changed",
+			a:        []tx{{"GET", "/a", 200}},
+			b:        []tx{{"GET", "/a", 404}},
+			expected: "~ GET /a status 200:1 -> 404:1\nAdded: 0, Removed: 0, Changed: 1\n",
+		},
+		{
+			name:     "status added",
+			a:        []tx{{"GET", "/a", 200}},
+			b:        []tx{{"GET", "/a", 200}, {"GET", "/a", 500}},
+			expected: "~ GET /a status 200:1 -> 200:1,500:1\nAdded: 0, Removed: 0, Changed: 1\n",
+		},
+		{
+			name:     "counts changed by the same statuses",
+			a:        []tx{{"GET", "/a", 200}, {"GET", "/a", 200}, {"GET", "/a", 500}},
+			b:        []tx{{"GET", "/a", 200}, {"GET", "/a", 500}, {"GET", "/a", 500}},
+			expected: "~ GET /a status 200:2,500:1 -> 200:1,500:2\nAdded: 0, Removed: 0, Changed: 1\n",
+		},
+		{
+			name:     "paths normalized",
+			a:        []tx{{"GET", "/users/1", 200}},
+			b:        []tx{{"GET", "/users/2", 200}},
+			expected: "Added: 0, Removed: 0, Changed: 0\n",
+		},
+		{
+			name:     "methods distinguished",
+			a:        []tx{{"GET", "/a", 200}},
+			b:        []tx{{"get", "/a", 200}},
+			expected: "- GET /a status 200:1\n+ get /a status 200:1\nAdded: 1, Removed: 1, Changed: 0\n",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			a, b := NewDiffCollector(normalizer), NewDiffCollector(normalizer)
+			for _, x := range c.a {
+				a.HandleTransaction(&Transaction{Method: x.method, Path: x.path, Status: x.status})
+			}
+			for _, x := range c.b {
+				b.HandleTransaction(&Transaction{Method: x.method, Path: x.path, Status: x.status})
+			}
+			assert.Equal(t, "\n### DIFF a.pcap -> b.pcap\n"+c.expected, DiffReport("a.pcap", "b.pcap", a, b))
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package handler
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/bingoohuang/httpdump/util"
+)
+
+// explainReq returns the request filters passed by the output requests, like host=*.api, uri=/v1/*,
+// or no filters if none set, for Option.ExplainFilter.
+func (o *Option) explainReq() string {
+	var matched []string
+	add := func(set bool, format string, a ...interface{}) {
+		if set {
+			matched = append(matched, fmt.Sprintf(format, a...))
+		}
+	}
+
+	add(o.Method != "", "method=%s", o.Method)
+	add(o.Host != "", "host=%s", o.Host)
+	add(o.HostPatterns != nil, "host-file")
+	add(o.Uri != "", "uri=%s", o.Uri)
+	add(o.UriPatterns != nil, "uri-file")
+	add(o.UAMatcher != nil, "ua=%s", strings.TrimPrefix(o.UAMatcher.String(), "User-Agent: "))
+	add(o.ExcludeUAMatcher != nil, "exclude-ua=%s", strings.TrimPrefix(o.ExcludeUAMatcher.String(), "User-Agent: "))
+	add(o.Nth != nil, "nth=%s", o.Nth)
+	add(o.ReqBodyMatcher != nil, "req-body=%s", o.ReqBodyMatcher)
+	add(o.SrcRatio > 0 && o.SrcRatio < 1, "ratio=%g", o.SrcRatio)
+	return explanation(matched)
+}
+
+// explainRsp returns the response filters passed by the output responses, like status=500, for Option.ExplainFilter.
+func (o *Option) explainRsp() string {
+	var matched []string
+	add := func(set bool, format string, a ...interface{}) {
+		if set {
+			matched = append(matched, fmt.Sprintf(format, a...))
+		}
+	}
+
+	status, excludeStatus := util.IntSet(o.Status).String(), util.IntSet(o.ExcludeStatus).String()
+	add(status != "", "status=%s", status)
+	add(excludeStatus != "", "exclude-status=%s", excludeStatus)
+	add(o.RspHeaderMatcher != nil, "rsp-header=%s", o.RspHeaderMatcher)
+	add(o.RspBodyMatcher != nil, "rsp-body=%s", o.RspBodyMatcher)
+	add(o.Nth != nil, "nth=%s", o.Nth)
+	add(o.SrcRatio > 0 && o.SrcRatio < 1, "ratio=%g", o.SrcRatio)
+	return explanation(matched)
+}
+
+func explanation(matched []string) string {
+	if len(matched) == 0 {
+		return "no filters"
+	}
+	return strings.Join(matched, ", ")
+}
+
+// writeMatched writes the line of the filters passed like // matched: host=*.api after the title, for Option.ExplainFilter.
+func writeMatched(b *bytes.Buffer, explain func() string, o *Option) {
+	if o.ExplainFilter {
+		writeFormat(b, "// matched: %s\r\n", explain())
+	}
+}
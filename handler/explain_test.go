@@ -0,0 +1,26 @@
+package handler
+
+import (
+	"testing"
+
+	"github.com/bingoohuang/httpdump/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExplainFilter(t *testing.T) {
+	assert.Equal(t, "no filters", (&Option{SrcRatio: 1}).explainReq())
+
+	ua, _ := NewUAMatcher("*bot*", false)
+	rspHeader, _ := NewHeaderMatcher([]string{"X-Cache: miss", "!Age"}, false)
+	nth, _ := ParseNthRange("2:")
+	var status util.IntSetFlag
+	assert.Nil(t, status.Set("500-599"))
+	option := &Option{SrcRatio: 1, Host: "*.api", Method: "POST", UAMatcher: ua, Nth: nth, Status: status, RspHeaderMatcher: rspHeader}
+	assert.Equal(t, "method=POST, host=*.api, ua=*bot*, nth=2:", option.explainReq())
+	assert.Equal(t, "status=500-599, rsp-header=X-Cache: miss, !Age, nth=2:", option.explainRsp())
+
+	c := newTestConn(&Option{SrcRatio: 1, Host: "*.api", ExplainFilter: true})
+	c.requests("GET /x HTTP/1.1\r\nHost: a.api\r\n\r\n")
+	assert.Contains(t, c.output(), " REQ 127.0.0.1:5000-127.0.0.2:8080 ")
+	assert.Contains(t, c.output(), "\r\n// matched: host=*.api\r\nGET /x HTTP/1.1\r\n")
+}
@@ -0,0 +1,83 @@
+package handler
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sendPair sends the transaction as one combined line for Option.FastPair,
+// after flushing the requests unpaired longer than the window before t.
+func (h *Base) sendPair(tx *Transaction, t time.Time) {
+	h.flushUnpaired(t.Add(-h.option.FastPair))
+	if tx != nil {
+		h.sender.Send(h.pairLine(tx), true)
+	}
+}
+
+// flushUnpaired sends the pending requests started before the deadline as unpaired, or all if the deadline is zero.
+func (h *Base) flushUnpaired(deadline time.Time) {
+	h.pending.Lock()
+	var expired []*Transaction
+	for seq, t := range h.pending.m {
+		if deadline.IsZero() || t.Start.Before(deadline) {
+			expired = append(expired, t)
+			delete(h.pending.m, seq)
+		}
+	}
+	h.pending.Unlock()
+
+	sort.Slice(expired, func(i, j int) bool { return expired[i].Seq < expired[j].Seq })
+	for _, t := range expired {
+		h.sender.Send(h.pairLine(t), true)
+	}
+}
+
+// pairFlusher flushes the requests unpaired within Option.FastPair periodically, by the assembler,
+// for the idle connections have no next transaction to flush them.
+type pairFlusher struct {
+	bases sync.Map // *connState -> *Base of the requests of the connection
+}
+
+// add adds the Base of the requests of the connection.
+func (p *pairFlusher) add(b *Base) { p.bases.Store(b.connState, b) }
+
+// flush flushes the requests started before the deadline of all the connections.
+func (p *pairFlusher) flush(deadline time.Time) {
+	p.bases.Range(func(_, b any) bool {
+		b.(*Base).flushUnpaired(deadline)
+		return true
+	})
+}
+
+// done flushes all the requests pending of the connection finished, and forgets it.
+func (p *pairFlusher) done(state *connState) {
+	if b, ok := p.bases.LoadAndDelete(state); ok {
+		b.(*Base).flushUnpaired(time.Time{})
+	}
+}
+
+// pairLine formats the transaction as one line like
+// 2024-05-06T07:08:09Z 127.0.0.1:5000-127.0.0.1:8080 #1 GET http://a.b/x -> 200 12ms,
+// the request or the response is - if unpaired.
+func (h *Base) pairLine(t *Transaction) string {
+	b := &strings.Builder{}
+	if t.Method != "" {
+		fmt.Fprintf(b, "%s %s #%d %s %s -> ", h.option.FormatTime(t.Start), h.option.FormatConn(t.Src, t.Dst), t.Seq,
+			t.Method, h.absoluteURL(t.Host, t.URI))
+	} else {
+		fmt.Fprintf(b, "%s %s #%d - -> ", h.option.FormatTime(t.End), h.option.FormatConn(t.Src, t.Dst), t.Seq)
+	}
+
+	switch {
+	case t.Status == 0:
+		fmt.Fprintf(b, "- no response within %s\n", h.option.FastPair)
+	case t.Method == "":
+		fmt.Fprintf(b, "%d\n", t.Status)
+	default:
+		fmt.Fprintf(b, "%d %s\n", t.Status, t.Duration())
+	}
+	return b.String()
+}
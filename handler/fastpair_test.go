@@ -0,0 +1,105 @@
+package handler
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFastPair(t *testing.T) {
+	option := &Option{FastPair: 3 * time.Second, SrcRatio: 1, Host: "a.b", TimeFormat: "15:04:05"}
+	c := newTestConn(option)
+	h := c.base(testClient, testServer)
+	wire := func() int64 { return 0 }
+	request := func(host, uri string, at time.Time) {
+		r, _ := http.ReadRequest(bufio.NewReader(strings.NewReader("GET " + uri + " HTTP/1.1\r\nHost: " + host + "\r\n\r\n")))
+		h.processRequest(true, &HttpReq{Request: r, wire: wire}, option, at)
+	}
+	response := func(status string, at time.Time) {
+		r, _ := http.ReadResponse(bufio.NewReader(strings.NewReader("HTTP/1.1 "+status+"\r\nContent-Length: 0\r\n\r\n")), nil)
+		h.processResponse(true, &HttpRsp{Response: r, wire: wire}, option, at)
+	}
+
+	start := time.Date(2024, 5, 6, 7, 8, 9, 0, time.Local)
+	request("a.b", "/x", start)
+	response("200 OK", start.Add(12*time.Millisecond))
+	request("a.b", "/lost", start.Add(time.Second))
+	request("c.d", "/filtered", start.Add(2*time.Second))
+	request("a.b", "/late", start.Add(5*time.Second)) // /lost is flushed as unpaired
+	response("502 Bad Gateway", start.Add(5*time.Second))
+	response("204 No Content", start.Add(6*time.Second)) // the response of the filtered out request
+	response("200 OK", start.Add(6*time.Second))         // /late
+	request("a.b", "/end", start.Add(8*time.Second))
+	h.flushUnpaired(time.Time{})
+
+	assert.Equal(t, []string{
+		"07:08:09 127.0.0.1:5000-127.0.0.2:8080 #1 GET http://a.b/x -> 200 12ms\n",
+		"07:08:10 127.0.0.1:5000-127.0.0.2:8080 #2 GET http://a.b/lost -> - no response within 3s\n",
+		"07:08:14 127.0.0.1:5000-127.0.0.2:8080 #2 - -> 502\n",
+		"07:08:14 127.0.0.1:5000-127.0.0.2:8080 #4 GET http://a.b/late -> 200 1s\n",
+		"07:08:17 127.0.0.1:5000-127.0.0.2:8080 #5 GET http://a.b/end -> - no response within 3s\n",
+	}, c.messages())
+}
+
+func TestFastPairFlushIdle(t *testing.T) {
+	s := &collectSender{}
+	option := &Option{FastPair: 3 * time.Second, Resp: 1, SrcRatio: 1, TimeFormat: "15:04:05"}
+	a := NewTCPAssembler(&ConnectionHandlerFast{Context: context.Background(), Option: option, Sender: s}, 10, 1)
+	flow := gopacket.NewFlow(layers.EndpointIPv4, net.IPv4(127, 0, 0, 1).To4(), net.IPv4(127, 0, 0, 2).To4())
+
+	start := time.Date(2024, 5, 6, 7, 8, 9, 0, time.Local)
+	req := []byte("GET /idle HTTP/1.1\r\nHost: a.b\r\n\r\n")
+	a.Assemble(flow, &layers.TCP{SrcPort: 5000, DstPort: 8080, Seq: 1, ACK: true, Ack: 1,
+		BaseLayer: layers.BaseLayer{Payload: req}}, start)
+	a.Assemble(flow.Reverse(), &layers.TCP{SrcPort: 8080, DstPort: 5000, Seq: 1, ACK: true,
+		Ack: 1 + uint32(len(req))}, start)
+
+	// the connection stays idle without the response, the packets of the others move the time on
+	a.Assemble(flow, &layers.TCP{SrcPort: 5001, DstPort: 8080, ACK: true}, start.Add(2*time.Second))
+	a.FlushOlderThan(time.Time{})
+	assert.Empty(t, s.messages())
+
+	a.Assemble(flow, &layers.TCP{SrcPort: 5001, DstPort: 8080, ACK: true}, start.Add(4*time.Second))
+	assert.Eventually(t, func() bool {
+		a.FlushOlderThan(time.Time{})
+		return len(s.messages()) == 1
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, "07:08:09 127.0.0.1:5000-127.0.0.2:8080 #1 GET http://a.b/idle -> - no response within 3s\n",
+		s.messages()[0])
+	a.FinishAll()
+	assert.Len(t, s.messages(), 1)
+}
+
+func TestFastPairStd(t *testing.T) {
+	option := &Option{FastPair: time.Second, Resp: 1, SrcRatio: 1}
+	c := newTestConn(option)
+	c.requests("GET /a HTTP/1.1\r\nHost: a.b\r\n\r\n")
+	c.responses("HTTP/1.1 204 No Content\r\n\r\n")
+	assert.Regexp(t, `^\S+ 127.0.0.1:5000-127.0.0.2:8080 #1 GET http://a.b/a -> 204 \S+\n$`, c.output())
+
+	// the requests unpaired are flushed periodically, and at the end of the connection
+	s := &collectSender{}
+	f := NewFactory(context.Background(), option, s)
+	request := func(uri string) *Base {
+		b := NewBase(context.Background(), &ConnectionKey{src: testClient, dst: testServer}, option, s)
+		f.run(b, strings.NewReader("GET "+uri+" HTTP/1.1\r\nHost: a.b\r\n\r\n"))
+		return b
+	}
+	request("/idle")
+	f.pairs.flush(time.Now().Add(-time.Minute))
+	assert.Empty(t, s.messages())
+	f.pairs.flush(time.Now().Add(time.Minute))
+	f.pairs.done(request("/end").connState)
+	if msgs := s.messages(); assert.Len(t, msgs, 2) {
+		assert.Contains(t, msgs[0], " #1 GET http://a.b/idle -> - no response within 1s\n")
+		assert.Contains(t, msgs[1], " #1 GET http://a.b/end -> - no response within 1s\n")
+	}
+}
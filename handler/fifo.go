@@ -0,0 +1,186 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// fifoRetryInterval is the interval to retry opening the fifo when it has no reader.
+const fifoRetryInterval = time.Second
+
+// fifoBatchMax is the max bytes of the messages batched before written to the fifo, within the flush latency.
+const fifoBatchMax = 64 << 10
+
+// IsFifo tells if the file is a named pipe.
+func IsFifo(file string) bool {
+	fi, err := os.Stat(file)
+	return err == nil && fi.Mode()&os.ModeNamedPipe != 0
+}
+
+// FifoSender writes the messages to a named pipe, tolerating the reader to disconnect and reconnect.
+// The messages are buffered in the channel while no reader is present,
+// and dropped with a counter when the channel is full.
+type FifoSender struct {
+	file    string
+	latency time.Duration // max time the messages are batched before written, negative to write each one
+	ch      chan string
+	closing chan struct{}
+	done    chan struct{}
+	dropped int64
+}
+
+// NewFifoSender creates a FifoSender to the named pipe file,
+// the messages are written within the flush latency, or each one if negative.
+func NewFifoSender(ctx context.Context, file string, chanSize uint, flushLatency time.Duration) *FifoSender {
+	s := &FifoSender{
+		file:    file,
+		latency: flushLatency,
+		ch:      make(chan string, chanSize),
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go s.run(ctx)
+	return s
+}
+
+// Send buffers the message, it is dropped after Close, like by the handlers still running on shutdown.
+func (s *FifoSender) Send(msg string, _ bool) {
+	select {
+	case <-s.closing:
+		return
+	default:
+	}
+
+	select {
+	case s.ch <- msg:
+	default:
+		atomic.AddInt64(&s.dropped, 1)
+	}
+}
+
+// Close writes out the buffered messages if the reader is present, and then closes the fifo.
+// The channel is left open, for Send to be safe after Close.
+func (s *FifoSender) Close() error {
+	close(s.closing)
+	<-s.done
+	if n := atomic.LoadInt64(&s.dropped); n > 0 {
+		log.Printf("W! fifo %s dropped %d messages", s.file, n)
+	}
+	return nil
+}
+
+// ChanOccupancy reports the occupancy of the channel buffering messages to the fifo.
+func (s *FifoSender) ChanOccupancy() (name string, length, capacity int) {
+	return "fifo " + s.file, len(s.ch), cap(s.ch)
+}
+
+func (s *FifoSender) run(ctx context.Context) {
+	defer close(s.done)
+
+	var f *os.File
+	defer func() {
+		if f != nil {
+			_ = f.Close()
+		}
+	}()
+
+	var flushC <-chan time.Time
+	if s.latency > 0 {
+		ticker := time.NewTicker(s.latency)
+		defer ticker.Stop()
+		flushC = ticker.C
+	}
+
+	var batch strings.Builder
+	var n int64 // the messages in the batch
+	flush := func() bool {
+		if batch.Len() == 0 {
+			return true
+		}
+		ok := s.write(ctx, &f, batch.String(), n)
+		batch.Reset()
+		n = 0
+		return ok
+	}
+	for {
+		select {
+		case msg := <-s.ch:
+			batch.WriteString(msg)
+			n++
+			if (s.latency < 0 || batch.Len() >= fifoBatchMax) && !flush() {
+				return
+			}
+		case <-flushC:
+			if !flush() {
+				return
+			}
+		case <-s.closing:
+			for {
+				select {
+				case msg := <-s.ch:
+					batch.WriteString(msg)
+					n++
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// write writes the batch of the n messages to the fifo, reopened on the reader reconnecting,
+// false when closing or canceled without a reader, then the messages buffered are dropped.
+func (s *FifoSender) write(ctx context.Context, f **os.File, batch string, n int64) bool {
+	for {
+		if *f == nil {
+			if *f = s.open(ctx); *f == nil {
+				atomic.AddInt64(&s.dropped, n+int64(len(s.ch)))
+				return false
+			}
+		}
+
+		_, err := (*f).WriteString(batch)
+		if err == nil {
+			return true
+		}
+
+		_ = (*f).Close()
+		*f = nil
+		if !errors.Is(err, syscall.EPIPE) {
+			log.Printf("E! write fifo %s failed: %v", s.file, err)
+			atomic.AddInt64(&s.dropped, n)
+			return true
+		}
+		log.Printf("W! fifo %s reader disconnected", s.file)
+	}
+}
+
+// open opens the fifo for writing, waiting for a reader, or nil when closing or canceled.
+func (s *FifoSender) open(ctx context.Context) *os.File {
+	for {
+		// O_NONBLOCK fails with ENXIO instead of blocking when there is no reader
+		f, err := os.OpenFile(s.file, os.O_WRONLY|syscall.O_NONBLOCK, 0)
+		if err == nil {
+			log.Printf("I! fifo %s reader connected, %d messages dropped so far", s.file, atomic.LoadInt64(&s.dropped))
+			return f
+		}
+		if !errors.Is(err, syscall.ENXIO) {
+			log.Printf("E! open fifo %s failed: %v", s.file, err)
+		}
+
+		select {
+		case <-time.After(fifoRetryInterval):
+		case <-s.closing:
+			return nil
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
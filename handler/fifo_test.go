@@ -0,0 +1,64 @@
+//go:build !windows
+// +build !windows
+
+package handler
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFifoSender(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "httpdump.fifo")
+	assert.Nil(t, syscall.Mkfifo(file, 0o600))
+	assert.True(t, IsFifo(file))
+	assert.False(t, IsFifo(filepath.Dir(file)))
+
+	s := NewFifoSender(context.Background(), file, 10, -1)
+	s.Send("buffered without reader\n", true)
+
+	r, err := os.Open(file)
+	assert.Nil(t, err)
+	line, err := bufio.NewReader(r).ReadString('\n')
+	assert.Nil(t, err)
+	assert.Equal(t, "buffered without reader\n", line)
+	_ = r.Close()
+
+	// the message written after the reader disconnected is retried when it reconnects
+	s.Send("after reconnect\n", true)
+	r, err = os.Open(file)
+	assert.Nil(t, err)
+	defer r.Close()
+	line, err = bufio.NewReader(r).ReadString('\n')
+	assert.Nil(t, err)
+	assert.Equal(t, "after reconnect\n", line)
+
+	assert.Nil(t, s.Close())
+	s.Send("after close\n", true) // dropped, not panicking on the closed sender
+}
+
+func TestFifoSenderBatched(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "httpdump.fifo")
+	assert.Nil(t, syscall.Mkfifo(file, 0o600))
+
+	s := NewFifoSender(context.Background(), file, 10, time.Hour)
+	s.Send("a\n", true)
+	s.Send("b\n", true)
+	assert.Eventually(t, func() bool { return len(s.ch) == 0 }, time.Second, time.Millisecond)
+	go func() { _ = s.Close() }() // the batch is written on closing, not before
+
+	r, err := os.Open(file)
+	assert.Nil(t, err)
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	assert.Nil(t, err)
+	assert.Equal(t, "a\nb\n", string(data))
+}
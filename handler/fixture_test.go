@@ -0,0 +1,65 @@
+package handler
+
+import (
+	"context"
+	"strings"
+	"sync"
+)
+
+// collectSender collects the messages sent.
+type collectSender struct {
+	sync.Mutex
+	msgs []string
+}
+
+func (s *collectSender) Send(msg string, _ bool) {
+	s.Lock()
+	defer s.Unlock()
+	s.msgs = append(s.msgs, msg)
+}
+
+func (s *collectSender) Close() error { return nil }
+
+func (s *collectSender) messages() []string {
+	s.Lock()
+	defer s.Unlock()
+	return append([]string(nil), s.msgs...)
+}
+
+// testClient and testServer are the endpoints of the test connections.
+var (
+	testClient = Endpoint{ip: "127.0.0.1", port: 5000}
+	testServer = Endpoint{ip: "127.0.0.2", port: 8080}
+)
+
+// testConn is a test connection handled in std mode, its request and response streams share the state
+// of the connection like by Factory.New.
+type testConn struct {
+	*collectSender
+	option *Option
+	state  *connState
+}
+
+func newTestConn(option *Option) *testConn {
+	return &testConn{collectSender: &collectSender{}, option: option, state: newConnState()}
+}
+
+// requests handles the data sent by the client.
+func (c *testConn) requests(data string) { c.run(testClient, testServer, data) }
+
+// responses handles the data sent by the server.
+func (c *testConn) responses(data string) { c.run(testServer, testClient, data) }
+
+func (c *testConn) run(src, dst Endpoint, data string) {
+	(&Factory{}).run(c.base(src, dst), strings.NewReader(data))
+}
+
+// base creates the Base of the stream from src to dst of the connection.
+func (c *testConn) base(src, dst Endpoint) *Base {
+	b := NewBase(context.Background(), &ConnectionKey{src: src, dst: dst}, c.option, c.collectSender)
+	b.connState = c.state
+	return b
+}
+
+// output returns the messages sent joined.
+func (c *testConn) output() string { return strings.Join(c.messages(), "") }
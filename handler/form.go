@@ -0,0 +1,32 @@
+package handler
+
+import (
+	"bytes"
+	"net/url"
+	"strings"
+)
+
+// isFormContent tells if the content type is application/x-www-form-urlencoded.
+func isFormContent(mimeType string) bool {
+	return strings.EqualFold(mimeType, "application/x-www-form-urlencoded")
+}
+
+// decodeForm decodes the application/x-www-form-urlencoded body into the fields in their original order,
+// each on its own line like a: 1, with the names and values URL-decoded, false if it fails to parse.
+func decodeForm(body []byte) ([]byte, bool) {
+	b := &bytes.Buffer{}
+	for _, field := range strings.Split(strings.TrimSpace(string(body)), "&") {
+		if field == "" {
+			continue
+		}
+		name, value, _ := strings.Cut(field, "=")
+		name, err1 := url.QueryUnescape(name)
+		value, err2 := url.QueryUnescape(value)
+		if err1 != nil || err2 != nil {
+			return nil, false
+		}
+		writeFormat(b, "%s: %s\n", name, value)
+	}
+
+	return b.Bytes(), b.Len() > 0
+}
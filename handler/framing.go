@@ -0,0 +1,73 @@
+package handler
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"log"
+	"strings"
+
+	"github.com/bingoohuang/httpdump/util"
+)
+
+// statusLinePrefix starts the status line of the HTTP/1 responses, to resync the response stream.
+var statusLinePrefix = []byte("HTTP/1.")
+
+// framingConflict tells if the raw headers of a message have both Content-Length and Transfer-Encoding: chunked,
+// where the chunked framing is preferred per RFC 7230 section 3.3.3.
+func framingConflict(headers []byte) bool {
+	if pos := util.MIMEHeadersEndPos(headers); pos >= 0 {
+		headers = headers[:pos]
+	}
+
+	var contentLength, chunked bool
+	for _, line := range strings.Split(string(headers), "\n") {
+		name, value, ok := strings.Cut(strings.TrimSuffix(line, "\r"), ":")
+		if !ok {
+			continue
+		}
+		switch {
+		case strings.EqualFold(name, "Content-Length"):
+			contentLength = true
+		case strings.EqualFold(name, "Transfer-Encoding"):
+			chunked = chunked || strings.Contains(strings.ToLower(value), "chunked")
+		}
+	}
+	return contentLength && chunked
+}
+
+// warnFramingConflict logs a warning when the headers of the response have both Content-Length and
+// Transfer-Encoding: chunked, the body is read as chunked.
+func (h *Base) warnFramingConflict(headers []byte) {
+	if framingConflict(headers) {
+		log.Printf("W! RSP#%d of %s-%s has both Content-Length and Transfer-Encoding: chunked, chunked preferred",
+			h.rspCounter.Get()+1, h.key.Src(), h.key.Dst())
+	}
+}
+
+// resyncResponse discards the lines until the next status line of the response stream, after a framing error,
+// and returns the bytes discarded.
+func resyncResponse(buf *bufio.Reader) (skipped int, err error) {
+	for {
+		if peek, _ := buf.Peek(len(statusLinePrefix)); bytes.Equal(peek, statusLinePrefix) {
+			return skipped, nil
+		}
+		line, err := buf.ReadSlice('\n')
+		skipped += len(line)
+		if err != nil && !errors.Is(err, bufio.ErrBufferFull) {
+			return skipped, err
+		}
+	}
+}
+
+// resync resyncs the response stream after the parsing error, logging the bytes discarded,
+// false if the stream ended before the next status line.
+func (h *Base) resync(buf *bufio.Reader) bool {
+	skipped, err := resyncResponse(buf)
+	if err != nil {
+		return false
+	}
+	log.Printf("W! RSP of %s-%s resynced to the next status line, %d bytes discarded",
+		h.key.Src(), h.key.Dst(), skipped)
+	return true
+}
@@ -0,0 +1,39 @@
+package handler
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFramingConflict(t *testing.T) {
+	assert.True(t, framingConflict([]byte("HTTP/1.1 200 OK\r\nContent-Length: 10\r\nTransfer-Encoding: Chunked\r\n\r\n")))
+	assert.False(t, framingConflict([]byte("HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n")))
+	assert.False(t, framingConflict([]byte("HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\nTransfer-Encoding: chunked\r\n")))
+}
+
+func TestResyncResponse(t *testing.T) {
+	buf := bufio.NewReader(strings.NewReader("garbage\r\nmore garbage\r\nHTTP/1.1 200 OK\r\n\r\n"))
+	skipped, err := resyncResponse(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, len("garbage\r\nmore garbage\r\n"), skipped)
+	line, _ := buf.ReadString('\n')
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n", line)
+
+	_, err = resyncResponse(bufio.NewReader(strings.NewReader("garbage\r\n")))
+	assert.NotNil(t, err)
+}
+
+func TestResponseFramingStd(t *testing.T) {
+	c := newTestConn(&Option{SrcRatio: 1, Resp: 1, Level: LevelBody})
+	c.responses("HTTP/1.1 200 OK\r\nContent-Length: 100\r\nTransfer-Encoding: chunked\r\n\r\n2\r\nok\r\n0\r\n\r\n" +
+		"HTTP/1.1 2xx Broken\r\nContent-Length: 3\r\n\r\nbad\r\n" +
+		"HTTP/1.1 201 Created\r\nContent-Length: 4\r\n\r\ndone")
+
+	out := c.output()
+	assert.Contains(t, out, "\n### #1 200 OK\r\n")
+	assert.Contains(t, out, "\n### ERR#1 RSP 127.0.0.2:8080-127.0.0.1:5000 ")
+	assert.Contains(t, out, "\n### #2 201 Created\r\ndone")
+}
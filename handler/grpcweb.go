@@ -0,0 +1,87 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"mime"
+	"strings"
+)
+
+// grpcWebTrailerFlag is the flag bit of the grpc-web frame carrying the trailers instead of a message.
+const grpcWebTrailerFlag = 0x80
+
+// grpcWebContent tells if the content type is grpc-web, like application/grpc-web+proto,
+// and if it is the base64 text format application/grpc-web-text.
+func grpcWebContent(contentType string) (isWeb, isText bool) {
+	mt, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case strings.HasPrefix(mt, "application/grpc-web-text"):
+		return true, true
+	case strings.HasPrefix(mt, "application/grpc-web"):
+		return true, false
+	default:
+		return false, false
+	}
+}
+
+// decodeGrpcWebBody decodes the grpc-web frames, the messages with their lengths,
+// and the trailers in the last frame, like grpc-status:0.
+func (d *ProtoDecoder) decodeGrpcWebBody(body []byte, isText bool, contentType, path string, isRequest bool) string {
+	b := &strings.Builder{}
+	if isText {
+		decoded, err := decodeGrpcWebText(body)
+		if err != nil {
+			fmt.Fprintf(b, "{Decode grpc-web-text failed: %v}\n", err)
+			return b.String()
+		}
+		body = decoded
+	}
+
+	md := d.messageType(contentType, path, isRequest)
+	for len(body) >= 5 {
+		flag, n := body[0], binary.BigEndian.Uint32(body[1:5])
+		if uint64(len(body)-5) < uint64(n) {
+			break
+		}
+		frame := body[5 : 5+n]
+		body = body[5+n:]
+
+		if flag&grpcWebTrailerFlag != 0 {
+			fmt.Fprintf(b, "// grpc-web trailers, len: %d\n", n)
+			b.WriteString(strings.TrimSpace(string(frame)))
+			b.WriteString("\n")
+			continue
+		}
+
+		fmt.Fprintf(b, "// grpc-web message, len: %d\n", n)
+		writeMessage(b, md, frame)
+	}
+	if len(body) > 0 {
+		fmt.Fprintf(b, "// grpc-web incomplete frame, len: %d\n", len(body))
+		writeMessage(b, nil, body)
+	}
+
+	return b.String()
+}
+
+// decodeGrpcWebText decodes the base64 body of grpc-web-text, which may be the concatenation
+// of the padded base64 chunks of the frames, so it is decoded by each 4 chars group.
+func decodeGrpcWebText(body []byte) ([]byte, error) {
+	body = bytes.Join(bytes.Fields(body), nil)
+	if len(body)%4 != 0 {
+		return nil, fmt.Errorf("invalid base64 length %d", len(body))
+	}
+
+	decoded := make([]byte, 0, len(body)/4*3)
+	group := make([]byte, 3)
+	for i := 0; i < len(body); i += 4 {
+		n, err := base64.StdEncoding.Decode(group, body[i:i+4])
+		if err != nil {
+			return nil, err
+		}
+		decoded = append(decoded, group[:n]...)
+	}
+	return decoded, nil
+}
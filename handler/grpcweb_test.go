@@ -0,0 +1,35 @@
+package handler
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestDecodeGrpcWebBody(t *testing.T) {
+	var msg []byte
+	msg = protowire.AppendTag(msg, 1, protowire.VarintType)
+	msg = protowire.AppendVarint(msg, 150)
+
+	trailers := "grpc-status:0\r\ngrpc-message:OK\r\n"
+	frames := append([]byte{0, 0, 0, 0, byte(len(msg))}, msg...)
+	trailerFrame := append([]byte{grpcWebTrailerFlag, 0, 0, 0, byte(len(trailers))}, trailers...)
+
+	var d *ProtoDecoder
+	s := d.decodeProtobufBody(append(frames, trailerFrame...), "application/grpc-web+proto", "/pkg.Service/Method", false)
+	assert.Contains(t, s, "// grpc-web message, len: 3\n")
+	assert.Contains(t, s, "field 1 (varint): 150\n")
+	assert.Contains(t, s, "// grpc-web trailers, len: 32\ngrpc-status:0\r\ngrpc-message:OK\n")
+
+	// the base64 chunks of the frames are concatenated with their paddings
+	text := base64.StdEncoding.EncodeToString(frames) + base64.StdEncoding.EncodeToString(trailerFrame)
+	assert.Equal(t, s, d.decodeProtobufBody([]byte(text), "application/grpc-web-text", "/pkg.Service/Method", false))
+
+	isWeb, isText := grpcWebContent("application/grpc-web-text+proto")
+	assert.True(t, isWeb)
+	assert.True(t, isText)
+	isWeb, _ = grpcWebContent("application/grpc")
+	assert.False(t, isWeb)
+}
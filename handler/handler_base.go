@@ -4,17 +4,22 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"crypto/tls"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/bingoohuang/gg/pkg/ginx"
 	"github.com/bingoohuang/gg/pkg/handy"
@@ -29,7 +34,22 @@ import (
 // ConnectionHandler is interface for handle tcp connection
 type ConnectionHandler interface {
 	handle(src, dst Endpoint, connection *TCPConnection)
+	// tlsSNI tells to print the TLS handshakes of the untracked connections by handshake.
+	tlsSNI() bool
+	handshake(src, dst Endpoint, record []byte, timestamp time.Time)
+	established(client, server Endpoint, hs *handshake)
 	finish()
+	pending() int
+	// flushUnpaired flushes the requests unpaired within Option.FastPair by now, the packet time.
+	flushUnpaired(now time.Time)
+	// captured records the timestamp of a packet captured, the first one re-bases the timestamps by TimestampFirst.
+	captured(timestamp time.Time)
+}
+
+// PendingCounter reports the number of the connections whose handling is not finished yet,
+// to tell the ones abandoned on shutdown.
+type PendingCounter interface {
+	PendingConnections() int
 }
 
 type Key interface {
@@ -69,6 +89,17 @@ func (ss Senders) Close() (err error) {
 	return err
 }
 
+// ChanGauge reports the occupancy of a buffered channel, to help tuning its size.
+type ChanGauge interface {
+	ChanOccupancy() (name string, length, capacity int)
+}
+
+// DiscardSender discards all the messages.
+type DiscardSender struct{}
+
+func (DiscardSender) Send(string, bool) {}
+func (DiscardSender) Close() error      { return nil }
+
 func IsUsingJSON() bool {
 	return ss.AnyOfFold(os.Getenv("PRINT_JSON"), "y", "1", "yes", "on")
 }
@@ -87,6 +118,14 @@ type Base struct {
 
 	usingJSON bool
 	cache     *rrCache
+
+	*connState
+	source string // capture source of the connection, like the interface name or the pcap file
+
+	// reqStream and rspStream are the bytes of the streams received in fast mode, for Option.Offsets
+	reqStream, rspStream int64
+	// eventTime returns the packet time of the events streamed in fast mode, nil for the time they are read in std mode
+	eventTime func() time.Time
 }
 
 type rrCache struct {
@@ -95,13 +134,17 @@ type rrCache struct {
 }
 
 func NewBase(ctx context.Context, key Key, option *Option, sender Sender) *Base {
-	b := &Base{Context: ctx, key: key, option: option, sender: sender, usingJSON: IsUsingJSON()}
+	b := &Base{Context: ctx, key: key, option: option, sender: sender, usingJSON: IsUsingJSON() || len(option.JSONFields) > 0}
+	b.connState = newConnState()
 	if option.Resp > 1 {
 		b.cache = &rrCache{Cache: make(map[string]*SendArgs)}
 	}
 	return b
 }
 
+// lineOutput tells if each message is output as one line, in JSON or access log format, without the markers.
+func (h *Base) lineOutput() bool { return h.usingJSON || h.option.Format != "" }
+
 func writeFormat(b *bytes.Buffer, f string, a ...interface{}) { _, _ = fmt.Fprintf(b, f, a...) }
 func writeBytes(b *bytes.Buffer, p []byte)                    { b.Write(p) }
 func writeLine(b *bytes.Buffer, a ...interface{}) {
@@ -109,9 +152,18 @@ func writeLine(b *bytes.Buffer, a ...interface{}) {
 	_, _ = fmt.Fprintf(b, "\r\n")
 }
 
-func printHeader(b *bytes.Buffer, header map[string][]string) {
-	for name, values := range header {
-		for _, value := range values {
+// printHeader prints the header, in case-insensitive sorted order of names if sorted is true.
+func printHeader(b *bytes.Buffer, header map[string][]string, sorted bool) {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	if sorted {
+		sort.Slice(names, func(i, j int) bool { return strings.ToLower(names[i]) < strings.ToLower(names[j]) })
+	}
+
+	for _, name := range names {
+		for _, value := range header[name] {
 			writeFormat(b, "%s: %s\r\n", name, value)
 		}
 	}
@@ -125,6 +177,7 @@ type Req interface {
 	GetMethod() string
 	GetProto() string
 	GetHeader() http.Header
+	GetRawHeaders() []string
 	GetContentLength() int64
 }
 
@@ -137,6 +190,9 @@ type ReqBean struct {
 	Host       string
 	Header     http.Header
 	Body       string `json:",clearQuotes"`
+	BodyHash   string `json:",omitempty"`
+	Session    string `json:",omitempty"`
+	Source     string `json:",omitempty"`
 }
 
 var MaxBodySize = osx.EnvSize("MAX_BODY_SIZE", 4096)
@@ -151,7 +207,7 @@ func ReadBody(h interface {
 	return string(data)
 }
 
-func ReqToJSON(ctx context.Context, h Req, seq int32, src, dest, timestamp string) ([]byte, error) {
+func ReqToJSON(ctx context.Context, h Req, seq int32, src, dest, timestamp, session, hash, source string) ([]byte, error) {
 	bean := ReqBean{
 		Seq:        seq,
 		Src:        src,
@@ -162,6 +218,9 @@ func ReqToJSON(ctx context.Context, h Req, seq int32, src, dest, timestamp strin
 		Method:     h.GetMethod(),
 		Header:     h.GetHeader(),
 		Body:       ReadBody(h),
+		BodyHash:   hash,
+		Session:    session,
+		Source:     source,
 	}
 
 	return ginx.JsoniConfig.Marshal(ctx, bean)
@@ -174,22 +233,48 @@ type RspBean struct {
 
 	Header     http.Header
 	Body       string `json:",clearQuotes"`
+	BodyHash   string `json:",omitempty"`
 	StatusCode int
+	StatusText string
+	Reason     string `json:",omitempty"`
+	Session    string `json:",omitempty"`
+	Source     string `json:",omitempty"`
+	Latency    string `json:",omitempty"`
 }
 
-func RspToJSON(ctx context.Context, h Rsp, seq int32, src, dest, timestamp string) ([]byte, error) {
+// RspToJSON marshals the response to JSON, the latency since the request is omitted if it is 0.
+func RspToJSON(ctx context.Context, h Rsp, seq int32, src, dest, timestamp, session, hash, source string,
+	latency time.Duration,
+) ([]byte, error) {
 	bean := RspBean{
 		Seq:        seq,
 		Src:        src,
 		Dest:       dest,
 		Timestamp:  timestamp,
 		StatusCode: h.GetStatusCode(),
+		StatusText: http.StatusText(h.GetStatusCode()),
+		Reason:     ReasonPhrase(h.GetStatusLine(), h.GetStatusCode()),
 		Header:     h.GetHeader(),
 		Body:       ReadBody(h),
+		BodyHash:   hash,
+		Session:    session,
+		Source:     source,
+	}
+	if latency > 0 {
+		bean.Latency = latency.String()
 	}
 	return ginx.JsoniConfig.Marshal(ctx, bean)
 }
 
+// ReasonPhrase returns the reason phrase of the status line like HTTP/1.1 200 OK or 200 OK as sent,
+// which may differ from the canonical http.StatusText, empty if absent.
+func ReasonPhrase(statusLine string, code int) string {
+	if strings.HasPrefix(statusLine, "HTTP/") {
+		_, statusLine, _ = strings.Cut(statusLine, " ")
+	}
+	return strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(statusLine), strconv.Itoa(code)))
+}
+
 type Rsp interface {
 	GetBody() io.ReadCloser
 	GetStatusLine() string
@@ -203,25 +288,60 @@ type Rsp interface {
 func (h *Base) handleRequest(wg *sync.WaitGroup, c *TCPConnection) {
 	defer wg.Done()
 	defer iox.Close(c.requestStream)
+	defer h.recordConnection()
 
 	rb := &bytes.Buffer{}
 	var method string
+	var lastOne bool // a non-persistent request was dealt, no more transactions follow
+	var started bool // the first payload was checked for the PROXY protocol header
+	var checked bool // the first payload was checked to look like HTTP for Option.HTTPOnly
 
 	for p := range c.requestStream.Packets() {
+		h.reqStream += int64(len(p.Payload))
+		if h.Upgraded() || lastOne {
+			continue // binary h2c frames follow, nothing to parse
+		}
+		if rb.Len() == 0 && h.tunnel.opaque(p.Payload, util.HasRequestTitle) {
+			continue // the tunneled data is not cleartext HTTP
+		}
+
+		payload := p.Payload
+		if !started && len(payload) > 0 {
+			started = true
+			payload = h.stripProxyProtocol(payload)
+		}
+		if h.option.HTTPOnly && !checked && len(payload) > 0 {
+			checked = true
+			if lastOne = !isHTTPStart(payload, true); lastOne {
+				continue // not HTTP, skipped quietly
+			}
+		}
+		if bytes.HasPrefix(payload, h2cPreface) {
+			h.markUpgraded(c.lastReqTimestamp, TagRequest)
+			rb.Reset()
+			continue
+		}
+
 		// 请求开头行解析成功，是一个新的请求
-		m, yes := util.ParseRequestTitle(p.Payload)
+		m, yes := util.ParseRequestTitle(payload)
 		// log.Printf("ParseRequestTitle: method: %s yes: %t payload: %q", m, yes, string(p.Payload))
 		if yes {
 			rb.Reset() // 清空缓冲
 			method = m // 记录请求方法
 		}
 
-		rb.Write(p.Payload)
+		rb.Write(payload)
+		if h.headersTooLarge(rb.Bytes(), TagRequest) {
+			lastOne = true
+			rb.Reset()
+			continue
+		}
 
 		// permitsMethod := h.option.PermitsMethod(method)
 		// http1EndHint := util.Http1EndHint(rb.Bytes())
 		// log.Printf("rb.Len(): %d, permitsMethod: %t, http1EndHint: %t", rb.Len(), permitsMethod, http1EndHint)
 		if rb.Len() > 0 && h.option.PermitsMethod(method) && util.Http1EndHint(rb.Bytes()) && h.LimitAllow() {
+			lastOne = !util.KeepAlive(rb.Bytes()) && method != http.MethodConnect
 			h.dealRequest(rb, h.option, c)
 			rb.Reset()
 		}
@@ -231,7 +351,7 @@ func (h *Base) handleRequest(wg *sync.WaitGroup, c *TCPConnection) {
 		}
 	}
 
-	if rb.Len() > 0 && h.option.PermitsMethod(method) && h.LimitAllow() {
+	if rb.Len() > 0 && !h.Upgraded() && h.option.PermitsMethod(method) && h.LimitAllow() {
 		h.dealRequest(rb, h.option, c)
 	}
 
@@ -245,26 +365,76 @@ func (h *Base) handleResponse(wg *sync.WaitGroup, c *TCPConnection) {
 
 	rb := &bytes.Buffer{}
 	var lastCode int
+	var lastOne bool // a non-persistent response was dealt, no more transactions follow
+	var checked bool // the first payload was checked to look like HTTP for Option.HTTPOnly
+
+	var events *eventStream // the event-stream response streamed, its body never hinted to end
 
 	for p := range c.responseStream.Packets() {
-		if code, yes := util.ParseResponseTitle(p.Payload); yes {
+		h.rspStream += int64(len(p.Payload))
+		if h.Upgraded() || lastOne {
+			continue // binary h2c frames follow, nothing to parse
+		}
+		if events != nil {
+			events.feed(p.Payload, c.lastRspTimestamp)
+			continue
+		}
+		if rb.Len() == 0 && h.tunnel.opaque(p.Payload, isHTTPResponseData) {
+			continue // the tunneled data is not cleartext HTTP
+		}
+		if h.option.HTTPOnly && !checked && len(p.Payload) > 0 {
+			checked = true
+			if lastOne = !isHTTPStart(p.Payload, false); lastOne {
+				continue // not HTTP, skipped quietly
+			}
+		}
+
+		// the body delimited by the connection close may look like a response title
+		untilClose := util.BodyUntilClose(rb.Bytes())
+		if code, yes := util.ParseResponseTitle(p.Payload); yes && !untilClose {
 			rb.Reset() // 清空缓冲
 			lastCode = code
 		}
 
 		rb.Write(p.Payload)
+		if h.headersTooLarge(rb.Bytes(), TagResponse) {
+			lastOne = true
+			rb.Reset()
+			continue
+		}
+
+		// the interim 1xx responses precede the final one
+		for n := util.InformationalLen(rb.Bytes()); n > 0; n = util.InformationalLen(rb.Bytes()) {
+			h.dealInformational(rb.Next(n), c.lastRspTimestamp)
+			lastCode, _ = util.ParseResponseTitle(rb.Bytes())
+		}
+
+		// the events are sent as they arrive, not to wait for the end of the body
+		if h.option.PermitsCode(lastCode) && eventStreamHeaders(rb.Bytes()) &&
+			(util.BodyUntilClose(rb.Bytes()) || !util.Http1EndHint(rb.Bytes())) && h.LimitAllow() {
+			events = h.streamResponse(rb, c)
+			rb.Reset()
+			continue
+		}
 
-		if rb.Len() > 0 && h.option.PermitsCode(lastCode) && util.Http1EndHint(rb.Bytes()) && h.LimitAllow() {
+		// the body delimited by the connection close completes only at the end of the stream,
+		// but the response to CONNECT ends at the headers, the tunneled data follows
+		connect := h.tunnel.State() == tunnelConnect
+		if rb.Len() > 0 && h.option.PermitsCode(lastCode) && (connect || !util.BodyUntilClose(rb.Bytes())) &&
+			util.Http1EndHint(rb.Bytes()) && h.LimitAllow() {
+			lastOne = !connect && !util.KeepAlive(rb.Bytes())
 			h.dealResponse(rb, h.option, c)
 			rb.Reset()
 		}
 
 		if h.option.ReachedN() {
+			events.close()
 			return
 		}
 	}
 
-	if rb.Len() > 0 && h.option.PermitsCode(lastCode) && h.LimitAllow() {
+	events.close()
+	if rb.Len() > 0 && !h.Upgraded() && h.option.PermitsCode(lastCode) && h.LimitAllow() {
 		h.dealResponse(rb, h.option, c)
 	}
 
@@ -273,10 +443,15 @@ func (h *Base) handleResponse(wg *sync.WaitGroup, c *TCPConnection) {
 
 func (h *Base) dealRequest(rb *bytes.Buffer, o *Option, c *TCPConnection) {
 	h.reqBuffer.Reset()
+	raw := rb.Bytes()
+	h.checkSmuggling(TagRequest, raw, c.lastReqTimestamp)
 	if r, err := httpport.ReadRequest(bufio.NewReader(rb)); err != nil {
+		h.flagDesync(TagRequest, err, c.lastReqTimestamp)
 		h.handleError(err, c.lastReqTimestamp, TagRequest)
+		h.hexdumpOnError(err, TagRequest, raw)
 	} else {
-		h.processRequest(false, r, o, c.lastReqTimestamp)
+		start := h.reqStream - int64(len(raw))
+		h.processRequest(false, wireReq{Req: r, wire: wireBodySize(raw), offsets: rawOffsets(start, raw)}, o, c.lastReqTimestamp)
 	}
 }
 
@@ -288,11 +463,56 @@ func (h *Base) dealResponse(rb *bytes.Buffer, o *Option, c *TCPConnection) {
 	}()
 
 	h.rspBuffer.Reset()
+	raw := rb.Bytes()
+	h.warnFramingConflict(raw)
+	h.checkSmuggling(TagResponse, raw, c.lastRspTimestamp)
 	if r, err := httpport.ReadResponse(bufio.NewReader(rb), nil); err != nil {
+		h.flagDesync(TagResponse, err, c.lastRspTimestamp)
 		h.handleError(err, c.lastRspTimestamp, TagResponse)
+		h.hexdumpOnError(err, TagResponse, raw)
 	} else {
-		h.processResponse(false, r, o, c.lastRspTimestamp)
+		start := h.rspStream - int64(len(raw))
+		h.processResponse(false, wireRsp{Rsp: r, wire: wireBodySize(raw), offsets: rawOffsets(start, raw)}, o, c.lastRspTimestamp)
+	}
+}
+
+// dealInformational prints the interim 1xx response, like 100 Continue or 103 Early Hints, if Option.Informational.
+func (h *Base) dealInformational(raw []byte, t time.Time) {
+	if !h.option.Informational {
+		return
+	}
+	if r, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(raw)), nil); err == nil {
+		h.printInformational(r, t)
+	}
+}
+
+// printInformational prints the interim 1xx response as a marker, not counted as a response.
+func (h *Base) printInformational(r *http.Response, t time.Time) {
+	if !h.option.Informational || h.lineOutput() {
+		return
+	}
+
+	b := &bytes.Buffer{}
+	writeFormat(b, "\n### INFO %s %s %s %s\n", h.conn(), h.option.FormatTime(t), r.Proto, r.Status)
+	printHeader(b, r.Header, h.option.SortHeaders)
+	h.sender.Send(b.String(), false)
+}
+
+// printTLS prints the SNI of the TLS ClientHello, or the negotiated version and cipher suite
+// of the TLS ServerHello, as a marker, if Option.TLSSNI.
+func (h *Base) printTLS(record []byte, t time.Time) {
+	if !h.option.TLSSNI || h.lineOutput() {
+		return
 	}
+
+	if version, cipher, ok := util.ParseServerHello(record); ok {
+		h.sender.Send(fmt.Sprintf("\n### TLS version: %s, cipher: %s %s->%s %s\n", tls.VersionName(version),
+			tls.CipherSuiteName(cipher), h.key.Src(), h.key.Dst(), h.option.FormatTime(t)), false)
+		return
+	}
+
+	sni := ss.Or(util.ParseSNI(record), "-")
+	h.sender.Send(fmt.Sprintf("\n### TLS %s %s->%s %s\n", sni, h.key.Src(), h.key.Dst(), h.option.FormatTime(t)), false)
 }
 
 type rrSender struct {
@@ -331,12 +551,42 @@ func (r rrSender) Close() error { return r.OriginSender.Close() }
 
 func (h *Base) processRequest(discard bool, r Req, o *Option, startTime time.Time) {
 	seq := h.reqCounter.Incr()
+	defer h.requests.done(seq)
+	if r.GetMethod() == http.MethodConnect {
+		h.tunnel.connect(r.GetRequestURI())
+	}
 
 	if discard {
 		defer discardAll(r.GetBody())
 	}
 
-	if !o.PermitsReq(r) {
+	if !o.Nth.Contains(seq) || !o.PermitsReq(r) {
+		if o.FastPair > 0 {
+			h.repeated.Store(seq, true) // not to output its response as unpaired
+		}
+		return
+	}
+	defer h.recordBodySize(r, discard, true)
+	o.PcapOut.Match(h.key.Src(), h.key.Dst())
+
+	var body []byte
+	if o.RecordBodies || o.Dedup != nil || o.BodyHash != "" {
+		r, body = bufferReqBody(r, o.MaxBodyBytes)
+	}
+	if o.ReqBodyMatcher != nil {
+		if body == nil {
+			r, body = peekReqBody(r, bodyMatchMax)
+		}
+		if !o.ReqBodyMatcher.Match(r.GetHeader(), body) {
+			h.repeated.Store(seq, true)
+			return
+		}
+	}
+	h.startTransaction(r, seq, startTime, body)
+	hash := bodyHash(o.BodyHash, body)
+	h.path.Store(r.GetPath())
+	session := h.requestSession(r.GetHeader(), seq)
+	if h.dedupRequest(r, seq, startTime, body) {
 		return
 	}
 
@@ -346,18 +596,46 @@ func (h *Base) processRequest(discard bool, r Req, o *Option, startTime time.Tim
 		sender = &rrSender{OriginSender: h.sender, key: key, cache: h.cache, Req: true}
 	}
 
-	if h.usingJSON {
-		data, err := ReqToJSON(h.Context, r, seq, h.key.Src(), h.key.Dst(), startTime.Format(time.RFC3339Nano))
+	if o.Format == FormatRaw {
+		sender.Send(h.rawRequest(r, seq, startTime), true)
+	} else if o.Format != "" {
+		return // output along with the response
+	} else if o.FastPair > 0 {
+		h.sendPair(nil, startTime) // output along with the response
+	} else if h.usingJSON {
+		h.reqTimes.Store(seq, startTime)
+		data, err := ReqToJSON(h.Context, r, seq, h.key.Src(), h.key.Dst(), h.option.FormatTime(startTime), session, hash, h.source)
 		if err != nil {
 			log.Printf("req to JSON  failed: %v", err)
 		}
-		sender.Send(string(data)+"\n", true)
+		sender.Send(string(selectJSONFields(data, o.JSONFields))+"\n", true)
 	} else {
-		h.printRequest(r, startTime, seq)
+		h.printRequest(r, startTime, seq, sessionField(session)+h.correlateField(r.GetHeader())+h.clientField()+
+			h.sourceField()+bodyHashField(hash))
+		h.writeOffsets(&h.reqBuffer, r)
 		sender.Send(h.reqBuffer.String(), true)
 	}
 }
 
+// dedupRequest reports whether the request repeats a recent one and should be suppressed,
+// sending the markers of the ended repetitions.
+func (h *Base) dedupRequest(r Req, seq int32, startTime time.Time, body []byte) bool {
+	if h.option.Dedup == nil {
+		return false
+	}
+
+	fingerprint := Fingerprint(r.GetMethod(), r.GetHost(), r.GetRequestURI(), body)
+	title := r.GetMethod() + " " + h.absoluteURL(r.GetHost(), r.GetRequestURI())
+	repeated, markers := h.option.Dedup.Seen(fingerprint, title, startTime)
+	for _, marker := range markers {
+		h.sender.Send(marker, false)
+	}
+	if repeated {
+		h.repeated.Store(seq, true)
+	}
+	return repeated
+}
+
 type SendArgs struct {
 	Msg                string
 	CountDiscards, Req bool
@@ -366,10 +644,45 @@ type SendArgs struct {
 
 func (h *Base) processResponse(discard bool, r Rsp, o *Option, endTime time.Time) {
 	seq := h.rspCounter.Incr()
+	h.awaitRequest(seq)
+	var latency time.Duration
+	if startTime, ok := h.reqTimes.LoadAndDelete(seq); ok {
+		latency = endTime.Sub(startTime.(time.Time))
+	}
 	if discard {
 		defer discardAll(r.GetBody())
 	}
 
+	if r.GetStatusCode() == http.StatusSwitchingProtocols && isH2cUpgrade(r.GetHeader()) {
+		defer h.markUpgraded(endTime, TagResponse)
+	}
+	if h.tunnel.established(r.GetStatusCode()) {
+		defer h.printTunnel(endTime)
+	}
+
+	var body []byte
+	if o.RecordBodies || o.BodyHash != "" && !isEventStream(r.GetHeader()) {
+		r, body = bufferRspBody(r, o.MaxBodyBytes)
+	}
+	t := h.finishTransaction(r, seq, endTime, body)
+	hash := bodyHash(o.BodyHash, body)
+	defer h.recordBodySize(r, discard, false)
+	session := h.responseSession(r.GetHeader(), seq)
+	if _, repeated := h.repeated.LoadAndDelete(seq); repeated || !o.Nth.Contains(seq) {
+		return
+	}
+	if !o.RspHeaderMatcher.Match(r.GetHeader()) {
+		return
+	}
+	if o.RspBodyMatcher != nil {
+		if body == nil {
+			r, body = peekRspBody(r, bodyMatchMax)
+		}
+		if !o.RspBodyMatcher.Match(r.GetHeader(), body) {
+			return
+		}
+	}
+
 	if !o.PermitRatio() {
 		return
 	}
@@ -380,79 +693,118 @@ func (h *Base) processResponse(discard bool, r Rsp, o *Option, endTime time.Time
 		sender = &rrSender{OriginSender: h.sender, cache: h.cache, key: key}
 	}
 
-	if h.usingJSON {
-		data, err := RspToJSON(h.Context, r, seq, h.key.Src(), h.key.Dst(), endTime.Format(time.RFC3339Nano))
+	if o.Format == FormatRaw {
+		sender.Send(h.rawResponse(r, seq, endTime), true)
+	} else if o.Format != "" {
+		if t != nil {
+			client, _ := h.client.Load().(string)
+			sender.Send(o.AccessLogLine(t, ss.Or(client, t.Src), discardAll(r.GetBody()))+"\n", true)
+		}
+	} else if o.FastPair > 0 {
+		if t == nil {
+			t = &Transaction{Seq: seq, Src: h.key.Src(), Dst: h.key.Dst(), Status: r.GetStatusCode(), End: endTime}
+		}
+		h.sendPair(t, endTime)
+	} else if h.usingJSON {
+		data, err := RspToJSON(h.Context, r, seq, h.key.Src(), h.key.Dst(), h.option.FormatTime(endTime), session, hash, h.source, latency)
 		if err != nil {
 			log.Printf("req to JSON  failed: %v", err)
 		}
 
-		sender.Send(string(data)+"\n", true)
+		sender.Send(string(selectJSONFields(data, o.JSONFields))+"\n", true)
 	} else {
-		h.printResponse(r, endTime, seq)
+		h.printResponse(r, endTime, seq, sessionField(session)+h.correlateField(r.GetHeader())+h.clientField()+
+			h.sourceField()+streamingField(r.GetHeader())+bodyHashField(hash))
+		if !isEventStream(r.GetHeader()) {
+			h.writeOffsets(&h.rspBuffer, r) // not to wait for the never-ending events
+		}
 		sender.Send(h.rspBuffer.String(), true)
+		if isEventStream(r.GetHeader()) && !ss.AnyOf(o.Level, LevelUrl, LevelHeader) {
+			h.streamEvents(sender, r.GetHeader(), r.GetBody(), seq)
+		}
 	}
 }
 
 // print http request
-func (h *Base) printRequest(r Req, startTime time.Time, seq int32) {
+func (h *Base) printRequest(r Req, startTime time.Time, seq int32, tags string) {
 	b := &h.reqBuffer
-	writeLine(b, fmt.Sprintf("\n### #%d REQ %s-%s %s",
-		seq, h.key.Src(), h.key.Dst(), startTime.Format(time.RFC3339Nano)))
-
 	o := h.option
+	if o.Level == LevelBody {
+		writeLine(b, fmt.Sprintf("\n### #%d %s %s", seq, r.GetMethod(), h.absoluteURL(r.GetHost(), r.GetRequestURI())))
+	} else {
+		writeLine(b, fmt.Sprintf("\n### #%d REQ %s %s%s", seq, h.conn(), h.option.FormatTime(startTime), tags))
+	}
+	writeMatched(b, o.explainReq, o)
+
 	if ss.AnyOf(o.Level, LevelUrl) {
-		writeFormat(b, "%s %s\r\n", r.GetMethod(), r.GetHost()+r.GetPath())
+		writeFormat(b, "%s %s\r\n", r.GetMethod(), h.absoluteURL(r.GetHost(), r.GetPath()))
 		return
 	}
 
-	writeFormat(b, "%s %s %s\r\n", r.GetMethod(), r.GetRequestURI(), r.GetProto())
 	header := r.GetHeader()
 	contentLength := parseContentLength(r.GetContentLength(), header)
-	header["Content-Length"] = []string{fmt.Sprintf("%d", contentLength)}
-	printHeader(b, header)
-	writeBytes(b, []byte("\r\n"))
+	if o.Level != LevelBody {
+		writeFormat(b, "%s %s %s\r\n", r.GetMethod(), r.GetRequestURI(), r.GetProto())
+		header["Content-Length"] = []string{fmt.Sprintf("%d", contentLength)}
+		printHeader(b, header, o.SortHeaders)
+		writeBytes(b, []byte("\r\n"))
+	}
 
-	hasBody := contentLength != 0 && !ss.AnyOf(r.GetMethod(), "CONNECT", "GET", "HEAD", "TRACE", "OPTIONS")
+	hasBody := contentLength != 0 && o.MethodHasBody(r.GetMethod())
+
+	if boundary := multipartBoundary(header); hasBody && o.DumpMultipart != "" && boundary != "" {
+		nr, _ := util.TryDecompress(header, r.GetBody())
+		if err := dumpMultipart(b, nr, boundary, o); err != nil {
+			writeLine(b, "dump multipart failed:", err)
+		}
+		return
+	}
 
 	if hasBody && o.CanDump() {
 		fn := bodyFileName(o.DumpBody, seq, "REQ", startTime)
 		if n, err := DumpBody(r.GetBody(), fn, &o.dumpNum); err != nil {
 			writeLine(b, "dump to file failed:", err)
 		} else if n > 0 {
-			writeLine(b, "\n// dump body to file:", fn, "size:", n)
+			writeFormat(b, "\n// dump body to file: %s size: %d\r\n", fn, n)
 		}
 		return
 	}
 
 	if o.Level == LevelHeader {
 		if hasBody {
-			writeLine(b, "\n// body size:", discardAll(r.GetBody()), ", set [level = all] to display http body")
+			writeBodySize(b, header, r, o.BodyPreview)
 		}
 		return
 	}
 
 	if hasBody {
-		h.printBody(b, header, r.GetBody())
+		h.printBody(b, header, r.GetBody(), r.GetPath(), true)
 	}
 }
 
 // print http response
-func (h *Base) printResponse(r Rsp, endTime time.Time, seq int32) {
+func (h *Base) printResponse(r Rsp, endTime time.Time, seq int32, tags string) {
 	b := &h.rspBuffer
-
-	writeLine(b, fmt.Sprintf("\n### #%d RSP %s-%s %s",
-		seq, h.key.Src(), h.key.Dst(), endTime.Format(time.RFC3339Nano)))
-
-	writeLine(b, r.GetStatusLine())
 	o := h.option
+	if o.Level == LevelBody {
+		writeLine(b, fmt.Sprintf("\n### #%d %s", seq, r.GetStatusLine()))
+		writeMatched(b, o.explainRsp, o)
+	} else {
+		writeLine(b, fmt.Sprintf("\n### #%d RSP %s %s%s", seq, h.conn(), h.option.FormatTime(endTime), tags))
+		writeMatched(b, o.explainRsp, o)
+		writeLine(b, r.GetStatusLine())
+	}
+
 	if o.Level == LevelUrl {
 		return
 	}
 
-	for _, header := range r.GetRawHeaders() {
-		writeLine(b, header)
+	if o.Level != LevelBody {
+		for _, header := range r.GetRawHeaders() {
+			writeLine(b, header)
+		}
+		writeBytes(b, []byte("\r\n"))
 	}
-	writeBytes(b, []byte("\r\n"))
 
 	contentLength := parseContentLength(r.GetContentLength(), r.GetHeader())
 	hasBody := contentLength > 0 && r.GetStatusCode() != 304 && r.GetStatusCode() != 204
@@ -462,21 +814,30 @@ func (h *Base) printResponse(r Rsp, endTime time.Time, seq int32) {
 		if n, err := DumpBody(r.GetBody(), fn, &o.dumpNum); err != nil {
 			writeLine(b, "dump to file failed:", err)
 		} else if n > 0 {
-			writeLine(b, "\n// dump body to file:", fn, "size:", n)
+			writeFormat(b, "\n// dump body to file: %s size: %d\r\n", fn, n)
 		}
 		return
 	}
 
 	if o.Level == LevelHeader {
 		if hasBody {
-			writeLine(b, "\n// body size:", discardAll(r.GetBody()), ", set [level = all] to display http body")
+			writeBodySize(b, r.GetHeader(), r, o.BodyPreview)
 		}
 		return
 	}
 
 	if hasBody {
-		h.printBody(b, r.GetHeader(), r.GetBody())
+		h.printBody(b, r.GetHeader(), r.GetBody(), h.lastPath(), false)
+	}
+}
+
+// absoluteURL reconstructs the absolute url with the scheme assumed from the destination,
+// the authority of CONNECT, like example.com:443, is kept as is.
+func (h *Base) absoluteURL(host, uri string) string {
+	if uri != "" && uri[0] != '/' && uri != "*" {
+		return uri
 	}
+	return h.option.SchemeOf(h.key.Dst()) + "://" + host + uri
 }
 
 func parseContentLength(cl int64, header http.Header) int64 {
@@ -533,7 +894,8 @@ func ReadTextBody(header http.Header, reader io.ReadCloser, limitSize int64) (Mi
 }
 
 // print http request/response body
-func (h *Base) printBody(b *bytes.Buffer, header http.Header, reader io.ReadCloser) {
+// path is the request path, to find the grpc method for decoding protobuf bodies.
+func (h *Base) printBody(b *bytes.Buffer, header http.Header, reader io.ReadCloser, path string, isRequest bool) {
 	// deal with content encoding such as gzip, deflate
 	nr, decompressed := util.TryDecompress(header, reader)
 	if decompressed {
@@ -542,37 +904,63 @@ func (h *Base) printBody(b *bytes.Buffer, header http.Header, reader io.ReadClos
 
 	// check mime type and charset
 	contentType := header.Get("Content-Type")
-	mimeTypeStr, charset := ParseContentType(contentType)
-	if mt := ParseMimeType(mimeTypeStr); !mt.isTextContent() {
-		if err := h.printNonTextTypeBody(b, nr, contentType, mt.isBinaryContent()); err != nil {
+	var content io.Reader = nr
+	if contentType == "" {
+		content, contentType = SniffContentType(nr)
+	}
+	if isProtobuf, _ := protobufContent(contentType); isProtobuf {
+		data, err := io.ReadAll(content)
+		if err != nil {
 			writeLine(b, "{Read content error", err, "}")
+			return
 		}
+		writeLine(b, h.option.Proto.decodeProtobufBody(data, contentType, path, isRequest))
 		return
 	}
 
-	var (
-		err  error
-		body []byte
-	)
-
-	if charset == "" {
-		body, err = io.ReadAll(nr)
-	} else {
-		body, err = ReadWithCharset(nr, charset)
+	mimeTypeStr, charset := ParseContentType(contentType)
+	if !h.option.IsTextType(mimeTypeStr) {
+		if err := h.printNonTextTypeBody(b, content, contentType, h.option.IsBinaryType(mimeTypeStr)); err != nil {
+			writeLine(b, "{Read content error", err, "}")
+		}
+		return
 	}
+
+	data, err := io.ReadAll(content)
 	if err != nil {
 		writeLine(b, "{Read body failed", err, "}")
 		return
 	}
 
+	body, err := DecodeCharset(data, ss.Or(charset, bomCharset(data)))
+	if err != nil { // unknown charset, raw if it looks like UTF-8, else hex
+		writeFormat(b, "{Unknown charset %s, len: %d}\n", charset, len(data))
+		if body = data; !utf8.Valid(data) {
+			body = []byte(hex.Dump(data))
+		}
+	}
+
+	if h.option.DecodeForm && isFormContent(mimeTypeStr) {
+		if fields, ok := decodeForm(body); ok {
+			body = fields
+		}
+	}
+
 	if l := len(body); l > 0 {
 		writeBytes(b, body)
 	}
 }
 
 func (h *Base) printNonTextTypeBody(b *bytes.Buffer, reader io.Reader, contentType string, isBinary bool) error {
-	if h.option.Force || !isBinary {
-		data, err := ioutil.ReadAll(reader)
+	if h.option.Hex && isBinary {
+		data, err := io.ReadAll(reader)
+		if err != nil {
+			return err
+		}
+		writeLine(b, "{Binary body, content-type:", contentType, ", len:", len(data), "}")
+		writeBytes(b, []byte(hex.Dump(data)))
+	} else if h.option.Force || !isBinary {
+		data, err := io.ReadAll(reader)
 		if err != nil {
 			return err
 		}
@@ -590,6 +978,93 @@ func discardAll(r io.Reader) int64 {
 	return n
 }
 
+// WireSizer reports the size of the body on the wire, before dechunking.
+type WireSizer interface {
+	WireBodySize() int64
+}
+
+// wireBodySizeOf returns the body size on the wire of the request or response wrapped, or -1 if unknown.
+func wireBodySizeOf(r any) int64 {
+	if ws, ok := r.(WireSizer); ok {
+		return ws.WireBodySize()
+	}
+	return -1
+}
+
+// writeBodySize writes the body size as received, dechunked but not decompressed, the decompressed size
+// if the body is encoded, and the wire size if it differs for the chunked body,
+// with the preview of the first bytes of the decompressed body if preview > 0.
+func writeBodySize(b *bytes.Buffer, header http.Header, r interface{ GetBody() io.ReadCloser }, preview int) {
+	raw := &countingReader{Reader: r.GetBody()}
+	body := io.NopCloser(raw)
+	content, decompressed := util.TryDecompress(header.Clone(), body)
+	if decompressed = decompressed && content != body; !decompressed {
+		content = body
+	}
+	head := make([]byte, max(preview, 0))
+	n, _ := io.ReadFull(content, head)
+	decoded := int64(n) + discardAll(content)
+	discardAll(body) // the rest not decompressed
+
+	line := []any{"\n// body size:", raw.n}
+	if decompressed {
+		line = append(line, ", decompressed size:", decoded)
+	}
+	if wire := wireBodySizeOf(r); wire >= 0 && wire != raw.n {
+		line = append(line, ", wire size:", wire)
+	}
+	writeLine(b, append(line, ", set [level = all] to display http body")...)
+	if n > 0 {
+		writeBodyPreview(b, head[:n], int64(n) < decoded)
+	}
+}
+
+// writeBodyPreview writes the first bytes of the body, as the text if printable, or else in hex.
+func writeBodyPreview(b *bytes.Buffer, head []byte, truncated bool) {
+	ellipsis := ss.If(truncated, "...", "")
+	// the last rune may be cut off by the preview
+	for i := len(head) - 1; i >= 0 && i >= len(head)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(head[i]) {
+			if i > 0 && !utf8.FullRune(head[i:]) {
+				head = head[:i]
+			}
+			break
+		}
+	}
+	if utf8.Valid(head) && !bytes.ContainsFunc(head, func(r rune) bool { return r < 0x20 && !unicode.IsSpace(r) }) {
+		writeLine(b, "// body preview: ", string(head), ellipsis)
+		return
+	}
+	writeFormat(b, "// body preview (binary): % x%s\r\n", head, ellipsis)
+}
+
+// wireReq and wireRsp are the request and response with the body size on the wire,
+// and the offsets of the headers in the stream.
+type wireReq struct {
+	Req
+	wire    int64
+	offsets streamOffsets
+}
+
+type wireRsp struct {
+	Rsp
+	wire    int64
+	offsets streamOffsets
+}
+
+func (r wireReq) WireBodySize() int64               { return r.wire }
+func (r wireRsp) WireBodySize() int64               { return r.wire }
+func (r wireReq) StreamOffsets() (start, end int64) { return r.offsets.start, r.offsets.end }
+func (r wireRsp) StreamOffsets() (start, end int64) { return r.offsets.start, r.offsets.end }
+
+// wireBodySize returns the body size of the raw http message, or -1 if the headers are incomplete.
+func wireBodySize(raw []byte) int64 {
+	if pos := util.MIMEHeadersEndPos(raw); pos >= 0 {
+		return int64(len(raw) - pos)
+	}
+	return -1
+}
+
 func bodyFileName(prefix string, seq int32, req string, t time.Time) string {
 	return fmt.Sprintf("%s.%s.%d.%s", prefix, t.Format("20060102"), seq, req)
 }
@@ -606,7 +1081,7 @@ func isEOF(e error) bool {
 }
 
 func (h *Base) handleError(err error, t time.Time, tag Tag) {
-	if h.usingJSON {
+	if h.lineOutput() {
 		return
 	}
 
@@ -616,20 +1091,120 @@ func (h *Base) handleError(err error, t time.Time, tag Tag) {
 	} else {
 		seq = h.rspCounter.Get()
 	}
-	k := h.key
-	tim := t.Format(time.RFC3339Nano)
+	tim := h.option.FormatTime(t)
 	if isEOF(err) {
 		if h.option.Eof {
-			msg := fmt.Sprintf("\n### EOF#%d %s %s-%s %s", seq, tag, k.Src(), k.Dst(), tim)
+			msg := fmt.Sprintf("\n### EOF#%d %s %s %s", seq, tag, h.conn(), tim)
 			h.sender.Send(msg, false)
 		}
 	} else {
-		msg := fmt.Sprintf("\n### ERR#%d %s %s-%s %s, error: %v", seq, tag, k.Src(), k.Dst(), tim, err)
+		msg := fmt.Sprintf("\n### ERR#%d %s %s %s, error: %v", seq, tag, h.conn(), tim, err)
 		h.sender.Send(msg, false)
 		_, _ = fmt.Fprintf(os.Stderr, "error parsing HTTP %s, error: %v\n", tag, err)
 	}
 }
 
+// hexdumpOnError sends the hex+ASCII dump of the leading payload bytes, at most Option.HexdumpOnError,
+// when the parsing failed with a non-EOF error, to diagnose what was on the wire.
+func (h *Base) hexdumpOnError(err error, tag Tag, payload []byte) {
+	if h.option.HexdumpOnError <= 0 || isEOF(err) || len(payload) == 0 || h.lineOutput() {
+		return
+	}
+
+	n := min(len(payload), h.option.HexdumpOnError)
+	msg := fmt.Sprintf("\n### HEXDUMP %s %s %d of %d bytes\n%s", tag, h.conn(), n, len(payload), hex.Dump(payload[:n]))
+	h.sender.Send(msg, false)
+}
+
+// correlateField returns the title field of the Option.CorrelateHeader value,
+// to join the requests and responses printed independently in fast mode.
+func (h *Base) correlateField(header http.Header) string {
+	if h.option.CorrelateHeader == "" {
+		return ""
+	}
+	if v := header.Get(h.option.CorrelateHeader); v != "" {
+		return " correlate:" + v
+	}
+	return ""
+}
+
+// stripProxyProtocol strips the PROXY protocol header at the start of the connection,
+// and keeps the original client address in it for display.
+func (h *Base) stripProxyProtocol(payload []byte) []byte {
+	if !util.HasProxyProtocol(payload) {
+		return payload
+	}
+
+	n, client := util.ParseProxyProtocol(payload)
+	h.storeClient(client)
+	return payload[n:]
+}
+
+// storeClient keeps the original client address from the PROXY protocol header, shared by the both directions.
+func (h *Base) storeClient(client string) {
+	if client != "" {
+		h.client.Store(client)
+	}
+}
+
+// clientField returns the title field of the original client address from the PROXY protocol header.
+func (h *Base) clientField() string {
+	if client, _ := h.client.Load().(string); client != "" {
+		return " client:" + client
+	}
+	return ""
+}
+
+func (h *Base) sourceField() string {
+	if h.source != "" {
+		return " source:" + h.source
+	}
+	return ""
+}
+
+// conn formats the connection of the key by Option.ConnFormat.
+func (h *Base) conn() string { return h.option.FormatConn(h.key.Src(), h.key.Dst()) }
+
+// lastPath returns the path of the last request on the connection.
+func (h *Base) lastPath() string {
+	p, _ := h.path.Load().(string)
+	return p
+}
+
+// recordConnection records the number of requests carried by the connection into the stats.
+func (h *Base) recordConnection() {
+	requests := int(h.reqCounter.Get())
+	h.record(func() { h.option.Stats.AddConnection(requests) })
+}
+
+// h2cPreface is the HTTP/2 connection preface sent by the client once the h2c upgrade is accepted.
+var h2cPreface = []byte("PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n")
+
+// isH2cUpgrade tells if the header requests or confirms an upgrade to cleartext HTTP/2.
+func isH2cUpgrade(header http.Header) bool {
+	return strings.EqualFold(strings.TrimSpace(header.Get("Upgrade")), "h2c")
+}
+
+// Upgraded tells if the connection has been upgraded to h2c, then only binary data follows.
+func (h *Base) Upgraded() bool { return atomic.LoadInt32(&h.upgraded) == 1 }
+
+// markUpgraded marks the connection upgraded to h2c, and outputs a notice only once.
+func (h *Base) markUpgraded(t time.Time, tag Tag) {
+	if !atomic.CompareAndSwapInt32(&h.upgraded, 0, 1) || h.lineOutput() {
+		return
+	}
+
+	var seq int32
+	if tag == TagRequest {
+		seq = h.reqCounter.Get()
+	} else {
+		seq = h.rspCounter.Get()
+	}
+	msg := fmt.Sprintf("\n### UPGRADE#%d %s %s %s, upgraded to h2c, binary data follows",
+		seq, tag, h.conn(), h.option.FormatTime(t))
+	h.sender.Send(msg, false)
+}
+
 func (h *Base) LimitAllow() bool {
 	l := h.option.RateLimiter
 	return l == nil || l.Allow()
@@ -0,0 +1,279 @@
+package handler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type bodyOnly struct{ body string }
+
+func (r bodyOnly) GetBody() io.ReadCloser { return io.NopCloser(strings.NewReader(r.body)) }
+
+func TestWriteBodySizePreview(t *testing.T) {
+	b := &bytes.Buffer{}
+	writeBodySize(b, http.Header{}, bodyOnly{body: `{"name":"bingoo","age":10}`}, 8)
+	assert.Equal(t, "\n// body size:26, set [level = all] to display http body\r\n// body preview: {\"name\":...\r\n", b.String())
+
+	b.Reset()
+	writeBodySize(b, http.Header{}, bodyOnly{body: "a=1"}, 8)
+	assert.Equal(t, "\n// body size:3, set [level = all] to display http body\r\n// body preview: a=1\r\n", b.String())
+
+	b.Reset()
+	writeBodySize(b, http.Header{}, bodyOnly{body: "\x89PNG\r\n\x1a\n\x00"}, 4)
+	assert.Contains(t, b.String(), "// body preview (binary): 89 50 4e 47...\r\n")
+
+	b.Reset()
+	writeBodySize(b, http.Header{}, bodyOnly{body: "中文"}, 4) // the second rune is cut off
+	assert.Contains(t, b.String(), "// body preview: 中...\r\n")
+
+	b.Reset()
+	writeBodySize(b, http.Header{}, bodyOnly{body: "a=1"}, 0)
+	assert.NotContains(t, b.String(), "preview")
+}
+
+// wireBody is the body with its size on the wire.
+type wireBody struct {
+	bodyOnly
+	wire int64
+}
+
+func (r wireBody) WireBodySize() int64 { return r.wire }
+
+func TestWriteBodySizes(t *testing.T) {
+	gz := &bytes.Buffer{}
+	w := gzip.NewWriter(gz)
+	_, _ = w.Write([]byte(strings.Repeat("a", 100)))
+	_ = w.Close()
+	gzipped := http.Header{"Content-Encoding": {"gzip"}}
+
+	for _, tc := range []struct {
+		name   string
+		header http.Header
+		r      interface{ GetBody() io.ReadCloser }
+		want   string
+	}{
+		{"plain", http.Header{}, bodyOnly{body: "abc"}, "// body size:3, set"},
+		{"chunked", http.Header{}, wireBody{bodyOnly{body: "abc"}, 13}, "// body size:3, wire size:13, set"},
+		{"same wire", http.Header{}, wireBody{bodyOnly{body: "abc"}, 3}, "// body size:3, set"},
+		{"gzip", gzipped, bodyOnly{body: gz.String()},
+			"// body size:" + strconv.Itoa(gz.Len()) + ", decompressed size:100, set"},
+		{"gzip chunked", gzipped, wireBody{bodyOnly{body: gz.String()}, int64(gz.Len() + 10)},
+			"// body size:" + strconv.Itoa(gz.Len()) + ", decompressed size:100, wire size:" + strconv.Itoa(gz.Len()+10) + ", set"},
+		{"bad gzip", gzipped, bodyOnly{body: "abc"}, "// body size:3, set"},
+		{"unsupported", http.Header{"Content-Encoding": {"br"}}, bodyOnly{body: "abc"}, "// body size:3, set"},
+		{"empty", http.Header{}, bodyOnly{}, "// body size:0, set"},
+	} {
+		b := &bytes.Buffer{}
+		writeBodySize(b, tc.header, tc.r, 0)
+		assert.Contains(t, b.String(), tc.want, tc.name)
+	}
+
+	b := &bytes.Buffer{}
+	writeBodySize(b, gzipped, bodyOnly{body: gz.String()}, 4) // the preview is decompressed
+	assert.Contains(t, b.String(), "// body preview: aaaa...\r\n")
+}
+
+func TestPrintBodyCharset(t *testing.T) {
+	h := &Base{option: &Option{}}
+	print := func(contentType, body string) string {
+		b := &bytes.Buffer{}
+		h.printBody(b, http.Header{"Content-Type": {contentType}}, io.NopCloser(strings.NewReader(body)), "/", false)
+		return b.String()
+	}
+
+	assert.Equal(t, "hi中", print("text/plain; charset=utf-16le", "h\x00i\x00\x2d\x4e"))
+	assert.Equal(t, "hi", print("text/plain", "\xff\xfeh\x00i\x00")) // by the BOM
+	assert.Equal(t, "{Unknown charset x-nope, len: 2}\nhi", print("text/plain; charset=x-nope", "hi"))
+	assert.Contains(t, print("text/plain; charset=x-nope", "\xff\x00"), "{Unknown charset x-nope, len: 2}\n00000000  ff 00")
+}
+
+func TestPrintBodyDecodeForm(t *testing.T) {
+	h := &Base{option: &Option{DecodeForm: true}}
+	print := func(contentType, body string) string {
+		b := &bytes.Buffer{}
+		h.printBody(b, http.Header{"Content-Type": {contentType}}, io.NopCloser(strings.NewReader(body)), "/", true)
+		return b.String()
+	}
+
+	assert.Equal(t, "name: Tom Li\nq: a&b=c\nempty: \nflag: \n",
+		print("application/x-www-form-urlencoded", "name=Tom+Li&q=a%26b%3Dc&empty=&flag"))
+	assert.Equal(t, "a=%zz", print("application/x-www-form-urlencoded; charset=utf-8", "a=%zz")) // raw on failure
+	assert.Equal(t, "a=1&b=2", print("text/plain", "a=1&b=2"))
+
+	h.option.DecodeForm = false
+	assert.Equal(t, "a=1&b=2", print("application/x-www-form-urlencoded", "a=1&b=2"))
+}
+
+func TestPrintBodyHex(t *testing.T) {
+	h := &Base{option: &Option{Hex: true}}
+	print := func(contentType, body string) string {
+		b := &bytes.Buffer{}
+		h.printBody(b, http.Header{"Content-Type": {contentType}}, io.NopCloser(strings.NewReader(body)), "/", false)
+		return b.String()
+	}
+
+	assert.Equal(t, "{Binary body, content-type:application/octet-stream, len:3}\r\n"+
+		"00000000  00 41 ff                                          |.A.|\n",
+		print("application/octet-stream", "\x00A\xff"))
+	assert.Equal(t, "{Binary body, content-type:application/octet-stream, len:0}\r\n", print("application/octet-stream", ""))
+	assert.Equal(t, "a=1", print("text/plain", "a=1")) // the text bodies kept
+
+	h.option.Hex = false
+	assert.Equal(t, "{Non-text body, content-type:application/octet-stream, len:3}\r\n", print("application/octet-stream", "\x00A\xff"))
+}
+
+func TestProxyProtocolStd(t *testing.T) {
+	tlvs := append([]byte{0x04, 0x02, 0x58}, bytes.Repeat([]byte{'x'}, 600)...) // a TLV longer than a peek
+	v2 := append([]byte("\r\n\r\n\x00\r\nQUIT\n"), 0x21, 0x11, byte((12+len(tlvs))>>8), byte(12+len(tlvs)),
+		192, 168, 0, 1, 10, 0, 0, 1, 0xdc, 0x04, 0, 80)
+	v2 = append(v2, tlvs...)
+
+	c := newTestConn(&Option{SrcRatio: 1, Resp: 1})
+	c.requests(string(v2) + "GET /a HTTP/1.1\r\nHost: a.b\r\n\r\n")
+	c.responses("HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n")
+
+	msgs := c.messages()
+	if assert.Len(t, msgs, 2) {
+		assert.Contains(t, msgs[0], "client:192.168.0.1:56324\r\nGET /a HTTP/1.1\r\n")
+		assert.Contains(t, msgs[1], "client:192.168.0.1:56324") // shared with the response stream
+	}
+}
+
+func TestH2cUpgradeStd(t *testing.T) {
+	c := newTestConn(&Option{SrcRatio: 1, Resp: 1})
+	c.requests("GET /a HTTP/1.1\r\nHost: a.b\r\nConnection: Upgrade, HTTP2-Settings\r\nUpgrade: h2c\r\n\r\n" +
+		"PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n\x00\x00\x00\x04\x00\x00\x00\x00\x00")
+	c.responses("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: h2c\r\n\r\n" +
+		"\x00\x00\x00\x04\x01\x00\x00\x00\x00")
+
+	out := c.output()
+	assert.Contains(t, out, "GET /a HTTP/1.1\r\n")
+	assert.Contains(t, out, "\r\n101 Switching Protocols\r\n")
+	assert.Contains(t, out, "\n### UPGRADE#1 REQ 127.0.0.1:5000-127.0.0.2:8080 ")
+	assert.Contains(t, out, ", upgraded to h2c, binary data follows")
+	assert.Equal(t, 1, strings.Count(out, "### UPGRADE#"))
+	assert.NotContains(t, out, "PRI * HTTP/2.0")
+	assert.NotContains(t, out, "### ERR#")
+}
+
+func TestLevelBodyStd(t *testing.T) {
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	_, _ = w.Write([]byte(`{"id":1}`))
+	_ = w.Close()
+
+	c := newTestConn(&Option{SrcRatio: 1, Resp: 1, Level: LevelBody})
+	c.requests("POST /a?b=1 HTTP/1.1\r\nHost: a.b\r\nContent-Type: text/plain\r\nContent-Length: 4\r\n\r\nping")
+	c.responses("HTTP/1.1 200 OK\r\nContent-Type: application/json\r\nContent-Encoding: gzip\r\n" +
+		"Content-Length: " + strconv.Itoa(gz.Len()) + "\r\n\r\n" + gz.String())
+
+	// the title lines and the bodies, decoded, without the headers
+	assert.Equal(t, "\n### #1 POST http://a.b/a?b=1\r\nping"+"\n### #1 200 OK\r\n{\"id\":1}", c.output())
+}
+
+func TestLevelUrlSchemeStd(t *testing.T) {
+	for scheme, expected := range map[string]string{"": "http", "https": "https"} {
+		c := newTestConn(&Option{SrcRatio: 1, Level: LevelUrl, AssumeScheme: scheme})
+		c.requests("GET /a?b=1 HTTP/1.1\r\nHost: a.b\r\n\r\n")
+		assert.Contains(t, c.output(), "\r\nGET "+expected+"://a.b/a\r\n", scheme)
+	}
+
+	// https by the port 443
+	c := newTestConn(&Option{SrcRatio: 1, Level: LevelUrl})
+	(&Factory{}).run(c.base(testClient, Endpoint{ip: "127.0.0.2", port: 443}), strings.NewReader("GET /a HTTP/1.1\r\nHost: a.b\r\n\r\n"))
+	assert.Contains(t, c.output(), "\r\nGET https://a.b/a\r\n")
+}
+
+func TestSortHeadersStd(t *testing.T) {
+	c := newTestConn(&Option{SrcRatio: 1, SortHeaders: true})
+	c.requests("GET /a HTTP/1.1\r\nHost: a.b\r\nX-Zeta: 1\r\naccept: */*\r\nUser-Agent: t\r\nX-Alpha: 2\r\n\r\n")
+
+	assert.Contains(t, c.output(), "GET /a HTTP/1.1\r\n"+
+		"Accept: */*\r\nContent-Length: 0\r\nUser-Agent: t\r\nX-Alpha: 2\r\nX-Zeta: 1\r\n\r\n")
+}
+
+func TestBodyMethodsStd(t *testing.T) {
+	cases := []struct {
+		name             string
+		option           Option
+		getBody, optBody bool
+	}{
+		{name: "default"},
+		{name: "body methods", option: Option{BodyMethods: "get"}, getBody: true},
+		{name: "always read body", option: Option{AlwaysReadBody: true}, getBody: true, optBody: true},
+	}
+	for _, tc := range cases {
+		o := tc.option
+		o.SrcRatio, o.Level = 1, "all"
+		c := newTestConn(&o)
+		c.requests("GET /a HTTP/1.1\r\nHost: a.b\r\nContent-Type: text/plain\r\nContent-Length: 7\r\n\r\nget-one" +
+			"OPTIONS /b HTTP/1.1\r\nHost: a.b\r\nContent-Type: text/plain\r\nContent-Length: 7\r\n\r\nopt-two")
+
+		out := c.output()
+		assert.Equal(t, tc.getBody, strings.Contains(out, "\r\n\r\nget-one"), tc.name)
+		assert.Equal(t, tc.optBody, strings.Contains(out, "\r\n\r\nopt-two"), tc.name)
+		assert.Contains(t, out, "OPTIONS /b HTTP/1.1\r\n", tc.name) // the body is consumed either way
+	}
+}
+
+func TestHexdumpOnErrorStd(t *testing.T) {
+	c := newTestConn(&Option{SrcRatio: 1, HexdumpOnError: 16})
+	c.requests("GET /a HTTP/1.1\r\nHost: a.b\r\n\r\n" + "BROKEN\r\n\x00\x01mangled by a middlebox")
+
+	// the bytes buffered after the broken request line are dumped, at most 16
+	out := c.output()
+	assert.Contains(t, out, "\r\nGET /a HTTP/1.1\r\n")
+	assert.Contains(t, out, "\n### ERR#1 REQ 127.0.0.1:5000-127.0.0.2:8080 ")
+	assert.Contains(t, out, "\n### HEXDUMP REQ 127.0.0.1:5000-127.0.0.2:8080 16 of 16 bytes\n"+
+		"00000000  00 01 6d 61 6e 67 6c 65  64 20 62 79 20 61 20 6d  |..mangled by a m|\n")
+
+	c = newTestConn(&Option{SrcRatio: 1})
+	c.requests("BROKEN\r\n\x00\x01mangled by a middlebox")
+	assert.NotContains(t, c.output(), "### HEXDUMP")
+}
+
+func TestCorrelateHeaderStd(t *testing.T) {
+	c := newTestConn(&Option{SrcRatio: 1, Resp: 1, CorrelateHeader: "X-Request-Id"})
+	c.requests("GET /a HTTP/1.1\r\nHost: a.b\r\nX-Request-Id: r-42\r\n\r\n")
+	c.responses("HTTP/1.1 200 OK\r\nX-Request-Id: r-42\r\nContent-Length: 0\r\n\r\n" +
+		"HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n")
+
+	// the titles are tagged by the header to join, but the one without it
+	msgs := c.messages()
+	if assert.Len(t, msgs, 3) {
+		assert.Regexp(t, `^\n### #1 REQ 127.0.0.1:5000-127.0.0.2:8080 \S+ correlate:r-42\r\n`, msgs[0])
+		assert.Regexp(t, `^\n### #1 RSP 127.0.0.2:8080-127.0.0.1:5000 \S+ correlate:r-42\r\n`, msgs[1])
+		assert.Regexp(t, `^\n### #2 RSP 127.0.0.2:8080-127.0.0.1:5000 \S+\r\n`, msgs[2])
+	}
+}
+
+func TestPendingConnectionsStd(t *testing.T) {
+	f := &Factory{}
+	a := &TcpStdAssembler{Factory: f}
+	c := newTestConn(&Option{SrcRatio: 1})
+	r, w := io.Pipe()
+	atomic.AddInt32(&f.active, 1) // like by New
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f.run(c.base(testClient, testServer), r)
+	}()
+
+	// the stream stuck after the request printed is pending, to be reported abandoned on the drain timeout
+	_, _ = w.Write([]byte("GET /a HTTP/1.1\r\nHost: a.b\r\n\r\n"))
+	assert.Eventually(t, func() bool { return strings.Contains(c.output(), "\r\nGET /a HTTP/1.1\r\n") }, time.Second, time.Millisecond)
+	assert.Equal(t, 1, a.PendingConnections())
+
+	_ = w.Close()
+	<-done
+	assert.Equal(t, 0, a.PendingConnections())
+}
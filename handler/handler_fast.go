@@ -3,6 +3,8 @@ package handler
 import (
 	"context"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // ConnectionHandlerFast impl ConnectionHandler
@@ -11,18 +13,116 @@ type ConnectionHandlerFast struct {
 	Option *Option
 	Sender Sender
 	wg     sync.WaitGroup
+
+	// Workers bounds the request streams and the response streams handled in parallel each,
+	// the streams over it wait in queue, 0 for unbounded.
+	Workers int
+
+	active              int32 // the connections not finished yet
+	poolOnce            sync.Once
+	requests, responses *workerPool // the workers reading the streams, nil for unbounded
+
+	pairs pairFlusher // the connections with the requests pending for Option.FastPair
 }
 
 func (h *ConnectionHandlerFast) handle(src Endpoint, dst Endpoint, c *TCPConnection) {
+	if !h.Option.PermitsConnection(src.String(), dst.String()) {
+		h.ignore(c)
+		return
+	}
+
 	b := NewBase(h.Context, &ConnectionKey{src: src, dst: dst}, h.Option, h.Sender)
+	b.source = c.source
+	if h.Option.FastPair > 0 {
+		h.pairs.add(b)
+	}
+	atomic.AddInt32(&h.active, 1)
+	h.wg.Add(1) // for the connection, done after both streams
 
-	h.wg.Add(1)
-	go b.handleRequest(&h.wg, c)
+	streams := int32(1)
+	if h.Option.Resp > 0 {
+		streams = 2
+	}
+	finished := func() {
+		if atomic.AddInt32(&streams, -1) == 0 {
+			h.done(b)
+		}
+	}
 
+	if h.Workers > 0 {
+		h.poolOnce.Do(func() {
+			h.requests, h.responses = newWorkerPool(h.Workers), newWorkerPool(h.Workers)
+		})
+	}
 	if h.Option.Resp > 0 {
 		h.wg.Add(1)
-		go b.handleResponse(&h.wg, c)
+		h.dispatch(h.responses, c.responseStream, func() { b.handleResponse(&h.wg, c); finished() })
+	}
+	h.wg.Add(1)
+	h.dispatch(h.requests, c.requestStream, func() { b.handleRequest(&h.wg, c); finished() })
+}
+
+// dispatch reads the stream by the pool, or in a new goroutine if unbounded.
+// The stream queued spills its packets until started by a worker, not to block the assembler.
+func (h *ConnectionHandlerFast) dispatch(pool *workerPool, s Stream, read func()) {
+	if pool == nil {
+		go read()
+		return
+	}
+
+	s.Queue()
+	pool.submit(func() {
+		s.Start()
+		read()
+	})
+}
+
+// ignore closes the streams of the connection, its packets are ignored.
+func (h *ConnectionHandlerFast) ignore(c *TCPConnection) {
+	_ = c.requestStream.Close()
+	if h.Option.Resp > 0 {
+		_ = c.responseStream.Close()
+	}
+}
+
+// done finishes the connection whose streams are both done.
+func (h *ConnectionHandlerFast) done(b *Base) {
+	defer h.wg.Done()
+	defer atomic.AddInt32(&h.active, -1)
+
+	h.pairs.done(b.connState)
+}
+
+func (h *ConnectionHandlerFast) flushUnpaired(now time.Time) {
+	if h.Option.FastPair > 0 {
+		h.pairs.flush(now.Add(-h.Option.FastPair))
+	}
+}
+
+func (h *ConnectionHandlerFast) captured(timestamp time.Time) { h.Option.rebase(timestamp) }
+
+// pending returns the number of the connections not finished yet.
+func (h *ConnectionHandlerFast) pending() int { return int(atomic.LoadInt32(&h.active)) }
+
+func (h *ConnectionHandlerFast) tlsSNI() bool { return h.Option.TLSSNI }
+
+// handshake prints the SNI of the TLS ClientHello, or the version and cipher of the ServerHello,
+// the encrypted connection is not tracked.
+func (h *ConnectionHandlerFast) handshake(src, dst Endpoint, record []byte, timestamp time.Time) {
+	NewBase(h.Context, &ConnectionKey{src: src, dst: dst}, h.Option, h.Sender).printTLS(record, timestamp)
+}
+
+// established records the handshake RTT of the connection, and prints it if Option.HandshakeRTT.
+func (h *ConnectionHandlerFast) established(client, server Endpoint, hs *handshake) {
+	if h.Option.tracksHandshake() {
+		NewBase(h.Context, &ConnectionKey{src: client, dst: server}, h.Option, h.Sender).printHandshake(hs)
 	}
 }
 
-func (h *ConnectionHandlerFast) finish() { h.wg.Wait() }
+func (h *ConnectionHandlerFast) finish() {
+	h.wg.Wait()
+	if h.requests != nil {
+		h.requests.close()
+		h.responses.close()
+	}
+}
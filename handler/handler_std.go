@@ -3,11 +3,17 @@ package handler
 import (
 	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/bingoohuang/httpdump/util"
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
 	"github.com/google/gopacket/tcpassembly"
@@ -16,14 +22,40 @@ import (
 
 type TcpStdAssembler struct {
 	*tcpassembly.Assembler
+	Factory *Factory
+}
+
+// PendingConnections returns the number of the streams not finished yet.
+func (r *TcpStdAssembler) PendingConnections() int {
+	if r.Factory == nil {
+		return 0
+	}
+	return int(atomic.LoadInt32(&r.Factory.active))
 }
 
 func (r *TcpStdAssembler) FinishAll() {
 	r.Assembler.FlushAll()
 }
-func (r *TcpStdAssembler) FlushOlderThan(time time.Time) { r.Assembler.FlushOlderThan(time) }
+func (r *TcpStdAssembler) FlushOlderThan(cutoff time.Time) {
+	flushed, closed := r.Assembler.FlushOlderThan(cutoff)
+	if r.Factory != nil && r.Factory.option.DebugConn && flushed > 0 {
+		log.Printf("D! conn %d streams flushed on idle, %d closed", flushed, closed)
+	}
+	if r.Factory != nil && r.Factory.option.FastPair > 0 { // the transactions are timed when read in std mode
+		r.Factory.pairs.flush(time.Now().Add(-r.Factory.option.FastPair))
+	}
+	if r.Factory != nil {
+		r.Factory.pruneHandshakes(cutoff)
+	}
+}
+
+// SetSource tags the streams created by the packets assembled next with the capture source.
+func (r *TcpStdAssembler) SetSource(source string) { r.Factory.source = source }
 
 func (r *TcpStdAssembler) Assemble(flow gopacket.Flow, tcp *layers.TCP, timestamp time.Time) {
+	if r.Factory != nil && r.Factory.option.tracksHandshake() {
+		r.Factory.observeHandshake(flow, tcp, timestamp)
+	}
 	r.Assembler.AssembleWithTimestamp(flow, tcp, timestamp)
 }
 
@@ -32,10 +64,64 @@ type Factory struct {
 
 	option *Option
 	sender Sender
+	conns  *connFilter
+	active int32 // the streams not finished yet
+
+	states sync.Map // connID -> *connState, shared by the streams of both directions
+
+	source string // capture source of the packets assembled next, set by TcpStdAssembler.SetSource
+
+	handshakes map[string]*handshake // connID -> the handshake in progress, by the assembling goroutine only
+
+	pairs pairFlusher // the connections with the requests pending for Option.FastPair
 }
 
-func NewFactory(ctx context.Context, option *Option, sender Sender) tcpassembly.StreamFactory {
-	return &Factory{Context: ctx, option: option, sender: sender}
+func NewFactory(ctx context.Context, option *Option, sender Sender) *Factory {
+	f := &Factory{Context: ctx, option: option, sender: sender}
+	if option.MinRequestsPerConnection > 1 {
+		f.conns = newConnFilter(option.MinRequestsPerConnection)
+	}
+	return f
+}
+
+// observeHandshake tracks the handshake packets of the connections, the handshake RTT is recorded and printed
+// when it completes, the ones not completed are forgotten by the FIN or RST, or pruned when idle.
+func (f *Factory) observeHandshake(flow gopacket.Flow, tcp *layers.TCP, timestamp time.Time) {
+	src := Endpoint{ip: flow.Src().String(), port: uint16(tcp.SrcPort)}
+	dst := Endpoint{ip: flow.Dst().String(), port: uint16(tcp.DstPort)}
+	connID := src.String() + "-" + dst.String()
+	if dst.String() < src.String() {
+		connID = dst.String() + "-" + src.String()
+	}
+
+	hs := f.handshakes[connID]
+	switch {
+	case tcp.FIN || tcp.RST:
+		delete(f.handshakes, connID)
+		return
+	case hs == nil && tcp.SYN && !tcp.ACK:
+		if f.handshakes == nil {
+			f.handshakes = map[string]*handshake{}
+		}
+		hs = &handshake{}
+		f.handshakes[connID] = hs
+	case hs == nil:
+		return
+	}
+
+	if hs.observe(src, tcp, timestamp) {
+		delete(f.handshakes, connID)
+		NewBase(f.Context, &ConnectionKey{src: src, dst: dst}, f.option, f.sender).printHandshake(hs)
+	}
+}
+
+// pruneHandshakes forgets the handshakes not completed and idle since the cutoff, like the unanswered SYNs.
+func (f *Factory) pruneHandshakes(cutoff time.Time) {
+	for connID, hs := range f.handshakes {
+		if hs.lastSeen().Before(cutoff) {
+			delete(f.handshakes, connID)
+		}
+	}
 }
 
 type streamKey struct {
@@ -49,39 +135,188 @@ func (k streamKey) String() string { // like 192.168.217.54:53933-192.168.126.18
 	return fmt.Sprintf("%v:%v-%v:%v", k.net.Src(), k.tcp.Src(), k.net.Dst(), k.tcp.Dst())
 }
 
+// connID returns the same id for the streams of both directions of the connection.
+func (k streamKey) connID() string {
+	src, dst := k.Src(), k.Dst()
+	if src < dst {
+		return src + "-" + dst
+	}
+	return dst + "-" + src
+}
+
 var _ Key = (*streamKey)(nil)
 
 func (f *Factory) New(netFlow, tcpFlow gopacket.Flow) tcpassembly.Stream {
-	h := NewBase(f.Context, &streamKey{net: netFlow, tcp: tcpFlow}, f.option, f.sender)
+	key := &streamKey{net: netFlow, tcp: tcpFlow}
+	var h *Base
+	if f.conns != nil {
+		sender := f.conns.sender(key.connID(), f.sender)
+		h = NewBase(f.Context, key, f.option, sender)
+		sender.requests = h.reqCounter.Get
+	} else {
+		h = NewBase(f.Context, key, f.option, f.sender)
+	}
+	h.source = f.source
+	connID := key.connID()
+	state, _ := f.states.LoadOrStore(connID, h.connState)
+	h.connState = state.(*connState)
+	atomic.AddInt32(&h.streams, 1)
 	reader := tcpreader.NewReaderStream()
 	reader.LossErrors = true
-	go f.run(h, &reader)
+	if f.option.DebugConn {
+		log.Printf("D! conn %s stream created, active streams: %d", key, atomic.LoadInt32(&f.active)+1)
+	}
+	atomic.AddInt32(&f.active, 1)
+	go func() {
+		defer f.release(connID, h.connState)
+		f.run(h, &reader)
+	}()
 	return &reader
 }
 
-func (f *Factory) run(b *Base, reader *tcpreader.ReaderStream) {
-	buf := bufio.NewReader(reader)
+// release releases the state of the connection by the stream finished, forgotten when both streams are finished.
+func (f *Factory) release(connID string, state *connState) {
+	state.requests.end()
+	if atomic.AddInt32(&state.streams, -1) == 0 {
+		f.states.CompareAndDelete(connID, state)
+		f.pairs.done(state)
+	}
+}
+
+func (f *Factory) run(b *Base, reader io.Reader) {
+	defer atomic.AddInt32(&f.active, -1)
+
+	counter := &countingReader{Reader: reader}
+	buf := bufio.NewReader(counter)
+	offset := func() int64 { return counter.n - int64(buf.Buffered()) }
+	// limit limits the headers to read next by Option.MaxHeaderBytes, or stops limiting if !on
+	limit := func(on bool) {
+		counter.limit = 0
+		if on && b.option.MaxHeaderBytes > 0 {
+			counter.limit = offset() + int64(b.option.MaxHeaderBytes)
+		}
+	}
+	b.discardProxyProtocol(buf)
+	isRequest := false
+	if s, ok := b.sender.(*heldSender); ok {
+		defer func() { s.finish(isRequest) }()
+	}
 	if peek, _ := buf.Peek(8); string(peek[:5]) == "HTTP/" {
-		if b.option.Resp > 0 {
-			f.runResponses(b, buf)
+		if b.option.Resp > 0 && b.option.PermitsConnection(b.key.Dst(), b.key.Src()) && b.httpStart(buf, false) {
+			f.runResponses(b, buf, offset, limit)
+		}
+	} else if isHTTPRequestData(peek) && b.option.PermitsConnection(b.key.Src(), b.key.Dst()) && b.httpStart(buf, true) {
+		if b.option.FastPair > 0 {
+			f.pairs.add(b)
 		}
-	} else if isHTTPRequestData(peek) {
-		f.runRequests(b, buf)
+		f.runRequests(b, buf, offset, limit)
+		b.recordConnection()
+		isRequest = true
+	} else if (util.IsTLSClientHello(peek) || util.IsTLSServerHello(peek)) && b.option.TLSSNI {
+		record, _ := buf.Peek(min(util.TLSRecordLen(peek), buf.Size()))
+		b.printTLS(record, time.Now())
 	}
 
 	_, _ = io.Copy(io.Discard, reader)
+	if b.option.DebugConn {
+		log.Printf("D! conn %s stream finished, bytes: %d, requests: %d, responses: %d",
+			b.key, counter.n, b.reqCounter.Get(), b.rspCounter.Get())
+	}
+}
+
+// countingReader counts the bytes read, to find the offset in the stream,
+// and stops reading at the limit offset if set, with errHeaderTooLarge.
+type countingReader struct {
+	io.Reader
+	n     int64
+	limit int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	if r.limit > 0 {
+		if r.n >= r.limit {
+			return 0, errHeaderTooLarge
+		}
+		p = p[:min(int64(len(p)), r.limit-r.n)]
+	}
+	n, err := r.Reader.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// wireCounter returns the size on the wire read since now, by the offset function of the stream.
+func wireCounter(offset func() int64) func() int64 {
+	start := offset()
+	return func() int64 { return offset() - start }
+}
+
+// peekBuffered peeks at most n next bytes of the reader after the parsing failure.
+func peekBuffered(buf *bufio.Reader, n int) []byte {
+	if n <= 0 {
+		return nil
+	}
+	peek, _ := buf.Peek(min(n, buf.Size()))
+	return peek
+}
+
+// discardProxyProtocol discards the PROXY protocol header at the start of the connection,
+// and keeps the original client address in it for display.
+func (h *Base) discardProxyProtocol(buf *bufio.Reader) {
+	peek, _ := buf.Peek(util.ProxyV2HeaderLen)
+	if !util.HasProxyProtocol(peek) {
+		return
+	}
+
+	// the v2 header declares its length, which may exceed the buffer by the TLVs
+	if n := util.ProxyV2Len(peek); n > 0 {
+		header := make([]byte, n)
+		if _, err := io.ReadFull(buf, header); err == nil {
+			_, client := util.ParseProxyProtocol(header)
+			h.storeClient(client)
+		}
+		return
+	}
+
+	// peek byte by byte, for the v1 header length is unknown before its CRLF
+	for size := util.ProxyV2HeaderLen; size <= util.ProxyV1MaxLen; size++ {
+		peek, err := buf.Peek(size)
+		if n, client := util.ParseProxyProtocol(peek); n > 0 {
+			h.storeClient(client)
+			_, _ = buf.Discard(n)
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
 }
 
 type HttpRsp struct {
 	*http.Response
+	wire    func() int64
+	offsets streamOffsets
+	raw     []string // the header lines as captured, nil if unknown
 }
 
 func (h HttpRsp) GetBody() io.ReadCloser  { return h.Response.Body }
 func (h HttpRsp) GetStatusLine() string   { return h.Response.Status }
-func (h HttpRsp) GetRawHeaders() []string { return MapKeys(h.Response.Header) }
+func (h HttpRsp) GetRawHeaders() []string { return rawOrMapKeys(h.raw, h.Response.Header) }
 func (h HttpRsp) GetContentLength() int64 { return h.Response.ContentLength }
 func (h HttpRsp) GetHeader() http.Header  { return h.Response.Header }
 func (h HttpRsp) GetStatusCode() int      { return h.Response.StatusCode }
+func (h HttpRsp) WireBodySize() int64     { return h.wire() }
+
+func (h HttpRsp) StreamOffsets() (start, end int64) { return h.offsets.start, h.offsets.end }
+
+// rawOrMapKeys returns the header lines as captured if known, or the sorted ones of the header.
+func rawOrMapKeys(raw []string, header http.Header) []string {
+	if raw != nil {
+		return raw
+	}
+	keys := MapKeys(header)
+	sort.Strings(keys)
+	return keys
+}
 
 func MapKeys(header http.Header) []string {
 	keys := make([]string, 0, len(header))
@@ -96,6 +331,9 @@ func MapKeys(header http.Header) []string {
 
 type HttpReq struct {
 	*http.Request
+	wire    func() int64
+	offsets streamOffsets
+	raw     []string // the header lines as captured, nil if unknown
 }
 
 func (h HttpReq) GetBody() io.ReadCloser  { return h.Body }
@@ -105,32 +343,101 @@ func (h HttpReq) GetPath() string         { return h.URL.Path }
 func (h HttpReq) GetMethod() string       { return h.Method }
 func (h HttpReq) GetProto() string        { return h.Proto }
 func (h HttpReq) GetHeader() http.Header  { return h.Header }
+func (h HttpReq) GetRawHeaders() []string { return rawOrMapKeys(h.raw, h.Header) }
 func (h HttpReq) GetContentLength() int64 { return h.ContentLength }
+func (h HttpReq) WireBodySize() int64     { return h.wire() }
+
+func (h HttpReq) StreamOffsets() (start, end int64) { return h.offsets.start, h.offsets.end }
 
-func (f *Factory) runResponses(h *Base, buf *bufio.Reader) {
+func (f *Factory) runResponses(h *Base, buf *bufio.Reader, offset func() int64, limit func(on bool)) {
 	for {
 		// 坑警告，这里返回的req，由于body没有读取，reader流位置可能没有移动到http请求的结束
-		r, err := http.ReadResponse(buf, nil)
+		if peek, _ := buf.Peek(8); h.tunnel.opaque(peek, isHTTPResponseData) {
+			return // the tunneled data is not cleartext HTTP
+		}
+		start := offset()
+		limit(true)
+		headers := peekHeaders(buf)
+		raw := rawHeaderLines(headers)
+		h.warnFramingConflict(headers)
+		if h.option.DetectSmuggling {
+			h.checkSmuggling(TagResponse, headers, time.Now())
+		}
+		h.awaitConnect(headers)
+		var r *http.Response
+		var err error
+		if h.tunnel.State() == tunnelConnect {
+			r, err = readConnectResponse(buf)
+		} else {
+			r, err = http.ReadResponse(buf, nil)
+		}
+		limit(false)
 		now := time.Now()
+		if errors.Is(err, errHeaderTooLarge) {
+			h.abandonTooLarge(TagResponse)
+			return
+		}
 		if err != nil {
+			h.flagDesync(TagResponse, err, now)
 			h.handleError(err, now, TagResponse)
-			return
+			h.hexdumpOnError(err, TagResponse, peekBuffered(buf, h.option.HexdumpOnError))
+			if isEOF(err) || !h.resync(buf) {
+				return
+			}
+			continue
+		}
+
+		if util.IsInformational(r.StatusCode) { // the interim 1xx responses precede the final one
+			h.printInformational(r, now)
+			continue
 		}
 
-		h.processResponse(true, &HttpRsp{Response: r}, h.option, now)
+		h.rspBuffer.Reset()
+		offsets := streamOffsets{start: start, end: offset()}
+		h.processResponse(true, &HttpRsp{Response: r, wire: wireCounter(offset), offsets: offsets, raw: raw}, h.option, now)
+		if h.Upgraded() || r.Close && h.tunnel.State() != tunnelOpen { // HTTP/1.0 without keep-alive, or Connection: close
+			return
+		}
 	}
 }
 
-func (f *Factory) runRequests(h *Base, buf *bufio.Reader) {
+func (f *Factory) runRequests(h *Base, buf *bufio.Reader, offset func() int64, limit func(on bool)) {
 	for {
 		// 坑警告，这里返回的req，由于body没有读取，reader流位置可能没有移动到http请求的结束
+		if peek, _ := buf.Peek(8); h.tunnel.opaque(peek, isHTTPRequestData) {
+			return // the tunneled data is not cleartext HTTP
+		}
+		start := offset()
+		limit(true)
+		headers := peekHeaders(buf)
+		raw := rawHeaderLines(headers)
+		if h.option.DetectSmuggling {
+			h.checkSmuggling(TagRequest, headers, time.Now())
+		}
 		r, err := http.ReadRequest(buf)
+		limit(false)
 		now := time.Now()
+		if errors.Is(err, errHeaderTooLarge) {
+			h.abandonTooLarge(TagRequest)
+			return
+		}
 		if err != nil {
+			h.flagDesync(TagRequest, err, now)
 			h.handleError(err, now, TagRequest)
+			h.hexdumpOnError(err, TagRequest, peekBuffered(buf, h.option.HexdumpOnError))
 			return
 		}
 
-		h.processRequest(true, &HttpReq{Request: r}, h.option, now)
+		if r.Method == "PRI" && r.ProtoMajor == 2 {
+			h.markUpgraded(now, TagRequest)
+			return
+		}
+
+		h.reqBuffer.Reset()
+		offsets := streamOffsets{start: start, end: offset()}
+		h.processRequest(true, &HttpReq{Request: r, wire: wireCounter(offset), offsets: offsets, raw: raw}, h.option, now)
+		if r.Close && r.Method != http.MethodConnect { // HTTP/1.0 without keep-alive, or Connection: close
+			return
+		}
 	}
 }
@@ -0,0 +1,32 @@
+package handler
+
+import (
+	"errors"
+	"log"
+
+	"github.com/bingoohuang/httpdump/util"
+)
+
+// errHeaderTooLarge is returned by the countingReader when the headers read exceed Option.MaxHeaderBytes.
+var errHeaderTooLarge = errors.New("headers too large")
+
+// headersTooLarge tells if the headers of the raw http message buffered in fast mode exceed Option.MaxHeaderBytes,
+// either the headers completed or not yet.
+func (h *Base) headersTooLarge(raw []byte, tag Tag) bool {
+	max := h.option.MaxHeaderBytes
+	if max <= 0 || len(raw) <= max {
+		return false
+	}
+	if pos := util.MIMEHeadersEndPos(raw); pos >= 0 && pos <= max {
+		return false
+	}
+
+	h.abandonTooLarge(tag)
+	return true
+}
+
+// abandonTooLarge logs the connection abandoned for its headers exceeding Option.MaxHeaderBytes.
+func (h *Base) abandonTooLarge(tag Tag) {
+	log.Printf("W! %s headers of %s-%s exceed %d bytes, connection abandoned",
+		tag, h.key.Src(), h.key.Dst(), h.option.MaxHeaderBytes)
+}
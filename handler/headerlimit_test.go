@@ -0,0 +1,30 @@
+package handler
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHeadersTooLarge(t *testing.T) {
+	h := &Base{key: &ConnectionKey{}, option: &Option{MaxHeaderBytes: 32}}
+	assert.False(t, h.headersTooLarge([]byte("GET / HTTP/1.1\r\nHost: a.b\r\n\r\n"), TagRequest))
+	assert.False(t, h.headersTooLarge([]byte("POST / HTTP/1.1\r\nHost: a.b\r\n\r\n"+strings.Repeat("x", 64)), TagRequest))
+	assert.True(t, h.headersTooLarge([]byte("GET / HTTP/1.1\r\nX-Large: "+strings.Repeat("x", 64)), TagRequest))
+	assert.True(t, h.headersTooLarge([]byte("GET / HTTP/1.1\r\nX-Large: "+strings.Repeat("x", 64)+"\r\n\r\n"), TagRequest))
+
+	h.option.MaxHeaderBytes = 0
+	assert.False(t, h.headersTooLarge([]byte("GET / HTTP/1.1\r\nX-Large: "+strings.Repeat("x", 64)), TagRequest))
+}
+
+func TestMaxHeaderBytesStd(t *testing.T) {
+	c := newTestConn(&Option{MaxHeaderBytes: 8192, SrcRatio: 1})
+	small := "GET /small HTTP/1.1\r\nHost: a.b\r\n\r\n"
+	large := "GET /large HTTP/1.1\r\nHost: a.b\r\nX-Large: " + strings.Repeat("x", 10000) + "\r\n\r\n"
+
+	c.requests(small + large + small)
+	msgs := c.messages()
+	assert.Equal(t, 1, len(msgs))
+	assert.Contains(t, msgs[0], "GET /small HTTP/1.1")
+}
@@ -0,0 +1,110 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// HeaderMatcher matches the http header by all of its rules.
+// A nil *HeaderMatcher matches all.
+type HeaderMatcher struct {
+	rules []headerRule
+}
+
+// headerRule is a rule of the header name, with the value pattern if any.
+type headerRule struct {
+	name   string
+	absent bool   // the header should be absent
+	value  string // wildcard pattern of the value, empty for presence only
+	re     *regexp.Regexp
+}
+
+// NewHeaderMatcher creates a HeaderMatcher of the rules, nil if no rules.
+// Each rule is like Name for the presence, !Name for the absence, or Name: value for the value,
+// which is a wildcard pattern, or a regexp if isRegex.
+func NewHeaderMatcher(rules []string, isRegex bool) (*HeaderMatcher, error) {
+	m := &HeaderMatcher{}
+	for _, rule := range rules {
+		name, value, hasValue := strings.Cut(rule, ":")
+		r := headerRule{name: http.CanonicalHeaderKey(strings.TrimSpace(name)), value: strings.TrimSpace(value)}
+		if strings.HasPrefix(r.name, "!") {
+			if hasValue {
+				return nil, fmt.Errorf("invalid header rule %s: the absent header has no value", rule)
+			}
+			r.name, r.absent = http.CanonicalHeaderKey(strings.TrimPrefix(r.name, "!")), true
+		}
+		if r.name == "" {
+			return nil, fmt.Errorf("invalid header rule %s: empty name", rule)
+		}
+		if isRegex && r.value != "" {
+			re, err := regexp.Compile(r.value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid header rule %s: %w", rule, err)
+			}
+			r.re = re
+		}
+		m.rules = append(m.rules, r)
+	}
+
+	if len(m.rules) == 0 {
+		return nil, nil
+	}
+	return m, nil
+}
+
+// NewUAMatcher creates a HeaderMatcher of the User-Agent by the pattern, nil if the pattern is empty.
+func NewUAMatcher(pattern string, isRegex bool) (*HeaderMatcher, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+	return NewHeaderMatcher([]string{"User-Agent: " + pattern}, isRegex)
+}
+
+// Match tells if the header matches all the rules.
+func (m *HeaderMatcher) Match(header http.Header) bool {
+	if m == nil {
+		return true
+	}
+
+	for _, r := range m.rules {
+		if !r.match(header.Values(r.name)) {
+			return false
+		}
+	}
+	return true
+}
+
+// String returns the rules like X-Tenant-Id: t1, !Authorization.
+func (m *HeaderMatcher) String() string {
+	if m == nil {
+		return ""
+	}
+
+	rules := make([]string, len(m.rules))
+	for i, r := range m.rules {
+		switch {
+		case r.absent:
+			rules[i] = "!" + r.name
+		case r.value == "":
+			rules[i] = r.name
+		default:
+			rules[i] = r.name + ": " + r.value
+		}
+	}
+	return strings.Join(rules, ", ")
+}
+
+func (r headerRule) match(values []string) bool {
+	if r.absent || r.value == "" {
+		return r.absent == (len(values) == 0)
+	}
+
+	for _, v := range values {
+		if r.re != nil && r.re.MatchString(v) || r.re == nil && wildcardMatch(v, r.value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,58 @@
+package handler
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHeaderMatcher(t *testing.T) {
+	header := http.Header{"Content-Type": {"application/json; charset=utf-8"}, "X-Cache": {"MISS"}}
+
+	m, err := NewHeaderMatcher([]string{"content-type: application/json*", "!Cache-Control", "X-Cache"}, false)
+	assert.Nil(t, err)
+	assert.True(t, m.Match(header))
+
+	header.Set("Cache-Control", "no-cache")
+	assert.False(t, m.Match(header))
+
+	m, _ = NewHeaderMatcher([]string{"Content-Type: text/*"}, false)
+	assert.False(t, m.Match(header))
+
+	m, err = NewHeaderMatcher([]string{"Content-Type: ^application/(json|xml)"}, true)
+	assert.Nil(t, err)
+	assert.True(t, m.Match(header))
+
+	m, err = NewHeaderMatcher(nil, false)
+	assert.Nil(t, err)
+	assert.True(t, m.Match(header))
+
+	_, err = NewHeaderMatcher([]string{"!Cache-Control: no-cache"}, false)
+	assert.NotNil(t, err)
+	_, err = NewHeaderMatcher([]string{"Content-Type: ("}, true)
+	assert.NotNil(t, err)
+}
+
+func TestUAMatcher(t *testing.T) {
+	chrome := http.Header{"User-Agent": {"Mozilla/5.0 Chrome/120.0"}}
+	bot := http.Header{"User-Agent": {"Googlebot/2.1 Chrome/120.0"}}
+
+	o := &Option{}
+	o.UAMatcher, _ = NewUAMatcher("*Chrome*", false)
+	o.ExcludeUAMatcher, _ = NewUAMatcher("*bot*", false)
+	assert.True(t, o.permitsUA(chrome))
+	assert.False(t, o.permitsUA(bot))
+	assert.False(t, o.permitsUA(http.Header{}))
+
+	o.UAMatcher = nil
+	assert.True(t, o.permitsUA(http.Header{}))
+
+	o.UAMatcher, _ = NewUAMatcher("^Mozilla/", true)
+	assert.True(t, o.permitsUA(chrome))
+	assert.False(t, o.permitsUA(bot))
+
+	m, err := NewUAMatcher("", false)
+	assert.Nil(t, err)
+	assert.Nil(t, m)
+}
@@ -0,0 +1,35 @@
+package handler
+
+import (
+	"bufio"
+	"bytes"
+
+	"github.com/bingoohuang/httpdump/util"
+)
+
+// isHTTPStart tells if the data at the start of a stream looks like an HTTP/1 request or response by its title line,
+// or by the method for the request title longer than the data, for Option.HTTPOnly to skip the other protocols.
+func isHTTPStart(data []byte, isRequest bool) bool {
+	if !isRequest {
+		return util.HasResponseTitle(data)
+	}
+	if bytes.HasPrefix(data, h2cPreface) || util.HasProxyProtocol(data) {
+		return true
+	}
+	return util.HasRequestTitle(data) || !bytes.Contains(data, util.CRLF) && isHTTPRequestData(data)
+}
+
+// httpStart tells if the stream starts like HTTP/1, always true without Option.HTTPOnly.
+func (h *Base) httpStart(buf *bufio.Reader, isRequest bool) bool {
+	return !h.option.HTTPOnly || isHTTPStart(peekLine(buf), isRequest)
+}
+
+// peekLine peeks the first line of the stream with its CRLF, or as much as buffered if the line is longer.
+func peekLine(buf *bufio.Reader) []byte {
+	for n := 1; ; n = buf.Buffered() + 1 {
+		peek, err := buf.Peek(min(n, buf.Size()))
+		if bytes.Contains(peek, util.CRLF) || err != nil || len(peek) >= buf.Size() {
+			return peek
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package handler
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsHTTPStart(t *testing.T) {
+	assert.True(t, isHTTPStart([]byte("GET /x HTTP/1.1\r\nHost: a.b\r\n\r\n"), true))
+	assert.True(t, isHTTPStart([]byte("GET /"+strings.Repeat("x", 2000)), true)) // the title longer than the data
+	assert.True(t, isHTTPStart(h2cPreface, true))
+	assert.False(t, isHTTPStart([]byte("GET abc\r\n\r\n"), true))
+	assert.False(t, isHTTPStart([]byte("SSH-2.0-OpenSSH_9.6\r\n"), true))
+	assert.False(t, isHTTPStart([]byte("\x16\x03\x01\x02\x00\x01\x00\x01\xfc\x03\x03"), true))
+
+	assert.True(t, isHTTPStart([]byte("HTTP/1.1 200 OK\r\n"), false))
+	assert.False(t, isHTTPStart([]byte("HTTP/9.9 200 OK\r\n"), false))
+	assert.False(t, isHTTPStart([]byte("SSH-2.0-OpenSSH_9.6\r\n"), false))
+}
+
+func TestPeekLine(t *testing.T) {
+	buf := bufio.NewReaderSize(strings.NewReader("GET /x HTTP/1.1\r\nHost: a.b\r\n\r\n"), 16)
+	assert.Equal(t, "GET /x HTTP/1.1\r", string(peekLine(buf))) // at most the buffer size
+	buf = bufio.NewReader(strings.NewReader("GET /x HTTP/1.1\r\nHost: a.b\r\n\r\n"))
+	assert.Contains(t, string(peekLine(buf)), "GET /x HTTP/1.1\r\n")
+	assert.Equal(t, "SSH", string(peekLine(bufio.NewReader(strings.NewReader("SSH")))))
+}
+
+func TestHTTPOnlyStd(t *testing.T) {
+	option := &Option{SrcRatio: 1, HTTPOnly: true}
+	binary := "PUT \x00\x01\x02\x03\x04\x05\r\n\x06\x07"
+
+	c := newTestConn(option)
+	c.requests(binary)
+	assert.Empty(t, c.messages())
+
+	option.HTTPOnly = false
+	c = newTestConn(option)
+	c.requests(binary)
+	assert.Contains(t, c.output(), "ERR#0 REQ")
+}
@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// jsonFieldAliases maps the short names of the JSON output fields to the keys in the objects.
+var jsonFieldAliases = map[string]string{
+	"seq":        "Seq",
+	"src":        "Src",
+	"dest":       "Dest",
+	"timestamp":  "Timestamp",
+	"uri":        "RequestURI",
+	"method":     "Method",
+	"host":       "Host",
+	"header":     "Header",
+	"body":       "Body",
+	"bodyhash":   "BodyHash",
+	"session":    "Session",
+	"source":     "Source",
+	"status":     "StatusCode",
+	"statustext": "StatusText",
+	"reason":     "Reason",
+	"latency":    "Latency",
+}
+
+// ParseJSONFields parses the field names, short ones like uri, status or the keys like RequestURI, StatusCode,
+// to the keys selected in the JSON output objects.
+func ParseJSONFields(names []string) ([]string, error) {
+	keys := make([]string, 0, len(names))
+	for _, name := range names {
+		key, ok := jsonFieldAliases[strings.ToLower(name)]
+		if !ok {
+			for _, k := range jsonFieldAliases {
+				if strings.EqualFold(k, name) {
+					key, ok = k, true
+					break
+				}
+			}
+		}
+		if !ok {
+			return nil, fmt.Errorf("unknown json field %s", name)
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// selectJSONFields keeps only the fields of the keys in the JSON object, in the order of the keys,
+// the keys are matched case-insensitively, like StatusCode to the statusCode in the output of ginx.JsoniConfig,
+// and the keys absent from the object are skipped.
+func selectJSONFields(data []byte, keys []string) []byte {
+	if len(keys) == 0 {
+		return data
+	}
+
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(data, &m); err != nil {
+		return data
+	}
+
+	b := &bytes.Buffer{}
+	b.WriteByte('{')
+	for _, key := range keys {
+		name, v, ok := lookupJSONField(m, key)
+		if !ok {
+			continue
+		}
+		if b.Len() > 1 {
+			b.WriteByte(',')
+		}
+		k, _ := json.Marshal(name)
+		b.Write(k)
+		b.WriteByte(':')
+		b.Write(v)
+	}
+	b.WriteByte('}')
+	return b.Bytes()
+}
+
+// lookupJSONField looks up the field of the key in the object case-insensitively, returning its name in the object.
+func lookupJSONField(m map[string]json.RawMessage, key string) (string, json.RawMessage, bool) {
+	if v, ok := m[key]; ok {
+		return key, v, true
+	}
+	for name, v := range m {
+		if strings.EqualFold(name, key) {
+			return name, v, true
+		}
+	}
+	return "", nil, false
+}
@@ -0,0 +1,42 @@
+package handler
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJSONFields(t *testing.T) {
+	keys, err := ParseJSONFields([]string{"method", "URI", "StatusCode", "latency"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"Method", "RequestURI", "StatusCode", "Latency"}, keys)
+
+	_, err = ParseJSONFields([]string{"method", "nope"})
+	assert.NotNil(t, err)
+
+	data := []byte(`{"Seq":1,"RequestURI":"/a?b=1","Method":"GET","Body":{"x":1}}`)
+	assert.Equal(t, `{"Method":"GET","RequestURI":"/a?b=1"}`, string(selectJSONFields(data, keys)))
+	assert.Equal(t, `{"Body":{"x":1}}`, string(selectJSONFields(data, []string{"Body"})))
+	assert.Equal(t, string(data), string(selectJSONFields(data, nil)))
+}
+
+func TestReasonPhrase(t *testing.T) {
+	assert.Equal(t, "OK", ReasonPhrase("HTTP/1.1 200 OK", 200))
+	assert.Equal(t, "Connection established", ReasonPhrase("200 Connection established", 200))
+	assert.Equal(t, "", ReasonPhrase("HTTP/1.1 204", 204))
+
+	keys, err := ParseJSONFields([]string{"status", "statusText", "reason"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"StatusCode", "StatusText", "Reason"}, keys)
+}
+
+func TestJSONFieldsLatencyStd(t *testing.T) {
+	c := newTestConn(&Option{SrcRatio: 1, Resp: 1, JSONFields: []string{"StatusCode", "Latency"}})
+	c.requests("GET /a HTTP/1.1\r\nHost: a.b\r\n\r\n")
+	c.responses("HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n")
+
+	msgs := c.messages()
+	if assert.Len(t, msgs, 2) {
+		assert.Regexp(t, `^\{"statusCode":200,"latency":"[^"]+"\}\n$`, msgs[1])
+	}
+}
@@ -0,0 +1,123 @@
+package handler
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+)
+
+// multipartFieldMax is the max length of the value of a multipart form field printed.
+const multipartFieldMax = 256
+
+// multipartBoundary returns the boundary of the multipart/form-data body, or empty if not multipart.
+func multipartBoundary(header http.Header) string {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/form-data" {
+		return ""
+	}
+	return params["boundary"]
+}
+
+// dumpMultipart splits the multipart/form-data body by the boundary, saves the uploaded files
+// into the dir of Option.DumpMultipart by their original file names, and prints the other form fields.
+// The parts are streamed to the files, without buffering the whole body.
+func dumpMultipart(b *bytes.Buffer, r io.Reader, boundary string, o *Option) error {
+	dir := o.DumpMultipart
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	mr := multipart.NewReader(r, boundary)
+	for {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if part.FileName() == "" {
+			value, err := io.ReadAll(io.LimitReader(part, multipartFieldMax+1))
+			if err != nil {
+				return err
+			}
+			if len(value) > multipartFieldMax {
+				value = append(value[:multipartFieldMax], "..."...)
+			}
+			writeFormat(b, "// multipart field %s: %s\r\n", part.FormName(), value)
+			continue
+		}
+
+		limit, ok := o.reserveMultipartFile()
+		if !ok {
+			writeFormat(b, "// multipart file %s: %s, content-type: %s, not saved, over the max files or bytes\r\n",
+				part.FormName(), part.FileName(), part.Header.Get("Content-Type"))
+			continue
+		}
+		var pr io.Reader = part
+		if limit >= 0 {
+			pr = io.LimitReader(part, limit)
+		}
+		path, n, err := saveMultipartFile(dir, part.FileName(), pr)
+		atomic.AddInt64(&o.multipartBytes, n)
+		if err != nil {
+			return err
+		}
+		truncated := ""
+		if more, _ := io.CopyN(io.Discard, part, 1); more > 0 {
+			truncated = ", truncated at the max bytes"
+		}
+		writeFormat(b, "// multipart file %s: %s, content-type: %s, saved to file: %s size: %d%s\r\n",
+			part.FormName(), part.FileName(), part.Header.Get("Content-Type"), path, n, truncated)
+	}
+}
+
+// reserveMultipartFile reserves a file to save by DumpMultipart, and returns the max bytes of it, -1 for unlimited,
+// false if over DumpMultipartMaxFiles or DumpMultipartMaxBytes.
+func (o *Option) reserveMultipartFile() (int64, bool) {
+	if o.DumpMultipartMaxFiles > 0 && atomic.AddInt32(&o.multipartFiles, 1) > int32(o.DumpMultipartMaxFiles) {
+		return 0, false
+	}
+	if o.DumpMultipartMaxBytes <= 0 {
+		return -1, true
+	}
+	left := int64(o.DumpMultipartMaxBytes) - atomic.LoadInt64(&o.multipartBytes)
+	return left, left > 0
+}
+
+// saveMultipartFile saves the file into the dir, with a numeric suffix if the file name exists.
+func saveMultipartFile(dir, fileName string, r io.Reader) (string, int64, error) {
+	// keep only the base name, like C:\fakepath\a.txt sent by some browsers, against path traversal
+	name := filepath.Base(strings.ReplaceAll(fileName, `\`, "/"))
+	if name == "." || name == "/" || name == ".." {
+		name = "upload"
+	}
+
+	ext := filepath.Ext(name)
+	path := filepath.Join(dir, name)
+	for i := 1; ; i++ {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if errors.Is(err, os.ErrExist) {
+			path = filepath.Join(dir, fmt.Sprintf("%s.%d%s", strings.TrimSuffix(name, ext), i, ext))
+			continue
+		}
+		if err != nil {
+			return "", 0, err
+		}
+
+		n, err := io.Copy(f, r)
+		if e := f.Close(); err == nil {
+			err = e
+		}
+		return path, n, err
+	}
+}
@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDumpMultipart(t *testing.T) {
+	body := &bytes.Buffer{}
+	w := multipart.NewWriter(body)
+	_ = w.WriteField("name", "bingoo")
+	fw, _ := w.CreateFormFile("file", `C:\fakepath\a.txt`)
+	_, _ = fw.Write([]byte("hello"))
+	fw, _ = w.CreateFormFile("file2", "../a.txt")
+	_, _ = fw.Write([]byte("world"))
+	_ = w.Close()
+
+	header := http.Header{"Content-Type": {w.FormDataContentType()}}
+	boundary := multipartBoundary(header)
+	assert.Equal(t, w.Boundary(), boundary)
+	assert.Equal(t, "", multipartBoundary(http.Header{"Content-Type": {"application/json"}}))
+
+	dir := t.TempDir()
+	b := &bytes.Buffer{}
+	assert.Nil(t, dumpMultipart(b, body, boundary, &Option{DumpMultipart: dir}))
+	assert.Contains(t, b.String(), "// multipart field name: bingoo\r\n")
+	assert.Contains(t, b.String(), "saved to file: "+filepath.Join(dir, "a.txt")+" size: 5\r\n")
+	assert.Contains(t, b.String(), "saved to file: "+filepath.Join(dir, "a.1.txt")+" size: 5\r\n")
+
+	data, _ := os.ReadFile(filepath.Join(dir, "a.1.txt"))
+	assert.Equal(t, "world", string(data))
+}
+
+func TestDumpMultipartMax(t *testing.T) {
+	upload := func(files ...string) (*bytes.Buffer, string) {
+		body := &bytes.Buffer{}
+		w := multipart.NewWriter(body)
+		for i, data := range files {
+			fw, _ := w.CreateFormFile("file", strconv.Itoa(i)+".txt")
+			_, _ = fw.Write([]byte(data))
+		}
+		_ = w.Close()
+		return body, w.Boundary()
+	}
+
+	dir := t.TempDir()
+	o := &Option{DumpMultipart: dir, DumpMultipartMaxFiles: 3, DumpMultipartMaxBytes: 8}
+	b := &bytes.Buffer{}
+	body, boundary := upload("hello", "world")
+	assert.Nil(t, dumpMultipart(b, body, boundary, o))
+	assert.Contains(t, b.String(), "saved to file: "+filepath.Join(dir, "0.txt")+" size: 5\r\n")
+	assert.Contains(t, b.String(), "saved to file: "+filepath.Join(dir, "1.txt")+" size: 3, truncated at the max bytes\r\n")
+	data, _ := os.ReadFile(filepath.Join(dir, "1.txt"))
+	assert.Equal(t, "wor", string(data))
+
+	b.Reset()
+	body, boundary = upload("a", "b") // the 3rd file over the bytes, the 4th over the files
+	assert.Nil(t, dumpMultipart(b, body, boundary, o))
+	assert.Equal(t, 2, strings.Count(b.String(), "not saved, over the max files or bytes\r\n"))
+	entries, _ := os.ReadDir(dir)
+	assert.Len(t, entries, 2)
+}
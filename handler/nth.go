@@ -0,0 +1,66 @@
+package handler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// NthRange is the positional range of the requests/responses in each connection, 1-based and inclusive.
+type NthRange struct {
+	From, To int32 // To 0 for no upper bound
+}
+
+// ParseNthRange parses the range like 1 for the first one, 2: for the ones after the first,
+// :3 for the first three, or 2:5, an empty s for nil to permit all.
+func ParseNthRange(s string) (*NthRange, error) {
+	if s = strings.TrimSpace(s); s == "" {
+		return nil, nil
+	}
+
+	from, to, isRange := strings.Cut(s, ":")
+	r := &NthRange{From: 1}
+	var err error
+	if from != "" {
+		if r.From, err = parseNth(from); err != nil {
+			return nil, fmt.Errorf("invalid nth %s: %w", s, err)
+		}
+	}
+	switch {
+	case !isRange:
+		r.To = r.From
+	case to != "":
+		if r.To, err = parseNth(to); err != nil {
+			return nil, fmt.Errorf("invalid nth %s: %w", s, err)
+		}
+		if r.To < r.From {
+			return nil, fmt.Errorf("invalid nth %s: empty range", s)
+		}
+	}
+	return r, nil
+}
+
+func parseNth(s string) (int32, error) {
+	n, err := strconv.ParseInt(strings.TrimSpace(s), 10, 32)
+	if err == nil && n < 1 {
+		err = fmt.Errorf("%d is not positive", n)
+	}
+	return int32(n), err
+}
+
+// String returns the range like 2:5, or 2: without the upper bound.
+func (r *NthRange) String() string {
+	switch {
+	case r.From == r.To:
+		return strconv.Itoa(int(r.From))
+	case r.To == 0:
+		return strconv.Itoa(int(r.From)) + ":"
+	default:
+		return strconv.Itoa(int(r.From)) + ":" + strconv.Itoa(int(r.To))
+	}
+}
+
+// Contains tells if the seq of the request/response in its connection is in the range, true for the nil range.
+func (r *NthRange) Contains(seq int32) bool {
+	return r == nil || seq >= r.From && (r.To == 0 || seq <= r.To)
+}
@@ -0,0 +1,33 @@
+package handler
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseNthRange(t *testing.T) {
+	for s, want := range map[string]*NthRange{
+		"":    nil,
+		"1":   {From: 1, To: 1},
+		"2:":  {From: 2},
+		":3":  {From: 1, To: 3},
+		"2:5": {From: 2, To: 5},
+	} {
+		r, err := ParseNthRange(s)
+		assert.Nil(t, err, s)
+		assert.Equal(t, want, r, s)
+	}
+	for _, s := range []string{"0", "a", "5:2", "1:x"} {
+		_, err := ParseNthRange(s)
+		assert.NotNil(t, err, s)
+	}
+
+	r, _ := ParseNthRange("2:")
+	assert.False(t, r.Contains(1))
+	assert.True(t, r.Contains(2))
+	assert.True(t, r.Contains(100))
+
+	var all *NthRange
+	assert.True(t, all.Contains(1))
+}
@@ -0,0 +1,55 @@
+package handler
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/bingoohuang/httpdump/util"
+)
+
+// StreamOffsetter reports the byte offsets in the connection stream where the headers of the message
+// started and ended, the end is where the body starts.
+type StreamOffsetter interface {
+	StreamOffsets() (start, end int64)
+}
+
+// streamOffsets are the offsets of the headers in the connection stream.
+type streamOffsets struct {
+	start, end int64
+}
+
+// rawOffsets returns the offsets of the headers of the raw http message starting at the start offset.
+func rawOffsets(start int64, raw []byte) streamOffsets {
+	end := start + int64(len(raw))
+	if pos := util.MIMEHeadersEndPos(raw); pos >= 0 {
+		end = start + int64(pos)
+	}
+	return streamOffsets{start: start, end: end}
+}
+
+// streamOffsetsOf returns the offsets of the request or response wrapped, or -1 if unknown.
+func streamOffsetsOf(r any) (start, end int64) {
+	if so, ok := r.(StreamOffsetter); ok {
+		return so.StreamOffsets()
+	}
+	return -1, -1
+}
+
+// writeOffsets writes the offsets of the headers in the connection stream, and the total size of the message
+// with the body on the wire, if Option.Offsets. The rest of the body is read up to know its size.
+func (h *Base) writeOffsets(b *bytes.Buffer, r interface{ GetBody() io.ReadCloser }) {
+	if !h.option.Offsets {
+		return
+	}
+	start, end := streamOffsetsOf(r)
+	if start < 0 {
+		return
+	}
+
+	discardAll(r.GetBody())
+	size := end - start
+	if wire := wireBodySizeOf(r); wire > 0 {
+		size += wire
+	}
+	writeFormat(b, "\n// offsets: headers %d-%d, body %d-%d, size: %d\n", start, end, end, start+size, size)
+}
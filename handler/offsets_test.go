@@ -0,0 +1,30 @@
+package handler
+
+import (
+	"bufio"
+	"bytes"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteOffsets(t *testing.T) {
+	raw := []byte("POST /a HTTP/1.1\r\nHost: a.b\r\nContent-Length: 5\r\n\r\nhello")
+	offsets := rawOffsets(100, raw)
+	assert.Equal(t, streamOffsets{start: 100, end: 100 + int64(len(raw)) - 5}, offsets)
+
+	r, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(raw)))
+	assert.Nil(t, err)
+	req := wireReq{Req: HttpReq{Request: r}, wire: wireBodySize(raw), offsets: offsets}
+
+	h := &Base{option: &Option{Offsets: true}}
+	b := &bytes.Buffer{}
+	h.writeOffsets(b, &peekedReq{Req: req, body: req.GetBody()})
+	assert.Equal(t, "\n// offsets: headers 100-150, body 150-155, size: 55\n", b.String())
+
+	h.option.Offsets = false
+	b.Reset()
+	h.writeOffsets(b, req)
+	assert.Equal(t, "", b.String())
+}
@@ -3,9 +3,15 @@ package handler
 import (
 	"context"
 	"math/rand"
+	"net"
+	"net/http"
+	"runtime"
+	"strconv"
 	"strings"
 	"sync/atomic"
+	"time"
 
+	"github.com/bingoohuang/gg/pkg/ss"
 	"github.com/bingoohuang/httpdump/util"
 	"golang.org/x/time/rate"
 )
@@ -13,6 +19,7 @@ import (
 const (
 	LevelUrl    = "url"
 	LevelHeader = "header"
+	LevelBody   = "body"
 )
 
 type Option struct {
@@ -27,8 +34,10 @@ type Option struct {
 	Resp        int
 	Force       bool
 	Curl        bool
+	SortHeaders bool
 	Eof         bool
 	Debug       bool
+	DebugConn   bool // logs the lifecycle of the streams in std mode, created, flushed on idle and finished
 	RateLimiter *rate.Limiter
 
 	N   int32
@@ -37,6 +46,139 @@ type Option struct {
 	CtxCancel context.CancelFunc
 
 	SrcRatio float64
+
+	AssumeScheme string
+
+	BodyMethods    string
+	AlwaysReadBody bool
+
+	Stats *Stats
+
+	TransactionHandlers []TransactionHandler
+	RecordBodies        bool
+
+	// BodyHash is the algorithm to hash the bodies, BodyHashMD5 or BodyHashSHA256, empty for no hashes.
+	BodyHash string
+
+	Sessions *SessionTracker
+	Proto    *ProtoDecoder
+	Dedup    *Deduper
+
+	HexdumpOnError int
+
+	CorrelateHeader string
+
+	PcapOut *PcapWriter
+
+	ExcludeStatus util.IntSetFlag
+
+	TimeFormat string
+	UTC        bool
+
+	// TimestampBase derives the displayed timestamps, TimestampOriginal, TimestampNow or TimestampFirst.
+	TimestampBase string
+	rebaseOffset  int64 // nanoseconds added to the timestamps by TimestampFirst, set once by rebase
+	rebaseState   int32 // rebaseUnset, rebaseSetting or rebaseSet, of the rebaseOffset
+
+	// Hex prints the binary bodies as the offset/hex/ASCII dump.
+	Hex bool
+
+	// TextTypes and BinaryTypes are the extra content types, wildcard supported,
+	// declared as text or binary, overriding the built-in detection.
+	TextTypes   []string
+	BinaryTypes []string
+
+	// HostPatterns and UriPatterns are the allowlist/denylist loaded from files, along with Host and Uri.
+	HostPatterns *PatternList
+	UriPatterns  *PatternList
+
+	// Informational prints the interim 1xx responses, which are skipped by default.
+	Informational bool
+
+	// ReqBodyMatcher and RspBodyMatcher filter the requests and responses by their bodies,
+	// the responses of the requests filtered out are skipped too.
+	ReqBodyMatcher *BodyMatcher
+	RspBodyMatcher *BodyMatcher
+
+	// RspHeaderMatcher filters the responses by their headers.
+	RspHeaderMatcher *HeaderMatcher
+
+	// UAMatcher filters the requests by the User-Agent, and then ExcludeUAMatcher excludes the ones matched.
+	UAMatcher, ExcludeUAMatcher *HeaderMatcher
+
+	// JSONFields are the keys selected in the JSON output objects, parsed by ParseJSONFields.
+	JSONFields []string
+
+	// TLSSNI prints the SNI of the TLS ClientHello, and the negotiated version and cipher suite
+	// of the TLS ServerHello, on the connections, which are not decrypted.
+	TLSSNI bool
+
+	// DumpMultipart is the directory to save the files uploaded in the multipart/form-data requests.
+	DumpMultipart string
+	// DumpMultipartMaxFiles and DumpMultipartMaxBytes cap the number and the total bytes of the files saved
+	// by DumpMultipart, 0 for unlimited.
+	DumpMultipartMaxFiles int
+	DumpMultipartMaxBytes int
+	multipartFiles        int32 // the files saved by DumpMultipart
+	multipartBytes        int64 // the bytes of the files saved by DumpMultipart
+
+	// MinRequestsPerConnection outputs only the connections carrying at least the number of requests in std mode.
+	MinRequestsPerConnection int
+
+	// Nth outputs only the requests/responses at the positions in each connection, nil for all.
+	Nth *NthRange
+
+	// ConnFormat formats the connections in the output titles, ConnFormatDash if empty,
+	// ConnFormatArrow or ConnFormatHash.
+	ConnFormat string
+
+	// Format outputs the paired transactions as access log lines, FormatCLF or FormatCombined,
+	// or the requests/responses as the raw records of FormatRaw, instead of the dumps.
+	Format string
+
+	// FastPair outputs the paired transactions as one line each, instead of the dumps,
+	// the requests unpaired within the window are output alone, 0 to disable.
+	FastPair time.Duration
+
+	// BodyPreview is the bytes of the body previewed in the level header, 0 for no preview.
+	BodyPreview int
+
+	// HTTPOnly skips the streams not starting like HTTP/1 quietly, like TLS, SSH or the binary protocols
+	// on the mixed ports, instead of the parse errors.
+	HTTPOnly bool
+
+	// ExplainFilter prints the filters passed by each request/response after its title, like // matched: host=*.api.
+	ExplainFilter bool
+
+	// HandshakeRTT prints the RTT of the connections estimated by their TCP handshakes, the ones observed,
+	// which are always recorded into Stats.
+	HandshakeRTT bool
+
+	// DetectSmuggling flags the messages with the request smuggling signatures by the SMUGGLING-SUSPECT records,
+	// like both Content-Length and Transfer-Encoding, the obfuscated Transfer-Encoding, or the desync.
+	DetectSmuggling bool
+
+	// DecodeForm prints the fields of the application/x-www-form-urlencoded bodies URL-decoded, each on its own line.
+	DecodeForm bool
+
+	// MaxHeaderBytes is the max bytes of the headers of a request/response,
+	// the connection with larger headers is abandoned, 0 for unlimited.
+	MaxHeaderBytes int
+
+	// MaxBodyBytes is the max bytes of a body buffered in memory for RecordBodies, Dedup and BodyHash,
+	// the bytes after it are still output but not recorded nor hashed, 0 for unlimited.
+	MaxBodyBytes int
+
+	// Offsets prints the byte offsets of the headers in the connection stream, and the size of each message.
+	Offsets bool
+
+	// Direction captures only the connections of the direction, DirectionInbound or DirectionOutbound,
+	// classified by the client/server sides in LocalIPs, empty for both.
+	Direction string
+	LocalIPs  map[string]bool
+
+	// Peers captures only the connections with the peers at the ips resolved from the DNS names, nil for all.
+	Peers *PeerResolver
 }
 
 func (o *Option) CanDump() bool {
@@ -47,19 +189,190 @@ func (o *Option) CanDump() bool {
 	return o.DumpMax <= 0 || atomic.LoadUint32(&o.dumpNum) < o.DumpMax
 }
 
+// SchemeOf returns the scheme of requests sent to dst, https if the port suggests TLS, or http.
+func (o *Option) SchemeOf(dst string) string {
+	if o.AssumeScheme != "" {
+		return o.AssumeScheme
+	}
+
+	if _, port, _ := net.SplitHostPort(dst); port == "443" || port == "8443" {
+		return "https"
+	}
+
+	return "http"
+}
+
+// FormatTime formats the timestamp in the output by the TimeFormat, a Go layout or
+// one of the presets rfc3339, rfc3339nano (default), unix, unixnano and epoch-ms.
+func (o *Option) FormatTime(t time.Time) string {
+	t = o.DisplayTime(t)
+	if o.UTC {
+		t = t.UTC()
+	}
+
+	switch strings.ToLower(o.TimeFormat) {
+	case "", "rfc3339nano":
+		return t.Format(time.RFC3339Nano)
+	case "rfc3339":
+		return t.Format(time.RFC3339)
+	case "unix":
+		return strconv.FormatInt(t.Unix(), 10)
+	case "unixnano":
+		return strconv.FormatInt(t.UnixNano(), 10)
+	case "epoch-ms":
+		return strconv.FormatInt(t.UnixMilli(), 10)
+	default:
+		return t.Format(o.TimeFormat)
+	}
+}
+
+const (
+	// TimestampOriginal displays the timestamps of the packets captured, the default.
+	TimestampOriginal = "original"
+	// TimestampNow displays the wall time when the messages are parsed.
+	TimestampNow = "now"
+	// TimestampFirst re-bases the timestamps of the packets, that the first one is displayed as the time
+	// it is parsed, keeping the intervals, like to replay an old pcap file as if captured now.
+	TimestampFirst = "first"
+)
+
+// the states of the rebaseOffset
+const (
+	rebaseUnset int32 = iota
+	rebaseSetting
+	rebaseSet
+)
+
+// rebase sets the rebaseOffset of TimestampFirst once, that the timestamp t of the first packet captured
+// is displayed as now, by the assembler before any message is parsed, or by the first timestamp displayed.
+func (o *Option) rebase(t time.Time) {
+	if o.TimestampBase != TimestampFirst || atomic.LoadInt32(&o.rebaseState) == rebaseSet {
+		return
+	}
+	if atomic.CompareAndSwapInt32(&o.rebaseState, rebaseUnset, rebaseSetting) {
+		atomic.StoreInt64(&o.rebaseOffset, int64(time.Since(t)))
+		atomic.StoreInt32(&o.rebaseState, rebaseSet)
+		return
+	}
+	for atomic.LoadInt32(&o.rebaseState) != rebaseSet { // being set by another goroutine
+		runtime.Gosched()
+	}
+}
+
+// DisplayTime derives the displayed timestamp from the timestamp of the packet by the TimestampBase.
+func (o *Option) DisplayTime(t time.Time) time.Time {
+	switch o.TimestampBase {
+	case TimestampNow:
+		return time.Now()
+	case TimestampFirst:
+		o.rebase(t)
+		return t.Add(time.Duration(atomic.LoadInt64(&o.rebaseOffset)))
+	default:
+		return t
+	}
+}
+
+const (
+	// DirectionInbound are the connections to the local servers, from the remote or local clients.
+	DirectionInbound = "inbound"
+	// DirectionOutbound are the connections from the local clients, to the remote or local servers.
+	DirectionOutbound = "outbound"
+)
+
+// PermitsDirection tells if the connection from the client to the server, like 127.0.0.1:5000, is in the Direction.
+func (o *Option) PermitsDirection(client, server string) bool {
+	local := func(addr string) bool {
+		ip, _, _ := net.SplitHostPort(addr)
+		return o.LocalIPs[ip]
+	}
+
+	switch o.Direction {
+	case DirectionInbound:
+		return local(server)
+	case DirectionOutbound:
+		return local(client)
+	default:
+		return true
+	}
+}
+
+// PermitsConnection tells if the connection from the client to the server is captured,
+// by the Direction and the Peers.
+func (o *Option) PermitsConnection(client, server string) bool {
+	return o.PermitsDirection(client, server) && o.Peers.Matches(client, server)
+}
+
+// IsTextType tells if the body of the content type (without parameters) is printed as text.
+func (o *Option) IsTextType(contentType string) bool {
+	if matchesContentType(contentType, o.BinaryTypes) {
+		return false
+	}
+
+	return matchesContentType(contentType, o.TextTypes) || ParseMimeType(contentType).isTextContent()
+}
+
+// IsBinaryType tells if the body of the content type (without parameters) is treated as binary.
+func (o *Option) IsBinaryType(contentType string) bool {
+	if matchesContentType(contentType, o.BinaryTypes) {
+		return true
+	}
+
+	return !matchesContentType(contentType, o.TextTypes) && ParseMimeType(contentType).isBinaryContent()
+}
+
+func matchesContentType(contentType string, patterns []string) bool {
+	contentType = strings.ToLower(contentType)
+	for _, p := range patterns {
+		if wildcardMatch(contentType, strings.ToLower(p)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// noBodyMethods are the request methods assumed to carry no body by default.
+var noBodyMethods = []string{"CONNECT", "GET", "HEAD", "TRACE", "OPTIONS"}
+
+// MethodHasBody tells if the request of the method is treated as body-bearing.
+func (o *Option) MethodHasBody(method string) bool {
+	if o.AlwaysReadBody {
+		return true
+	}
+
+	if o.BodyMethods != "" {
+		methods := ss.Split(o.BodyMethods, ss.WithSeps(","), ss.WithIgnoreEmpty(true), ss.WithTrimSpace(true))
+		return ss.AnyOfFold(method, methods...)
+	}
+
+	return !ss.AnyOf(method, noBodyMethods...)
+}
+
 func (o *Option) PermitsMethod(method string) bool {
 	return o.Method == "" || strings.Contains(o.Method, method)
 }
 
 func (o *Option) PermitsReq(r Req) bool {
-	return o.permitsHost(r.GetHost()) && o.permitsUri(r.GetRequestURI()) && o.permitN() && o.PermitRatio()
+	return o.permitsHost(r.GetHost()) && o.permitsUri(r.GetRequestURI()) && o.permitsUA(r.GetHeader()) &&
+		o.permitN() && o.PermitRatio()
+}
+
+func (o *Option) permitsUA(header http.Header) bool {
+	return o.UAMatcher.Match(header) && (o.ExcludeUAMatcher == nil || !o.ExcludeUAMatcher.Match(header))
 }
 
-func (o *Option) PermitsCode(code int) bool { return o.Status.Contains(code) }
+// PermitsCode tells if the response status code is included by Status, and then not excluded by ExcludeStatus.
+func (o *Option) PermitsCode(code int) bool {
+	return o.Status.Contains(code) && !o.ExcludeStatus.Matches(code)
+}
 
-func (o *Option) permitsUri(uri string) bool { return o.Uri == "" || wildcardMatch(uri, o.Uri) }
+func (o *Option) permitsUri(uri string) bool {
+	return (o.Uri == "" || wildcardMatch(uri, o.Uri)) && o.UriPatterns.Permits(uri)
+}
 
-func (o *Option) permitsHost(host string) bool { return o.Host == "" || wildcardMatch(host, o.Host) }
+func (o *Option) permitsHost(host string) bool {
+	return (o.Host == "" || wildcardMatch(host, o.Host)) && o.HostPatterns.Permits(host)
+}
 
 func (o *Option) ReachedN() bool {
 	reached := o.N > 0 && atomic.LoadInt32(&o.Num) <= 0
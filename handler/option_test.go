@@ -0,0 +1,106 @@
+package handler
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOptionFormatTime(t *testing.T) {
+	tm := time.Date(2024, 5, 6, 7, 8, 9, 123456789, time.FixedZone("CST", 8*3600))
+
+	assert.Equal(t, "2024-05-06T07:08:09.123456789+08:00", (&Option{}).FormatTime(tm))
+	assert.Equal(t, "2024-05-06T07:08:09+08:00", (&Option{TimeFormat: "rfc3339"}).FormatTime(tm))
+	assert.Equal(t, "1714950489", (&Option{TimeFormat: "unix"}).FormatTime(tm))
+	assert.Equal(t, "1714950489123", (&Option{TimeFormat: "epoch-ms"}).FormatTime(tm))
+	assert.Equal(t, "1714950489123456789", (&Option{TimeFormat: "unixnano"}).FormatTime(tm))
+	assert.Equal(t, "2024-05-05 23:08:09.123", (&Option{TimeFormat: "2006-01-02 15:04:05.000", UTC: true}).FormatTime(tm))
+}
+
+func TestOptionDisplayTime(t *testing.T) {
+	first := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	second := first.Add(1500 * time.Millisecond)
+
+	assert.Equal(t, first, (&Option{}).DisplayTime(first))
+	assert.Equal(t, first, (&Option{TimestampBase: TimestampOriginal}).DisplayTime(first))
+	assert.WithinDuration(t, time.Now(), (&Option{TimestampBase: TimestampNow}).DisplayTime(first), time.Second)
+
+	o := &Option{TimestampBase: TimestampFirst}
+	rebased := o.DisplayTime(first)
+	assert.WithinDuration(t, time.Now(), rebased, time.Second)
+	assert.Equal(t, rebased.Add(1500*time.Millisecond), o.DisplayTime(second))
+}
+
+func TestOptionRebaseFirstPacket(t *testing.T) {
+	first := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	o := &Option{TimestampBase: TimestampFirst}
+	a := NewTCPAssembler(&ConnectionHandlerFast{Context: context.Background(), Option: o}, 10, 1)
+	a.Assemble(gopacket.NewFlow(layers.EndpointIPv4, net.IPv4(127, 0, 0, 1).To4(), net.IPv4(127, 0, 0, 2).To4()),
+		&layers.TCP{SrcPort: 5000, DstPort: 8080, ACK: true}, first)
+	// the message parsed later than the first packet is displayed as later than now
+	assert.WithinDuration(t, time.Now().Add(time.Hour), o.DisplayTime(first.Add(time.Hour)), time.Second)
+
+	o = &Option{TimestampBase: TimestampFirst}
+	rebased := make([]time.Time, 10)
+	var wg sync.WaitGroup
+	for i := range rebased {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			rebased[i] = o.DisplayTime(first.Add(time.Duration(i) * time.Second)).Add(-time.Duration(i) * time.Second)
+		}(i)
+	}
+	wg.Wait()
+	for _, r := range rebased { // all by the same offset
+		assert.Equal(t, rebased[0], r)
+	}
+}
+
+func TestOptionContentTypes(t *testing.T) {
+	o := &Option{}
+	assert.True(t, o.IsTextType("application/json"))
+	assert.False(t, o.IsTextType("application/vnd.myapp+json"))
+	assert.True(t, o.IsBinaryType("application/octet-stream"))
+
+	o = &Option{
+		TextTypes:   []string{"application/vnd.myapp+*", "application/octet-stream"},
+		BinaryTypes: []string{"application/json"},
+	}
+	assert.True(t, o.IsTextType("application/vnd.myapp+json"))
+	assert.True(t, o.IsTextType("Application/Octet-Stream"))
+	assert.False(t, o.IsBinaryType("application/octet-stream"))
+	assert.False(t, o.IsTextType("application/json"))
+	assert.True(t, o.IsBinaryType("application/json"))
+}
+
+func TestOptionPermitsDirection(t *testing.T) {
+	local := map[string]bool{"10.0.0.1": true}
+	in := &Option{Direction: DirectionInbound, LocalIPs: local}
+	out := &Option{Direction: DirectionOutbound, LocalIPs: local}
+
+	assert.True(t, in.PermitsDirection("10.0.0.9:5000", "10.0.0.1:8080"))
+	assert.False(t, in.PermitsDirection("10.0.0.1:5000", "10.0.0.9:8080"))
+	assert.True(t, out.PermitsDirection("10.0.0.1:5000", "10.0.0.9:8080"))
+	assert.False(t, out.PermitsDirection("10.0.0.9:5000", "10.0.0.1:8080"))
+	assert.True(t, (&Option{}).PermitsDirection("10.0.0.9:5000", "10.0.0.1:8080"))
+}
+
+func TestOptionFormatConn(t *testing.T) {
+	o := &Option{}
+	assert.Equal(t, "127.0.0.1:5000-127.0.0.1:8080", o.FormatConn("127.0.0.1:5000", "127.0.0.1:8080"))
+
+	o.ConnFormat = ConnFormatArrow
+	assert.Equal(t, "127.0.0.1:5000->127.0.0.1:8080", o.FormatConn("127.0.0.1:5000", "127.0.0.1:8080"))
+
+	o.ConnFormat = ConnFormatHash
+	hash := o.FormatConn("127.0.0.1:5000", "127.0.0.1:8080")
+	assert.Regexp(t, `^[0-9a-f]{8}$`, hash)
+	assert.Equal(t, hash, o.FormatConn("127.0.0.1:8080", "127.0.0.1:5000"))
+	assert.NotEqual(t, hash, o.FormatConn("127.0.0.1:5001", "127.0.0.1:8080"))
+}
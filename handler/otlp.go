@@ -0,0 +1,221 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bingoohuang/gg/pkg/iox"
+)
+
+// OTLPExporter exports transactions as OpenTelemetry spans to an OTLP/HTTP endpoint in JSON encoding.
+type OTLPExporter struct {
+	endpoint string
+	client   *http.Client
+	ch       chan *Transaction
+	wg       sync.WaitGroup
+
+	lock   sync.RWMutex
+	closed bool
+}
+
+var _ TransactionHandler = (*OTLPExporter)(nil)
+
+const otlpBatchSize = 100
+
+// NewOTLPExporter creates an OTLPExporter, endpoint like http://127.0.0.1:4318,
+// the default path /v1/traces is used if endpoint has no path.
+func NewOTLPExporter(endpoint string) *OTLPExporter {
+	if u, err := url.Parse(endpoint); err == nil && (u.Path == "" || u.Path == "/") {
+		u.Path = "/v1/traces"
+		endpoint = u.String()
+	}
+
+	e := &OTLPExporter{
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		ch:       make(chan *Transaction, 4096),
+	}
+	e.wg.Add(1)
+	go e.loop()
+	return e
+}
+
+// HandleTransaction queues the transaction to export, it is dropped when the queue is full.
+func (e *OTLPExporter) HandleTransaction(t *Transaction) {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+
+	if e.closed {
+		return
+	}
+
+	select {
+	case e.ch <- t:
+	default:
+		log.Printf("W! otlp queue is full, span dropped")
+	}
+}
+
+// Close flushes the queued spans and stops the exporter.
+func (e *OTLPExporter) Close() error {
+	e.lock.Lock()
+	e.closed = true
+	close(e.ch)
+	e.lock.Unlock()
+
+	e.wg.Wait()
+	return nil
+}
+
+func (e *OTLPExporter) loop() {
+	defer e.wg.Done()
+
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+
+	batch := make([]*Transaction, 0, otlpBatchSize)
+	flush := func() {
+		if len(batch) > 0 {
+			if err := e.export(batch); err != nil {
+				log.Printf("E! otlp export %d spans failed: %v", len(batch), err)
+			}
+			batch = batch[:0]
+		}
+	}
+
+	for {
+		select {
+		case t, ok := <-e.ch:
+			if !ok {
+				flush()
+				return
+			}
+			if batch = append(batch, t); len(batch) >= otlpBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+type otlpKeyValue struct {
+	Key   string            `json:"key"`
+	Value map[string]string `json:"value"`
+}
+
+type otlpSpan struct {
+	TraceID      string         `json:"traceId"`
+	SpanID       string         `json:"spanId"`
+	ParentSpanID string         `json:"parentSpanId,omitempty"`
+	Name         string         `json:"name"`
+	Kind         int            `json:"kind"`
+	Start        string         `json:"startTimeUnixNano"`
+	End          string         `json:"endTimeUnixNano"`
+	Attributes   []otlpKeyValue `json:"attributes"`
+	Status       map[string]int `json:"status,omitempty"`
+}
+
+func otlpString(key, value string) otlpKeyValue {
+	return otlpKeyValue{Key: key, Value: map[string]string{"stringValue": value}}
+}
+
+func otlpInt(key string, value int) otlpKeyValue {
+	return otlpKeyValue{Key: key, Value: map[string]string{"intValue": strconv.Itoa(value)}}
+}
+
+// otlpSpanKindServer is SPAN_KIND_SERVER, the traffic is observed on the server side.
+const otlpSpanKindServer = 2
+
+func newOTLPSpan(t *Transaction) otlpSpan {
+	traceID, parentID, ok := parseTraceparent(t.ReqHeader.Get("traceparent"))
+	if !ok {
+		traceID = randomHex(16)
+	}
+
+	s := otlpSpan{
+		TraceID:      traceID,
+		SpanID:       randomHex(8),
+		ParentSpanID: parentID,
+		Name:         t.Method + " " + t.Path,
+		Kind:         otlpSpanKindServer,
+		Start:        strconv.FormatInt(t.Start.UnixNano(), 10),
+		End:          strconv.FormatInt(t.End.UnixNano(), 10),
+		Attributes: []otlpKeyValue{
+			otlpString("http.method", t.Method),
+			otlpString("http.host", t.Host),
+			otlpString("http.target", t.URI),
+			otlpInt("http.status_code", t.Status),
+			otlpString("net.peer", t.Src),
+			otlpString("net.host", t.Dst),
+		},
+	}
+	if t.Status >= 500 {
+		s.Status = map[string]int{"code": 2} // STATUS_CODE_ERROR
+	}
+
+	return s
+}
+
+// parseTraceparent parses the W3C traceparent header like 00-<32 hex trace id>-<16 hex parent id>-<2 hex flags>.
+func parseTraceparent(v string) (traceID, parentID string, ok bool) {
+	parts := strings.Split(strings.TrimSpace(v), "-")
+	if len(parts) != 4 || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return "", "", false
+	}
+	if _, err := hex.DecodeString(parts[1] + parts[2]); err != nil {
+		return "", "", false
+	}
+
+	return strings.ToLower(parts[1]), strings.ToLower(parts[2]), true
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (e *OTLPExporter) export(batch []*Transaction) error {
+	spans := make([]otlpSpan, 0, len(batch))
+	for _, t := range batch {
+		spans = append(spans, newOTLPSpan(t))
+	}
+
+	payload := map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": []otlpKeyValue{otlpString("service.name", "httpdump")},
+			},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]string{"name": "httpdump"},
+				"spans": spans,
+			}},
+		}},
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	rsp, err := e.client.Post(e.endpoint, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer iox.Close(rsp.Body)
+
+	if rsp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status %s", rsp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,19 @@
+package handler
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	traceID, parentID, ok := parseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	assert.True(t, ok)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", traceID)
+	assert.Equal(t, "00f067aa0ba902b7", parentID)
+
+	_, _, ok = parseTraceparent("00-xyz-00f067aa0ba902b7-01")
+	assert.False(t, ok)
+	_, _, ok = parseTraceparent("")
+	assert.False(t, ok)
+}
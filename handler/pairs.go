@@ -0,0 +1,123 @@
+package handler
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/bingoohuang/httpdump/replay"
+)
+
+// PairRecorder records the requests with their original responses into a file in JSON lines,
+// which can be replayed and compared by the replay client.
+type PairRecorder struct {
+	sync.Mutex
+	f *os.File
+	w *bufio.Writer
+}
+
+var _ TransactionHandler = (*PairRecorder)(nil)
+
+// NewPairRecorder creates a PairRecorder writing to the file.
+func NewPairRecorder(file string) (*PairRecorder, error) {
+	f, err := os.Create(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PairRecorder{f: f, w: bufio.NewWriter(f)}, nil
+}
+
+// HandleTransaction records the transaction as a pair record.
+func (p *PairRecorder) HandleTransaction(t *Transaction) {
+	data, err := json.Marshal(replay.PairRecord{
+		Timestamp:      t.Start.Format(time.RFC3339Nano),
+		Src:            t.Src,
+		Dst:            t.Dst,
+		Request:        t.RawRequest(),
+		Status:         t.Status,
+		ResponseHeader: t.RspHeader,
+		ResponseBody:   t.RspBody,
+	})
+	if err != nil {
+		log.Printf("E! marshal pair record failed: %v", err)
+		return
+	}
+
+	p.Lock()
+	defer p.Unlock()
+
+	_, _ = p.w.Write(data)
+	_ = p.w.WriteByte('\n')
+}
+
+// Close flushes and closes the file.
+func (p *PairRecorder) Close() error {
+	p.Lock()
+	defer p.Unlock()
+
+	if err := p.w.Flush(); err != nil {
+		_ = p.f.Close()
+		return err
+	}
+	return p.f.Close()
+}
+
+// RawRequest rebuilds the raw http request from the transaction.
+func (t *Transaction) RawRequest() []byte {
+	b := &bytes.Buffer{}
+	_, _ = fmt.Fprintf(b, "%s %s %s\r\n", t.Method, t.URI, t.Proto)
+	if t.Host != "" && t.ReqHeader.Get("Host") == "" {
+		_, _ = fmt.Fprintf(b, "Host: %s\r\n", t.Host)
+	}
+	_ = t.ReqHeader.Write(b)
+	b.WriteString("\r\n")
+	b.Write(t.ReqBody)
+	return b.Bytes()
+}
+
+type bufferedReq struct {
+	Req
+	body []byte
+}
+
+func (r *bufferedReq) GetBody() io.ReadCloser { return io.NopCloser(bytes.NewReader(r.body)) }
+func (r *bufferedReq) WireBodySize() int64    { return wireBodySizeOf(r.Req) }
+
+func (r *bufferedReq) StreamOffsets() (start, end int64) { return streamOffsetsOf(r.Req) }
+
+type bufferedRsp struct {
+	Rsp
+	body []byte
+}
+
+func (r *bufferedRsp) GetBody() io.ReadCloser { return io.NopCloser(bytes.NewReader(r.body)) }
+func (r *bufferedRsp) WireBodySize() int64    { return wireBodySizeOf(r.Rsp) }
+
+func (r *bufferedRsp) StreamOffsets() (start, end int64) { return streamOffsetsOf(r.Rsp) }
+
+// bufferReqBody reads the request body, at most n bytes if n > 0,
+// and returns the request to read the whole body again.
+func bufferReqBody(r Req, n int) (Req, []byte) {
+	if n > 0 {
+		return peekReqBody(r, int64(n))
+	}
+	body, _ := io.ReadAll(r.GetBody())
+	return &bufferedReq{Req: r, body: body}, body
+}
+
+// bufferRspBody reads the response body, at most n bytes if n > 0,
+// and returns the response to read the whole body again.
+func bufferRspBody(r Rsp, n int) (Rsp, []byte) {
+	if n > 0 {
+		return peekRspBody(r, int64(n))
+	}
+	body, _ := io.ReadAll(r.GetBody())
+	return &bufferedRsp{Rsp: r, body: body}, body
+}
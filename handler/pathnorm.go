@@ -0,0 +1,68 @@
+package handler
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// pathRule replaces the path segment matched by the regexp with the placeholder.
+type pathRule struct {
+	re          *regexp.Regexp
+	placeholder string
+}
+
+// builtinPathRules collapse the high-cardinality segments of numbers, uuids and hashes.
+var builtinPathRules = []pathRule{
+	{re: regexp.MustCompile(`^\d+$`), placeholder: "{id}"},
+	{re: regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`), placeholder: "{uuid}"},
+	{re: regexp.MustCompile(`^[0-9a-fA-F]{16,}$`), placeholder: "{hex}"},
+}
+
+// PathNormalizer collapses the variable segments of the paths into placeholders to group the routes,
+// like /users/123/orders/3f2a6c1e-... to /users/{id}/orders/{uuid}.
+// A nil *PathNormalizer keeps the paths as they are.
+type PathNormalizer struct {
+	rules []pathRule
+}
+
+// NewPathNormalizer creates a PathNormalizer with the custom rules like `^v\d+$={ver}` before the built-in rules,
+// the placeholder defaults to {id} if omitted. Rules of single "off" disables the normalization.
+func NewPathNormalizer(rules []string) (*PathNormalizer, error) {
+	if len(rules) == 1 && rules[0] == "off" {
+		return nil, nil
+	}
+
+	n := &PathNormalizer{}
+	for _, rule := range rules {
+		expr, placeholder := rule, "{id}"
+		if i := strings.LastIndex(rule, "="); i > 0 {
+			expr, placeholder = rule[:i], rule[i+1:]
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid path rule %s: %w", rule, err)
+		}
+		n.rules = append(n.rules, pathRule{re: re, placeholder: placeholder})
+	}
+	n.rules = append(n.rules, builtinPathRules...)
+	return n, nil
+}
+
+// Normalize normalizes the path segment by segment, by the first rule matched.
+func (n *PathNormalizer) Normalize(path string) string {
+	if n == nil {
+		return path
+	}
+
+	segments := strings.Split(path, "/")
+	for i, seg := range segments {
+		for _, r := range n.rules {
+			if seg != "" && r.re.MatchString(seg) {
+				segments[i] = r.placeholder
+				break
+			}
+		}
+	}
+	return strings.Join(segments, "/")
+}
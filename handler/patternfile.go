@@ -0,0 +1,91 @@
+package handler
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// pattern matches a host or a path by the wildcard (*, ?) or by the regexp.
+type pattern struct {
+	wildcard string
+	re       *regexp.Regexp
+	exclude  bool
+}
+
+func (p pattern) matches(s string) bool {
+	if p.re != nil {
+		return p.re.MatchString(s)
+	}
+	return wildcardMatch(s, p.wildcard)
+}
+
+// PatternList is the allowlist/denylist of the hosts or paths loaded from a file.
+// A nil *PatternList permits all.
+type PatternList struct {
+	includes, excludes []pattern
+}
+
+// LoadPatternFile loads the patterns from the file, one per line, blank lines and # comments are ignored.
+// A line is a wildcard pattern like *.example.com, or a regexp with the prefix ~, like ~^/api/v\d+/.
+// A line with the prefix ! excludes the matched, like !/health.
+func LoadPatternFile(file string) (*PatternList, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	l := &PatternList{}
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		var p pattern
+		if p.exclude = strings.HasPrefix(line, "!"); p.exclude {
+			line = line[1:]
+		}
+		if expr, ok := strings.CutPrefix(line, "~"); ok {
+			if p.re, err = regexp.Compile(expr); err != nil {
+				return nil, fmt.Errorf("invalid pattern at %s:%d: %w", file, lineNo, err)
+			}
+		} else {
+			p.wildcard = line
+		}
+
+		if p.exclude {
+			l.excludes = append(l.excludes, p)
+		} else {
+			l.includes = append(l.includes, p)
+		}
+	}
+
+	return l, scanner.Err()
+}
+
+// Permits tells if s matches none of the excludes, and any of the includes if there are.
+func (l *PatternList) Permits(s string) bool {
+	if l == nil {
+		return true
+	}
+
+	for _, p := range l.excludes {
+		if p.matches(s) {
+			return false
+		}
+	}
+	if len(l.includes) == 0 {
+		return true
+	}
+	for _, p := range l.includes {
+		if p.matches(s) {
+			return true
+		}
+	}
+	return false
+}
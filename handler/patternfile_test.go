@@ -0,0 +1,33 @@
+package handler
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPatternList(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "paths.txt")
+	_ = os.WriteFile(file, []byte("# api only\n/api/*\n~^/v\\d+/\n\n!/api/health\n"), 0o644)
+
+	l, err := LoadPatternFile(file)
+	assert.Nil(t, err)
+	assert.True(t, l.Permits("/api/users"))
+	assert.True(t, l.Permits("/v2/users"))
+	assert.False(t, l.Permits("/api/health"))
+	assert.False(t, l.Permits("/static/a.js"))
+
+	_ = os.WriteFile(file, []byte("!*.internal\n"), 0o644)
+	l, err = LoadPatternFile(file)
+	assert.Nil(t, err)
+	assert.True(t, l.Permits("a.example.com"))
+	assert.False(t, l.Permits("db.internal"))
+
+	assert.True(t, (*PatternList)(nil).Permits("any"))
+
+	_ = os.WriteFile(file, []byte("~[\n"), 0o644)
+	_, err = LoadPatternFile(file)
+	assert.NotNil(t, err)
+}
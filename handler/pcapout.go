@@ -0,0 +1,198 @@
+package handler
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+// pcapMaxRetained is the max number of the packets retained for a connection before its filter decision.
+const pcapMaxRetained = 4096
+
+// PcapWriter writes the packets of the connections which passed the filters to a pcap file.
+// The packets are retained per connection until the filter decision is made.
+// All methods are safe to be called on a nil *PcapWriter, which writes nothing.
+type PcapWriter struct {
+	sync.Mutex
+
+	file   *os.File
+	writer *pcapgo.Writer
+	idle   time.Duration
+	conns  map[string]*pcapConn
+	expire time.Time
+}
+
+type pcapConn struct {
+	matched  bool
+	skipped  bool // too many packets retained before the filter decision
+	packets  []gopacket.Packet
+	lastSeen time.Time
+}
+
+// NewPcapWriter creates a new PcapWriter to the file,
+// the connections not matched in idle time are dropped.
+func NewPcapWriter(file string, idle time.Duration) (*PcapWriter, error) {
+	f, err := os.Create(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PcapWriter{file: f, idle: idle, conns: map[string]*pcapConn{}}, nil
+}
+
+// Tee retains or writes the packets flowing through, and returns the channel of them.
+func (w *PcapWriter) Tee(ctx context.Context, packets chan gopacket.Packet) chan gopacket.Packet {
+	if w == nil {
+		return packets
+	}
+
+	out := make(chan gopacket.Packet, cap(packets))
+	go func() {
+		defer close(out)
+
+		for p := range packets {
+			if p != nil {
+				w.retain(p)
+			}
+
+			select {
+			case out <- p:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return out
+}
+
+func (w *PcapWriter) retain(p gopacket.Packet) {
+	key, ok := pcapConnKey(p)
+	if !ok {
+		return
+	}
+
+	w.Lock()
+	defer w.Unlock()
+
+	now := time.Now()
+	w.expireIdle(now)
+
+	c := w.conns[key]
+	if c == nil {
+		c = &pcapConn{}
+		w.conns[key] = c
+	}
+	c.lastSeen = now
+
+	switch {
+	case c.matched:
+		w.write(p)
+	case c.skipped:
+	case len(c.packets) >= pcapMaxRetained:
+		c.skipped, c.packets = true, nil
+	default:
+		c.packets = append(c.packets, p)
+	}
+}
+
+// expireIdle drops the connections which have been idle, at most once per second.
+func (w *PcapWriter) expireIdle(now time.Time) {
+	if now.Before(w.expire) {
+		return
+	}
+	w.expire = now.Add(time.Second)
+
+	for key, c := range w.conns {
+		if now.Sub(c.lastSeen) > w.idle {
+			delete(w.conns, key)
+		}
+	}
+}
+
+// Match marks the connection between src and dst passed the filters,
+// and writes its retained packets.
+func (w *PcapWriter) Match(src, dst string) {
+	if w == nil {
+		return
+	}
+
+	w.Lock()
+	defer w.Unlock()
+
+	c := w.conns[connKey(src, dst)]
+	if c == nil || c.matched || c.skipped {
+		return
+	}
+
+	c.matched = true
+	for _, p := range c.packets {
+		w.write(p)
+	}
+	c.packets = nil
+}
+
+func (w *PcapWriter) write(p gopacket.Packet) {
+	if w.writer == nil {
+		w.writer = pcapgo.NewWriter(w.file)
+		if err := w.writer.WriteFileHeader(65536, linkType(p)); err != nil {
+			return
+		}
+	}
+
+	_ = w.writer.WritePacket(p.Metadata().CaptureInfo, p.Data())
+}
+
+// Close closes the pcap file.
+func (w *PcapWriter) Close() error {
+	if w == nil {
+		return nil
+	}
+
+	w.Lock()
+	defer w.Unlock()
+
+	return w.file.Close()
+}
+
+// pcapConnKey returns the key of the tcp connection of the packet, same for both directions.
+func pcapConnKey(p gopacket.Packet) (string, bool) {
+	n := p.NetworkLayer()
+	tcp, ok := p.TransportLayer().(*layers.TCP)
+	if n == nil || !ok {
+		return "", false
+	}
+
+	flow := n.NetworkFlow()
+	return connKey(flow.Src().String()+":"+strconv.Itoa(int(tcp.SrcPort)),
+		flow.Dst().String()+":"+strconv.Itoa(int(tcp.DstPort))), true
+}
+
+func connKey(a, b string) string {
+	if a > b {
+		a, b = b, a
+	}
+	return a + "-" + b
+}
+
+func linkType(p gopacket.Packet) layers.LinkType {
+	l := p.LinkLayer()
+	if l == nil {
+		return layers.LinkTypeRaw
+	}
+
+	switch l.LayerType() {
+	case layers.LayerTypeLinuxSLL:
+		return layers.LinkTypeLinuxSLL
+	case layers.LayerTypeLoopback:
+		return layers.LinkTypeNull
+	default:
+		return layers.LinkTypeEthernet
+	}
+}
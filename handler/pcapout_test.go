@@ -0,0 +1,68 @@
+package handler
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/stretchr/testify/assert"
+)
+
+func tcpPacket(t *testing.T, src, dst string, srcPort, dstPort int) gopacket.Packet {
+	eth := &layers.Ethernet{
+		SrcMAC: net.HardwareAddr{0, 0, 0, 0, 0, 1}, DstMAC: net.HardwareAddr{0, 0, 0, 0, 0, 2},
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+	ip := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolTCP, SrcIP: net.ParseIP(src), DstIP: net.ParseIP(dst)}
+	tcp := &layers.TCP{SrcPort: layers.TCPPort(srcPort), DstPort: layers.TCPPort(dstPort), ACK: true, Window: 1024}
+	assert.Nil(t, tcp.SetNetworkLayerForChecksum(ip))
+
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	assert.Nil(t, gopacket.SerializeLayers(buf, opts, eth, ip, tcp, gopacket.Payload("GET / HTTP/1.1\r\n\r\n")))
+
+	p := gopacket.NewPacket(buf.Bytes(), layers.LayerTypeEthernet, gopacket.Default)
+	p.Metadata().CaptureInfo = gopacket.CaptureInfo{Timestamp: time.Now(), CaptureLength: len(buf.Bytes()), Length: len(buf.Bytes())}
+	return p
+}
+
+func TestPcapWriter(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "out.pcap")
+	w, err := NewPcapWriter(file, time.Minute)
+	assert.Nil(t, err)
+
+	packets := make(chan gopacket.Packet, 4)
+	packets <- tcpPacket(t, "10.0.0.1", "10.0.0.2", 50000, 80)
+	packets <- tcpPacket(t, "10.0.0.2", "10.0.0.2", 50001, 80)
+	packets <- tcpPacket(t, "10.0.0.2", "10.0.0.1", 80, 50000)
+	close(packets)
+
+	for range w.Tee(context.Background(), packets) {
+	}
+	w.Match("10.0.0.1:50000", "10.0.0.2:80")
+	w.Match("10.0.0.9:1", "10.0.0.2:80")
+	assert.Nil(t, w.Close())
+
+	f, err := os.Open(file)
+	assert.Nil(t, err)
+	defer f.Close()
+
+	r, err := pcapgo.NewReader(f)
+	assert.Nil(t, err)
+	assert.Equal(t, layers.LinkTypeEthernet, r.LinkType())
+
+	n := 0
+	for {
+		if _, _, err := r.ReadPacketData(); err != nil {
+			break
+		}
+		n++
+	}
+	assert.Equal(t, 2, n)
+}
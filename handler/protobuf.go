@@ -0,0 +1,216 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"os"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// ProtoDecoder decodes protobuf bodies to JSON by the message types in a descriptor set.
+type ProtoDecoder struct {
+	files *protoregistry.Files
+}
+
+// NewProtoDecoder creates a ProtoDecoder from the descriptor set file,
+// which is created by protoc --include_imports --descriptor_set_out=descriptor.pb.
+func NewProtoDecoder(descriptorFile string) (*ProtoDecoder, error) {
+	data, err := os.ReadFile(descriptorFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var set descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("unmarshal descriptor set %s failed: %w", descriptorFile, err)
+	}
+
+	files, err := protodesc.NewFiles(&set)
+	if err != nil {
+		return nil, fmt.Errorf("create descriptors from %s failed: %w", descriptorFile, err)
+	}
+
+	return &ProtoDecoder{files: files}, nil
+}
+
+// protobufContent tells if the content type is grpc or protobuf, and if it is grpc framed.
+func protobufContent(contentType string) (isProtobuf, isGrpc bool) {
+	mt, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case strings.HasPrefix(mt, "application/grpc"):
+		return true, true
+	case mt == "application/x-protobuf", mt == "application/protobuf", mt == "application/x-google-protobuf":
+		return true, false
+	default:
+		return false, false
+	}
+}
+
+// messageType finds the message descriptor of the body.
+// The grpc path like /pkg.Service/Method determines the input or output type of the method,
+// otherwise the proto or messageType parameter of the content type is used.
+func (d *ProtoDecoder) messageType(contentType, path string, isRequest bool) protoreflect.MessageDescriptor {
+	if d == nil {
+		return nil
+	}
+
+	if _, params, err := mime.ParseMediaType(contentType); err == nil {
+		name := params["proto"]
+		if name == "" {
+			name = params["messagetype"]
+		}
+		if name != "" {
+			if desc, err := d.files.FindDescriptorByName(protoreflect.FullName(name)); err == nil {
+				if md, ok := desc.(protoreflect.MessageDescriptor); ok {
+					return md
+				}
+			}
+		}
+	}
+
+	service, method, ok := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	if !ok {
+		return nil
+	}
+	desc, err := d.files.FindDescriptorByName(protoreflect.FullName(service))
+	if err != nil {
+		return nil
+	}
+	sd, ok := desc.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return nil
+	}
+	md := sd.Methods().ByName(protoreflect.Name(method))
+	if md == nil {
+		return nil
+	}
+	if isRequest {
+		return md.Input()
+	}
+	return md.Output()
+}
+
+// decodeProtobufBody decodes the protobuf body to JSON, or to a hex dump with field number hints
+// if the message type is unknown.
+func (d *ProtoDecoder) decodeProtobufBody(body []byte, contentType, path string, isRequest bool) string {
+	if isWeb, isText := grpcWebContent(contentType); isWeb {
+		return d.decodeGrpcWebBody(body, isText, contentType, path, isRequest)
+	}
+
+	_, isGrpc := protobufContent(contentType)
+	messages := [][]byte{body}
+	if isGrpc {
+		messages = splitGrpcFrames(body)
+	}
+
+	md := d.messageType(contentType, path, isRequest)
+	b := &strings.Builder{}
+	for _, msg := range messages {
+		writeMessage(b, md, msg)
+	}
+
+	return b.String()
+}
+
+// writeMessage writes the message decoded to JSON by the message descriptor,
+// or the hex dump with field number hints if the descriptor is nil or mismatched.
+func writeMessage(b *strings.Builder, md protoreflect.MessageDescriptor, msg []byte) {
+	if md != nil {
+		m := dynamicpb.NewMessage(md)
+		if err := proto.Unmarshal(msg, m); err == nil {
+			b.WriteString(protojson.Format(m))
+			b.WriteString("\n")
+			return
+		}
+	}
+
+	b.WriteString(hex.Dump(msg))
+	writeFieldHints(b, msg, "")
+}
+
+// splitGrpcFrames splits the grpc length-prefixed messages,
+// each one has 1 byte compressed flag and 4 bytes big endian length before the message.
+func splitGrpcFrames(body []byte) (messages [][]byte) {
+	for len(body) >= 5 {
+		n := binary.BigEndian.Uint32(body[1:5])
+		if uint64(len(body)-5) < uint64(n) {
+			break
+		}
+		messages = append(messages, body[5:5+n])
+		body = body[5+n:]
+	}
+	if len(body) > 0 {
+		messages = append(messages, body)
+	}
+	return messages
+}
+
+// writeFieldHints writes the field numbers and wire types of the raw protobuf message.
+func writeFieldHints(b *strings.Builder, msg []byte, indent string) {
+	for len(msg) > 0 {
+		num, typ, n := protowire.ConsumeTag(msg)
+		if n < 0 {
+			fmt.Fprintf(b, "%s(invalid wire data, %d bytes left)\n", indent, len(msg))
+			return
+		}
+		msg = msg[n:]
+
+		switch typ {
+		case protowire.VarintType:
+			v, n := protowire.ConsumeVarint(msg)
+			if n < 0 {
+				return
+			}
+			fmt.Fprintf(b, "%sfield %d (varint): %d\n", indent, num, v)
+			msg = msg[n:]
+		case protowire.Fixed32Type:
+			v, n := protowire.ConsumeFixed32(msg)
+			if n < 0 {
+				return
+			}
+			fmt.Fprintf(b, "%sfield %d (fixed32): %d\n", indent, num, v)
+			msg = msg[n:]
+		case protowire.Fixed64Type:
+			v, n := protowire.ConsumeFixed64(msg)
+			if n < 0 {
+				return
+			}
+			fmt.Fprintf(b, "%sfield %d (fixed64): %d\n", indent, num, v)
+			msg = msg[n:]
+		case protowire.BytesType:
+			v, n := protowire.ConsumeBytes(msg)
+			if n < 0 {
+				return
+			}
+			if isPrintable(v) {
+				fmt.Fprintf(b, "%sfield %d (bytes, len %d): %q\n", indent, num, len(v), v)
+			} else {
+				fmt.Fprintf(b, "%sfield %d (bytes, len %d): %x\n", indent, num, len(v), v)
+			}
+			msg = msg[n:]
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, msg)
+			if n < 0 {
+				return
+			}
+			fmt.Fprintf(b, "%sfield %d (wire type %d, len %d)\n", indent, num, typ, n)
+			msg = msg[n:]
+		}
+	}
+}
+
+func isPrintable(v []byte) bool {
+	return len(bytes.TrimFunc(v, func(r rune) bool { return r >= 0x20 && r < 0x7f })) == 0
+}
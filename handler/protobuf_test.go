@@ -0,0 +1,59 @@
+package handler
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+func TestDecodeProtobufBodyWithoutDescriptor(t *testing.T) {
+	var msg []byte
+	msg = protowire.AppendTag(msg, 1, protowire.VarintType)
+	msg = protowire.AppendVarint(msg, 150)
+	msg = protowire.AppendTag(msg, 2, protowire.BytesType)
+	msg = protowire.AppendString(msg, "hello")
+
+	frame := append([]byte{0, 0, 0, 0, byte(len(msg))}, msg...)
+	assert.Equal(t, [][]byte{msg}, splitGrpcFrames(frame))
+
+	var d *ProtoDecoder
+	s := d.decodeProtobufBody(frame, "application/grpc", "/pkg.Service/Method", true)
+	assert.Contains(t, s, "field 1 (varint): 150")
+	assert.Contains(t, s, `field 2 (bytes, len 5): "hello"`)
+}
+
+func TestDecodeProtobufResponseStd(t *testing.T) {
+	file := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("pkg.proto"),
+		Package: proto.String("pkg"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("Reply"),
+			Field: []*descriptorpb.FieldDescriptorProto{{
+				Name: proto.String("name"), Number: proto.Int32(1), JsonName: proto.String("name"),
+				Type:  descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+				Label: descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			}},
+		}},
+		Service: []*descriptorpb.ServiceDescriptorProto{{
+			Name:   proto.String("Service"),
+			Method: []*descriptorpb.MethodDescriptorProto{{Name: proto.String("Get"), InputType: proto.String(".pkg.Reply"), OutputType: proto.String(".pkg.Reply")}},
+		}},
+	}
+	files, err := protodesc.NewFiles(&descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{file}})
+	require.Nil(t, err)
+
+	msg := protowire.AppendString(protowire.AppendTag(nil, 1, protowire.BytesType), "hello")
+	frame := string(append([]byte{0, 0, 0, 0, byte(len(msg))}, msg...))
+	c := newTestConn(&Option{SrcRatio: 1, Resp: 1, Proto: &ProtoDecoder{files: files}})
+	c.requests("POST /pkg.Service/Get HTTP/1.1\r\nHost: a.b\r\nContent-Type: application/grpc\r\nContent-Length: 0\r\n\r\n")
+	c.responses("HTTP/1.1 200 OK\r\nContent-Type: application/grpc\r\nContent-Length: " + strconv.Itoa(len(frame)) + "\r\n\r\n" + frame)
+
+	assert.Regexp(t, `"name":\s*"hello"`, c.output()) // decoded by the output type of the method of the request
+}
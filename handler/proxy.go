@@ -0,0 +1,131 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/bingoohuang/gg/pkg/iox"
+)
+
+// Proxy is a passthrough proxy which forwards connections to the target and dumps the http traffic in flight.
+// It captures the traffic that pcap can't see, like http over unix domain sockets.
+type Proxy struct {
+	context.Context
+
+	listen, target string
+	factory        *Factory
+	connSeq        int32
+}
+
+// NewProxy creates a Proxy listening on listen and forwarding to target,
+// both in the form of unix:/path/to.sock, tcp:host:port or host:port.
+func NewProxy(ctx context.Context, option *Option, sender Sender, listen, target string) *Proxy {
+	return &Proxy{
+		Context: ctx,
+		listen:  listen,
+		target:  target,
+		factory: &Factory{Context: ctx, option: option, sender: sender},
+	}
+}
+
+// parseNetAddr parses address like unix:/path/to.sock, tcp:host:port or host:port to network and address.
+func parseNetAddr(addr string) (network, address string) {
+	if strings.HasPrefix(addr, "unix:") {
+		return "unix", strings.TrimPrefix(addr, "unix:")
+	}
+
+	return "tcp", strings.TrimPrefix(addr, "tcp:")
+}
+
+type proxyKey struct {
+	src, dst string
+}
+
+func (k proxyKey) Src() string { return k.src }
+func (k proxyKey) Dst() string { return k.dst }
+
+var _ Key = (*proxyKey)(nil)
+
+// Serve accepts connections until the context is done.
+func (p *Proxy) Serve() error {
+	network, address := parseNetAddr(p.listen)
+	if network == "unix" {
+		_ = os.Remove(address) // remove the stale socket file left by last run
+	}
+
+	l, err := net.Listen(network, address)
+	if err != nil {
+		return fmt.Errorf("listen %s failed: %w", p.listen, err)
+	}
+
+	log.Printf("proxy listen on %s, forward to %s", p.listen, p.target)
+
+	go func() {
+		<-p.Done()
+		iox.Close(l)
+	}()
+
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			if p.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		go p.serve(c)
+	}
+}
+
+func (p *Proxy) serve(c net.Conn) {
+	defer iox.Close(c)
+
+	network, address := parseNetAddr(p.target)
+	t, err := net.Dial(network, address)
+	if err != nil {
+		log.Printf("E! dial %s failed: %v", p.target, err)
+		return
+	}
+	defer iox.Close(t)
+
+	seq := atomic.AddInt32(&p.connSeq, 1)
+	src := c.RemoteAddr().String()
+	if src == "" || src == "@" { // unnamed unix socket peer
+		src = fmt.Sprintf("%s#%d", p.listen, seq)
+	}
+
+	b := NewBase(p.Context, &proxyKey{src: src, dst: p.target}, p.factory.option, p.factory.sender)
+	reqReader, reqWriter := io.Pipe()
+	rspReader, rspWriter := io.Pipe()
+	go p.factory.run(b, reqReader)
+	go p.factory.run(b, rspReader)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go pipeTee(&wg, t, c, reqWriter)
+	go pipeTee(&wg, c, t, rspWriter)
+	wg.Wait()
+}
+
+// pipeTee copies data from src to dst, and tees the data to w for dumping.
+func pipeTee(wg *sync.WaitGroup, dst, src net.Conn, w *io.PipeWriter) {
+	defer wg.Done()
+
+	_, err := io.Copy(io.MultiWriter(dst, w), src)
+	_ = w.CloseWithError(err)
+
+	// half close to let the peer know there is nothing more to read
+	if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+		_ = cw.CloseWrite()
+	} else {
+		iox.Close(dst)
+	}
+}
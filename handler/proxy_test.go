@@ -0,0 +1,76 @@
+package handler
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProxy(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("X-Echo", r.URL.Path)
+		_, _ = w.Write(append([]byte("echo "), body...))
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sock := filepath.Join(t.TempDir(), "proxy.sock")
+	out := &collectSender{}
+	p := NewProxy(ctx, &Option{SrcRatio: 1, Resp: 1}, out, "unix:"+sock, server.Listener.Addr().String())
+	served := make(chan error, 1)
+	go func() { served <- p.Serve() }()
+	require.Eventually(t, func() bool { _, err := os.Stat(sock); return err == nil }, time.Second, time.Millisecond)
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", sock)
+		},
+		DisableKeepAlives: true,
+	}}
+	rsp, err := client.Post("http://proxy/hello", "text/plain", strings.NewReader("ping"))
+	require.Nil(t, err)
+	body, err := io.ReadAll(rsp.Body)
+	require.Nil(t, rsp.Body.Close())
+	require.Nil(t, err)
+
+	// the traffic is passed through as is
+	assert.Equal(t, "echo ping", string(body))
+	assert.Equal(t, "/hello", rsp.Header.Get("X-Echo"))
+
+	// and dumped in flight
+	var dumped string
+	assert.Eventually(t, func() bool {
+		dumped = strings.Join(out.messages(), "")
+		return strings.Contains(dumped, "echo ping") && strings.Contains(dumped, "\r\n\r\nping")
+	}, time.Second, time.Millisecond)
+	assert.Contains(t, dumped, "POST /hello HTTP/1.1\r\n")
+	assert.Contains(t, dumped, "\r\n200 OK\r\n")
+	assert.Contains(t, dumped, "X-Echo: /hello\r\n")
+	assert.Contains(t, dumped, " unix:"+sock+"#1-"+server.Listener.Addr().String()+" ")
+
+	cancel()
+	assert.Nil(t, <-served)
+}
+
+func TestParseNetAddr(t *testing.T) {
+	for addr, expected := range map[string][2]string{
+		"unix:/tmp/a.sock": {"unix", "/tmp/a.sock"},
+		"tcp:a.b:80":       {"tcp", "a.b:80"},
+		"a.b:80":           {"tcp", "a.b:80"},
+	} {
+		network, address := parseNetAddr(addr)
+		assert.Equal(t, expected, [2]string{network, address}, addr)
+	}
+}
@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bingoohuang/httpdump/util"
+)
+
+// FormatRaw outputs the requests and responses re-serialized as the raw HTTP messages, dechunked,
+// each one a record like raw REQ 1 127.0.0.1:5000-127.0.0.1:8080 2024-05-06T07:08:09Z 78,
+// followed by the 78 bytes of the message and a newline, so that the binary bodies never break the records.
+const FormatRaw = "raw"
+
+// rawRecord re-serializes the message of the start line, the header lines and the body as a FormatRaw record.
+// The header lines are kept as captured, and the body as on the wire but dechunked,
+// with the Content-Length of it instead of the Transfer-Encoding.
+func (h *Base) rawRecord(tag Tag, seq int32, t time.Time, startLine string, lines []string, body io.Reader) string {
+	data, _ := io.ReadAll(body)
+
+	chunked := hasHeaderLine(lines, "Transfer-Encoding")
+	contentLength := hasHeaderLine(lines, "Content-Length")
+
+	msg := &bytes.Buffer{}
+	writeLine(msg, startLine)
+	for _, line := range lines {
+		// the Content-Length of the chunked body is replaced by the one of the dechunked body
+		if isHeaderLine(line, "Transfer-Encoding") || chunked && isHeaderLine(line, "Content-Length") {
+			continue
+		}
+		writeLine(msg, line)
+	}
+	// the captured Content-Length is kept, like the ones of the responses to HEAD or 304 without bodies
+	if chunked || !contentLength && len(data) > 0 {
+		writeLine(msg, "Content-Length: "+strconv.Itoa(len(data)))
+	}
+	writeBytes(msg, []byte("\r\n"))
+	writeBytes(msg, data)
+
+	return fmt.Sprintf("raw %s %d %s %s %d\n%s\n", tag, seq, h.conn(), h.option.FormatTime(t), msg.Len(), msg.Bytes())
+}
+
+// rawHeaderLines returns the header lines of the raw message after the start line, as captured in their order
+// and case, nil if the headers are incomplete.
+func rawHeaderLines(raw []byte) []string {
+	end := util.MIMEHeadersEndPos(raw)
+	start := util.MIMEHeadersStartPos(raw)
+	if end < 0 || start < 0 {
+		return nil
+	}
+
+	lines := []string{}
+	if start < end-2 {
+		lines = strings.Split(string(raw[start:end-4]), "\r\n")
+	}
+	return lines
+}
+
+// rawRequest re-serializes the request as a FormatRaw record.
+func (h *Base) rawRequest(r Req, seq int32, t time.Time) string {
+	lines := r.GetRawHeaders()
+	if host := r.GetHost(); host != "" && !hasHeaderLine(lines, "Host") {
+		lines = append([]string{"Host: " + host}, lines...) // restored, taken out of the header by net/http
+	}
+	startLine := r.GetMethod() + " " + r.GetRequestURI() + " " + r.GetProto()
+	return h.rawRecord(TagRequest, seq, t, startLine, lines, r.GetBody())
+}
+
+// rawResponse re-serializes the response as a FormatRaw record.
+func (h *Base) rawResponse(r Rsp, seq int32, t time.Time) string {
+	statusLine := r.GetStatusLine()
+	if !strings.HasPrefix(statusLine, "HTTP/") {
+		statusLine = "HTTP/1.1 " + statusLine
+	}
+	return h.rawRecord(TagResponse, seq, t, statusLine, r.GetRawHeaders(), r.GetBody())
+}
+
+// hasHeaderLine tells if the header lines have the header of the name.
+func hasHeaderLine(lines []string, name string) bool {
+	for _, line := range lines {
+		if isHeaderLine(line, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// isHeaderLine tells if the header line is of the header of the name.
+func isHeaderLine(line, name string) bool {
+	n, _, ok := strings.Cut(line, ":")
+	return ok && strings.EqualFold(strings.TrimSpace(n), name)
+}
@@ -0,0 +1,44 @@
+package handler
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormatRaw(t *testing.T) {
+	c := newTestConn(&Option{SrcRatio: 1, Resp: 1, Format: FormatRaw})
+	c.requests("POST /a HTTP/1.1\r\nHost: a.b\r\nTransfer-Encoding: chunked\r\n\r\n3\r\n\x00\n\x01\r\n0\r\n\r\n")
+	c.responses("HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok")
+
+	msgs := c.messages()
+	require.Len(t, msgs, 2)
+
+	req := "POST /a HTTP/1.1\r\nHost: a.b\r\nContent-Length: 3\r\n\r\n\x00\n\x01"
+	assert.Regexp(t, `^raw REQ 1 127.0.0.1:5000-127.0.0.2:8080 \S+ `+strconv.Itoa(len(req))+`\n`, msgs[0])
+	assert.True(t, strings.HasSuffix(msgs[0], "\n"+req+"\n"))
+
+	rsp := "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok"
+	assert.Regexp(t, `^raw RSP 1 127.0.0.2:8080-127.0.0.1:5000 \S+ `+strconv.Itoa(len(rsp))+`\n`, msgs[1])
+	assert.True(t, strings.HasSuffix(msgs[1], "\n"+rsp+"\n"))
+}
+
+func TestFormatRawHeaderLines(t *testing.T) {
+	c := newTestConn(&Option{SrcRatio: 1, Resp: 1, Format: FormatRaw})
+	c.requests("GET /a HTTP/1.1\r\nx-b: 1\r\nHost: a.b\r\nX-B: 2\r\naccept: */*\r\n\r\n")
+	c.responses("HTTP/1.1 304 Not Modified\r\nETAG: \"x\"\r\nContent-Length: 10\r\n\r\n")
+
+	msgs := c.messages()
+	require.Len(t, msgs, 2)
+	assert.True(t, strings.HasSuffix(msgs[0], "\nGET /a HTTP/1.1\r\nx-b: 1\r\nHost: a.b\r\nX-B: 2\r\naccept: */*\r\n\r\n\n"))
+	assert.True(t, strings.HasSuffix(msgs[1], "\nHTTP/1.1 304 Not Modified\r\nETAG: \"x\"\r\nContent-Length: 10\r\n\r\n\n"))
+}
+
+func TestRawHeaderLines(t *testing.T) {
+	assert.Equal(t, []string{"A: 1", "b: 2"}, rawHeaderLines([]byte("GET / HTTP/1.1\r\nA: 1\r\nb: 2\r\n\r\nbody")))
+	assert.Equal(t, []string{}, rawHeaderLines([]byte("GET / HTTP/1.1\r\n\r\n")))
+	assert.Nil(t, rawHeaderLines([]byte("GET / HTTP/1.1\r\nA: 1\r\n")))
+}
@@ -0,0 +1,76 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"slices"
+	"sync/atomic"
+	"time"
+)
+
+// PeerResolver resolves the DNS names to the ips of the peers to capture, re-resolved periodically,
+// which matches the network peers rather than the Host header.
+type PeerResolver struct {
+	names  []string
+	byName map[string][]string // the last ips resolved of the names, kept if the name fails to resolve
+	ips    atomic.Pointer[map[string]bool]
+	lookup func(ctx context.Context, host string) ([]string, error)
+}
+
+// NewPeerResolver creates the PeerResolver of the names, resolved at once.
+func NewPeerResolver(ctx context.Context, names []string) (*PeerResolver, error) {
+	r := &PeerResolver{names: names, byName: map[string][]string{}, lookup: net.DefaultResolver.LookupHost}
+	if len(r.resolve(ctx)) == 0 {
+		return nil, fmt.Errorf("no ips resolved from %v", names)
+	}
+	return r, nil
+}
+
+// resolve resolves the names, and returns the ips.
+func (r *PeerResolver) resolve(ctx context.Context) map[string]bool {
+	ips := map[string]bool{}
+	for _, name := range r.names {
+		if addrs, err := r.lookup(ctx, name); err != nil {
+			log.Printf("W! resolve %s failed: %v", name, err)
+		} else if slices.Sort(addrs); !slices.Equal(r.byName[name], addrs) {
+			log.Printf("I! resolved %s: %v", name, addrs)
+			r.byName[name] = addrs
+		}
+		for _, addr := range r.byName[name] {
+			ips[addr] = true
+		}
+	}
+
+	r.ips.Store(&ips)
+	return ips
+}
+
+// Refresh re-resolves the names every interval until ctx is done.
+func (r *PeerResolver) Refresh(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			r.resolve(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Matches tells if either endpoint of the connection, like 127.0.0.1:5000, is at the resolved ips,
+// true for the nil resolver.
+func (r *PeerResolver) Matches(client, server string) bool {
+	if r == nil {
+		return true
+	}
+
+	ips := *r.ips.Load()
+	clientIP, _, _ := net.SplitHostPort(client)
+	serverIP, _, _ := net.SplitHostPort(server)
+	return ips[clientIP] || ips[serverIP]
+}
@@ -0,0 +1,39 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPeerResolver(t *testing.T) {
+	r, err := NewPeerResolver(context.Background(), []string{"localhost"})
+	assert.Nil(t, err)
+	assert.True(t, r.Matches("127.0.0.1:5000", "10.0.0.1:8080"))
+	assert.True(t, r.Matches("10.0.0.1:5000", "127.0.0.1:8080"))
+	assert.False(t, r.Matches("10.0.0.1:5000", "10.0.0.2:8080"))
+
+	answers := map[string][]string{"a.b": {"10.0.0.2", "10.0.0.1"}}
+	r.names, r.lookup = []string{"a.b"}, func(_ context.Context, host string) ([]string, error) {
+		if addrs, ok := answers[host]; ok {
+			return addrs, nil
+		}
+		return nil, errors.New("no such host")
+	}
+	r.resolve(context.Background())
+	assert.True(t, r.Matches("10.0.0.9:5000", "10.0.0.2:8080"))
+
+	delete(answers, "a.b") // the previous ips are kept on failures
+	r.resolve(context.Background())
+	assert.True(t, r.Matches("10.0.0.9:5000", "10.0.0.1:8080"))
+
+	answers["a.b"] = []string{"10.0.0.3"}
+	r.resolve(context.Background())
+	assert.False(t, r.Matches("10.0.0.9:5000", "10.0.0.1:8080"))
+	assert.True(t, r.Matches("10.0.0.9:5000", "10.0.0.3:8080"))
+
+	var all *PeerResolver
+	assert.True(t, all.Matches("10.0.0.9:5000", "10.0.0.1:8080"))
+}
@@ -0,0 +1,58 @@
+package handler
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/google/gopacket/layers"
+)
+
+// handshake tracks the timestamps of the TCP three-way handshake of a connection, to estimate its RTT.
+type handshake struct {
+	client           Endpoint // the sender of the SYN
+	syn, synAck, ack time.Time
+}
+
+// observe records the timestamp of the handshake packet from src, true when the handshake completes by the ACK.
+func (s *handshake) observe(src Endpoint, tcp *layers.TCP, t time.Time) bool {
+	switch {
+	case tcp.SYN && !tcp.ACK: // the retransmitted SYN restarts the handshake
+		*s = handshake{client: src, syn: t}
+	case tcp.SYN && tcp.ACK:
+		if !s.syn.IsZero() && !src.equals(s.client) {
+			s.synAck = t
+		}
+	case tcp.ACK && !s.synAck.IsZero() && s.ack.IsZero() && src.equals(s.client):
+		s.ack = t
+		return true
+	}
+	return false
+}
+
+// lastSeen returns the timestamp of the last handshake packet observed.
+func (s *handshake) lastSeen() time.Time {
+	if s.synAck.IsZero() {
+		return s.syn
+	}
+	return s.synAck
+}
+
+// rtt returns the RTT estimated by the handshake, from the SYN to the ACK, like the iRTT of Wireshark.
+func (s *handshake) rtt() time.Duration { return s.ack.Sub(s.syn) }
+
+// printHandshake records the handshake RTT into the stats, and prints it as a marker if Option.HandshakeRTT,
+// the server side one is from the SYN to the SYN-ACK, and the client side one is from the SYN-ACK to the ACK.
+func (h *Base) printHandshake(hs *handshake) {
+	h.option.Stats.AddHandshakeRTT(hs.rtt())
+	if !h.option.HandshakeRTT || h.lineOutput() {
+		return
+	}
+
+	b := &bytes.Buffer{}
+	writeFormat(b, "\n### RTT %s %s handshake: %s, server: %s, client: %s\n", h.conn(), h.option.FormatTime(hs.ack),
+		hs.rtt(), hs.synAck.Sub(hs.syn), hs.ack.Sub(hs.synAck))
+	h.sender.Send(b.String(), false)
+}
+
+// tracksHandshake tells if the handshakes are tracked, for Option.HandshakeRTT or the stats.
+func (o *Option) tracksHandshake() bool { return o.HandshakeRTT || o.Stats != nil }
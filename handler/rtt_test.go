@@ -0,0 +1,78 @@
+package handler
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/tcpassembly"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandshakeObserve(t *testing.T) {
+	start := time.Now()
+	hs := &handshake{}
+	assert.False(t, hs.observe(testClient, &layers.TCP{ACK: true}, start)) // no SYN seen
+	assert.False(t, hs.observe(testClient, &layers.TCP{SYN: true}, start))
+	assert.False(t, hs.observe(testServer, &layers.TCP{SYN: true, ACK: true}, start.Add(30*time.Millisecond)))
+	assert.False(t, hs.observe(testServer, &layers.TCP{ACK: true}, start.Add(35*time.Millisecond))) // not from the client
+	assert.True(t, hs.observe(testClient, &layers.TCP{ACK: true}, start.Add(40*time.Millisecond)))
+	assert.False(t, hs.observe(testClient, &layers.TCP{ACK: true}, start.Add(50*time.Millisecond)))
+	assert.Equal(t, 40*time.Millisecond, hs.rtt())
+}
+
+func handshakePackets(assemble func(flow gopacket.Flow, tcp *layers.TCP, timestamp time.Time), start time.Time) {
+	flow := gopacket.NewFlow(layers.EndpointIPv4, net.IPv4(127, 0, 0, 1).To4(), net.IPv4(127, 0, 0, 2).To4())
+	assemble(flow, &layers.TCP{SrcPort: 5000, DstPort: 8080, SYN: true, Seq: 100}, start)
+	assemble(flow.Reverse(), &layers.TCP{SrcPort: 8080, DstPort: 5000, SYN: true, ACK: true, Seq: 200, Ack: 101},
+		start.Add(30*time.Millisecond))
+	assemble(flow, &layers.TCP{SrcPort: 5000, DstPort: 8080, ACK: true, Seq: 101, Ack: 201}, start.Add(40*time.Millisecond))
+}
+
+func TestHandshakeRTTFast(t *testing.T) {
+	s := &collectSender{}
+	option := &Option{Resp: 1, SrcRatio: 1, HandshakeRTT: true, Stats: NewStats(nil)}
+	a := NewTCPAssembler(&ConnectionHandlerFast{Context: context.Background(), Option: option, Sender: s}, 10, 1)
+	handshakePackets(a.Assemble, time.Now())
+	a.FinishAll()
+
+	out := strings.Join(s.messages(), "")
+	assert.Contains(t, out, "\n### RTT 127.0.0.1:5000-127.0.0.2:8080 ")
+	assert.Contains(t, out, " handshake: 40ms, server: 30ms, client: 10ms\n")
+	assert.Contains(t, option.Stats.Summary(), "Handshake RTT p50: 40ms, p95: 40ms, p99: 40ms, max: 40ms, connections: 1\n")
+}
+
+func TestHandshakeRTTStd(t *testing.T) {
+	s := &collectSender{}
+	option := &Option{SrcRatio: 1, Stats: NewStats(nil)} // the stats are fed without HandshakeRTT
+	f := NewFactory(context.Background(), option, s)
+	handshakePackets(f.observeHandshake, time.Now())
+
+	assert.Empty(t, s.messages())
+	assert.Empty(t, f.handshakes)
+	data, err := option.Stats.SummaryJSON()
+	assert.Nil(t, err)
+	assert.Contains(t, string(data), `"HandshakeRTT": {
+    "Count": 1,
+    "P50": "40ms",`)
+}
+
+func TestHandshakePruned(t *testing.T) {
+	f := NewFactory(context.Background(), &Option{SrcRatio: 1, Stats: NewStats(nil)}, &collectSender{})
+	a := &TcpStdAssembler{Factory: f, Assembler: tcpassembly.NewAssembler(tcpassembly.NewStreamPool(f))}
+	flow := gopacket.NewFlow(layers.EndpointIPv4, net.IPv4(127, 0, 0, 1).To4(), net.IPv4(127, 0, 0, 2).To4())
+	start := time.Now()
+	for i := 0; i < 3; i++ { // the SYNs unanswered
+		a.Assemble(flow, &layers.TCP{SrcPort: layers.TCPPort(5000 + i), DstPort: 8080, SYN: true}, start.Add(time.Duration(i)*time.Second))
+	}
+	assert.Len(t, f.handshakes, 3)
+
+	a.FlushOlderThan(start.Add(1500 * time.Millisecond))
+	assert.Len(t, f.handshakes, 1)
+	a.FlushOlderThan(start.Add(3 * time.Second))
+	assert.Empty(t, f.handshakes)
+}
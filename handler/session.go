@@ -0,0 +1,158 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// sessionIdleMax is the max idle time of the cookies of a session, then they are evicted.
+const sessionIdleMax = 30 * time.Minute
+
+// SessionTracker correlates transactions into sessions by the cookies,
+// or by the value of a configurable session header.
+type SessionTracker struct {
+	sync.Mutex
+
+	header    string
+	seq       int
+	cookies   map[string]*sessionCookie // name=value of cookie -> session
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// sessionCookie is the session of a cookie, and when the cookie was seen last.
+type sessionCookie struct {
+	session string
+	seen    time.Time
+}
+
+// NewSessionTracker creates a new SessionTracker, header is the optional session header name.
+func NewSessionTracker(header string) *SessionTracker {
+	return &SessionTracker{header: header, cookies: make(map[string]*sessionCookie), now: time.Now}
+}
+
+// bind binds the cookie to the session, and evicts the cookies idle longer than sessionIdleMax at most once a minute.
+func (s *SessionTracker) bind(cookie *http.Cookie, session string, now time.Time) {
+	s.cookies[cookie.Name+"="+cookie.Value] = &sessionCookie{session: session, seen: now}
+
+	if now.Sub(s.lastSweep) < time.Minute {
+		return
+	}
+	s.lastSweep = now
+	for k, c := range s.cookies {
+		if now.Sub(c.seen) > sessionIdleMax {
+			delete(s.cookies, k)
+		}
+	}
+}
+
+// RequestSession returns the session id of the request, connSession is the last session seen on the connection.
+func (s *SessionTracker) RequestSession(header http.Header, connSession string) string {
+	if s.header != "" {
+		if v := header.Get(s.header); v != "" {
+			return v
+		}
+	}
+
+	cookies := (&http.Request{Header: header}).Cookies()
+
+	s.Lock()
+	defer s.Unlock()
+
+	session := ""
+	for _, c := range cookies {
+		if sc, ok := s.cookies[c.Name+"="+c.Value]; ok {
+			session = sc.session
+			break
+		}
+	}
+
+	if session == "" {
+		session = connSession
+	}
+	if session == "" {
+		s.seq++
+		session = fmt.Sprintf("s%d", s.seq)
+	}
+
+	now := s.now()
+	for _, c := range cookies {
+		s.bind(c, session, now)
+	}
+
+	return session
+}
+
+// ResponseCookies binds the cookies set by the response to the session.
+func (s *SessionTracker) ResponseCookies(header http.Header, session string) {
+	if session == "" {
+		return
+	}
+
+	cookies := (&http.Response{Header: header}).Cookies()
+
+	s.Lock()
+	defer s.Unlock()
+
+	now := s.now()
+	for _, c := range cookies {
+		s.bind(c, session, now)
+	}
+}
+
+// connSessions holds the sessions of a connection.
+type connSessions struct {
+	sync.Mutex
+	last string
+	m    map[int32]string // seq -> session id
+}
+
+// requestSession tracks the session of the request with seq, returns empty if session tracking is off.
+func (h *Base) requestSession(header http.Header, seq int32) string {
+	t := h.option.Sessions
+	if t == nil {
+		return ""
+	}
+
+	h.sessions.Lock()
+	defer h.sessions.Unlock()
+
+	session := t.RequestSession(header, h.sessions.last)
+	h.sessions.last = session
+	if h.sessions.m == nil {
+		h.sessions.m = make(map[int32]string)
+	}
+	h.sessions.m[seq] = session
+	return session
+}
+
+// responseSession returns the session of the response with seq, and tracks the cookies it sets.
+// The sessions of the requests before seq are evicted too, whose responses are never to come in order.
+func (h *Base) responseSession(header http.Header, seq int32) string {
+	t := h.option.Sessions
+	if t == nil {
+		return ""
+	}
+
+	h.sessions.Lock()
+	session := h.sessions.m[seq]
+	for s := range h.sessions.m {
+		if s <= seq {
+			delete(h.sessions.m, s)
+		}
+	}
+	h.sessions.Unlock()
+
+	t.ResponseCookies(header, session)
+	return session
+}
+
+// sessionField formats the session field appended to the title line.
+func sessionField(session string) string {
+	if session == "" {
+		return ""
+	}
+	return " session:" + session
+}
@@ -0,0 +1,47 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSessionTrackerEviction(t *testing.T) {
+	s := NewSessionTracker("")
+	now := time.Now()
+	s.now = func() time.Time { return now }
+
+	assert.Equal(t, "s1", s.RequestSession(http.Header{"Cookie": {"sid=1"}}, ""))
+	now = now.Add(10 * time.Minute)
+	assert.Equal(t, "s2", s.RequestSession(http.Header{"Cookie": {"sid=2"}}, ""))
+	assert.Equal(t, "s1", s.RequestSession(http.Header{"Cookie": {"sid=1"}}, ""))
+	assert.Len(t, s.cookies, 2)
+
+	now = now.Add(sessionIdleMax + time.Minute)
+	s.ResponseCookies(http.Header{"Set-Cookie": {"sid=3"}}, "s3")
+	assert.Len(t, s.cookies, 1) // the idle sid=1 and sid=2 evicted, sid=1 starts a new session
+	assert.Equal(t, "s3", s.RequestSession(http.Header{"Cookie": {"sid=1"}}, ""))
+}
+
+func TestConnSessionsEviction(t *testing.T) {
+	b := NewBase(context.Background(), &ConnectionKey{}, &Option{Sessions: NewSessionTracker("")}, nil)
+	for seq := int32(1); seq <= 3; seq++ {
+		b.requestSession(http.Header{}, seq)
+	}
+	assert.Equal(t, "s1", b.responseSession(http.Header{}, 2))
+	assert.Equal(t, map[int32]string{3: "s1"}, b.sessions.m) // the unanswered 1 evicted
+}
+
+func TestSessionStd(t *testing.T) {
+	o := &Option{SrcRatio: 1, Resp: 1, Sessions: NewSessionTracker("")}
+	c1, c2 := newTestConn(o), newTestConn(o)
+	c1.requests("GET /login HTTP/1.1\r\nHost: a.b\r\n\r\n")
+	c1.responses("HTTP/1.1 200 OK\r\nSet-Cookie: sid=1\r\nContent-Length: 0\r\n\r\n")
+	c2.requests("GET /a HTTP/1.1\r\nHost: a.b\r\nCookie: sid=1\r\n\r\n")
+
+	assert.Contains(t, c1.output(), "session:s1")
+	assert.Contains(t, c2.output(), "session:s1") // correlated by the cookie set by the response on c1
+}
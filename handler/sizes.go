@@ -0,0 +1,95 @@
+package handler
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/bingoohuang/gg/pkg/man"
+	"github.com/bingoohuang/gg/pkg/ss"
+)
+
+// defaultSizeBuckets are the default upper bounds (inclusive) of the body size histograms.
+var defaultSizeBuckets = []int64{1 << 10, 10 << 10, 100 << 10, 1 << 20, 10 << 20}
+
+// ParseSizeBuckets parses the upper bounds of the body size histograms, like 1KiB,10KiB,1MiB.
+func ParseSizeBuckets(s string) ([]int64, error) {
+	var buckets []int64
+	for _, v := range ss.Split(s, ss.WithSeps(","), ss.WithIgnoreEmpty(true), ss.WithTrimSpace(true)) {
+		n, err := man.ParseBytes(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid size bucket %s: %w", v, err)
+		}
+		buckets = append(buckets, int64(n))
+	}
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i] < buckets[j] })
+	return buckets, nil
+}
+
+// sizeHistogram counts the body sizes by the buckets of the upper bounds, and the larger ones in the last bucket.
+type sizeHistogram struct {
+	count, total, max int64
+	buckets           []int
+}
+
+func (h *sizeHistogram) add(size int64, bounds []int64) {
+	if h.buckets == nil {
+		h.buckets = make([]int, len(bounds)+1)
+	}
+	h.count++
+	h.total += size
+	h.max = max(h.max, size)
+	h.buckets[sort.Search(len(bounds), func(i int) bool { return size <= bounds[i] })]++
+}
+
+// AddBodySize records the body size of a request or response, the ones without body are skipped.
+func (s *Stats) AddBodySize(isRequest bool, size int64) {
+	if s == nil || size <= 0 {
+		return
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	if s.SizeBuckets == nil {
+		s.SizeBuckets = defaultSizeBuckets
+	}
+	if isRequest {
+		s.reqSizes.add(size, s.SizeBuckets)
+	} else {
+		s.rspSizes.add(size, s.SizeBuckets)
+	}
+}
+
+func (s *Stats) writeSizes(b *strings.Builder, name string, h *sizeHistogram) {
+	fmt.Fprintf(b, "%s body sizes total: %s, avg: %s, max: %s\n", name,
+		man.IBytes(uint64(h.total)), man.IBytes(uint64(h.total/h.count)), man.IBytes(uint64(h.max)))
+	for i, n := range h.buckets {
+		if n == 0 {
+			continue
+		}
+		fmt.Fprintf(b, "  %-12s %6d %s\n", s.sizeBucketLabel(i), n, bar(n, int(h.count)))
+	}
+}
+
+// sizeBucketLabel returns the label of the i-th bucket of the body size histograms, like <= 1KiB, or > 10MiB for the last.
+func (s *Stats) sizeBucketLabel(i int) string {
+	if i < len(s.SizeBuckets) {
+		return "<= " + man.IBytes(uint64(s.SizeBuckets[i]))
+	}
+	return "> " + man.IBytes(uint64(s.SizeBuckets[len(s.SizeBuckets)-1]))
+}
+
+// recordBodySize records the body size on the wire of the request or response into the stats,
+// after the rest of the body is discarded if discard, to know the whole size in the std mode.
+func (h *Base) recordBodySize(r interface{ GetBody() io.ReadCloser }, discard, isRequest bool) {
+	if h.option.Stats == nil {
+		return
+	}
+	if discard {
+		discardAll(r.GetBody())
+	}
+	size := wireBodySizeOf(r)
+	h.record(func() { h.option.Stats.AddBodySize(isRequest, size) })
+}
@@ -0,0 +1,162 @@
+package handler
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"time"
+
+	"github.com/bingoohuang/gg/pkg/ss"
+	"github.com/bingoohuang/httpdump/util"
+)
+
+// transferCodings are the known transfer codings, the others in Transfer-Encoding are taken as obfuscated.
+var transferCodings = map[string]bool{"chunked": true, "gzip": true, "x-gzip": true, "deflate": true, "compress": true, "identity": true}
+
+// smugglingIndicators inspects the raw headers of a message for the request smuggling signatures,
+// like both Content-Length and Transfer-Encoding, the conflicting Content-Length values,
+// the obfuscated Transfer-Encoding names or values, and returns the reasons with the offending header lines.
+func smugglingIndicators(headers []byte) (reasons, lines []string) {
+	if pos := util.MIMEHeadersEndPos(headers); pos >= 0 {
+		headers = headers[:pos]
+	}
+	headerLines := strings.Split(string(headers), "\n")
+	if len(headerLines) > 0 {
+		headerLines = headerLines[1:] // the title line
+	}
+
+	reason := func(r, line string) {
+		if !ss.AnyOf(r, reasons...) {
+			reasons = append(reasons, r)
+		}
+		if !ss.AnyOf(line, lines...) {
+			lines = append(lines, line)
+		}
+	}
+
+	var contentLengths, contentLengthLines, transferEncodings []string
+	var framing bool // the last header line is Content-Length or Transfer-Encoding, for the folded lines
+	for _, line := range headerLines {
+		line = strings.TrimSuffix(line, "\r")
+		if line == "" {
+			break
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			if framing {
+				reason("folded framing header", line)
+			}
+			continue
+		}
+
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			framing = false
+			continue
+		}
+		lower := strings.ToLower(name)
+		switch squeezeLetters(lower) {
+		case "contentlength":
+			framing = true
+			if lower != "content-length" {
+				reason("obfuscated Content-Length name", line)
+			}
+			contentLengths = append(contentLengths, strings.Trim(value, " \t"))
+			contentLengthLines = append(contentLengthLines, line)
+		case "transferencoding":
+			framing = true
+			if lower != "transfer-encoding" {
+				reason("obfuscated Transfer-Encoding name", line)
+			}
+			for _, coding := range strings.Split(value, ",") {
+				if !transferCodings[strings.ToLower(strings.Trim(coding, " \t"))] {
+					reason("obfuscated Transfer-Encoding value", line)
+				}
+			}
+			transferEncodings = append(transferEncodings, line)
+		default:
+			framing = false
+		}
+	}
+
+	if len(contentLengths) > 0 && len(transferEncodings) > 0 {
+		reason("both Content-Length and Transfer-Encoding", contentLengthLines[0])
+		reason("both Content-Length and Transfer-Encoding", transferEncodings[0])
+	}
+	for i := 1; i < len(contentLengths); i++ {
+		if contentLengths[i] != contentLengths[0] {
+			reason("conflicting Content-Length", contentLengthLines[0])
+			reason("conflicting Content-Length", contentLengthLines[i])
+		}
+	}
+	if len(transferEncodings) > 1 {
+		reason("multiple Transfer-Encoding", transferEncodings[1])
+	}
+	if len(reasons) == 0 {
+		return nil, nil
+	}
+	return reasons, lines
+}
+
+// squeezeLetters keeps only the letters of the header name, to tell the obfuscated names like Transfer_Encoding.
+func squeezeLetters(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' {
+			return r
+		}
+		return -1
+	}, name)
+}
+
+// peekHeaders peeks the headers of the next message with the blank line ending them,
+// or as much as buffered if the headers are longer.
+func peekHeaders(buf *bufio.Reader) []byte {
+	for {
+		peek, _ := buf.Peek(buf.Buffered()) // the bytes buffered are checked before waiting for more
+		if bytes.Contains(peek, util.EmptyLine) || len(peek) >= buf.Size() {
+			return peek
+		}
+		if _, err := buf.Peek(len(peek) + 1); err != nil {
+			peek, _ = buf.Peek(buf.Buffered())
+			return peek
+		}
+	}
+}
+
+// checkSmuggling sends a SMUGGLING-SUSPECT record of the next message if its headers have the request smuggling
+// signatures, for Option.DetectSmuggling.
+func (h *Base) checkSmuggling(tag Tag, headers []byte, t time.Time) {
+	if !h.option.DetectSmuggling || h.lineOutput() {
+		return
+	}
+	if reasons, lines := smugglingIndicators(headers); len(reasons) > 0 {
+		h.sendSmuggling(tag, strings.Join(reasons, ", "), lines, t)
+	}
+}
+
+// flagDesync sends a SMUGGLING-SUSPECT record when the data after a message fails to parse as the next one,
+// which suggests the framing of the message is interpreted differently, for Option.DetectSmuggling.
+func (h *Base) flagDesync(tag Tag, err error, t time.Time) {
+	if !h.option.DetectSmuggling || isEOF(err) || h.lineOutput() {
+		return
+	}
+	if counter := h.counterOf(tag); counter.Get() > 0 {
+		h.sendSmuggling(tag, "desync, unparsable data after the previous message: "+err.Error(), nil, t)
+	}
+}
+
+func (h *Base) counterOf(tag Tag) *Counter {
+	if tag == TagRequest {
+		return &h.reqCounter
+	}
+	return &h.rspCounter
+}
+
+func (h *Base) sendSmuggling(tag Tag, reasons string, lines []string, t time.Time) {
+	b := &bytes.Buffer{}
+	writeFormat(b, "\n### SMUGGLING-SUSPECT#%d %s %s %s, %s\n",
+		h.counterOf(tag).Get()+1, tag, h.conn(), h.option.FormatTime(t), reasons)
+	for _, line := range lines {
+		writeFormat(b, "%s\r\n", line)
+	}
+	h.sender.Send(b.String(), false)
+}
@@ -0,0 +1,61 @@
+package handler
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSmugglingIndicators(t *testing.T) {
+	reasons, lines := smugglingIndicators([]byte("POST / HTTP/1.1\r\nHost: a.b\r\nContent-Length: 4\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n"))
+	assert.Equal(t, []string{"both Content-Length and Transfer-Encoding"}, reasons)
+	assert.Equal(t, []string{"Content-Length: 4", "Transfer-Encoding: chunked"}, lines)
+
+	reasons, lines = smugglingIndicators([]byte("POST / HTTP/1.1\r\nTransfer-Encoding: xchunked\r\nTransfer_Encoding: chunked\r\n\r\n"))
+	assert.Equal(t, []string{"obfuscated Transfer-Encoding value", "obfuscated Transfer-Encoding name", "multiple Transfer-Encoding"}, reasons)
+	assert.Equal(t, []string{"Transfer-Encoding: xchunked", "Transfer_Encoding: chunked"}, lines)
+
+	reasons, lines = smugglingIndicators([]byte("POST / HTTP/1.1\r\nContent-Length: 4\r\nContent-Length: 5\r\n\r\n"))
+	assert.Equal(t, []string{"conflicting Content-Length"}, reasons)
+	assert.Equal(t, []string{"Content-Length: 4", "Content-Length: 5"}, lines)
+
+	reasons, _ = smugglingIndicators([]byte("POST / HTTP/1.1\r\nTransfer-Encoding: gzip, chunked\r\nContent-Type: a/b\r\n\r\n"))
+	assert.Nil(t, reasons)
+	reasons, _ = smugglingIndicators([]byte("HTTP/1.1 200 OK\r\nContent-Length: 4\r\nContent-Length: 4\r\n\r\n"))
+	assert.Nil(t, reasons)
+}
+
+func TestDetectSmugglingStd(t *testing.T) {
+	c := newTestConn(&Option{SrcRatio: 1, DetectSmuggling: true})
+	c.requests("POST /a HTTP/1.1\r\nHost: a.b\r\nContent-Length: 6\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\nxyz\r\n\r\n")
+
+	out := c.output()
+	assert.Contains(t, out, "\n### SMUGGLING-SUSPECT#1 REQ 127.0.0.1:5000-127.0.0.2:8080 ")
+	assert.Contains(t, out, ", both Content-Length and Transfer-Encoding\nContent-Length: 6\r\nTransfer-Encoding: chunked\r\n")
+	assert.Contains(t, out, "\n### SMUGGLING-SUSPECT#2 REQ 127.0.0.1:5000-127.0.0.2:8080 ")
+	assert.Contains(t, out, ", desync, unparsable data after the previous message: ")
+}
+
+func TestPeekHeaders(t *testing.T) {
+	r, w := io.Pipe()
+	defer w.Close()
+	peeked := make(chan string, 1)
+	go func() { peeked <- string(peekHeaders(bufio.NewReader(r))) }()
+
+	// the headers buffered are peeked without waiting for the data after them
+	_, _ = w.Write([]byte("GET / HTTP/1.1\r\nHost: a.b\r\n\r\n"))
+	select {
+	case headers := <-peeked:
+		assert.Equal(t, "GET / HTTP/1.1\r\nHost: a.b\r\n\r\n", headers)
+	case <-time.After(time.Second):
+		assert.Fail(t, "peekHeaders waits for more data")
+	}
+
+	buf := bufio.NewReaderSize(strings.NewReader("GET / HTTP/1.1\r\nHost: a.b"), 16)
+	assert.Equal(t, "GET / HTTP/1.1\r\n", string(peekHeaders(buf))) // longer than the buffer
+	assert.Equal(t, "GET / HTTP/1.1\r\nHost: a.b", string(peekHeaders(bufio.NewReader(strings.NewReader("GET / HTTP/1.1\r\nHost: a.b")))))
+}
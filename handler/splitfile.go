@@ -0,0 +1,192 @@
+package handler
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bingoohuang/gg/pkg/rotate"
+)
+
+// SplitByTime splits the file outputs into the files of the wall-clock time windows.
+const SplitByTime = "time"
+
+// SplitSender writes the messages to the files of the time windows, like capture-2024010114.log for the hour
+// of capture.log, and closes the file at the window boundary even when no messages arrive.
+type SplitSender struct {
+	file     string
+	interval time.Duration
+	utc      bool
+	latency  time.Duration // max time the messages are buffered before flushed, negative to flush each one
+	ch       chan string
+	closing  chan struct{}
+	done     chan struct{}
+}
+
+// ParseSplitOutput parses the file of the output to split by time, the suffix :append is accepted for the files
+// are always appended, and the max size suffix like :100m is rejected for the files are split by time only.
+func ParseSplitOutput(out string) (string, error) {
+	c := &rotate.Config{}
+	file := rotate.ParseOutputPath(c, out)
+	if c.MaxSize > 0 {
+		return "", fmt.Errorf("output %s: the max size suffix is not supported when split by time", out)
+	}
+	return file, nil
+}
+
+// NewSplitSender creates a SplitSender to the files named by the file split by the interval like 1h,
+// the messages are flushed within the flush latency, or each one if negative.
+func NewSplitSender(file string, interval time.Duration, utc bool, chanSize uint, flushLatency time.Duration) *SplitSender {
+	s := &SplitSender{
+		file:     file,
+		interval: interval,
+		utc:      utc,
+		latency:  flushLatency,
+		ch:       make(chan string, chanSize),
+		closing:  make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// Send buffers the message, it blocks when the buffer is full, and the message is dropped after Close,
+// like by the handlers still running on shutdown.
+func (s *SplitSender) Send(msg string, _ bool) {
+	select {
+	case <-s.closing:
+		return
+	default:
+	}
+
+	select {
+	case s.ch <- msg:
+	case <-s.closing:
+	}
+}
+
+// Close writes out the buffered messages, and then closes the current file.
+// The channel is left open, for Send to be safe after Close.
+func (s *SplitSender) Close() error {
+	close(s.closing)
+	<-s.done
+	return nil
+}
+
+// ChanOccupancy reports the occupancy of the channel buffering messages to the files.
+func (s *SplitSender) ChanOccupancy() (name string, length, capacity int) {
+	return "split " + s.file, len(s.ch), cap(s.ch)
+}
+
+// window returns the start of the time window of t, aligned to the local wall clock, or UTC if utc.
+func (s *SplitSender) window(t time.Time) time.Time {
+	if s.utc {
+		t = t.UTC()
+	}
+	_, offset := t.Zone()
+	d := time.Duration(offset) * time.Second
+	return t.Add(d).Truncate(s.interval).Add(-d)
+}
+
+// SplitFileName names the file of the window starting at start, like capture-2024010114.log for capture.log,
+// with the date for the days intervals, the hour for the hours, the minute for the minutes and the second for the rest.
+func SplitFileName(file string, start time.Time, interval time.Duration) string {
+	layout := "20060102150405"
+	switch {
+	case interval%(24*time.Hour) == 0:
+		layout = "20060102"
+	case interval%time.Hour == 0:
+		layout = "2006010215"
+	case interval%time.Minute == 0:
+		layout = "200601021504"
+	}
+
+	ext := filepath.Ext(file)
+	return strings.TrimSuffix(file, ext) + "-" + start.Format(layout) + ext
+}
+
+func (s *SplitSender) run() {
+	defer close(s.done)
+
+	var f *os.File
+	var w *bufio.Writer
+	flush := func() {
+		if w != nil && w.Buffered() > 0 {
+			if err := w.Flush(); err != nil {
+				log.Printf("E! write split file %s failed: %v", f.Name(), err)
+			}
+		}
+	}
+	closeFile := func() {
+		if f != nil {
+			flush()
+			_ = f.Close()
+			f, w = nil, nil
+		}
+	}
+	defer closeFile()
+
+	var flushC <-chan time.Time
+	if s.latency > 0 {
+		ticker := time.NewTicker(s.latency)
+		defer ticker.Stop()
+		flushC = ticker.C
+	}
+
+	start := s.window(time.Now())
+	timer := time.NewTimer(time.Until(start.Add(s.interval)))
+	defer timer.Stop()
+	roll := func(now time.Time) {
+		closeFile()
+		start = s.window(now)
+		timer.Reset(time.Until(start.Add(s.interval)))
+	}
+
+	write := func(msg string) {
+		if now := time.Now(); !now.Before(start.Add(s.interval)) {
+			if !timer.Stop() {
+				<-timer.C
+			}
+			roll(now)
+		}
+		if f == nil {
+			name := SplitFileName(s.file, start, s.interval)
+			var err error
+			if f, err = os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644); err != nil {
+				log.Printf("E! open split file %s failed: %v", name, err)
+				return
+			}
+			w = bufio.NewWriter(f)
+		}
+		if _, err := w.WriteString(msg); err != nil {
+			log.Printf("E! write split file %s failed: %v", f.Name(), err)
+		}
+		if s.latency < 0 {
+			flush()
+		}
+	}
+
+	for {
+		select {
+		case <-timer.C:
+			roll(time.Now())
+		case <-flushC:
+			flush()
+		case msg := <-s.ch:
+			write(msg)
+		case <-s.closing:
+			for {
+				select {
+				case msg := <-s.ch:
+					write(msg)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitFileName(t *testing.T) {
+	start := time.Date(2024, 1, 1, 14, 0, 0, 0, time.UTC)
+	assert.Equal(t, "capture-2024010114.log", SplitFileName("capture.log", start, time.Hour))
+	assert.Equal(t, "a/capture-20240101.log", SplitFileName("a/capture.log", start, 24*time.Hour))
+	assert.Equal(t, "capture-202401011400", SplitFileName("capture", start, 30*time.Minute))
+	assert.Equal(t, "capture-20240101140000.log", SplitFileName("capture.log", start, 90*time.Second))
+
+	s := &SplitSender{interval: time.Hour, utc: true}
+	assert.Equal(t, start, s.window(start.Add(59*time.Minute)))
+}
+
+func TestSplitSender(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "capture.log")
+	s := NewSplitSender(file, time.Hour, true, 10, time.Hour)
+	s.Send("a\n", true)
+	s.Send("b\n", true)
+	assert.Nil(t, s.Close())
+
+	s.Send("c\n", true) // dropped, not panicking on the closed sender
+
+	data, err := os.ReadFile(SplitFileName(file, s.window(time.Now()), time.Hour))
+	assert.Nil(t, err)
+	assert.Equal(t, "a\nb\n", string(data))
+}
+
+func TestSplitSenderFlush(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "capture.log")
+	s := NewSplitSender(file, time.Hour, true, 10, -1)
+	defer s.Close()
+
+	s.Send("a\n", true)
+	assert.Eventually(t, func() bool { // flushed without Close
+		data, _ := os.ReadFile(SplitFileName(file, s.window(time.Now()), time.Hour))
+		return string(data) == "a\n"
+	}, time.Second, time.Millisecond)
+}
+
+func TestSplitSenderBuffered(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "capture.log")
+	s := NewSplitSender(file, time.Hour, true, 10, time.Hour)
+	s.Send("a\n", true)
+	assert.Eventually(t, func() bool { return len(s.ch) == 0 }, time.Second, time.Millisecond)
+	name := SplitFileName(file, s.window(time.Now()), time.Hour)
+	data, _ := os.ReadFile(name)
+	assert.Empty(t, data) // buffered within the latency
+
+	assert.Nil(t, s.Close())
+	data, _ = os.ReadFile(name)
+	assert.Equal(t, "a\n", string(data))
+}
+
+func TestParseSplitOutput(t *testing.T) {
+	file, err := ParseSplitOutput("capture.log:append")
+	assert.Nil(t, err)
+	assert.Equal(t, "capture.log", file)
+
+	_, err = ParseSplitOutput("capture.log:100m")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,245 @@
+package handler
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bingoohuang/gg/pkg/rotate"
+	_ "modernc.org/sqlite" // the SQLite driver
+)
+
+// SQLitePrefix prefixes the output of the SQLite database to write the transactions into, like sqlite:captures.db,
+// suffix like :100m for the max size of a file, and :bodies to write the bodies too.
+const SQLitePrefix = "sqlite:"
+
+// sqliteSchema creates the table of the transactions if not exists, the times are in RFC3339 with nanoseconds.
+const (
+	sqliteSchema = `CREATE TABLE IF NOT EXISTS transactions (
+  seq INTEGER, src TEXT, dst TEXT, method TEXT, host TEXT, uri TEXT, status INTEGER,
+  latency_ms REAL, req_size INTEGER, rsp_size INTEGER, start_time TEXT, end_time TEXT,
+  req_body BLOB, rsp_body BLOB
+)`
+	sqliteInsert = `INSERT INTO transactions (seq, src, dst, method, host, uri, status,
+  latency_ms, req_size, rsp_size, start_time, end_time, req_body, rsp_body) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+)
+
+// SQLiteOutput is the SQLite output parsed by ParseSQLiteOutput.
+type SQLiteOutput struct {
+	File    string
+	MaxSize uint64 // the max size of a file, rotated to the next file like captures_00002.db, 0 for unlimited
+	Bodies  bool
+	Append  bool // append to the existing file, or else the existing files are kept and the transactions go to the next file
+}
+
+// SQLiteRecorder writes the transactions into the transactions table of the SQLite database files,
+// batched in the commits in one goroutine, the files are rotated by the max size.
+type SQLiteRecorder struct {
+	ch      chan *Transaction
+	output  SQLiteOutput
+	db      *sql.DB
+	stmt    *sql.Stmt // the insert prepared on db
+	index   int       // the index of the current file, 1 for the file itself
+	dropped uint64
+	wg      sync.WaitGroup
+
+	lock   sync.RWMutex
+	closed bool
+}
+
+var _ TransactionHandler = (*SQLiteRecorder)(nil)
+
+const sqliteBatchSize = 100
+
+// ParseSQLiteOutput parses the output like sqlite:captures.db, sqlite:captures.db:100m or sqlite:captures.db:bodies,
+// false if not SQLite.
+func ParseSQLiteOutput(out string) (o SQLiteOutput, ok bool) {
+	file, ok := strings.CutPrefix(out, SQLitePrefix)
+	if !ok {
+		return o, false
+	}
+
+	file, o.Bodies = strings.CutSuffix(file, ":bodies")
+	c := &rotate.Config{}
+	o.File = rotate.ParseOutputPath(c, file)
+	o.MaxSize, o.Append = c.MaxSize, c.Append
+	return o, true
+}
+
+// NewSQLiteRecorder creates a SQLiteRecorder writing to the database file with the table, the last file
+// is appended to if Append, or else a new file is created with the next index like captures_00002.db
+// if the file exists, with the request and response bodies if Bodies, which requires Option.RecordBodies.
+func NewSQLiteRecorder(o SQLiteOutput) (*SQLiteRecorder, error) {
+	r := &SQLiteRecorder{ch: make(chan *Transaction, 4096), output: o}
+	r.index, _ = rotate.FindMaxFileIndex(o.File, "")
+	if _, err := os.Stat(r.fileName()); err == nil && !o.Append {
+		r.index++
+	}
+
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	r.wg.Add(1)
+	go r.loop()
+	return r, nil
+}
+
+func (r *SQLiteRecorder) fileName() string {
+	if r.index <= 1 {
+		return r.output.File
+	}
+	return rotate.SetFileIndex(r.output.File, r.index)
+}
+
+// HandleTransaction queues the transaction to write, it is dropped and counted when the queue is full.
+func (r *SQLiteRecorder) HandleTransaction(t *Transaction) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	if r.closed {
+		return
+	}
+
+	select {
+	case r.ch <- t:
+	default:
+		atomic.AddUint64(&r.dropped, 1)
+	}
+}
+
+// Dropped returns the number of the transactions dropped on the full queue.
+func (r *SQLiteRecorder) Dropped() uint64 { return atomic.LoadUint64(&r.dropped) }
+
+// Close writes the queued transactions, and closes the database file.
+func (r *SQLiteRecorder) Close() error {
+	r.lock.Lock()
+	r.closed = true
+	close(r.ch)
+	r.lock.Unlock()
+
+	r.wg.Wait()
+	if dropped := r.Dropped(); dropped > 0 {
+		log.Printf("W! sqlite queue was full, %d transactions dropped in total", dropped)
+	}
+	return r.close()
+}
+
+func (r *SQLiteRecorder) loop() {
+	defer r.wg.Done()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	var reported uint64
+	batch := make([]*Transaction, 0, sqliteBatchSize)
+	flush := func() {
+		if len(batch) > 0 {
+			if err := r.insert(batch); err != nil {
+				log.Printf("E! sqlite insert %d transactions failed: %v", len(batch), err)
+			}
+			batch = batch[:0]
+		}
+	}
+
+	for {
+		select {
+		case t, ok := <-r.ch:
+			if !ok {
+				flush()
+				return
+			}
+			if batch = append(batch, t); len(batch) >= sqliteBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+			if dropped := r.Dropped(); dropped > reported {
+				log.Printf("W! sqlite queue is full, %d transactions dropped", dropped-reported)
+				reported = dropped
+			}
+		}
+	}
+}
+
+// open opens the current file, creates the table if not exists, and prepares the insert.
+func (r *SQLiteRecorder) open() error {
+	db, err := sql.Open("sqlite", r.fileName())
+	if err != nil {
+		return err
+	}
+	db.SetMaxOpenConns(1) // written by the loop only
+
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		_ = db.Close()
+		return err
+	}
+	stmt, err := db.Prepare(sqliteInsert)
+	if err != nil {
+		_ = db.Close()
+		return err
+	}
+	r.db, r.stmt = db, stmt
+	return nil
+}
+
+func (r *SQLiteRecorder) close() error {
+	_ = r.stmt.Close()
+	return r.db.Close()
+}
+
+// insert inserts the batch of the transactions in one commit, and rotates the file by the max size.
+func (r *SQLiteRecorder) insert(batch []*Transaction) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	stmt := tx.Stmt(r.stmt)
+	for _, t := range batch {
+		var reqBody, rspBody []byte
+		if r.output.Bodies {
+			reqBody, rspBody = t.ReqBody, t.RspBody
+		}
+		if _, err := stmt.Exec(int64(t.Seq), t.Src, t.Dst, t.Method, t.Host, t.URI, int64(t.Status),
+			float64(t.Duration().Microseconds())/1000,
+			int64(bodySize(t.ReqHeader, t.ReqBody)), int64(bodySize(t.RspHeader, t.RspBody)),
+			t.Start.Format(time.RFC3339Nano), t.End.Format(time.RFC3339Nano), reqBody, rspBody); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	if r.output.MaxSize > 0 {
+		if info, err := os.Stat(r.fileName()); err == nil && uint64(info.Size()) >= r.output.MaxSize {
+			return r.rotate()
+		}
+	}
+	return nil
+}
+
+// rotate closes the current file, and opens the next one.
+func (r *SQLiteRecorder) rotate() error {
+	if err := r.close(); err != nil {
+		return err
+	}
+
+	r.index++
+	return r.open()
+}
+
+// bodySize returns the size of the recorded body, or else the Content-Length, 0 if unknown.
+func bodySize(header http.Header, body []byte) int {
+	if body != nil {
+		return len(body)
+	}
+	n, _ := strconv.Atoi(header.Get("Content-Length"))
+	return n
+}
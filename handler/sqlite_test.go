@@ -0,0 +1,119 @@
+package handler
+
+import (
+	"bytes"
+	"database/sql"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSQLiteRecorder(t *testing.T) {
+	o, ok := ParseSQLiteOutput("sqlite:" + filepath.Join(t.TempDir(), "captures.db") + ":bodies")
+	require.True(t, ok && o.Bodies)
+	r, err := NewSQLiteRecorder(o)
+	require.Nil(t, err)
+
+	start := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	r.HandleTransaction(&Transaction{
+		Seq: 1, Src: "127.0.0.1:5000", Dst: "127.0.0.1:8080", Method: "POST", Host: "a.b", URI: "/x?q='1'",
+		ReqHeader: http.Header{}, ReqBody: []byte("ab"), Status: 201,
+		RspHeader: http.Header{"Content-Length": {"3"}}, Start: start, End: start.Add(12 * time.Millisecond),
+	})
+	assert.Nil(t, r.Close())
+
+	assert.Equal(t, [][]any{{int64(1), "127.0.0.1:5000", "127.0.0.1:8080", "POST", "a.b", "/x?q='1'", int64(201), 12.0,
+		int64(2), int64(3), "2024-05-06T07:08:09Z", "2024-05-06T07:08:09.012Z", []byte("ab"), nil}}, readSQLiteRows(t, o.File))
+
+	_, ok = ParseSQLiteOutput("captures.db")
+	assert.False(t, ok)
+}
+
+func TestSQLiteRecorderAppend(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "captures.db")
+	for i := 1; i <= 2; i++ {
+		o, _ := ParseSQLiteOutput("sqlite:" + file + ":append")
+		assert.True(t, o.Append)
+		r, err := NewSQLiteRecorder(o)
+		require.Nil(t, err)
+		r.HandleTransaction(&Transaction{Seq: int32(i)})
+		require.Nil(t, r.Close())
+	}
+
+	rows := readSQLiteRows(t, file)
+	if assert.Len(t, rows, 2) {
+		assert.Equal(t, []any{int64(1), int64(2)}, []any{rows[0][0], rows[1][0]})
+	}
+	_, err := os.Stat(filepath.Join(filepath.Dir(file), "captures_00002.db"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestSQLiteRecorderRotate(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "captures.db")
+	require.Nil(t, os.WriteFile(file, nil, 0o600)) // kept, the next file is created
+
+	o, _ := ParseSQLiteOutput("sqlite:" + file + ":20k:bodies")
+	assert.Equal(t, uint64(20000), o.MaxSize)
+	r, err := NewSQLiteRecorder(o)
+	require.Nil(t, err)
+	for i := 1; i <= 3; i++ { // 16k after the first row of the 4k pages, 24k after the second one
+		require.Nil(t, r.insert([]*Transaction{{Seq: int32(i), ReqBody: bytes.Repeat([]byte("a"), 10000)}}))
+	}
+	assert.Nil(t, r.Close())
+
+	assert.Len(t, readSQLiteRows(t, filepath.Join(filepath.Dir(file), "captures_00002.db")), 2)
+	assert.Len(t, readSQLiteRows(t, filepath.Join(filepath.Dir(file), "captures_00003.db")), 1)
+}
+
+func TestSQLiteRecorderDropped(t *testing.T) {
+	r := &SQLiteRecorder{ch: make(chan *Transaction)}
+	r.HandleTransaction(&Transaction{})
+	r.HandleTransaction(&Transaction{})
+	assert.Equal(t, uint64(2), r.Dropped())
+}
+
+func TestSQLiteRecorderStd(t *testing.T) {
+	r, err := NewSQLiteRecorder(SQLiteOutput{File: filepath.Join(t.TempDir(), "captures.db")})
+	require.Nil(t, err)
+
+	c := newTestConn(&Option{SrcRatio: 1, Resp: 1, TransactionHandlers: []TransactionHandler{r}})
+	c.requests("GET /a HTTP/1.1\r\nHost: a.b\r\n\r\n")
+	c.responses("HTTP/1.1 204 No Content\r\n\r\n")
+	require.Nil(t, r.Close())
+
+	rows := readSQLiteRows(t, r.output.File)
+	if assert.Len(t, rows, 1) {
+		assert.Equal(t, []any{"GET", "a.b", "/a", int64(204)}, rows[0][3:7])
+	}
+}
+
+// readSQLiteRows reads the rows of the transactions table in the SQLite database file.
+func readSQLiteRows(t *testing.T, file string) [][]any {
+	db, err := sql.Open("sqlite", file)
+	require.Nil(t, err)
+	defer db.Close()
+
+	rows, err := db.Query("SELECT * FROM transactions ORDER BY rowid")
+	require.Nil(t, err)
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	require.Nil(t, err)
+	var result [][]any
+	for rows.Next() {
+		row := make([]any, len(columns))
+		pointers := make([]any, len(columns))
+		for i := range row {
+			pointers[i] = &row[i]
+		}
+		require.Nil(t, rows.Scan(pointers...))
+		result = append(result, row)
+	}
+	require.Nil(t, rows.Err())
+	return result
+}
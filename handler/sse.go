@@ -0,0 +1,134 @@
+package handler
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/bingoohuang/gg/pkg/iox"
+	"github.com/bingoohuang/httpdump/util"
+)
+
+// isEventStream tells if the response is the server-sent events of text/event-stream.
+func isEventStream(header http.Header) bool {
+	mt, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	return mt == "text/event-stream"
+}
+
+// eventStreamHeaders tells if the raw response has its headers complete, and is the server-sent events.
+func eventStreamHeaders(raw []byte) bool {
+	pos := util.MIMEHeadersEndPos(raw)
+	if pos < 0 {
+		return false
+	}
+	contentType := util.Header(raw[:pos], []byte("Content-Type"))
+	return isEventStream(http.Header{"Content-Type": {string(contentType)}})
+}
+
+// streamingField tags the title of the streaming response, whose events follow.
+func streamingField(header http.Header) string {
+	if isEventStream(header) {
+		return " streaming"
+	}
+	return ""
+}
+
+// streamEvents sends the server-sent events of the response body one by one as each one arrives,
+// instead of waiting for the never-ending body to end. The events are separated by the blank lines.
+func (h *Base) streamEvents(sender Sender, header http.Header, body io.Reader, seq int32) {
+	nr, decompressed := util.TryDecompress(header, io.NopCloser(body))
+	if decompressed {
+		defer iox.Close(nr)
+	}
+
+	r := bufio.NewReader(nr)
+	event := &bytes.Buffer{}
+	for n := 1; ; {
+		line, err := r.ReadString('\n')
+		blank := strings.TrimRight(line, "\r\n") == ""
+		if !blank {
+			event.WriteString(line)
+		}
+		if (blank || err != nil) && event.Len() > 0 {
+			h.sendEvent(sender, event, seq, n)
+			n++
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (h *Base) sendEvent(sender Sender, event *bytes.Buffer, seq int32, n int) {
+	b := &bytes.Buffer{}
+	now := time.Now()
+	if h.eventTime != nil {
+		now = h.eventTime()
+	}
+	writeFormat(b, "\n### #%d EVENT %d %s %s\n", seq, n, h.conn(), h.option.FormatTime(now))
+	b.Write(event.Bytes())
+	sender.Send(b.String(), true)
+	event.Reset()
+}
+
+// eventStream streams the event-stream response in fast mode, whose end is never hinted by the packets,
+// the response is parsed in its goroutine, and the payloads of the packets are fed to its body as they arrive.
+type eventStream struct {
+	pw   *io.PipeWriter
+	time atomic.Value // the time of the last packet fed, for the events
+	done chan struct{}
+}
+
+// streamResponse starts to stream the event-stream response of the raw headers, and the body bytes following.
+func (h *Base) streamResponse(rb *bytes.Buffer, c *TCPConnection) *eventStream {
+	raw := append([]byte(nil), rb.Bytes()...)
+	h.warnFramingConflict(raw)
+	h.checkSmuggling(TagResponse, raw, c.lastRspTimestamp)
+
+	pr, pw := io.Pipe()
+	s := &eventStream{pw: pw, done: make(chan struct{})}
+	s.time.Store(c.lastRspTimestamp)
+	h.eventTime = s.now
+	start := h.rspStream - int64(len(raw))
+	go func() {
+		defer close(s.done)
+		defer discardAll(pr) // the data after the response are not parsed, not to block the feeding
+		defer func() {
+			if err := recover(); err != nil {
+				log.Printf("E! recover: %+v", err)
+			}
+		}()
+
+		// read by net/http like in std mode, the chunked body is not buffered whole by httpport
+		r, err := http.ReadResponse(bufio.NewReader(io.MultiReader(bytes.NewReader(raw), pr)), nil)
+		if err != nil {
+			h.handleError(err, s.now(), TagResponse)
+			return
+		}
+		wire := func() int64 { return -1 } // not counted along the events
+		h.processResponse(true, &HttpRsp{Response: r, wire: wire, offsets: rawOffsets(start, raw)}, h.option, s.now())
+	}()
+	return s
+}
+
+// feed feeds the payload of the packet received at t to the body.
+func (s *eventStream) feed(payload []byte, t time.Time) {
+	s.time.Store(t)
+	_, _ = s.pw.Write(payload)
+}
+
+func (s *eventStream) now() time.Time { return s.time.Load().(time.Time) }
+
+// close ends the body at the end of the stream, and waits for the events sent.
+func (s *eventStream) close() {
+	if s != nil {
+		_ = s.pw.Close()
+		<-s.done
+	}
+}
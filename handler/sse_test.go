@@ -0,0 +1,83 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStreamEvents(t *testing.T) {
+	header := http.Header{"Content-Type": {"text/event-stream; charset=utf-8"}}
+	assert.True(t, isEventStream(header))
+	assert.Equal(t, " streaming", streamingField(header))
+	assert.Equal(t, "", streamingField(http.Header{"Content-Type": {"text/plain"}}))
+
+	c := newTestConn(&Option{})
+	h := c.base(testServer, testClient)
+
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		h.streamEvents(c, header, pr, 1)
+	}()
+
+	_, _ = io.WriteString(pw, "event: greeting\ndata: hello\n\n")
+	assert.Eventually(t, func() bool { return len(c.messages()) == 1 }, time.Second, time.Millisecond)
+	assert.True(t, strings.HasPrefix(c.messages()[0], "\n### #1 EVENT 1 127.0.0.2:8080-127.0.0.1:5000 "))
+	assert.True(t, strings.HasSuffix(c.messages()[0], "\nevent: greeting\ndata: hello\n"))
+
+	_, _ = io.WriteString(pw, "data: a\r\ndata: b\r\n\r\n\r\ndata: last")
+	_ = pw.Close()
+	<-done
+
+	msgs := c.messages()
+	assert.Equal(t, 3, len(msgs))
+	assert.True(t, strings.HasSuffix(msgs[1], "\ndata: a\r\ndata: b\r\n"))
+	assert.True(t, strings.Contains(msgs[2], "### #1 EVENT 3 "))
+	assert.True(t, strings.HasSuffix(msgs[2], "\ndata: last"))
+}
+
+func TestStreamEventsFast(t *testing.T) {
+	s := &collectSender{}
+	o := &Option{Resp: 1, SrcRatio: 1}
+	a := NewTCPAssembler(&ConnectionHandlerFast{Context: context.Background(), Option: o, Sender: s}, 10, 1)
+	flow := gopacket.NewFlow(layers.EndpointIPv4, net.IPv4(127, 0, 0, 1).To4(), net.IPv4(127, 0, 0, 2).To4())
+
+	req := []byte("GET /events HTTP/1.1\r\nHost: a.b\r\n\r\n")
+	rsps := [][]byte{[]byte("HTTP/1.1 200 OK\r\nContent-Type: text/event-stream\r\nTransfer-Encoding: chunked\r\n\r\n"),
+		[]byte("9\r\ndata: a\n\n\r\n"), []byte("9\r\ndata: b\n\n\r\n")}
+	start := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	a.Assemble(flow, &layers.TCP{SrcPort: 5000, DstPort: 8080, Seq: 1, ACK: true, Ack: 1,
+		BaseLayer: layers.BaseLayer{Payload: req}}, start)
+
+	// each event is sent as its packet arrives, before the never-ending body ends, stamped by the packet time
+	rspSeq := uint32(1)
+	for i, rsp := range rsps {
+		packetTime := start.Add(time.Duration(i+1) * time.Second)
+		a.Assemble(flow.Reverse(), &layers.TCP{SrcPort: 8080, DstPort: 5000, Seq: rspSeq, ACK: true,
+			Ack: 1 + uint32(len(req)), BaseLayer: layers.BaseLayer{Payload: rsp}}, packetTime)
+		rspSeq += uint32(len(rsp))
+		a.Assemble(flow, &layers.TCP{SrcPort: 5000, DstPort: 8080, Seq: 1 + uint32(len(req)), ACK: true,
+			Ack: rspSeq}, packetTime)
+
+		want := " RSP 127.0.0.1:5000-127.0.0.2:8080 " + o.FormatTime(packetTime) + " streaming\r\n"
+		if i > 0 {
+			want = fmt.Sprintf("\n### #1 EVENT %d 127.0.0.1:5000-127.0.0.2:8080 %s\ndata: %c\n", i, o.FormatTime(packetTime), 'a'+i-1)
+		}
+		assert.Eventually(t, func() bool { return strings.Contains(strings.Join(s.messages(), ""), want) },
+			time.Second, time.Millisecond, want)
+	}
+	a.FinishAll()
+
+	assert.Equal(t, 2, strings.Count(strings.Join(s.messages(), ""), " EVENT "))
+}
@@ -0,0 +1,319 @@
+package handler
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Stats collects statistics of the captured traffic, which is output as a summary on exit.
+// All methods are safe to be called on a nil *Stats, which collects nothing.
+type Stats struct {
+	sync.Mutex
+
+	connections    int
+	connRequests   int
+	minConnReqs    int
+	maxConnReqs    int
+	connReqsBucket []int
+
+	handshakeRTT latencySamples
+
+	latency       latencySamples
+	pathLatency   map[string]*latencySamples
+	statusLatency map[int]*latencySamples
+	normalizer    *PathNormalizer
+
+	// GroupByHeader partitions the latency and the slowest paths by the value of the request header, like X-Tenant-Id.
+	GroupByHeader string
+	groupLatency  map[string]*latencySamples
+
+	// SizeBuckets are the upper bounds of the body size histograms, like ParseSizeBuckets("1KiB,1MiB").
+	SizeBuckets        []int64
+	reqSizes, rspSizes sizeHistogram
+}
+
+// NewStats creates a new Stats, grouping the paths by the normalizer.
+func NewStats(normalizer *PathNormalizer) *Stats {
+	return &Stats{
+		connReqsBucket: make([]int, len(connReqsBuckets)),
+		pathLatency:    map[string]*latencySamples{},
+		statusLatency:  map[int]*latencySamples{},
+		groupLatency:   map[string]*latencySamples{},
+		normalizer:     normalizer,
+	}
+}
+
+// connReqsBuckets are the upper bounds (inclusive) of the requests-per-connection histogram.
+var connReqsBuckets = []int{1, 2, 5, 10, 50, 100, math.MaxInt}
+
+// AddConnection records a finished connection with the number of requests it carried.
+func (s *Stats) AddConnection(requests int) {
+	if s == nil || requests <= 0 {
+		return
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	if s.connections == 0 || requests < s.minConnReqs {
+		s.minConnReqs = requests
+	}
+	if requests > s.maxConnReqs {
+		s.maxConnReqs = requests
+	}
+	s.connections++
+	s.connRequests += requests
+
+	for i, upper := range connReqsBuckets {
+		if requests <= upper {
+			s.connReqsBucket[i]++
+			break
+		}
+	}
+}
+
+// AddHandshakeRTT records the RTT of a connection estimated by its TCP handshake.
+func (s *Stats) AddHandshakeRTT(rtt time.Duration) {
+	if s == nil {
+		return
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	s.handshakeRTT.add(rtt)
+}
+
+// HandleTransaction records the latency of the transaction, overall, per host and normalized path,
+// per response status code if paired, and per the value of GroupByHeader if set.
+func (s *Stats) HandleTransaction(t *Transaction) {
+	if s == nil {
+		return
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	d := t.Duration()
+	s.latency.add(d)
+	if t.Status > 0 {
+		l := s.statusLatency[t.Status]
+		if l == nil {
+			l = &latencySamples{}
+			s.statusLatency[t.Status] = l
+		}
+		l.add(d)
+	}
+
+	key := t.Host + s.normalizer.Normalize(t.Path)
+	if s.GroupByHeader != "" {
+		group := groupOf(t.ReqHeader, s.GroupByHeader)
+		samplesOf(s.groupLatency, group).add(d)
+		key = "[" + group + "] " + key
+	}
+	samplesOf(s.pathLatency, key).add(d)
+}
+
+// groupOf returns the value of the header to group by, or - if absent.
+func groupOf(header http.Header, name string) string {
+	if v := header.Get(name); v != "" {
+		return v
+	}
+	return "-"
+}
+
+func samplesOf(m map[string]*latencySamples, key string) *latencySamples {
+	l := m[key]
+	if l == nil {
+		l = &latencySamples{}
+		m[key] = l
+	}
+	return l
+}
+
+// Summary returns the text summary of the statistics.
+func (s *Stats) Summary() string {
+	if s == nil {
+		return ""
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	b := &strings.Builder{}
+	b.WriteString("\n### SUMMARY\n")
+	fmt.Fprintf(b, "Connections: %d, Requests: %d\n", s.connections, s.connRequests)
+	if s.connections > 0 {
+		s.writeConnections(b)
+	}
+	if s.handshakeRTT.count > 0 {
+		s.writeHandshakeRTT(b)
+	}
+	if s.latency.count > 0 {
+		s.writeLatency(b)
+	}
+	if s.reqSizes.count > 0 {
+		s.writeSizes(b, "Request", &s.reqSizes)
+	}
+	if s.rspSizes.count > 0 {
+		s.writeSizes(b, "Response", &s.rspSizes)
+	}
+
+	return b.String()
+}
+
+func (s *Stats) writeConnections(b *strings.Builder) {
+	fmt.Fprintf(b, "Requests per connection min: %d, avg: %.2f, max: %d\n",
+		s.minConnReqs, float64(s.connRequests)/float64(s.connections), s.maxConnReqs)
+	lower := 1
+	for i, upper := range connReqsBuckets {
+		if n := s.connReqsBucket[i]; n > 0 {
+			fmt.Fprintf(b, "  %-8s %6d %s\n", bucketLabel(lower, upper), n, bar(n, s.connections))
+		}
+		lower = upper + 1
+	}
+}
+
+func (s *Stats) writeHandshakeRTT(b *strings.Builder) {
+	all := s.handshakeRTT.sorted()
+	fmt.Fprintf(b, "Handshake RTT p50: %s, p95: %s, p99: %s, max: %s, connections: %d\n",
+		percentile(all, 50), percentile(all, 95), percentile(all, 99), all[len(all)-1], s.handshakeRTT.count)
+}
+
+// statsTopPaths is the number of the slowest paths in the summary.
+const statsTopPaths = 10
+
+func (s *Stats) writeLatency(b *strings.Builder) {
+	all := s.latency.sorted()
+	fmt.Fprintf(b, "Latency p50: %s, p95: %s, p99: %s, max: %s\n",
+		percentile(all, 50), percentile(all, 95), percentile(all, 99), all[len(all)-1])
+	if len(s.statusLatency) > 0 {
+		s.writeStatusLatency(b)
+	}
+	if len(s.groupLatency) > 0 {
+		s.writeGroupLatency(b)
+	}
+
+	fmt.Fprintf(b, "Slowest paths by p95:\n  %12s %12s %8s  %s\n", "p95", "max", "count", "path")
+	for _, p := range s.slowestPaths() {
+		fmt.Fprintf(b, "  %12s %12s %8d  %s\n", p.p95, p.max, p.count, p.path)
+	}
+}
+
+type pathP95 struct {
+	path  string
+	count int
+	p95   time.Duration
+	max   time.Duration
+}
+
+// slowestPaths returns the statsTopPaths slowest paths by p95 latency.
+func (s *Stats) slowestPaths() []pathP95 {
+	paths := make([]pathP95, 0, len(s.pathLatency))
+	for path, l := range s.pathLatency {
+		sorted := l.sorted()
+		paths = append(paths, pathP95{path: path, count: l.count, p95: percentile(sorted, 95), max: sorted[len(sorted)-1]})
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		if paths[i].p95 != paths[j].p95 {
+			return paths[i].p95 > paths[j].p95
+		}
+		return paths[i].path < paths[j].path
+	})
+	if len(paths) > statsTopPaths {
+		paths = paths[:statsTopPaths]
+	}
+	return paths
+}
+
+// groups returns the sorted values of GroupByHeader.
+func (s *Stats) groups() []string {
+	groups := make([]string, 0, len(s.groupLatency))
+	for group := range s.groupLatency {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+	return groups
+}
+
+// statuses returns the sorted response status codes.
+func (s *Stats) statuses() []int {
+	statuses := make([]int, 0, len(s.statusLatency))
+	for status := range s.statusLatency {
+		statuses = append(statuses, status)
+	}
+	sort.Ints(statuses)
+	return statuses
+}
+
+// writeStatusLatency writes the latency by the response status codes, to tell the fast-failing errors from the slow ones.
+func (s *Stats) writeStatusLatency(b *strings.Builder) {
+	fmt.Fprintf(b, "Latency by status:\n  %12s %12s %12s %8s  %s\n", "p50", "p95", "p99", "count", "status")
+	for _, status := range s.statuses() {
+		l := s.statusLatency[status]
+		sorted := l.sorted()
+		fmt.Fprintf(b, "  %12s %12s %12s %8d  %d\n",
+			percentile(sorted, 50), percentile(sorted, 95), percentile(sorted, 99), l.count, status)
+	}
+}
+
+func (s *Stats) writeGroupLatency(b *strings.Builder) {
+	fmt.Fprintf(b, "Latency by %s:\n  %12s %12s %8s  %s\n", s.GroupByHeader, "p50", "p95", "count", "value")
+	for _, group := range s.groups() {
+		l := s.groupLatency[group]
+		sorted := l.sorted()
+		fmt.Fprintf(b, "  %12s %12s %8d  %s\n", percentile(sorted, 50), percentile(sorted, 95), l.count, group)
+	}
+}
+
+// latencySamplesMax is the max number of the latency samples kept, by reservoir sampling.
+const latencySamplesMax = 10000
+
+// latencySamples keeps the latency samples for percentiles.
+type latencySamples struct {
+	count   int
+	samples []time.Duration
+}
+
+func (l *latencySamples) add(d time.Duration) {
+	l.count++
+	if len(l.samples) < latencySamplesMax {
+		l.samples = append(l.samples, d)
+	} else if i := rand.Intn(l.count); i < latencySamplesMax {
+		l.samples[i] = d
+	}
+}
+
+func (l *latencySamples) sorted() []time.Duration {
+	sorted := append([]time.Duration(nil), l.samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted
+}
+
+// percentile returns the p-th percentile of the sorted durations by the nearest rank.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	return sorted[max(rank, 1)-1]
+}
+
+func bucketLabel(lower, upper int) string {
+	switch {
+	case upper == math.MaxInt:
+		return fmt.Sprintf("%d+", lower)
+	case lower == upper:
+		return fmt.Sprintf("%d", lower)
+	default:
+		return fmt.Sprintf("%d-%d", lower, upper)
+	}
+}
+
+// bar renders n of total as a text bar at most 40 chars wide.
+func bar(n, total int) string {
+	return strings.Repeat("#", int(math.Ceil(float64(n)*40/float64(total))))
+}
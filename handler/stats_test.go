@@ -0,0 +1,180 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatsConnections(t *testing.T) {
+	s := NewStats(nil)
+	s.AddConnection(1)
+	s.AddConnection(3)
+	s.AddConnection(0)
+	s.AddConnection(120)
+
+	assert.Equal(t, 3, s.connections)
+	assert.Equal(t, 1, s.minConnReqs)
+	assert.Equal(t, 120, s.maxConnReqs)
+	assert.Equal(t, []int{1, 0, 1, 0, 0, 0, 1}, s.connReqsBucket)
+	assert.Contains(t, s.Summary(), "Requests per connection min: 1, avg: 41.33, max: 120")
+
+	var nilStats *Stats
+	nilStats.AddConnection(1)
+	assert.Equal(t, "", nilStats.Summary())
+}
+
+func TestStatsLatency(t *testing.T) {
+	n, _ := NewPathNormalizer(nil)
+	s := NewStats(n)
+	start := time.Now()
+	for i := 1; i <= 20; i++ {
+		s.HandleTransaction(&Transaction{Host: "a.b", Path: fmt.Sprintf("/users/%d", i), Start: start, End: start.Add(time.Duration(i) * time.Millisecond)})
+	}
+	s.HandleTransaction(&Transaction{Host: "a.b", Path: "/slow", Start: start, End: start.Add(time.Second)})
+
+	summary := s.Summary()
+	assert.Contains(t, summary, "Latency p50: 11ms, p95: 20ms, p99: 1s, max: 1s\n")
+	assert.Contains(t, summary, "            1s           1s        1  a.b/slow\n"+
+		"          19ms         20ms       20  a.b/users/{id}\n")
+}
+
+func TestStatsGroupByHeader(t *testing.T) {
+	n, _ := NewPathNormalizer(nil)
+	s := NewStats(n)
+	s.GroupByHeader = "X-Tenant-Id"
+	start := time.Now()
+	for i := 1; i <= 4; i++ {
+		header := http.Header{}
+		if i%2 == 0 {
+			header.Set("X-Tenant-Id", "t1")
+		}
+		s.HandleTransaction(&Transaction{Host: "a.b", Path: "/x", ReqHeader: header, Start: start, End: start.Add(time.Duration(i) * time.Millisecond)})
+	}
+
+	summary := s.Summary()
+	assert.Contains(t, summary, "Latency by X-Tenant-Id:\n")
+	assert.Contains(t, summary, "           1ms          3ms        2  -\n"+
+		"           2ms          4ms        2  t1\n")
+	assert.Contains(t, summary, "           4ms          4ms        2  [t1] a.b/x\n")
+}
+
+func TestStatsStatusLatency(t *testing.T) {
+	s := NewStats(nil)
+	start := time.Now()
+	for i := 1; i <= 3; i++ {
+		s.HandleTransaction(&Transaction{Host: "a.b", Path: "/x", Status: 200, Start: start, End: start.Add(time.Duration(i) * 10 * time.Millisecond)})
+	}
+	s.HandleTransaction(&Transaction{Host: "a.b", Path: "/x", Status: 404, Start: start, End: start.Add(time.Millisecond)})
+	s.HandleTransaction(&Transaction{Host: "a.b", Path: "/x", Status: 504, Start: start, End: start.Add(5 * time.Second)})
+	s.HandleTransaction(&Transaction{Host: "a.b", Path: "/x", Start: start, End: start.Add(time.Millisecond)})
+
+	summary := s.Summary()
+	assert.Contains(t, summary, "Latency by status:\n"+
+		"           p50          p95          p99    count  status\n"+
+		"          20ms         30ms         30ms        3  200\n"+
+		"           1ms          1ms          1ms        1  404\n"+
+		"            5s           5s           5s        1  504\n")
+
+	data, err := s.SummaryJSON()
+	assert.Nil(t, err)
+	var bean SummaryBean
+	assert.Nil(t, json.Unmarshal(data, &bean))
+	assert.Equal(t, []statusBean{
+		{Status: 200, Count: 3, P50: "20ms", P95: "30ms", P99: "30ms"},
+		{Status: 404, Count: 1, P50: "1ms", P95: "1ms", P99: "1ms"},
+		{Status: 504, Count: 1, P50: "5s", P95: "5s", P99: "5s"},
+	}, bean.StatusLatency)
+}
+
+func TestStatsStatusLatencyStd(t *testing.T) {
+	s := NewStats(nil)
+	c := newTestConn(&Option{SrcRatio: 1, Resp: 1, Stats: s, TransactionHandlers: []TransactionHandler{s}})
+	c.requests("GET /a HTTP/1.1\r\nHost: a.b\r\n\r\nGET /b HTTP/1.1\r\nHost: a.b\r\n\r\n")
+	c.responses("HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\nHTTP/1.1 404 Not Found\r\nContent-Length: 0\r\n\r\n")
+
+	data, err := s.SummaryJSON()
+	require.Nil(t, err)
+	var bean SummaryBean
+	require.Nil(t, json.Unmarshal(data, &bean))
+	if assert.Len(t, bean.StatusLatency, 2) {
+		assert.Equal(t, 200, bean.StatusLatency[0].Status)
+		assert.Equal(t, 404, bean.StatusLatency[1].Status)
+	}
+}
+
+func TestPathNormalizer(t *testing.T) {
+	n, err := NewPathNormalizer(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "/users/{id}/orders/{uuid}", n.Normalize("/users/123/orders/3f2a6c1e-8b7d-4c2a-9e1f-0a1b2c3d4e5f"))
+	assert.Equal(t, "/blobs/{hex}/v2", n.Normalize("/blobs/0123456789abcdef0123/v2"))
+	assert.Equal(t, "/api/v1/users", n.Normalize("/api/v1/users"))
+
+	n, err = NewPathNormalizer([]string{`^v\d+$={ver}`, `^[a-z]{2}-[A-Z]{2}$`})
+	assert.Nil(t, err)
+	assert.Equal(t, "/api/{ver}/{id}/users/{id}", n.Normalize("/api/v1/en-US/users/12"))
+
+	n, err = NewPathNormalizer([]string{"off"})
+	assert.Nil(t, err)
+	assert.Equal(t, "/users/12", n.Normalize("/users/12"))
+
+	_, err = NewPathNormalizer([]string{"(="})
+	assert.NotNil(t, err)
+}
+
+func TestStatsBodySizes(t *testing.T) {
+	buckets, err := ParseSizeBuckets("10KiB, 1KiB")
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{1024, 10240}, buckets)
+
+	s := NewStats(nil)
+	s.SizeBuckets = buckets
+	s.AddBodySize(true, 0)
+	s.AddBodySize(true, 100)
+	s.AddBodySize(true, 1024)
+	s.AddBodySize(true, 20000)
+	s.AddBodySize(false, 2048)
+
+	summary := s.Summary()
+	assert.Contains(t, summary, "Request body sizes total: 20.6KiB, avg: 6.9KiB, max: 19.5KiB\n"+
+		"  <= 1KiB           2 ")
+	assert.Contains(t, summary, "  > 10KiB           1 ")
+	assert.Contains(t, summary, "Response body sizes total: 2KiB, avg: 2KiB, max: 2KiB\n"+
+		"  <= 10KiB          1 ")
+
+	_, err = ParseSizeBuckets("1XB")
+	assert.NotNil(t, err)
+}
+
+func TestStatsSummaryJSON(t *testing.T) {
+	n, _ := NewPathNormalizer(nil)
+	s := NewStats(n)
+	s.AddConnection(2)
+	start := time.Now()
+	s.HandleTransaction(&Transaction{Host: "a.b", Path: "/users/1", Start: start, End: start.Add(10 * time.Millisecond)})
+	s.HandleTransaction(&Transaction{Host: "a.b", Path: "/users/2", Start: start, End: start.Add(20 * time.Millisecond)})
+	s.AddBodySize(false, 2048)
+
+	data, err := s.SummaryJSON()
+	assert.Nil(t, err)
+
+	var bean SummaryBean
+	assert.Nil(t, json.Unmarshal(data, &bean))
+	assert.Equal(t, 1, bean.Connections)
+	assert.Equal(t, 2, bean.Requests)
+	assert.Equal(t, []bucketBean{{Bucket: "2", Count: 1}}, bean.RequestsPerConnection.Buckets)
+	assert.Equal(t, &latencyBean{P50: "10ms", P95: "20ms", P99: "20ms", Max: "20ms"}, bean.Latency)
+	assert.Equal(t, []pathBean{{Path: "a.b/users/{id}", Count: 2, P95: "20ms", Max: "20ms"}}, bean.SlowestPaths)
+	assert.Nil(t, bean.RequestBodySizes)
+	assert.Equal(t, &sizesBean{Count: 1, Total: 2048, Avg: 2048, Max: 2048, Buckets: []bucketBean{{Bucket: "<= 10KiB", Count: 1}}},
+		bean.ResponseBodySizes)
+
+	var nilStats *Stats
+	data, _ = nilStats.SummaryJSON()
+	assert.Nil(t, data)
+}
@@ -0,0 +1,146 @@
+package handler
+
+import (
+	"encoding/json"
+	"math"
+)
+
+// SummaryBean is the JSON form of the summary, the latencies are like 12.3ms.
+type SummaryBean struct {
+	Connections, Requests int
+
+	RequestsPerConnection *connReqsBean `json:",omitempty"`
+	HandshakeRTT          *rttBean      `json:",omitempty"`
+	Latency               *latencyBean  `json:",omitempty"`
+	StatusLatency         []statusBean  `json:",omitempty"`
+	GroupByHeader         string        `json:",omitempty"`
+	GroupLatency          []groupBean   `json:",omitempty"`
+	SlowestPaths          []pathBean    `json:",omitempty"`
+
+	RequestBodySizes  *sizesBean `json:",omitempty"`
+	ResponseBodySizes *sizesBean `json:",omitempty"`
+}
+
+type connReqsBean struct {
+	Min, Max int
+	Avg      float64
+	Buckets  []bucketBean
+}
+
+type bucketBean struct {
+	Bucket string
+	Count  int
+}
+
+type latencyBean struct {
+	P50, P95, P99, Max string
+}
+
+type rttBean struct {
+	Count              int
+	P50, P95, P99, Max string
+}
+
+type statusBean struct {
+	Status        int
+	Count         int
+	P50, P95, P99 string
+}
+
+type groupBean struct {
+	Value    string
+	Count    int
+	P50, P95 string
+}
+
+type pathBean struct {
+	Path     string
+	Count    int
+	P95, Max string
+}
+
+type sizesBean struct {
+	Count, Total, Avg, Max int64
+	Buckets                []bucketBean
+}
+
+// SummaryJSON returns the summary of the statistics in JSON, a SummaryBean.
+func (s *Stats) SummaryJSON() ([]byte, error) {
+	if s == nil {
+		return nil, nil
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	bean := SummaryBean{Connections: s.connections, Requests: s.connRequests}
+	if s.connections > 0 {
+		bean.RequestsPerConnection = s.connReqsBean()
+	}
+	if s.handshakeRTT.count > 0 {
+		all := s.handshakeRTT.sorted()
+		bean.HandshakeRTT = &rttBean{
+			Count: s.handshakeRTT.count, P50: percentile(all, 50).String(), P95: percentile(all, 95).String(),
+			P99: percentile(all, 99).String(), Max: all[len(all)-1].String(),
+		}
+	}
+	if s.latency.count > 0 {
+		all := s.latency.sorted()
+		bean.Latency = &latencyBean{
+			P50: percentile(all, 50).String(), P95: percentile(all, 95).String(),
+			P99: percentile(all, 99).String(), Max: all[len(all)-1].String(),
+		}
+		for _, status := range s.statuses() {
+			l := s.statusLatency[status]
+			sorted := l.sorted()
+			bean.StatusLatency = append(bean.StatusLatency, statusBean{
+				Status: status, Count: l.count, P50: percentile(sorted, 50).String(),
+				P95: percentile(sorted, 95).String(), P99: percentile(sorted, 99).String(),
+			})
+		}
+		for _, group := range s.groups() {
+			l := s.groupLatency[group]
+			sorted := l.sorted()
+			bean.GroupLatency = append(bean.GroupLatency, groupBean{
+				Value: group, Count: l.count, P50: percentile(sorted, 50).String(), P95: percentile(sorted, 95).String(),
+			})
+		}
+		if len(bean.GroupLatency) > 0 {
+			bean.GroupByHeader = s.GroupByHeader
+		}
+		for _, p := range s.slowestPaths() {
+			bean.SlowestPaths = append(bean.SlowestPaths, pathBean{Path: p.path, Count: p.count, P95: p.p95.String(), Max: p.max.String()})
+		}
+	}
+	if s.reqSizes.count > 0 {
+		bean.RequestBodySizes = s.sizesBean(&s.reqSizes)
+	}
+	if s.rspSizes.count > 0 {
+		bean.ResponseBodySizes = s.sizesBean(&s.rspSizes)
+	}
+
+	return json.MarshalIndent(bean, "", "  ")
+}
+
+func (s *Stats) connReqsBean() *connReqsBean {
+	bean := &connReqsBean{Min: s.minConnReqs, Max: s.maxConnReqs, Avg: float64(s.connRequests) / float64(s.connections)}
+	bean.Avg = math.Round(bean.Avg*100) / 100
+	lower := 1
+	for i, upper := range connReqsBuckets {
+		if n := s.connReqsBucket[i]; n > 0 {
+			bean.Buckets = append(bean.Buckets, bucketBean{Bucket: bucketLabel(lower, upper), Count: n})
+		}
+		lower = upper + 1
+	}
+	return bean
+}
+
+func (s *Stats) sizesBean(h *sizeHistogram) *sizesBean {
+	bean := &sizesBean{Count: h.count, Total: h.total, Avg: h.total / h.count, Max: h.max}
+	for i, n := range h.buckets {
+		if n > 0 {
+			bean.Buckets = append(bean.Buckets, bucketBean{Bucket: s.sizeBucketLabel(i), Count: n})
+		}
+	}
+	return bean
+}
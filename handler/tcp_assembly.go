@@ -5,8 +5,10 @@ import (
 	"encoding/binary"
 	"encoding/hex"
 	"fmt"
+	"log"
 	"net"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/bingoohuang/gg/pkg/handy"
@@ -31,6 +33,14 @@ type TCPAssembler struct {
 
 	chanSize    uint
 	processResp int
+
+	// DebugConn logs the lifecycle of the connections, created, closed, flushed on idle and finished, with their counts.
+	DebugConn bool
+
+	source string // capture source of the packets assembled next, set by SetSource
+
+	lastTimestamp time.Time // timestamp of the last packet assembled
+	lastAssembled time.Time // the time the last packet was assembled
 }
 
 func NewTCPAssembler(handler ConnectionHandler, chanSize uint, processResp int) *TCPAssembler {
@@ -45,22 +55,48 @@ func NewTCPAssembler(handler ConnectionHandler, chanSize uint, processResp int)
 func (r *TCPAssembler) Assemble(flow gopacket.Flow, tcp *layers.TCP, timestamp time.Time) {
 	src := Endpoint{ip: flow.Src().String(), port: uint16(tcp.SrcPort)}
 	dst := Endpoint{ip: flow.Dst().String(), port: uint16(tcp.DstPort)}
+	r.lastTimestamp, r.lastAssembled = timestamp, time.Now()
+	r.handler.captured(timestamp)
+
+	if isPureACK(tcp) && !tcp.SYN { // only to confirm the data of the existing connection
+		if c := r.retrieveConnection(src, dst, r.createConnectionKey(src, dst), false); c != nil {
+			c.onReceive(src, tcp, timestamp)
+			r.observeHandshake(c, src, dst, tcp, timestamp)
+		}
+		return
+	}
 
 	key := r.createConnectionKey(src, dst)
-	createNewConn := tcp.SYN && !tcp.ACK || isHTTPRequestData(tcp.Payload)
+	// the TLS handshake of an untracked connection is printed, the data alike of an HTTP connection is assembled
+	if r.handler.tlsSNI() && (util.IsTLSClientHello(tcp.Payload) || util.IsTLSServerHello(tcp.Payload)) &&
+		r.retrieveConnection(src, dst, key, false) == nil {
+		r.handler.handshake(src, dst, tcp.Payload, timestamp)
+		return
+	}
+
+	createNewConn := tcp.SYN && !tcp.ACK || isHTTPRequestData(tcp.Payload) || util.HasProxyProtocol(tcp.Payload)
 	c := r.retrieveConnection(src, dst, key, createNewConn)
 	if c == nil {
 		return
 	}
 
 	c.onReceive(src, tcp, timestamp)
+	r.observeHandshake(c, src, dst, tcp, timestamp)
 
 	if c.closed() {
 		r.deleteConnection(key)
+		r.debugConn(c, "closed")
 		c.finish()
 	}
 }
 
+// observeHandshake tracks the handshake packets of the connection, the handler is told when the handshake completes.
+func (r *TCPAssembler) observeHandshake(c *TCPConnection, src, dst Endpoint, tcp *layers.TCP, timestamp time.Time) {
+	if (tcp.SYN || tcp.ACK) && c.handshake.observe(src, tcp, timestamp) {
+		r.handler.established(src, dst, &c.handshake)
+	}
+}
+
 func (r *TCPAssembler) createConnectionKey(src Endpoint, dst Endpoint) string {
 	srcString, dstString := src.String(), dst.String()
 	if srcString < dstString {
@@ -70,6 +106,9 @@ func (r *TCPAssembler) createConnectionKey(src Endpoint, dst Endpoint) string {
 	return dstString + "-" + srcString
 }
 
+// SetSource tags the connections created by the packets assembled next with the capture source.
+func (r *TCPAssembler) SetSource(source string) { r.source = source }
+
 // retrieveConnection get connection this packet belongs to; create new one if is new connection.
 func (r *TCPAssembler) retrieveConnection(src, dst Endpoint, key string, init bool) *TCPConnection {
 	defer r.lock.LockDeferUnlock()()
@@ -77,7 +116,9 @@ func (r *TCPAssembler) retrieveConnection(src, dst Endpoint, key string, init bo
 	c := r.connections[key]
 	if c == nil && init {
 		c = newTCPConnection(key, src, dst, r.chanSize, r.processResp)
+		c.source = r.source
 		r.connections[key] = c
+		r.debugConn(c, fmt.Sprintf("created, client: %s, connections: %d", src, len(r.connections)))
 		r.handler.handle(src, dst, c)
 	}
 	return c
@@ -90,12 +131,12 @@ func (r *TCPAssembler) deleteConnection(key string) {
 }
 
 // FlushOlderThan flushes timeout connections.
-func (r *TCPAssembler) FlushOlderThan(time time.Time) {
+func (r *TCPAssembler) FlushOlderThan(cutoff time.Time) {
 	var connections []*TCPConnection
 
 	r.lock.Lock()
 	for _, c := range r.connections {
-		if c.lastTimestamp.Before(time) {
+		if c.lastTimestamp.Before(cutoff) {
 			connections = append(connections, c)
 			delete(r.connections, c.key)
 		}
@@ -103,14 +144,48 @@ func (r *TCPAssembler) FlushOlderThan(time time.Time) {
 	r.lock.Unlock()
 
 	for _, c := range connections {
+		r.debugConn(c, fmt.Sprintf("flushed on idle, last packet at %s", c.lastTimestamp.Format(time.RFC3339Nano)))
 		c.flushOlderThan()
 	}
+
+	// the packet time now, advanced by the time passed since the last packet, when the capture is idle
+	if !r.lastTimestamp.IsZero() {
+		r.handler.flushUnpaired(r.lastTimestamp.Add(time.Since(r.lastAssembled)))
+	}
 }
 
+// debugConn logs the event of the connection with its counts, if DebugConn.
+func (r *TCPAssembler) debugConn(c *TCPConnection, event string) {
+	if r.DebugConn {
+		log.Printf("D! conn %s %s, packets: %d, bytes: %d, age: %s",
+			c.key, event, c.packets, c.bytes, c.lastTimestamp.Sub(c.firstTimestamp))
+	}
+}
+
+// ChanOccupancy reports the max occupancy among the channels buffering tcp packets of the streams.
+func (r *TCPAssembler) ChanOccupancy() (name string, length, capacity int) {
+	defer r.lock.LockDeferUnlock()()
+
+	capacity = int(r.chanSize)
+	for _, c := range r.connections {
+		for _, s := range []Stream{c.requestStream, c.responseStream} {
+			if ns, ok := s.(*NetworkStream); ok && len(ns.c) > length {
+				length = len(ns.c)
+			}
+		}
+	}
+
+	return fmt.Sprintf("chan(%d connections)", len(r.connections)), length, capacity
+}
+
+// PendingConnections returns the number of the connections not finished by the handler yet.
+func (r *TCPAssembler) PendingConnections() int { return r.handler.pending() }
+
 func (r *TCPAssembler) FinishAll() {
 	defer r.lock.LockDeferUnlock()()
 
 	for _, c := range r.connections {
+		r.debugConn(c, "finished")
 		c.finish()
 	}
 	r.connections = nil
@@ -127,6 +202,14 @@ type TCPConnection struct {
 	lastReqTimestamp time.Time // timestamp receive last packet
 	lastRspTimestamp time.Time // timestamp receive last packet
 	isHTTP           bool
+
+	firstTimestamp time.Time // timestamp receive first packet
+	packets        int       // packets received
+	bytes          int64     // payload bytes received
+
+	source string // capture source of the first packet, like the interface name or the pcap file
+
+	handshake handshake // the TCP three-way handshake, if observed
 }
 
 // Endpoint is one endpoint of a tcp connection
@@ -154,16 +237,35 @@ func newTCPConnection(key string, src, dst Endpoint, chanSize uint, processResp
 	return t
 }
 
+// isPureACK tells if the segment carries no data, like the pure or duplicate ACKs, or the SYNs,
+// which only confirm the data of the other direction, the FIN or RST ones close the stream.
+func isPureACK(tcp *layers.TCP) bool {
+	return len(tcp.Payload) == 0 && !tcp.FIN && !tcp.RST
+}
+
 // when receive tcp packet
 func (c *TCPConnection) onReceive(src Endpoint, tcp *layers.TCP, timestamp time.Time) {
+	if c.firstTimestamp.IsZero() {
+		c.firstTimestamp = timestamp
+	}
 	c.lastTimestamp = timestamp
+	c.packets++
+	c.bytes += int64(len(tcp.Payload))
+	if isPureACK(tcp) {
+		if tcp.ACK && c.isHTTP {
+			c.peerStream(src).ConfirmPacket(tcp.Ack)
+		}
+		return
+	}
+
 	var (
 		isReq bool
 		isRsp bool
 	)
 
 	if !c.isHTTP {
-		isReq = isHTTPRequestData(tcp.Payload)
+		// the client may send the PROXY protocol header before the http request
+		isReq = isHTTPRequestData(tcp.Payload) || util.HasProxyProtocol(tcp.Payload)
 		if !isReq {
 			_, isRsp = util.ParseResponseTitle(tcp.Payload)
 		}
@@ -205,6 +307,14 @@ func (c *TCPConnection) onReceive(src Endpoint, tcp *layers.TCP, timestamp time.
 	}
 }
 
+// peerStream returns the stream of the other direction than the packets from src, to be confirmed by their ACKs.
+func (c *TCPConnection) peerStream(src Endpoint) Stream {
+	if c.clientID.equals(src) {
+		return c.responseStream
+	}
+	return c.requestStream
+}
+
 // just close this connection?
 func (c *TCPConnection) flushOlderThan() {
 	// flush all data
@@ -232,6 +342,14 @@ type NetworkStream struct {
 	ignore bool
 	closed bool
 
+	// the packets of the stream queued to the workers spill over the full chan, not to block the assembler,
+	// which feeds the streams being read, they are sent to the chan in order once the stream is started.
+	lock      sync.Mutex
+	queued    bool          // queued to the workers, no reader yet
+	spill     []*layers.TCP // the packets over the full chan
+	flushing  bool          // the spill being sent to the chan after the start
+	finishing bool          // finished while flushing, the chan is closed after the spill sent
+
 	src, dst  Endpoint
 	isRequest bool
 }
@@ -248,6 +366,8 @@ type Stream interface {
 	Packets() chan *layers.TCP
 	Close() error
 	DiscardAll()
+	Queue()
+	Start()
 }
 
 type FakeStream struct {
@@ -263,6 +383,8 @@ func (f *FakeStream) SetClosed(closed bool)     { f.closed = closed }
 func (f *FakeStream) IsClosed() bool            { return f.closed }
 func (*FakeStream) Finish()                     {}
 func (*FakeStream) DiscardAll()                 {}
+func (*FakeStream) Queue()                      {}
+func (*FakeStream) Start()                      {}
 
 func newNetworkStream(src, dst Endpoint, isRequest bool, chanSize uint) Stream {
 	return &NetworkStream{
@@ -282,16 +404,89 @@ func (s *NetworkStream) AppendPacket(tcp *layers.TCP) {
 }
 
 func (s *NetworkStream) ConfirmPacket(ack uint32) {
-	if s.ignore {
+	if s.ignore || s.window.size == 0 { // nothing to confirm, like by the duplicate ACKs
+		return
+	}
+	s.window.confirm(ack, s.deliver)
+}
+
+// deliver sends the confirmed packet to the reader, or spills it over the full chan while the stream is queued.
+func (s *NetworkStream) deliver(packet *layers.TCP) {
+	s.lock.Lock()
+	if s.flushing || len(s.spill) > 0 {
+		s.spill = append(s.spill, packet) // after the packets spilled
+		s.lock.Unlock()
 		return
 	}
-	s.window.confirm(ack, s.c)
+	if s.queued {
+		select {
+		case s.c <- packet:
+		default:
+			s.spill = append(s.spill, packet)
+		}
+		s.lock.Unlock()
+		return
+	}
+	s.lock.Unlock()
+
+	s.c <- packet
 }
 
-func (s *NetworkStream) Finish() { close(s.c) }
+// Queue marks the stream queued to the workers, its packets spill over the full chan until Start.
+func (s *NetworkStream) Queue() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.queued = true
+}
+
+// Start marks the stream read by a worker, the packets spilled are sent to the chan along by another goroutine.
+func (s *NetworkStream) Start() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.queued = false
+	if len(s.spill) > 0 {
+		s.flushing = true
+		go s.flush()
+	}
+}
+
+func (s *NetworkStream) flush() {
+	for {
+		s.lock.Lock()
+		if len(s.spill) == 0 {
+			s.flushing = false
+			finishing := s.finishing
+			s.lock.Unlock()
+			if finishing {
+				close(s.c)
+			}
+			return
+		}
+		packet := s.spill[0]
+		s.spill[0] = nil
+		s.spill = s.spill[1:]
+		s.lock.Unlock()
+
+		s.c <- packet
+	}
+}
+
+// Finish closes the chan, after the packets spilled if any.
+func (s *NetworkStream) Finish() {
+	s.lock.Lock()
+	if s.flushing || len(s.spill) > 0 {
+		s.finishing = true
+		s.lock.Unlock()
+		return
+	}
+	s.lock.Unlock()
+
+	close(s.c)
+}
 
 // UUID returns the UUID of a TCP request and its response.
-func (s NetworkStream) UUID(p *layers.TCP) []byte {
+func (s *NetworkStream) UUID(p *layers.TCP) []byte {
 	l, r := s.src, s.dst
 	streamID := uint64(l.port)<<48 | uint64(r.port)<<32 | uint64(ip2int(l.ip))
 	id := make([]byte, 12)
@@ -397,7 +592,7 @@ func (w *ReceiveWindow) insert(packet *layers.TCP) {
 }
 
 // send confirmed packets to reader, when receive ack
-func (w *ReceiveWindow) confirm(ack uint32, c chan *layers.TCP) {
+func (w *ReceiveWindow) confirm(ack uint32, deliver func(*layers.TCP)) {
 	idx := 0
 	for ; idx < w.size; idx++ {
 		index := (idx + w.start) % len(w.buffer)
@@ -422,7 +617,7 @@ func (w *ReceiveWindow) confirm(ack uint32, c chan *layers.TCP) {
 				// TODO: we lose packet here
 			}
 		}
-		c <- packet
+		deliver(packet)
 		w.expectBegin = newExpect
 	}
 	w.start = (w.start + idx) % len(w.buffer)
@@ -1,8 +1,13 @@
 package handler
 
 import (
+	"context"
+	"net"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
 	"github.com/stretchr/testify/assert"
 )
@@ -32,7 +37,85 @@ func TestReceiveWindow(t *testing.T) {
 
 	c := make(chan *layers.TCP, 1000)
 	// confirm
-	window.confirm(10020, c)
+	window.confirm(10020, func(p *layers.TCP) { c <- p })
 	assert.Equal(t, 1, window.size)
 	assert.Equal(t, 4, window.start)
 }
+
+func TestTCPConnectionCounts(t *testing.T) {
+	c := newTCPConnection("127.0.0.1:5000-127.0.0.2:8080", testClient, testServer, 10, 0)
+
+	start := time.Now()
+	c.onReceive(testClient, &layers.TCP{SYN: true}, start)
+	payload := []byte("GET / HTTP/1.1\r\nHost: a.b\r\n\r\n")
+	c.onReceive(testClient, &layers.TCP{Seq: 1, BaseLayer: layers.BaseLayer{Payload: payload}}, start.Add(time.Second))
+
+	assert.Equal(t, 2, c.packets)
+	assert.Equal(t, int64(len(payload)), c.bytes)
+	assert.Equal(t, time.Second, c.lastTimestamp.Sub(c.firstTimestamp))
+}
+
+func TestTCPAssemblerSource(t *testing.T) {
+	s := &collectSender{}
+	h := &ConnectionHandlerFast{Context: context.Background(), Option: &Option{Resp: 1, SrcRatio: 1}, Sender: s}
+	a := NewTCPAssembler(h, 10, 1)
+	flow := gopacket.NewFlow(layers.EndpointIPv4, net.IPv4(127, 0, 0, 1).To4(), net.IPv4(127, 0, 0, 2).To4())
+
+	req, rsp := []byte("GET / HTTP/1.1\r\nHost: a.b\r\n\r\n"), []byte("HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n")
+
+	a.SetSource("eth9")
+	a.Assemble(flow, &layers.TCP{SrcPort: 5000, DstPort: 8080, Seq: 1, BaseLayer: layers.BaseLayer{Payload: req}}, time.Now())
+	a.SetSource("eth8") // the connection keeps the source of its first packet
+	a.Assemble(flow.Reverse(), &layers.TCP{SrcPort: 8080, DstPort: 5000, Seq: 1, ACK: true, Ack: 1 + uint32(len(req)),
+		BaseLayer: layers.BaseLayer{Payload: rsp}}, time.Now())
+	a.Assemble(flow, &layers.TCP{SrcPort: 5000, DstPort: 8080, Seq: 1 + uint32(len(req)), ACK: true,
+		Ack: 1 + uint32(len(rsp))}, time.Now())
+	a.FinishAll()
+
+	out := strings.Join(s.messages(), "")
+	assert.Equal(t, 2, strings.Count(out, " source:eth9"))
+	assert.NotContains(t, out, "eth8")
+}
+
+func TestTCPConnectionPureACKs(t *testing.T) {
+	c := newTCPConnection("127.0.0.1:5000-127.0.0.2:8080", testClient, testServer, 10, 1)
+
+	start := time.Now()
+	part1, part2 := []byte("GET / HTTP/1.1\r\n"), []byte("Host: a.b\r\n\r\n")
+	c.onReceive(testClient, &layers.TCP{Seq: 1, BaseLayer: layers.BaseLayer{Payload: part1}}, start)
+	c.onReceive(testServer, &layers.TCP{Seq: 1, ACK: true, Ack: 1}, start)  // confirms nothing
+	c.onReceive(testClient, &layers.TCP{Seq: 17, ACK: true, Ack: 1}, start) // the client's ACK is not response data
+	assert.True(t, c.lastRspTimestamp.IsZero())
+	c.onReceive(testServer, &layers.TCP{Seq: 1, ACK: true, Ack: 17}, start) // confirms part1
+	c.onReceive(testServer, &layers.TCP{Seq: 1, ACK: true, Ack: 17}, start) // duplicate
+	c.onReceive(testClient, &layers.TCP{Seq: 17, BaseLayer: layers.BaseLayer{Payload: part2}}, start.Add(time.Second))
+	c.onReceive(testServer, &layers.TCP{Seq: 1, ACK: true, Ack: 30}, start.Add(time.Second)) // confirms part2
+
+	assert.Equal(t, 7, c.packets)
+	assert.Equal(t, part1, (<-c.requestStream.Packets()).Payload)
+	assert.Equal(t, part2, (<-c.requestStream.Packets()).Payload)
+	assert.Len(t, c.requestStream.Packets(), 0)
+	assert.Len(t, c.responseStream.Packets(), 0)
+	assert.False(t, c.requestStream.IsClosed())
+}
+
+func TestTCPAssemblerTLSLikeBody(t *testing.T) {
+	s := &collectSender{}
+	h := &ConnectionHandlerFast{Context: context.Background(), Option: &Option{SrcRatio: 1, Level: LevelBody, TLSSNI: true}, Sender: s}
+	a := NewTCPAssembler(h, 10, 0)
+	flow := gopacket.NewFlow(layers.EndpointIPv4, net.IPv4(127, 0, 0, 1).To4(), net.IPv4(127, 0, 0, 2).To4())
+
+	// the body of the tracked HTTP connection starting like a TLS ClientHello is still assembled
+	req, body := []byte("POST / HTTP/1.1\r\nHost: a.b\r\nContent-Length: 6\r\n\r\n"), []byte{0x16, 0x03, 0x01, 0x00, 0x01, 0x01}
+	a.Assemble(flow, &layers.TCP{SrcPort: 5000, DstPort: 8080, Seq: 1, BaseLayer: layers.BaseLayer{Payload: req}}, time.Now())
+	a.Assemble(flow, &layers.TCP{SrcPort: 5000, DstPort: 8080, Seq: 1 + uint32(len(req)),
+		BaseLayer: layers.BaseLayer{Payload: body}}, time.Now())
+	a.Assemble(flow.Reverse(), &layers.TCP{SrcPort: 8080, DstPort: 5000, Seq: 1, ACK: true,
+		Ack: 1 + uint32(len(req)+len(body))}, time.Now())
+	a.FinishAll()
+
+	out := strings.Join(s.messages(), "")
+	assert.Contains(t, out, "### #1 POST http://a.b/\r\n")
+	assert.Contains(t, out, "{Non-text body, content-type:application/octet-stream, len:6}")
+	assert.NotContains(t, out, "### TLS")
+}
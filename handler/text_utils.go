@@ -1,11 +1,16 @@
 package handler
 
 import (
+	"bytes"
 	"io"
 	"io/ioutil"
+	"net/http"
 	"strings"
 
+	"golang.org/x/text/encoding"
 	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/encoding/unicode/utf32"
 	"golang.org/x/text/transform"
 )
 
@@ -135,18 +140,58 @@ func (ct MimeType) isBinaryContent() bool {
 
 // ReadWithCharset read reader content to string, using charset specified
 func ReadWithCharset(reader io.Reader, charset string) ([]byte, error) {
-	charset = strings.ToUpper(charset)
-	switch charset {
-	case "UTF-8", "UTF8":
+	enc, err := lookupCharset(charset)
+	if err != nil {
+		return nil, err
+	}
+	if enc == nil {
 		return ioutil.ReadAll(reader)
+	}
+	return ioutil.ReadAll(transform.NewReader(reader, enc.NewDecoder()))
+}
+
+// DecodeCharset transcodes the data in the charset to UTF-8.
+func DecodeCharset(data []byte, charset string) ([]byte, error) {
+	enc, err := lookupCharset(charset)
+	if err != nil || enc == nil {
+		return data, err
+	}
+	return enc.NewDecoder().Bytes(data)
+}
+
+// lookupCharset returns the encoding of the charset, nil for UTF-8.
+// UTF-16/UTF-32 without the byte order in the name follow the BOM, or big endian without one by RFC 2781.
+func lookupCharset(charset string) (encoding.Encoding, error) {
+	switch strings.ToUpper(charset) {
+	case "", "UTF-8", "UTF8":
+		return nil, nil
 	case "GBK", "GB2312":
 		charset = "GB18030"
+	case "UTF-16":
+		return unicode.UTF16(unicode.BigEndian, unicode.UseBOM), nil
+	case "UTF-16BE":
+		return unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM), nil
+	case "UTF-16LE":
+		return unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM), nil
+	case "UTF-32":
+		return utf32.UTF32(utf32.BigEndian, utf32.UseBOM), nil
+	case "UTF-32BE":
+		return utf32.UTF32(utf32.BigEndian, utf32.IgnoreBOM), nil
+	case "UTF-32LE":
+		return utf32.UTF32(utf32.LittleEndian, utf32.IgnoreBOM), nil
 	}
-	encoder, err := htmlindex.Get(charset)
-	if err != nil {
-		return nil, err
+	return htmlindex.Get(charset)
+}
+
+// bomCharset detects the charset UTF-16/UTF-32 by the BOM at the start of the data, or empty if none.
+func bomCharset(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte{0, 0, 0xFE, 0xFF}), bytes.HasPrefix(data, []byte{0xFF, 0xFE, 0, 0}):
+		return "UTF-32"
+	case bytes.HasPrefix(data, []byte{0xFE, 0xFF}), bytes.HasPrefix(data, []byte{0xFF, 0xFE}):
+		return "UTF-16"
 	}
-	return ioutil.ReadAll(transform.NewReader(reader, encoder.NewDecoder()))
+	return ""
 }
 
 // ParseContentType parse content type to MimeType and charset
@@ -158,17 +203,36 @@ func ParseContentType(contentType string) (string, string) {
 		charset = ""
 	} else {
 		mimeTypeStr = strings.TrimSpace(contentType[:idx])
-		charsetSeg := strings.TrimSpace(contentType[idx+1:])
-		eidx := strings.Index(charsetSeg, "=")
-		if eidx < 0 {
-			charset = ""
-		} else {
-			charset = strings.TrimSpace(charsetSeg[eidx+1:])
+		for _, param := range strings.Split(contentType[idx+1:], ";") {
+			if k, v, ok := strings.Cut(param, "="); ok && strings.EqualFold(strings.TrimSpace(k), "charset") {
+				charset = strings.Trim(strings.TrimSpace(v), `"`)
+				break
+			}
 		}
 	}
 	return mimeTypeStr, charset
 }
 
+// sniffLen is the max bytes used by http.DetectContentType.
+const sniffLen = 512
+
+// SniffContentType detects the content type by the leading bytes of the body without a Content-Type,
+// application/json for the text looks like json, and returns the reader to read the whole body again.
+func SniffContentType(r io.Reader) (io.Reader, string) {
+	peek, _ := io.ReadAll(io.LimitReader(r, sniffLen))
+	if len(peek) == 0 {
+		return r, ""
+	}
+
+	contentType := http.DetectContentType(peek)
+	if strings.HasPrefix(contentType, "text/plain") {
+		if s := strings.TrimSpace(string(peek)); strings.HasPrefix(s, "{") || strings.HasPrefix(s, "[") {
+			contentType = "application/json"
+		}
+	}
+	return io.MultiReader(bytes.NewReader(peek), r), contentType
+}
+
 // LikeJSON tells if sting 'looks like' a json string.
 func LikeJSON(s string) bool {
 	if len(s) < 2 {
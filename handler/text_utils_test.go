@@ -1,6 +1,8 @@
 package handler
 
 import (
+	"io"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -42,3 +44,43 @@ func TestWildcardMatch(t *testing.T) {
 	assert.False(t, wildcardMatch("test", "tt*"))
 	assert.False(t, wildcardMatch("test", "es"))
 }
+
+func TestSniffContentType(t *testing.T) {
+	r, contentType := SniffContentType(strings.NewReader(` {"a": 1}`))
+	assert.Equal(t, "application/json", contentType)
+	data, _ := io.ReadAll(r)
+	assert.Equal(t, ` {"a": 1}`, string(data))
+
+	_, contentType = SniffContentType(strings.NewReader("<html><body>hi</body></html>"))
+	assert.Equal(t, "text/html; charset=utf-8", contentType)
+
+	_, contentType = SniffContentType(strings.NewReader("\x00\x01\x02\x03"))
+	assert.Equal(t, "application/octet-stream", contentType)
+
+	_, contentType = SniffContentType(strings.NewReader("  \n"))
+	assert.Equal(t, "text/plain; charset=utf-8", contentType)
+
+	_, contentType = SniffContentType(strings.NewReader(""))
+	assert.Equal(t, "", contentType)
+}
+
+func TestCharset(t *testing.T) {
+	mimeType, charset := ParseContentType(`multipart/mixed; boundary=x; charset="UTF-16BE"`)
+	assert.Equal(t, "multipart/mixed", mimeType)
+	assert.Equal(t, "UTF-16BE", charset)
+
+	body, err := DecodeCharset([]byte("\x00h\x00i"), "utf-16") // big endian without the BOM
+	assert.Nil(t, err)
+	assert.Equal(t, "hi", string(body))
+
+	body, err = DecodeCharset([]byte("\xff\xfeh\x00i\x00"), "utf-16")
+	assert.Nil(t, err)
+	assert.Equal(t, "hi", string(body))
+
+	body, err = DecodeCharset([]byte("\xc4\xe3\xba\xc3"), "gbk")
+	assert.Nil(t, err)
+	assert.Equal(t, "你好", string(body))
+
+	_, err = DecodeCharset([]byte("hi"), "x-nope")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,168 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bingoohuang/gg/pkg/man"
+	"golang.org/x/time/rate"
+)
+
+// ParseRate parses the rate in bytes per second, like 1MB/s, 512KiB/s or 1000.
+func ParseRate(s string) (int, error) {
+	n, err := man.ParseBytes(strings.TrimSuffix(strings.TrimSpace(s), "/s"))
+	if err != nil {
+		return 0, err
+	}
+	if n == 0 {
+		return 0, fmt.Errorf("rate %s should be positive", s)
+	}
+	return int(n), nil
+}
+
+// ThrottledSender limits the output rate of the sender in bytes per second by a token bucket.
+// The messages are queued in a channel to absorb the bursts, Send blocks when the channel is full.
+type ThrottledSender struct {
+	Sender
+	ctx     context.Context
+	limiter *rate.Limiter
+	delay   time.Duration // the sleep after each message, to simulate a slow output
+	name    string        // the name of the queue in the occupancy gauge
+	ch      chan SendArgs
+	closing chan struct{}
+	done    chan struct{}
+}
+
+// NewThrottledSender creates a ThrottledSender sending at most bytesPerSecond to the sender,
+// the throttling stops when ctx is done, to flush the queued messages on exit.
+func NewThrottledSender(ctx context.Context, sender Sender, bytesPerSecond int, chanSize uint) *ThrottledSender {
+	s := &ThrottledSender{
+		Sender:  sender,
+		ctx:     ctx,
+		limiter: rate.NewLimiter(rate.Limit(bytesPerSecond), bytesPerSecond),
+		name:    "output-rate",
+		ch:      make(chan SendArgs, chanSize),
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// NewDelayedSender creates a ThrottledSender sleeping delay after each message sent to the sender,
+// to debug the backpressure of the downstream consumers, the delaying stops when ctx is done.
+func NewDelayedSender(ctx context.Context, sender Sender, delay time.Duration, chanSize uint) *ThrottledSender {
+	s := &ThrottledSender{
+		Sender:  sender,
+		ctx:     ctx,
+		delay:   delay,
+		name:    "output-delay",
+		ch:      make(chan SendArgs, chanSize),
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// NewQueuedSender creates a ThrottledSender neither limiting nor delaying, only queuing the messages to the sender
+// in the channel named by name, for the occupancy gauge of the senders without one, like the main output queue.
+func NewQueuedSender(ctx context.Context, sender Sender, name string, chanSize uint) *ThrottledSender {
+	s := &ThrottledSender{
+		Sender:  sender,
+		ctx:     ctx,
+		name:    name,
+		ch:      make(chan SendArgs, chanSize),
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// Send queues the message, it blocks when the queue is full, and the message is dropped after Close,
+// like by the handlers still running on shutdown.
+func (s *ThrottledSender) Send(msg string, countDiscards bool) {
+	select {
+	case <-s.closing:
+		return
+	default:
+	}
+
+	select {
+	case s.ch <- SendArgs{Msg: msg, CountDiscards: countDiscards}:
+	case <-s.closing:
+	}
+}
+
+func (s *ThrottledSender) run() {
+	defer close(s.done)
+
+	for {
+		m, ok := s.next()
+		if !ok {
+			return
+		}
+		s.wait(len(m.Msg))
+		s.Sender.Send(m.Msg, m.CountDiscards)
+		s.sleep()
+	}
+}
+
+// next returns the next message queued, false when closing and no more queued.
+func (s *ThrottledSender) next() (SendArgs, bool) {
+	select {
+	case m := <-s.ch:
+		return m, true
+	case <-s.closing:
+		select {
+		case m := <-s.ch:
+			return m, true
+		default:
+			return SendArgs{}, false
+		}
+	}
+}
+
+// sleep sleeps the delay after each message, or returns when ctx is done.
+func (s *ThrottledSender) sleep() {
+	if s.delay <= 0 {
+		return
+	}
+	t := time.NewTimer(s.delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-s.ctx.Done():
+	}
+}
+
+// wait waits for the tokens of n bytes, by the burst at most each time for the large messages.
+func (s *ThrottledSender) wait(n int) {
+	if s.limiter == nil {
+		return
+	}
+	for burst := s.limiter.Burst(); n > 0; n -= burst {
+		if err := s.limiter.WaitN(s.ctx, min(n, burst)); err != nil {
+			return // ctx done
+		}
+	}
+}
+
+// Close flushes the queued messages and closes the sender.
+// The channel is left open, for Send to be safe after Close.
+func (s *ThrottledSender) Close() error {
+	close(s.closing)
+	<-s.done
+	return s.Sender.Close()
+}
+
+// ChanOccupancy reports the occupancy of the channel queuing the messages to throttle.
+func (s *ThrottledSender) ChanOccupancy() (name string, length, capacity int) {
+	return s.name, len(s.ch), cap(s.ch)
+}
+
+// Unwrap returns the sender wrapped, for the occupancy gauges of the senders wrapped.
+func (s *ThrottledSender) Unwrap() Sender { return s.Sender }
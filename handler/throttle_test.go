@@ -0,0 +1,107 @@
+package handler
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRate(t *testing.T) {
+	for s, expected := range map[string]int{"1MB/s": 1000000, "512KiB/s": 512 * 1024, "1000": 1000} {
+		n, err := ParseRate(s)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, n)
+	}
+
+	_, err := ParseRate("0/s")
+	assert.NotNil(t, err)
+	_, err = ParseRate("fast")
+	assert.NotNil(t, err)
+}
+
+func TestThrottledSender(t *testing.T) {
+	out := &collectSender{}
+	s := NewThrottledSender(context.Background(), out, 1000, 10)
+
+	start := time.Now()
+	msg := strings.Repeat("x", 500)
+	for i := 0; i < 4; i++ { // the first 1000 bytes of the burst, then 1000 bytes in 1s
+		s.Send(msg, true)
+	}
+	assert.Nil(t, s.Close())
+	assert.Len(t, out.msgs, 4)
+	assert.InDelta(t, time.Second, time.Since(start), float64(200*time.Millisecond))
+
+	s.Send(msg, true) // dropped, not panicking on the closed sender
+	assert.Len(t, out.messages(), 4)
+}
+
+func TestDelayedSender(t *testing.T) {
+	out := &collectSender{}
+	s := NewDelayedSender(context.Background(), out, 50*time.Millisecond, 10)
+
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		s.Send("x", true)
+	}
+	assert.Nil(t, s.Close())
+	assert.Len(t, out.msgs, 4)
+	assert.InDelta(t, 200*time.Millisecond, time.Since(start), float64(100*time.Millisecond))
+}
+
+// blockedSender blocks the sending until released.
+type blockedSender struct {
+	collectSender
+	release chan struct{}
+}
+
+func (s *blockedSender) Send(msg string, countDiscards bool) {
+	<-s.release
+	s.collectSender.Send(msg, countDiscards)
+}
+
+func TestQueuedSender(t *testing.T) {
+	out := &blockedSender{release: make(chan struct{})}
+	s := NewQueuedSender(context.Background(), out, "out stdout", 10)
+	for i := 0; i < 4; i++ {
+		s.Send("x", true)
+	}
+
+	// one message is taken by the sending blocked, the others stay queued
+	assert.Eventually(t, func() bool { _, n, _ := s.ChanOccupancy(); return n == 3 }, time.Second, time.Millisecond)
+	name, _, capacity := s.ChanOccupancy()
+	assert.Equal(t, "out stdout", name)
+	assert.Equal(t, 10, capacity)
+	assert.Equal(t, Sender(out), s.Unwrap())
+
+	close(out.release)
+	assert.Nil(t, s.Close())
+	assert.Len(t, out.messages(), 4)
+}
+
+func TestQueuedSenderStd(t *testing.T) {
+	out := &blockedSender{release: make(chan struct{})}
+	s := NewQueuedSender(context.Background(), out, "out stdout", 10)
+	c := newTestConn(&Option{SrcRatio: 1, Resp: 1})
+	run := func(src, dst Endpoint, data string) {
+		b := c.base(src, dst)
+		b.sender = s
+		(&Factory{}).run(b, strings.NewReader(data))
+	}
+	run(testClient, testServer, "GET /a HTTP/1.1\r\nHost: a.b\r\n\r\n")
+	run(testServer, testClient, "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok")
+
+	// the request is taken by the output blocked, the response waits in the queue gauged
+	assert.Eventually(t, func() bool { _, n, _ := s.ChanOccupancy(); return n == 1 }, time.Second, time.Millisecond)
+
+	close(out.release)
+	assert.Nil(t, s.Close())
+	msgs := out.messages()
+	if assert.Len(t, msgs, 2) {
+		assert.Contains(t, msgs[0], "\r\nGET /a HTTP/1.1\r\n")
+		assert.Contains(t, msgs[1], "\r\n200 OK\r\n")
+	}
+}
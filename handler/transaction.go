@@ -0,0 +1,103 @@
+package handler
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Transaction is an http request paired with its response.
+type Transaction struct {
+	Seq       int32
+	Src, Dst  string
+	Method    string
+	Host      string
+	URI       string
+	Path      string
+	Proto     string
+	ReqHeader http.Header
+	ReqBody   []byte // only available when Option.RecordBodies is set
+	Status    int
+	RspHeader http.Header
+	RspBody   []byte // only available when Option.RecordBodies is set
+	Start     time.Time
+	End       time.Time
+}
+
+// Duration returns the duration from the request to the response.
+func (t *Transaction) Duration() time.Duration { return t.End.Sub(t.Start) }
+
+// TransactionHandler handles the paired transactions.
+type TransactionHandler interface {
+	HandleTransaction(t *Transaction)
+}
+
+// pendingTransactions holds the requests which are waiting for their responses, keyed by seq.
+type pendingTransactions struct {
+	sync.Mutex
+	m map[int32]*Transaction
+}
+
+// pairsTransactions tells if the requests are paired with their responses,
+// for the handlers, the access log lines of Option.Format or Option.FastPair.
+func (h *Base) pairsTransactions() bool {
+	o := h.option
+	return len(o.TransactionHandlers) > 0 || o.Format != "" && o.Format != FormatRaw || o.FastPair > 0
+}
+
+// startTransaction records the request to be paired with its response later.
+func (h *Base) startTransaction(r Req, seq int32, startTime time.Time, body []byte) {
+	if !h.pairsTransactions() {
+		return
+	}
+
+	t := &Transaction{
+		Seq:       seq,
+		Src:       h.key.Src(),
+		Dst:       h.key.Dst(),
+		Method:    r.GetMethod(),
+		Host:      r.GetHost(),
+		URI:       r.GetRequestURI(),
+		Path:      r.GetPath(),
+		Proto:     r.GetProto(),
+		ReqHeader: r.GetHeader().Clone(),
+		ReqBody:   body,
+		Start:     startTime,
+	}
+
+	h.pending.Lock()
+	defer h.pending.Unlock()
+
+	if h.pending.m == nil {
+		h.pending.m = make(map[int32]*Transaction)
+	}
+	h.pending.m[seq] = t
+}
+
+// finishTransaction pairs the response with its request, passes the transaction to the handlers,
+// and returns it, or nil if the request is not found.
+func (h *Base) finishTransaction(r Rsp, seq int32, endTime time.Time, body []byte) *Transaction {
+	if !h.pairsTransactions() {
+		return nil
+	}
+
+	h.pending.Lock()
+	t, ok := h.pending.m[seq]
+	delete(h.pending.m, seq)
+	h.pending.Unlock()
+
+	if !ok {
+		return nil
+	}
+
+	t.Status = r.GetStatusCode()
+	t.RspHeader = r.GetHeader().Clone()
+	t.RspBody = body
+	t.End = endTime
+	h.record(func() {
+		for _, th := range h.option.TransactionHandlers {
+			th.HandleTransaction(t)
+		}
+	})
+	return t
+}
@@ -0,0 +1,110 @@
+package handler
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/tcpassembly"
+	"github.com/stretchr/testify/assert"
+)
+
+// recordTransactions records the transactions handled.
+type recordTransactions struct {
+	sync.Mutex
+	ts []*Transaction
+}
+
+func (r *recordTransactions) HandleTransaction(t *Transaction) {
+	r.Lock()
+	defer r.Unlock()
+	r.ts = append(r.ts, t)
+}
+
+func (r *recordTransactions) transactions() []*Transaction {
+	r.Lock()
+	defer r.Unlock()
+	return append([]*Transaction(nil), r.ts...)
+}
+
+func TestTransactionStd(t *testing.T) {
+	r := &recordTransactions{}
+	c := newTestConn(&Option{SrcRatio: 1, Resp: 1, TransactionHandlers: []TransactionHandler{r}})
+	c.requests("GET /a HTTP/1.1\r\nHost: a.b\r\n\r\nGET /b HTTP/1.1\r\nHost: a.b\r\n\r\n")
+	c.responses("HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\nHTTP/1.1 404 Not Found\r\nContent-Length: 0\r\n\r\n")
+
+	ts := r.transactions()
+	if assert.Len(t, ts, 2) {
+		assert.Equal(t, "127.0.0.1:5000", ts[0].Src)
+		assert.Equal(t, "127.0.0.2:8080", ts[0].Dst)
+		assert.Equal(t, "/a", ts[0].URI)
+		assert.Equal(t, 200, ts[0].Status)
+		assert.Equal(t, "/b", ts[1].URI)
+		assert.Equal(t, 404, ts[1].Status)
+	}
+}
+
+func TestTransactionStdAssembler(t *testing.T) {
+	r := &recordTransactions{}
+	option := &Option{SrcRatio: 1, Resp: 1, TransactionHandlers: []TransactionHandler{r}}
+	f := NewFactory(context.Background(), option, &collectSender{})
+	a := &TcpStdAssembler{Assembler: tcpassembly.NewAssembler(tcpassembly.NewStreamPool(f)), Factory: f}
+	flow := gopacket.NewFlow(layers.EndpointIPv4, net.IPv4(127, 0, 0, 1).To4(), net.IPv4(127, 0, 0, 2).To4())
+
+	req, rsp := []byte("GET /a HTTP/1.1\r\nHost: a.b\r\n\r\n"), []byte("HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n")
+	start := time.Now()
+	a.Assemble(flow, &layers.TCP{SrcPort: 5000, DstPort: 8080, SYN: true, Seq: 0}, start)
+	a.Assemble(flow.Reverse(), &layers.TCP{SrcPort: 8080, DstPort: 5000, SYN: true, ACK: true, Seq: 0, Ack: 1}, start)
+	a.Assemble(flow, &layers.TCP{SrcPort: 5000, DstPort: 8080, Seq: 1, BaseLayer: layers.BaseLayer{Payload: req}}, start)
+	a.Assemble(flow.Reverse(), &layers.TCP{SrcPort: 8080, DstPort: 5000, Seq: 1, BaseLayer: layers.BaseLayer{Payload: rsp}},
+		start.Add(time.Millisecond))
+	a.FinishAll()
+
+	assert.Eventually(t, func() bool { return a.PendingConnections() == 0 }, time.Second, time.Millisecond)
+	ts := r.transactions()
+	if assert.Len(t, ts, 1) {
+		assert.Equal(t, "/a", ts[0].URI)
+		assert.Equal(t, 200, ts[0].Status)
+	}
+	_, ok := f.states.Load("127.0.0.1:5000-127.0.0.2:8080")
+	assert.False(t, ok) // released by both streams
+}
+
+func TestTransactionBodiesCapped(t *testing.T) {
+	r := &recordTransactions{}
+	c := newTestConn(&Option{SrcRatio: 1, Resp: 1, RecordBodies: true, MaxBodyBytes: 4, TransactionHandlers: []TransactionHandler{r}})
+	c.requests("POST /a HTTP/1.1\r\nHost: a.b\r\nContent-Length: 10\r\n\r\n0123456789")
+	c.responses("HTTP/1.1 200 OK\r\nContent-Length: 3\r\n\r\nabc")
+
+	ts := r.transactions()
+	if assert.Len(t, ts, 1) {
+		assert.Equal(t, []byte("0123"), ts[0].ReqBody)
+		assert.Equal(t, []byte("abc"), ts[0].RspBody)
+	}
+	assert.Contains(t, c.output(), "\r\n\r\n0123456789") // the whole body is still output
+}
+
+func TestBufferBody(t *testing.T) {
+	req := &HttpReq{Request: &http.Request{Body: io.NopCloser(strings.NewReader("0123456789"))}}
+	r, body := bufferReqBody(req, 4)
+	assert.Equal(t, []byte("0123"), body)
+	rest, _ := io.ReadAll(r.GetBody())
+	assert.Equal(t, "0123456789", string(rest))
+
+	req = &HttpReq{Request: &http.Request{Body: io.NopCloser(strings.NewReader("0123456789"))}}
+	r, body = bufferReqBody(req, 0)
+	assert.Equal(t, []byte("0123456789"), body)
+	rest, _ = io.ReadAll(r.GetBody())
+	assert.Equal(t, "0123456789", string(rest))
+
+	rsp := &HttpRsp{Response: &http.Response{Body: io.NopCloser(strings.NewReader("abcdef"))}}
+	_, body = bufferRspBody(rsp, 5)
+	assert.Equal(t, []byte("abcde"), body)
+}
@@ -0,0 +1,99 @@
+package handler
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// the states of the HTTP CONNECT tunnel of a connection
+const (
+	tunnelNone    int32 = iota
+	tunnelConnect       // the CONNECT request is sent, its response is awaited
+	tunnelOpen          // the CONNECT is established, the cleartext HTTP in the tunnel is parsed as usual
+	tunnelOpaque        // the tunneled data is not cleartext HTTP, like TLS, nothing to parse
+)
+
+// tunnel tracks the HTTP CONNECT tunnel of a connection, shared by the streams of both directions.
+type tunnel struct {
+	state  int32
+	target atomic.Value // the target of the CONNECT request, like example.com:443
+}
+
+func (t *tunnel) State() int32 { return atomic.LoadInt32(&t.state) }
+
+// connect records the CONNECT request to the target.
+func (t *tunnel) connect(target string) {
+	t.target.Store(target)
+	atomic.StoreInt32(&t.state, tunnelConnect)
+}
+
+// established records the response to the pending CONNECT request,
+// and tells if the tunnel is established by the 2xx status code.
+func (t *tunnel) established(code int) bool {
+	if code/100 == 2 {
+		return atomic.CompareAndSwapInt32(&t.state, tunnelConnect, tunnelOpen)
+	}
+	atomic.CompareAndSwapInt32(&t.state, tunnelConnect, tunnelNone)
+	return false
+}
+
+// opaque tells if the data tunneled is not cleartext HTTP, by isHTTP on the data at the start of a message,
+// then nothing more of the connection is parsed.
+func (t *tunnel) opaque(data []byte, isHTTP func([]byte) bool) bool {
+	switch t.State() {
+	case tunnelNone:
+		return false
+	case tunnelOpaque:
+		return true
+	}
+	if len(data) == 0 || isHTTP(data) {
+		return false
+	}
+
+	atomic.StoreInt32(&t.state, tunnelOpaque)
+	return true
+}
+
+func isHTTPResponseData(data []byte) bool { return bytes.HasPrefix(data, []byte("HTTP/")) }
+
+// awaitConnect waits for the request of the next response handled, if the response by its raw headers is 2xx
+// and no CONNECT is pending, as in std mode the response to a CONNECT may be read before its request is handled,
+// then the tunneled data following the headers would be read as its body.
+func (h *Base) awaitConnect(headers []byte) {
+	if h.tunnel.State() == tunnelNone && isSuccessStatus(headers) {
+		h.awaitRequest(h.rspCounter.Get() + 1)
+	}
+}
+
+// isSuccessStatus tells if the status code in the status line of the raw headers is 2xx.
+func isSuccessStatus(headers []byte) bool {
+	line, _, _ := bytes.Cut(headers, []byte("\n"))
+	fields := bytes.Fields(line)
+	return len(fields) > 1 && len(fields[1]) == 3 && fields[1][0] == '2'
+}
+
+// readConnectResponse reads the response to the pending CONNECT request, without a body if 2xx,
+// as the tunneled data follows its headers.
+func readConnectResponse(buf *bufio.Reader) (*http.Response, error) {
+	r, err := http.ReadResponse(buf, &http.Request{Method: http.MethodHead})
+	if err == nil && r.StatusCode/100 != 2 && r.ContentLength > 0 {
+		r.Body = io.NopCloser(io.LimitReader(buf, r.ContentLength))
+	}
+	return r, err
+}
+
+// printTunnel outputs the notice of the CONNECT tunnel established.
+func (h *Base) printTunnel(t time.Time) {
+	if h.lineOutput() {
+		return
+	}
+
+	msg := fmt.Sprintf("\n### TUNNEL#%d %s %s %s, CONNECT %v established, tunneled data follows",
+		h.rspCounter.Get(), TagResponse, h.conn(), h.option.FormatTime(t), h.tunnel.target.Load())
+	h.sender.Send(msg, false)
+}
@@ -0,0 +1,77 @@
+package handler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTunnelStd(t *testing.T) {
+	c := newTestConn(&Option{Resp: 1, SrcRatio: 1})
+	c.requests("CONNECT a.b:80 HTTP/1.1\r\nHost: a.b:80\r\n\r\nGET /inner HTTP/1.1\r\nHost: a.b\r\n\r\n")
+	c.responses("HTTP/1.1 200 Connection established\r\n\r\nHTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok")
+
+	out := c.output()
+	assert.Contains(t, out, "CONNECT a.b:80 HTTP/1.1")
+	assert.Contains(t, out, "GET /inner HTTP/1.1")
+	assert.Contains(t, out, "CONNECT a.b:80 established, tunneled data follows")
+	assert.Contains(t, out, "200 OK\r\nContent-Length: 2\r\n\r\nok")
+	assert.Equal(t, tunnelOpen, c.state.tunnel.State())
+}
+
+func TestTunnelStdResponseFirst(t *testing.T) {
+	c := newTestConn(&Option{Resp: 1, SrcRatio: 1})
+	c.state.streams = 2
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.responses("HTTP/1.1 200 Connection established\r\n\r\nHTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok")
+	}()
+	assert.Eventually(t, func() bool { // the response awaits its request
+		c.state.requests.Lock()
+		defer c.state.requests.Unlock()
+		return c.state.requests.changed != nil
+	}, time.Second, time.Millisecond)
+	c.requests("CONNECT a.b:80 HTTP/1.1\r\nHost: a.b:80\r\n\r\nGET /inner HTTP/1.1\r\nHost: a.b\r\n\r\n")
+	<-done
+
+	out := c.output()
+	assert.Contains(t, out, "CONNECT a.b:80 established, tunneled data follows")
+	assert.Contains(t, out, "200 OK\r\nContent-Length: 2\r\n\r\nok")
+	assert.Equal(t, tunnelOpen, c.state.tunnel.State())
+}
+
+func TestIsSuccessStatus(t *testing.T) {
+	assert.True(t, isSuccessStatus([]byte("HTTP/1.1 200 Connection established\r\n\r\n")))
+	assert.True(t, isSuccessStatus([]byte("HTTP/1.0 204\r\n")))
+	assert.False(t, isSuccessStatus([]byte("HTTP/1.1 407 Proxy Authentication Required\r\n\r\n")))
+	assert.False(t, isSuccessStatus([]byte("HTTP/1.1 2000 OK\r\n")))
+	assert.False(t, isSuccessStatus(nil))
+}
+
+func TestTunnelOpaque(t *testing.T) {
+	c := newTestConn(&Option{SrcRatio: 1})
+	c.requests("CONNECT a.b:443 HTTP/1.1\r\nHost: a.b:443\r\n\r\n\x16\x03\x01\x02\x00\x01\x00\x01\xfc\x03\x03")
+
+	out := c.output()
+	assert.Contains(t, out, "CONNECT a.b:443 HTTP/1.1")
+	assert.NotContains(t, out, "ERR")
+	assert.Equal(t, tunnelOpaque, c.state.tunnel.State())
+}
+
+func TestTunnelEstablished(t *testing.T) {
+	tun := &tunnel{}
+	assert.False(t, tun.established(200))
+
+	tun.connect("a.b:443")
+	assert.False(t, tun.established(407))
+	assert.Equal(t, tunnelNone, tun.State())
+
+	tun.connect("a.b:443")
+	assert.True(t, tun.established(200))
+	assert.False(t, tun.opaque([]byte("GET / HTTP/1.1\r\n"), isHTTPRequestData))
+	assert.True(t, tun.opaque([]byte("\x16\x03\x01\x02\x00\x01\x00\x01"), isHTTPRequestData))
+	assert.True(t, tun.opaque([]byte("GET / HTTP/1.1\r\n"), isHTTPRequestData))
+}
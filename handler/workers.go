@@ -0,0 +1,80 @@
+package handler
+
+import "sync"
+
+// workerPool runs the jobs submitted on a fixed number of long-lived workers reading from a bounded chan.
+// The jobs over the full chan wait in the queue, fed to the chan in order, so that the submitting never
+// blocks nor discards, for the assembler keeps feeding the packets of the connections being handled.
+type workerPool struct {
+	jobs chan func()
+	wg   sync.WaitGroup
+
+	lock    sync.Mutex
+	queue   []func() // the jobs over the full chan
+	feeding sync.WaitGroup
+}
+
+func newWorkerPool(workers int) *workerPool {
+	p := &workerPool{jobs: make(chan func(), workers)}
+	p.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+func (p *workerPool) work() {
+	defer p.wg.Done()
+
+	for job := range p.jobs {
+		job()
+	}
+}
+
+// submit queues the job to the workers.
+func (p *workerPool) submit(job func()) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if len(p.queue) == 0 {
+		select {
+		case p.jobs <- job:
+			return
+		default:
+		}
+	}
+	p.queue = append(p.queue, job)
+	if len(p.queue) == 1 {
+		p.feeding.Add(1)
+		go p.feed()
+	}
+}
+
+// feed feeds the jobs queued to the chan in order, until the queue is empty.
+func (p *workerPool) feed() {
+	defer p.feeding.Done()
+
+	for {
+		p.lock.Lock()
+		job := p.queue[0]
+		p.lock.Unlock()
+
+		p.jobs <- job
+
+		p.lock.Lock()
+		p.queue[0] = nil
+		p.queue = p.queue[1:]
+		empty := len(p.queue) == 0
+		p.lock.Unlock()
+		if empty {
+			return
+		}
+	}
+}
+
+// close closes the chan after the jobs queued fed, and waits for the workers to finish all the jobs.
+func (p *workerPool) close() {
+	p.feeding.Wait()
+	close(p.jobs)
+	p.wg.Wait()
+}
@@ -0,0 +1,76 @@
+package handler
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnectionHandlerFastWorkers(t *testing.T) {
+	s := &collectSender{}
+	h := &ConnectionHandlerFast{Context: context.Background(), Option: &Option{Resp: 1, SrcRatio: 1}, Sender: s, Workers: 2}
+	a := NewTCPAssembler(h, 1, 1) // the chan of 1 blocks the assembler on any stream without a reader
+	flow := gopacket.NewFlow(layers.EndpointIPv4, net.IPv4(127, 0, 0, 1).To4(), net.IPv4(127, 0, 0, 2).To4())
+
+	// the keep-alive connections more than the workers, all open concurrently
+	req, rsp := []byte("GET / HTTP/1.1\r\nHost: a.b\r\n\r\n"), []byte("HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n")
+	for i := uint32(0); i < 5; i++ {
+		reqSeq, rspSeq := 1+i*uint32(len(req)), 1+i*uint32(len(rsp))
+		for port := layers.TCPPort(5000); port < 5004; port++ {
+			a.Assemble(flow, &layers.TCP{SrcPort: port, DstPort: 8080, Seq: reqSeq, ACK: true, Ack: rspSeq,
+				BaseLayer: layers.BaseLayer{Payload: req}}, time.Now())
+			a.Assemble(flow.Reverse(), &layers.TCP{SrcPort: 8080, DstPort: port, Seq: rspSeq, ACK: true,
+				Ack: reqSeq + uint32(len(req)), BaseLayer: layers.BaseLayer{Payload: rsp}}, time.Now())
+		}
+	}
+	for port := layers.TCPPort(5000); port < 5004; port++ { // confirms the last responses
+		a.Assemble(flow, &layers.TCP{SrcPort: port, DstPort: 8080, Seq: 1 + 5*uint32(len(req)), ACK: true,
+			Ack: 1 + 5*uint32(len(rsp))}, time.Now())
+	}
+	a.FinishAll()
+
+	out := strings.Join(s.messages(), "")
+	// all the connections are printed, the ones over the workers after waiting in queue
+	assert.Equal(t, 20, strings.Count(out, " REQ 127.0.0.1:"))
+	assert.Equal(t, 20, strings.Count(out, " RSP 127.0.0.1:"))
+	for port := 5000; port < 5004; port++ {
+		assert.Equal(t, 10, strings.Count(out, " 127.0.0.1:"+strconv.Itoa(port)+"-127.0.0.2:8080 "), port)
+	}
+	assert.Equal(t, 0, h.pending())
+}
+
+func TestConnectionHandlerFastStreamPools(t *testing.T) {
+	for _, resp := range []int{0, 1} {
+		s := &collectSender{}
+		h := &ConnectionHandlerFast{Context: context.Background(), Option: &Option{Resp: resp, SrcRatio: 1}, Sender: s, Workers: 1}
+		a := NewTCPAssembler(h, 1, 1)
+		flow := gopacket.NewFlow(layers.EndpointIPv4, net.IPv4(127, 0, 0, 1).To4(), net.IPv4(127, 0, 0, 2).To4())
+
+		req, rsp := []byte("GET / HTTP/1.1\r\nHost: a.b\r\n\r\n"), []byte("HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n")
+		for port := layers.TCPPort(5000); port < 5003; port++ {
+			a.Assemble(flow, &layers.TCP{SrcPort: port, DstPort: 8080, Seq: 1, ACK: true, Ack: 1,
+				BaseLayer: layers.BaseLayer{Payload: req}}, time.Now())
+			a.Assemble(flow.Reverse(), &layers.TCP{SrcPort: 8080, DstPort: port, Seq: 1, ACK: true,
+				Ack: 1 + uint32(len(req)), BaseLayer: layers.BaseLayer{Payload: rsp}}, time.Now())
+			a.Assemble(flow, &layers.TCP{SrcPort: port, DstPort: 8080, Seq: 1 + uint32(len(req)), ACK: true,
+				Ack: 1 + uint32(len(rsp))}, time.Now())
+		}
+		a.FinishAll()
+
+		// the streams are read by a worker each for the requests and the responses
+		assert.Equal(t, 1, cap(h.requests.jobs), resp)
+		assert.Equal(t, 1, cap(h.responses.jobs), resp)
+		out := strings.Join(s.messages(), "")
+		assert.Equal(t, 3, strings.Count(out, " REQ 127.0.0.1:"), resp)
+		assert.Equal(t, 3*resp, strings.Count(out, " RSP 127.0.0.1:"), resp)
+		assert.Equal(t, 0, h.pending(), resp)
+	}
+}
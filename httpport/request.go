@@ -262,6 +262,7 @@ func (r *Request) GetPath() string         { return r.URL.Path }
 func (r *Request) GetMethod() string       { return r.Method }
 func (r *Request) GetProto() string        { return r.Proto }
 func (r *Request) GetHeader() http.Header  { return http.Header(r.Header) }
+func (r *Request) GetRawHeaders() []string { return r.RawHeaders }
 func (r *Request) GetContentLength() int64 { return r.ContentLength }
 
 // ProtoAtLeast reports whether the HTTP protocol used
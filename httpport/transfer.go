@@ -519,8 +519,9 @@ func fixLength(isResponse bool, status int, requestMethod string, header Header,
 		return 0, errors.New("http: message cannot contain multiple Content-Length headers")
 	}
 
-	// Logic based on Transfer-Encoding
+	// Logic based on Transfer-Encoding, which is preferred over Content-Length (RFC 7230 section 3.3.3)
 	if chunked(te) {
+		header.Del("Content-Length")
 		return -1, nil
 	}
 
@@ -4,8 +4,10 @@ import (
 	"context"
 	"embed"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"os"
 	"path"
 	"strconv"
 	"strings"
@@ -19,23 +21,22 @@ import (
 	"github.com/bingoohuang/gg/pkg/rest"
 	"github.com/bingoohuang/gg/pkg/rotate"
 	"github.com/bingoohuang/gg/pkg/sigx"
-	"github.com/bingoohuang/gg/pkg/v"
+	"github.com/bingoohuang/gg/pkg/ss"
 	"github.com/bingoohuang/godaemon"
 	"github.com/bingoohuang/golog"
 	"github.com/bingoohuang/httpdump/handler"
 	"github.com/bingoohuang/httpdump/replay"
 	"github.com/bingoohuang/httpdump/util"
 	"github.com/bingoohuang/jj"
+	"github.com/google/gopacket"
 	"github.com/google/gopacket/tcpassembly"
+	"golang.org/x/term"
 	"golang.org/x/time/rate"
 )
 
-// VersionInfo prints version information.
-func (App) VersionInfo() string { return v.Version() }
-
 func main() {
 	app := &App{}
-	flagparse.Parse(app, flagparse.AutoLoadYaml("c", ""),
+	flagparse.ParseArgs(app, applyProfile(os.Args), flagparse.AutoLoadYaml("c", ""),
 		flagparse.ProcessInit(&initAssets))
 
 	if app.Daemonize {
@@ -61,6 +62,199 @@ func main() {
 		N:        app.N,
 		Num:      app.N,
 		SrcRatio: app.SrcRatio,
+
+		AssumeScheme: app.AssumeScheme,
+		SortHeaders:  app.SortHeaders,
+
+		BodyMethods:    app.BodyMethods,
+		AlwaysReadBody: app.AlwaysReadBody,
+
+		HexdumpOnError: app.HexdumpOnError,
+
+		CorrelateHeader: app.CorrelateHeader,
+
+		ExcludeStatus: app.ExcludeStatus,
+
+		TimeFormat:    app.TimeFormat,
+		UTC:           app.UTC,
+		TimestampBase: app.TimestampBase,
+
+		ConnFormat: app.ConnFormat,
+
+		Hex: app.Hex,
+
+		TextTypes:   app.TextTypes,
+		BinaryTypes: app.BinaryTypes,
+
+		Informational: app.Informational,
+
+		TLSSNI: app.TLSSNI,
+
+		DumpMultipart:         app.DumpMultipart,
+		DumpMultipartMaxFiles: app.DumpMultipartMaxFiles,
+		DumpMultipartMaxBytes: app.DumpMultipartMaxBytes,
+
+		MinRequestsPerConnection: app.MinRequestsPerConnection,
+
+		Format:   app.Format,
+		FastPair: app.FastPair,
+
+		Offsets: app.Offsets,
+
+		MaxHeaderBytes: app.MaxHeaderBytes,
+		MaxBodyBytes:   app.MaxBodyBytes,
+
+		BodyPreview: app.BodyPreview,
+		DecodeForm:  app.DecodeForm,
+
+		HTTPOnly: app.HTTPOnly,
+
+		Direction: app.Direction,
+
+		DebugConn: app.DebugConn,
+
+		BodyHash: app.BodyHash,
+
+		DetectSmuggling: app.DetectSmuggling,
+
+		ExplainFilter: app.ExplainFilter,
+
+		HandshakeRTT: app.HandshakeRTT,
+	}
+
+	normalizer, err := handler.NewPathNormalizer(app.NormalizePath)
+	if err != nil {
+		log.Fatalf("create path normalizer failed: %v", err)
+	}
+	app.normalizer = normalizer
+
+	if app.Resolve != "" {
+		names := ss.Split(app.Resolve, ss.WithSeps(","), ss.WithIgnoreEmpty(true), ss.WithTrimSpace(true))
+		if app.handlerOption.Peers, err = handler.NewPeerResolver(context.Background(), names); err != nil {
+			log.Fatalf("resolve %s failed: %v", app.Resolve, err)
+		}
+	}
+	if app.Direction != "" {
+		if app.handlerOption.LocalIPs, err = util.LocalIPs(); err != nil {
+			log.Fatalf("list local ips failed: %v", err)
+		}
+	}
+
+	if app.Summary || app.SummaryJSON != "" {
+		app.handlerOption.Stats = handler.NewStats(normalizer)
+		app.handlerOption.Stats.GroupByHeader = app.GroupByHeader
+		if app.handlerOption.Stats.SizeBuckets, err = handler.ParseSizeBuckets(app.SizeBuckets); err != nil {
+			log.Fatalf("parse size buckets failed: %v", err)
+		}
+		app.handlerOption.TransactionHandlers = append(app.handlerOption.TransactionHandlers, app.handlerOption.Stats)
+	}
+
+	if app.HostFile != "" {
+		if app.handlerOption.HostPatterns, err = handler.LoadPatternFile(app.HostFile); err != nil {
+			log.Fatalf("load host file failed: %v", err)
+		}
+	}
+	if app.URIFile != "" {
+		if app.handlerOption.UriPatterns, err = handler.LoadPatternFile(app.URIFile); err != nil {
+			log.Fatalf("load uri file failed: %v", err)
+		}
+	}
+
+	if app.handlerOption.ReqBodyMatcher, err = handler.NewBodyMatcher(ss.Or(app.ReqBodyContains, app.BodyContains), app.Regex); err != nil {
+		log.Fatalf("create request body matcher failed: %v", err)
+	}
+	if app.handlerOption.RspBodyMatcher, err = handler.NewBodyMatcher(ss.Or(app.RspBodyContains, app.BodyContains), app.Regex); err != nil {
+		log.Fatalf("create response body matcher failed: %v", err)
+	}
+	if app.handlerOption.Nth, err = handler.ParseNthRange(app.Nth); err != nil {
+		log.Fatalf("parse nth failed: %v", err)
+	}
+	if app.handlerOption.RspHeaderMatcher, err = handler.NewHeaderMatcher(app.RspHeader, app.Regex); err != nil {
+		log.Fatalf("create response header matcher failed: %v", err)
+	}
+	if app.handlerOption.UAMatcher, err = handler.NewUAMatcher(app.UA, app.Regex); err != nil {
+		log.Fatalf("create user agent matcher failed: %v", err)
+	}
+	if app.handlerOption.ExcludeUAMatcher, err = handler.NewUAMatcher(app.ExcludeUA, app.Regex); err != nil {
+		log.Fatalf("create excluded user agent matcher failed: %v", err)
+	}
+
+	if app.JSONFields != "" {
+		fields := ss.Split(app.JSONFields, ss.WithSeps(","), ss.WithIgnoreEmpty(true), ss.WithTrimSpace(true))
+		if app.handlerOption.JSONFields, err = handler.ParseJSONFields(fields); err != nil {
+			log.Fatalf("parse json fields failed: %v", err)
+		}
+	}
+
+	if app.Dedup > 0 {
+		app.handlerOption.Dedup = handler.NewDeduper(app.Dedup)
+	}
+
+	if app.Session {
+		app.handlerOption.Sessions = handler.NewSessionTracker(app.SessionHeader)
+	}
+
+	if app.Proto != "" {
+		decoder, err := handler.NewProtoDecoder(app.Proto)
+		if err != nil {
+			log.Fatalf("load proto descriptor set failed: %v", err)
+		}
+		app.handlerOption.Proto = decoder
+	}
+
+	if app.RecordPairs != "" {
+		recorder, err := handler.NewPairRecorder(app.RecordPairs)
+		if err != nil {
+			log.Fatalf("create pair recorder failed: %v", err)
+		}
+		app.closers = append(app.closers, recorder)
+		app.handlerOption.RecordBodies = true
+		app.handlerOption.TransactionHandlers = append(app.handlerOption.TransactionHandlers, recorder)
+	}
+
+	for _, out := range app.Output {
+		sqliteOut, ok := handler.ParseSQLiteOutput(out)
+		if !ok {
+			continue
+		}
+		recorder, err := handler.NewSQLiteRecorder(sqliteOut)
+		if err != nil {
+			log.Fatalf("create sqlite output %s failed: %v", sqliteOut.File, err)
+		}
+		app.closers = append(app.closers, recorder)
+		app.handlerOption.RecordBodies = app.handlerOption.RecordBodies || sqliteOut.Bodies
+		app.handlerOption.Resp = max(app.handlerOption.Resp, 1) // responses are needed to pair transactions
+		app.handlerOption.TransactionHandlers = append(app.handlerOption.TransactionHandlers, recorder)
+	}
+
+	if app.PcapOut != "" {
+		w, err := handler.NewPcapWriter(app.PcapOut, app.Idle)
+		if err != nil {
+			log.Fatalf("create pcap out failed: %v", err)
+		}
+		app.closers = append(app.closers, w)
+		app.handlerOption.PcapOut = w
+	}
+
+	if app.Otlp != "" {
+		exporter := handler.NewOTLPExporter(app.Otlp)
+		app.closers = append(app.closers, exporter)
+		app.handlerOption.TransactionHandlers = append(app.handlerOption.TransactionHandlers, exporter)
+	}
+
+	if app.Tui {
+		if term.IsTerminal(int(os.Stdout.Fd())) {
+			app.dashboard = handler.NewDashboard(normalizer)
+			app.dashboard.GroupByHeader = app.GroupByHeader
+			app.handlerOption.Resp = max(app.handlerOption.Resp, 1) // responses are needed to pair transactions
+			app.handlerOption.TransactionHandlers = append(app.handlerOption.TransactionHandlers, app.dashboard)
+		} else {
+			log.Printf("W! stdout is not a terminal, -tui falls back to dumping")
+		}
+	}
+
+	if app.Format != "" && app.Format != handler.FormatRaw || app.FastPair > 0 {
+		app.handlerOption.Resp = max(app.handlerOption.Resp, 1) // responses are needed to pair transactions
 	}
 
 	if app.Rate > 0 {
@@ -75,10 +269,11 @@ var initAssets embed.FS
 // App Command line options.
 type App struct {
 	Config    string `flag:"c" usage:"yaml config filepath"`
+	Profile   string `usage:"Named profile of flags in the profiles block of the config file, like api-errors"`
 	Init      bool   `usage:"init example httpdump.yml/ctl and then exit"`
 	Daemonize bool   `usage:"daemonize and then exit"`
-	Level     string `val:"all" usage:"Output level, url: only url, header: http headers, all: headers and text http body"`
-	Input     string `flag:"i" val:"any" usage:"Interface name or pcap file. If not set, If is any, capture all interface traffics"`
+	Level     string `val:"all" usage:"Output level, url: only url, header: http headers, body: only text http body, all: headers and text http body"`
+	Input     string `flag:"i" val:"any" usage:"Interface name or pcap(ng) file, or tcp://host:port to read the pcap stream of a remote tap like tcpdump -w - | nc -lk 9000. If not set, If is any, capture all interface traffics"`
 
 	IP   string `usage:"Filter by ip, or ip range like 1.1.1.1-1.1.1.3, or multiple ip like 1.1.1.1,1.1.1.3, if either src or dst ip is matched, the packet will be processed"`
 	Port string `usage:"Filter by port, or port range like 8001-8003, or multiple ports like 8001,8003, if either source or target port is matched, the packet will be processed"`
@@ -88,12 +283,37 @@ type App struct {
 	Chan    uint `val:"10240" usage:"Channel size to buffer tcp packets"`
 	OutChan uint `val:"40960" usage:"Output channel size to buffer tcp packets"`
 
+	OutputRate string `usage:"Max output rate of the captured messages to the non-replay outputs, like 1MB/s or 512KiB/s, queued in -out-chan when exceeded"`
+
+	OutputDelay time.Duration `usage:"Sleep between the messages to the non-replay outputs, like 5ms, to simulate a slow output for debugging the downstream consumers, the packets may be dropped upstream when -out-chan fills up if it is too high"`
+
+	Flush            bool          `usage:"Flush the file, split and fifo outputs after each message, line-buffered for piping to other tools in real time"`
+	FlushIntervalOut time.Duration `val:"10s" usage:"Max time the messages written to the file, split and fifo outputs are buffered before flushed, without -flush"`
+
 	Host    string `usage:"Filter by request host, using wildcard match(*, ?)"`
 	URI     string `usage:"Filter by request url path, using wildcard match(*, ?)"`
 	Method  string `usage:"Filter by request method, multiple by comma"`
 	Verbose string `usage:"Verbose flag, available req/rsp/all for http replay dump"`
 
-	Status util.IntSetFlag `usage:"Filter by response status code. Can use range. eg: 200, 200-300 or 200:300-400"`
+	HostFile string `usage:"File of request host patterns, one per line, wildcard or ~regexp, prefix ! to exclude, like hosts.txt"`
+	URIFile  string `usage:"File of request url path patterns, one per line, wildcard or ~regexp, prefix ! to exclude, like paths.txt"`
+
+	BodyContains    string `usage:"Filter requests and responses by the body containing the substring, like order_id=42, buffering at most 1MiB of each body in memory to match"`
+	ReqBodyContains string `usage:"Filter requests by the body containing the substring, instead of -body-contains, the responses of the requests filtered out are skipped too"`
+	RspBodyContains string `usage:"Filter responses by the body containing the substring, instead of -body-contains"`
+	BodyPreview     int    `usage:"Print the first N bytes of the decoded bodies along with the // body size: line in -level header, like 128, to tell JSON from form or binary"`
+	DecodeForm      bool   `usage:"Print the fields of the application/x-www-form-urlencoded bodies URL-decoded, each on its own line like a: 1"`
+	Regex           bool   `usage:"The patterns of -body-contains, -req-body-contains and -rsp-body-contains, and the values of -rsp-header, -ua and -exclude-ua are regexps"`
+
+	BodyHash string `usage:"Hash the request/response bodies as on the wire by md5 or sha256, printed like sha256:<hex> in the ### lines and as BodyHash in the JSON outputs, to verify the replayed bodies byte-for-byte"`
+
+	UA        string `flag:"ua" usage:"Filter requests by the User-Agent header, using wildcard match(*, ?), like *Chrome*"`
+	ExcludeUA string `flag:"exclude-ua" usage:"Exclude requests by the User-Agent header after -ua, using wildcard match(*, ?), like *bot*"`
+
+	RspHeader []string `usage:"Filter responses by the headers, all to match, like Content-Type: application/json* with wildcard, Cache-Control for the presence, !Cache-Control for the absence"`
+
+	Status        util.IntSetFlag `usage:"Filter by response status code. Can use range. eg: 200, 200-300 or 200:300-400"`
+	ExcludeStatus util.IntSetFlag `usage:"Exclude response status code after -status. Can use range. eg: 200-299 or 301,304"`
 
 	Web        bool   `usage:"Start web server for HTTP requests and responses event"`
 	WebPort    int    `usage:"Web server port if web is enable"`
@@ -101,17 +321,159 @@ type App struct {
 	Resp       int    `flag:"r" count:"true" usage:"-r: print response, -rr: print response after relative request "`
 	Force      bool   `usage:"Force print unknown content-type http body even if it seems not to be text content"`
 	Curl       bool   `usage:"Output an equivalent curl command for each http request"`
-	Version    bool   `flag:"v" usage:"Print version info and exit"`
+	Version    bool   `flag:"version,v" usage:"Print version info with git commit, build time, gopacket and libpcap versions, and exit"`
 	Eof        bool   `usage:"Output EOF connection info or not."`
-	Debug      bool   `usage:"Enable debugging."`
+	Debug      bool   `usage:"Enable debugging, logging channel occupancy periodically."`
 
 	DumpBody string   `usage:"Prefix file of dump http request/response body, empty for no dump, like solr, solr:10 (max 10)"`
 	Mode     string   `val:"fast" usage:"std/fast"`
-	Output   []string `usage:"\n        File output, like dump-yyyy-MM-dd-HH-mm.http, suffix like :32m for max size, suffix :append for append mode\n        Or Relay http address, eg http://127.0.0.1:5002\n        Or any of stdout/stderr/stdout:log"`
+	Output   []string `usage:"\n        File output, like dump-yyyy-MM-dd-HH-mm.http, suffix like :32m for max size, suffix :append for append mode\n        Or Relay http address, eg http://127.0.0.1:5002\n        Or named pipe created by mkfifo, reopened when the reader reconnects\n        Or SQLite database like sqlite:captures.db to write the transactions into the table transactions, suffix like :100m for max size, suffix :append to append to the existing file, suffix :bodies to write the bodies too\n        Or any of stdout/stderr/stdout:log"`
+
+	SplitBy       string        `usage:"Split the file outputs by time, rolling the files on the wall-clock boundaries of -split-interval, like capture-2024010114.log for capture.log, always appended, the size suffix of -output is rejected"`
+	SplitInterval time.Duration `val:"1h" usage:"Interval of the files of -split-by time, like 1h or 24h"`
 
 	Idle time.Duration `val:"4m" usage:"Idle time to remove connection if no package received"`
 
-	dumpMax uint32
+	DebugConn bool `usage:"Log the lifecycle of the connections, created, flushed on idle and finished, with their counts, to debug the missing transactions"`
+
+	MaxHeaderBytes int `val:"1048576" usage:"Max bytes of the headers of a request/response, the connection with larger headers is abandoned with a warning, 0 for unlimited"`
+
+	MaxBodyBytes int `val:"1048576" usage:"Max bytes of a request/response body buffered for -record-pairs, the sqlite bodies, -dedup and -body-hash, the bytes after it are not recorded nor hashed, 0 for unlimited"`
+
+	DrainTimeout time.Duration `val:"10s" usage:"Max time to wait for the connections to finish on shutdown, then exits reporting the abandoned ones, 0 to wait forever"`
+
+	DumpMultipart string `usage:"Directory to save the files uploaded by multipart/form-data requests with their original file names, instead of dumping the raw bodies"`
+
+	DumpMultipartMaxFiles int `val:"1000" usage:"Max number of the files saved by -dump-multipart, the files after it are not saved, 0 for unlimited"`
+	DumpMultipartMaxBytes int `val:"1073741824" usage:"Max total bytes of the files saved by -dump-multipart, the file over it is truncated and the ones after it are not saved, 0 for unlimited"`
+
+	MinRequestsPerConnection int `usage:"Output only the connections carrying at least N requests in std mode, dropping the single-shot ones, to study keep-alive reuse"`
+
+	Nth string `usage:"Output only the Nth request/response of each connection, like 1 for the first, 2: for the ones after the first, or 2:5"`
+
+	Workers int `usage:"Max request streams and response streams each handled in parallel in fast mode, the others wait in queue, 0 for unbounded"`
+
+	ProxyListen string `usage:"Run as a passthrough proxy instead of pcap capture, listen address like unix:/tmp/in.sock or :8080"`
+	ProxyTarget string `usage:"Target address the proxy forwards to, like unix:/tmp/real.sock or 127.0.0.1:8080"`
+
+	NormalizePath []string `usage:"Path segment rule to group paths in -summary, -tui and -diff, like ^v\\d+$={ver}, before the built-in rules collapsing numbers/uuids/hashes, off to disable"`
+
+	GroupByHeader string `usage:"Request header to partition the latency and the slowest paths of -summary and the top paths of -tui by its value, like X-Tenant-Id or Accept-Language"`
+
+	SummaryJSON string `flag:"summary-json" usage:"File to write the summary statistics in JSON on exit, like summary.json, with or without -summary"`
+
+	SizeBuckets string `val:"1KiB,10KiB,100KiB,1MiB,10MiB" usage:"Upper bounds of the request/response body size histograms of -summary"`
+
+	Summary      bool   `usage:"Print summary statistics of the captured traffic on exit, with the handshake RTT, the latency by status and the slowest paths by p95 latency when -r"`
+	AssumeScheme string `usage:"Scheme assumed to reconstruct absolute urls, default https for port 443/8443, else http"`
+	SortHeaders  bool   `usage:"Print request headers in case-insensitive sorted order for stable diffs"`
+
+	BodyMethods    string `usage:"Request methods treated as body-bearing, multiple by comma, default all except CONNECT,GET,HEAD,TRACE,OPTIONS"`
+	AlwaysReadBody bool   `usage:"Read request body for all methods, relying on Content-Length/chunked only"`
+
+	Session       bool   `usage:"Track sessions by cookies, and tag each request/response with a session id"`
+	SessionHeader string `usage:"Header whose value is used as the session id if present, like X-Session-Id, works with -session"`
+
+	CorrelateHeader string `usage:"Header carried by both requests and responses, like X-Request-Id, whose value tags the output title lines to join them"`
+
+	Diff        string `usage:"Compare two pcap files by method and path, like before.pcap,after.pcap, and then exit"`
+	RecordPairs string `usage:"File to record requests with their original responses in JSON lines, works with -r, replay and compare it by -f file -pairs"`
+	Pairs       bool   `usage:"The file of -f is in the pairs format recorded by -record-pairs, replay and compare with the original responses"`
+
+	StripAcceptEncoding bool   `usage:"Remove Accept-Encoding from the replayed requests, so that the responses come back uncompressed for diffs"`
+	ReplayCsv           string `usage:"CSV file to report the replayed requests with status, cost and dns/connect/tls/ttfb timings"`
+	UseCookieJar        bool   `usage:"Keep the cookies set by the replay target, and send them on the subsequent replayed requests, overriding the captured ones"`
+	ReplayAfter         string `usage:"Replay only the requests recorded at or after the time, like 2024-05-06 07:08:00 or 2024-05-06T07:08:00+08:00"`
+	ReplayBefore        string `usage:"Replay only the requests recorded before the time, like 2024-05-06 07:09:00 or 2024-05-06T07:09:00+08:00"`
+	PerHostConcurrency  int    `usage:"Replay requests concurrently, at most N in flight per target host shared by the replay outputs, 0 to replay one by one"`
+	ReplayOrdered       bool   `usage:"Replay the requests of the same captured connection one after another in order with -per-host-concurrency, concurrently only across the connections, for the session-dependent flows"`
+	FailFast            bool   `usage:"Stop replaying on the first error or response mismatch of -pairs, and exit non-zero, for regression tests in CI"`
+	Loop                int    `usage:"Replay the requests of -f file N times repeatedly for load testing, -1 to loop until stopped, 0 to replay once"`
+	BodyDir             string `usage:"Dir of the body files dumped by -dump-body, to stream the replayed request bodies from the files referenced by the // dump body to file markers of -f file, like ."`
+
+	Ramp          string  `usage:"Ramp the replay rate up to find the capacity of the target, like 10:1000:60s from 10 to 1000 rps over 60s, stopping when the error rate exceeds -ramp-error-rate, with -per-host-concurrency for high rates"`
+	RampErrorRate float64 `val:"0.05" usage:"Max error rate per second of -ramp, the failures and 5xx responses, like 0.05 for 5%"`
+
+	Healthcheck       string `usage:"Path to GET on the replay target before starting, like /healthz, exiting with the error if it doesn't respond -healthcheck-status within the replay timeout of 15s"`
+	HealthcheckStatus string `val:"2xx" usage:"Expected status of -healthcheck, like 2xx or 200,204"`
+
+	MinTLS string `flag:"min-tls" usage:"Min TLS version of the replay client to negotiate with the https targets, 1.0, 1.1, 1.2 or 1.3, default by Go"`
+
+	FollowRedirects int `usage:"Follow at most N redirects of the replayed requests, logging the chain of each hop's URL and status, 0 to replay the redirect responses as captured"`
+
+	ChaosDelay     float64       `usage:"Percentage of the replayed requests to delay by -chaos-delay-time for resilience testing, like 10 for 10%"`
+	ChaosDelayTime time.Duration `val:"1s" usage:"Delay of the requests delayed by -chaos-delay"`
+	ChaosDrop      float64       `usage:"Percentage of the replayed requests to drop without sending for resilience testing, like 10 for 10%"`
+	ChaosCorrupt   float64       `usage:"Percentage of the replayed requests with bodies to send with the bodies truncated to the half for resilience testing, like 10 for 10%"`
+
+	ReplaceBody []string `usage:"Regexp substitution on the replayed request bodies like s/prod-bucket/staging-bucket/g, applied in order if multiple, the binary bodies are untouched unless -force"`
+
+	ReplayOriginal bool   `usage:"Replay the requests to their original hosts, from the Host headers or the connection destinations, instead of the http address of -o"`
+	ReplayScheme   string `usage:"Scheme of -replay-original, http or https, default https for port 443/8443, else http"`
+
+	ReplayHostHeader string `usage:"Host header of the replayed requests independently of the target address of -o, for the reverse proxies routing by Host, like api.example.com, or preserve to keep the captured ones, default the host of the target"`
+
+	ExpectContinueTimeout time.Duration `usage:"Time to wait for 100 Continue before sending the bodies of the replayed requests with Expect: 100-continue, like 3s, default 1s"`
+
+	Proto string `usage:"Protobuf descriptor set file (protoc --include_imports --descriptor_set_out) to decode grpc/grpc-web/protobuf bodies to JSON"`
+	Otlp  string `usage:"OTLP/HTTP endpoint to export request/response pairs as OpenTelemetry spans, e.g. http://127.0.0.1:4318"`
+
+	Dedup time.Duration `usage:"Suppress repeated requests of the same method, url and body within the window, like 1s, printing a repeated N times marker instead"`
+
+	HexdumpOnError int `usage:"Max bytes of the raw tcp payload to hex dump when parsing http fails, 0 to disable, like 512"`
+
+	PcapOut string `usage:"Pcap file to write the packets of the connections which passed the filters, like filtered.pcap"`
+
+	TimeFormat string `usage:"Timestamp format in the output, a Go layout like 2006-01-02 15:04:05.000, or rfc3339/rfc3339nano/unix/unixnano/epoch-ms, default rfc3339nano"`
+	UTC        bool   `usage:"Output timestamps in UTC instead of local time"`
+
+	ConnFormat string `val:"ip:port" usage:"Format of the connections in the output titles, ip:port like 127.0.0.1:5000-127.0.0.1:8080 which the replay parses, ip:port->ip:port, or hash for a compact hash of the connection the same for both directions"`
+
+	TimestampBase string `usage:"Base of the output timestamps, original: the packet capture time, now: the parse time, first: the capture time re-based that the first packet is at now, default original"`
+
+	Source bool `usage:"Tag each request/response with its capture source, the interface name of -i any with -host or else the input of -i, like the pcap file, as source: in the ### lines and Source in the JSON outputs, to tell the merged outputs apart"`
+
+	HTTPOnly bool `flag:"http-only" usage:"Skip the connections not starting like HTTP/1 request or status lines quietly, like TLS, SSH or binary protocols on mixed ports, instead of the parse errors"`
+
+	ExplainFilter bool `usage:"Print the filters passed by each request/response after its ### line, like // matched: host=*.api, status=500, to debug the filter combinations"`
+
+	HandshakeRTT bool `flag:"handshake-rtt" usage:"Print the RTT of each connection estimated by its TCP SYN/SYN-ACK/ACK handshake, when observed, as ### RTT <conn> <time> handshake: <rtt>, server: <SYN to SYN-ACK>, client: <SYN-ACK to ACK>"`
+
+	DetectSmuggling bool `usage:"Flag the messages with the request smuggling signatures by ### SMUGGLING-SUSPECT records with the offending headers, like both Content-Length and Transfer-Encoding, the obfuscated Transfer-Encoding, or the unparsable data after a message"`
+
+	Informational bool `usage:"Print the interim 1xx responses like 100 Continue and 103 Early Hints, which are skipped to pair the final responses by default"`
+
+	Format string `usage:"Output the request/response pairs as access log lines in the Apache Common Log Format by clf, or Combined Log Format with referer and user agent by combined, or the raw dechunked requests/responses by raw, each after a line like raw REQ 1 <src>-<dst> <time> <length> for piping"`
+
+	FastPair time.Duration `usage:"Output each request/response pair as one line, paired by the arrival order in the connection, the requests without the responses within the window like 3s are output alone"`
+
+	JSONFields string `usage:"Output JSON objects with only the fields, like method,uri,status,latency, out of seq/src/dest/timestamp/uri/method/host/header/body/bodyhash/session/source/status/statustext/reason/latency"`
+
+	TLSSNI bool `flag:"tls-sni" usage:"Print the SNI of the TLS ClientHello and the connection as ### TLS <sni> <src>-><dst>, and the version and cipher negotiated by the ServerHello, for the https traffic not decrypted"`
+
+	Resolve         string        `usage:"Filter the connections by the peer addresses resolved from the DNS names, like api.example.com,auth.example.com, unlike -host matching the Host header"`
+	ResolveInterval time.Duration `val:"1m" usage:"Interval to re-resolve the DNS names of -resolve"`
+
+	Direction string `usage:"Capture only the inbound connections to the local servers, or the outbound ones of the local clients, inbound/outbound, by the addresses of the local interfaces"`
+
+	Offsets bool `usage:"Print the byte offsets in the connection stream where the headers of each request/response start and end, and its total size, to correlate with the raw pcap"`
+
+	Hex bool `usage:"Print binary http bodies as offset/hex/ASCII dump, instead of skipping them or the raw bytes of -force"`
+
+	TextTypes   []string `usage:"Extra content types printed as text without -force, wildcard supported, like application/vnd.myapp+json"`
+	BinaryTypes []string `usage:"Extra content types treated as binary, wildcard supported, like application/vnd.myapp.*, overriding -text-types"`
+
+	Tui bool `usage:"Render a live dashboard of rps, top paths, status codes and recent requests instead of dumping to stdout, falls back to dumping when stdout is not a terminal"`
+
+	dumpMax      uint32
+	outputRate   int
+	replayAfter  time.Time
+	replayBefore time.Time
+	ramp         *replay.Ramp
+	replaceBody  []*replay.BodyReplacer
+	healthCheck  *replay.HealthCheck
+	chaos        *replay.Chaos
+	minTLS       uint16
 
 	// https://github.com/influxdata/telegraf/blob/master/plugins/inputs/tail/tail.go
 	//  ## File names or a pattern to tail.
@@ -131,31 +493,126 @@ type App struct {
 	ReplayRatio float64 `val:"1" usage:"replay ratio, e.g. 2 to double replay, 0.1 to replay only 10% requests"`
 
 	handlerOption *handler.Option
+	closers       []io.Closer
+	dashboard     *handler.Dashboard
+	normalizer    *handler.PathNormalizer
 
 	ReplayN        int     `flag:"-"`
 	ReplayFraction float64 `flag:"-"`
 }
 
+// writeSummaryJSON writes the summary statistics in JSON to the file of -summary-json.
+func (o *App) writeSummaryJSON() {
+	data, err := o.handlerOption.Stats.SummaryJSON()
+	if err == nil {
+		err = os.WriteFile(o.SummaryJSON, data, 0o644)
+	}
+	if err != nil {
+		log.Printf("E! write summary json %s failed: %v", o.SummaryJSON, err)
+	}
+}
+
+// flushLatency returns the max latency to flush the file outputs, negative to flush immediately by -flush.
+func (o *App) flushLatency() time.Duration {
+	if o.Flush {
+		return -1
+	}
+	return o.FlushIntervalOut
+}
+
+// throttle limits the output rate of the sender by -output-rate, and slows it down by -output-delay.
+func (o *App) throttle(ctx context.Context, sender handler.Sender) handler.Sender {
+	if o.outputRate > 0 {
+		sender = handler.NewThrottledSender(ctx, sender, o.outputRate, o.OutChan)
+	}
+	if o.OutputDelay > 0 {
+		sender = handler.NewDelayedSender(ctx, sender, o.OutputDelay, o.OutChan)
+	}
+	return sender
+}
+
+// replayConfig creates the config to replay the requests to addr.
+func (o *App) replayConfig(addr string) replay.Config {
+	return replay.Config{
+		Method: o.Method, File: o.File, Verbose: o.Verbose, Replay: addr,
+		ReplayN: o.ReplayN, ReplayFraction: o.ReplayFraction, Pairs: o.Pairs,
+
+		StripAcceptEncoding: o.StripAcceptEncoding,
+		CSV:                 o.ReplayCsv,
+		UseCookieJar:        o.UseCookieJar,
+		PerHostConcurrency:  o.PerHostConcurrency,
+		Ordered:             o.ReplayOrdered,
+		ReplayAfter:         o.replayAfter,
+		ReplayBefore:        o.replayBefore,
+		FailFast:            o.FailFast,
+		OnFail:              func(error) { o.handlerOption.CtxCancel() },
+		Loop:                o.Loop,
+		BodyDir:             o.BodyDir,
+		Ramp:                o.ramp,
+		ReplaceBody:         o.replaceBody,
+		ForceReplaceBody:    o.Force,
+		HealthCheck:         o.healthCheck,
+		Chaos:               o.chaos,
+		RedirectLimit:       o.FollowRedirects,
+		MinTLSVersion:       o.minTLS,
+		HostHeader:          o.ReplayHostHeader,
+
+		ExpectContinueTimeout: o.ExpectContinueTimeout,
+	}
+}
+
 func (o *App) run() {
 	ctx, ctxCancel := sigx.RegisterSignals(nil)
 	o.handlerOption.CtxCancel = ctxCancel
 	sigx.RegisterSignalProfile()
 	wg := &sync.WaitGroup{}
+	if o.handlerOption.Peers != nil {
+		go o.handlerOption.Peers.Refresh(ctx, o.ResolveInterval)
+	}
 
-	if len(o.Output) == 0 {
+	if len(o.Output) == 0 && o.dashboard == nil && !o.ReplayOriginal {
 		o.Output = []string{"stdout:log"}
 	}
 
 	senders := make(handler.Senders, 0, len(o.Output))
 	for _, out := range o.Output {
+		if _, ok := handler.ParseSQLiteOutput(out); ok {
+			continue // written by the transaction handler
+		}
 		if addr, ok := rest.MaybeURL(out); ok {
-			sender := replay.CreateSender(ctx, wg, o.Method, o.File, o.Verbose, addr, o.OutChan, o.ReplayN, o.ReplayFraction)
+			rc := o.replayConfig(addr)
+			if err := rc.CheckHealth(ctx); err != nil {
+				log.Fatalf("replay target %s is unhealthy: %v", addr, err)
+			}
+			sender := replay.CreateSender(ctx, wg, rc, o.OutChan)
 			senders = append(senders, sender)
+		} else if handler.IsFifo(out) {
+			senders = append(senders, o.throttle(ctx, handler.NewFifoSender(ctx, out, o.OutChan, o.flushLatency())))
+		} else if o.SplitBy == handler.SplitByTime && !strings.HasPrefix(out, "stdout") && !strings.HasPrefix(out, "stderr") {
+			file, err := handler.ParseSplitOutput(out)
+			if err != nil {
+				log.Fatalf("split output failed: %v", err)
+			}
+			senders = append(senders, o.throttle(ctx, handler.NewSplitSender(file, o.SplitInterval, o.UTC, o.OutChan, o.flushLatency())))
 		} else {
-			senders = append(senders, rotate.NewQueueWriter(out,
-				rotate.WithContext(ctx), rotate.WithOutChanSize(int(o.OutChan)), rotate.WithAppend(true)))
+			// the messages are queued by the QueuedSender for the occupancy gauge, the queue of the writer is minimal
+			w := rotate.NewQueueWriter(out, rotate.WithContext(ctx),
+				rotate.WithOutChanSize(1), rotate.WithAppend(true), rotate.WithFlushLatency(o.flushLatency()))
+			senders = append(senders, o.throttle(ctx, handler.NewQueuedSender(ctx, w, "out "+out, o.OutChan)))
 		}
 	}
+	if o.ReplayOriginal {
+		rc := o.replayConfig("")
+		rc.Original, rc.OriginalScheme = true, o.ReplayScheme
+		senders = append(senders, replay.CreateSender(ctx, wg, rc, o.OutChan))
+	}
+
+	if o.Diff != "" {
+		o.runDiff(ctx, senders)
+		_ = senders.Close()
+		wg.Wait()
+		return
+	}
 
 	if o.Web {
 		var port int
@@ -181,38 +638,164 @@ func (o *App) run() {
 		go osx.OpenBrowser(fmt.Sprintf("http://127.0.0.1:%d%s", port, contextPath))
 	}
 
+	if o.dashboard != nil {
+		go o.dashboard.Run(ctx, os.Stdout, time.Second, func() (int, int) {
+			width, height, _ := term.GetSize(int(os.Stdout.Fd()))
+			return width, height
+		})
+	}
+
 	var isPcapFile bool
 	var waitLoop sync.WaitGroup
-	if o.File == "" {
+	var assembler util.Assembler
+	if o.ProxyListen != "" {
+		p := handler.NewProxy(ctx, o.handlerOption, senders, o.ProxyListen, o.ProxyTarget)
+		go func() {
+			if err := p.Serve(); err != nil {
+				log.Printf("E! proxy serve failed: %v", err)
+			}
+		}()
+	} else if o.File == "" {
 		pcapFile, packets, err := util.CreatePacketsChan(o.Input, o.Bpf, o.Host, o.IP, o.Port)
 		if err != nil {
 			panic(err)
 		}
+		packets = o.handlerOption.PcapOut.Tee(ctx, packets)
+		assembler = o.createAssembler(ctx, senders)
+		if o.Debug {
+			go gaugeChans(ctx, senders, assembler)
+		}
 		waitLoop.Add(1)
 		go func() {
 			defer waitLoop.Done()
-			util.LoopPackets(ctx, packets, o.createAssembler(ctx, senders), o.Idle)
+			util.LoopPackets(ctx, packets, assembler, o.Idle, o.packetSource(o.Input))
 		}()
 		isPcapFile = pcapFile
 	}
 
 	if isPcapFile {
-		waitLoop.Wait()
+		o.drain(ctx, &waitLoop, assembler)
 	} else {
 		<-ctx.Done()
 		log.Printf("sleep 3s and then exit...")
 		time.Sleep(3 * time.Second)
+		o.drain(ctx, &waitLoop, assembler)
+	}
+
+	for _, marker := range o.handlerOption.Dedup.Flush() {
+		senders.Send(marker, false)
+	}
+	if summary := o.handlerOption.Stats.Summary(); summary != "" && o.Summary {
+		senders.Send(summary, false)
+	}
+	if o.SummaryJSON != "" {
+		o.writeSummaryJSON()
 	}
 
 	_ = senders.Close()
+	for _, c := range o.closers {
+		_ = c.Close()
+	}
 	wg.Wait()
+
+	for _, s := range senders {
+		if r, ok := s.(*replay.Sender); ok && r.Err() != nil && o.FailFast {
+			log.Fatalf("replay failed: %v", r.Err())
+		}
+	}
+}
+
+// drain waits for the packet loop and the connection handlers to finish, at most -drain-timeout
+// after ctx is done, then proceeds, reporting the connections abandoned.
+// The handlers of the abandoned connections may still send after the senders are closed,
+// which drop the messages then.
+func (o *App) drain(ctx context.Context, waitLoop *sync.WaitGroup, assembler util.Assembler) {
+	done := make(chan struct{})
+	go func() {
+		waitLoop.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return
+	case <-ctx.Done():
+	}
+
+	if o.DrainTimeout <= 0 {
+		<-done
+		return
+	}
+
+	select {
+	case <-done:
+	case <-time.After(o.DrainTimeout):
+		pending := 0
+		if p, ok := assembler.(handler.PendingCounter); ok {
+			pending = p.PendingConnections()
+		}
+		log.Printf("W! drain timeout %s, %d connections abandoned", o.DrainTimeout, pending)
+	}
+}
+
+// gaugeChans logs the occupancy of the channels periodically, and warns when one is nearly full.
+func gaugeChans(ctx context.Context, senders handler.Senders, assembler util.Assembler) {
+	var gauges []handler.ChanGauge
+	for _, s := range senders {
+		for s != nil {
+			if g, ok := s.(handler.ChanGauge); ok {
+				gauges = append(gauges, g)
+			}
+			u, ok := s.(interface{ Unwrap() handler.Sender })
+			if !ok {
+				break
+			}
+			s = u.Unwrap()
+		}
+	}
+	if g, ok := assembler.(handler.ChanGauge); ok {
+		gauges = append(gauges, g)
+	}
+	if len(gauges) == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for _, g := range gauges {
+			name, length, capacity := g.ChanOccupancy()
+			if capacity > 0 && length*100 >= capacity*80 {
+				log.Printf("W! %s occupancy %d/%d is nearly full, consider increasing its size", name, length, capacity)
+			} else {
+				log.Printf("D! %s occupancy %d/%d", name, length, capacity)
+			}
+		}
+	}
+}
+
+// packetSource names the capture sources of the packets of the input for -source, nil if not enabled.
+func (o *App) packetSource(input string) func(gopacket.Packet) string {
+	if !o.Source {
+		return nil
+	}
+	return util.PacketSource(input, o.Host)
 }
 
 func (o *App) createAssembler(ctx context.Context, sender handler.Sender) util.Assembler {
 	switch o.Mode {
 	case "fast":
-		h := &handler.ConnectionHandlerFast{Context: ctx, Option: o.handlerOption, Sender: sender}
-		return handler.NewTCPAssembler(h, o.Chan, o.Resp)
+		h := &handler.ConnectionHandlerFast{Context: ctx, Option: o.handlerOption, Sender: sender, Workers: o.Workers}
+		a := handler.NewTCPAssembler(h, o.Chan, o.Resp)
+		a.DebugConn = o.DebugConn
+		return a
 	default:
 		return o.createTCPStdAssembler(ctx, sender)
 	}
@@ -222,7 +805,49 @@ func (o *App) createTCPStdAssembler(ctx context.Context, printer handler.Sender)
 	f := handler.NewFactory(ctx, o.handlerOption, printer)
 	p := tcpassembly.NewStreamPool(f)
 	assembler := tcpassembly.NewAssembler(p)
-	return &handler.TcpStdAssembler{Assembler: assembler}
+	return &handler.TcpStdAssembler{Assembler: assembler, Factory: f}
+}
+
+// runDiff captures the two pcap files and reports the difference of their transactions.
+func (o *App) runDiff(ctx context.Context, senders handler.Senders) {
+	files := ss.Split(o.Diff, ss.WithSeps(","), ss.WithIgnoreEmpty(true), ss.WithTrimSpace(true))
+	collectors := make([]*handler.DiffCollector, len(files))
+	for i, file := range files {
+		collectors[i] = handler.NewDiffCollector(o.normalizer)
+		if err := o.collectTransactions(ctx, file, collectors[i]); err != nil {
+			log.Fatalf("collect transactions from %s failed: %v", file, err)
+		}
+	}
+
+	senders.Send(handler.DiffReport(files[0], files[1], collectors[0], collectors[1]), false)
+}
+
+// collectTransactions captures the pcap file, and passes all its transactions to the collector.
+func (o *App) collectTransactions(ctx context.Context, file string, collector handler.TransactionHandler) error {
+	_, packets, err := util.CreatePacketsChan(file, o.Bpf, o.Host, o.IP, o.Port)
+	if err != nil {
+		return err
+	}
+
+	option := *o.handlerOption
+	option.Resp = 1
+	option.Level = handler.LevelUrl
+	option.TransactionHandlers = []handler.TransactionHandler{collector}
+	h := &handler.ConnectionHandlerFast{Context: ctx, Option: &option, Sender: handler.DiscardSender{}}
+	util.LoopPackets(ctx, packets, handler.NewTCPAssembler(h, o.Chan, option.Resp), o.Idle, nil)
+	return nil
+}
+
+func parseReplayTime(name, value string) time.Time {
+	if value == "" {
+		return time.Time{}
+	}
+
+	t, err := replay.ParseTime(value)
+	if err != nil {
+		log.Fatalf("%s %s is invalid, should be like 2024-05-06 07:08:09 or 2024-05-06T07:08:09+08:00", name, value)
+	}
+	return t
 }
 
 // PostProcess does some post processes.
@@ -236,6 +861,93 @@ func (o *App) PostProcess() {
 	o.ReplayN = int(o.ReplayRatio)
 	o.ReplayFraction = o.ReplayRatio - float64(o.ReplayN)
 
+	if o.Diff != "" && len(ss.Split(o.Diff, ss.WithSeps(","), ss.WithIgnoreEmpty(true))) != 2 {
+		log.Fatalf("Diff %s is invalid, should be two pcap files like before.pcap,after.pcap", o.Diff)
+	}
+	if !ss.AnyOf(o.Format, "", handler.FormatCLF, handler.FormatCombined, handler.FormatRaw) {
+		log.Fatalf("Format %s is invalid, should be clf, combined or raw", o.Format)
+	}
+	if !ss.AnyOf(o.ReplayScheme, "", "http", "https") {
+		log.Fatalf("ReplayScheme %s is invalid, should be http or https", o.ReplayScheme)
+	}
+	if !ss.AnyOf(o.BodyHash, "", handler.BodyHashMD5, handler.BodyHashSHA256) {
+		log.Fatalf("BodyHash %s is invalid, should be md5 or sha256", o.BodyHash)
+	}
+	if !ss.AnyOf(o.SplitBy, "", handler.SplitByTime) {
+		log.Fatalf("SplitBy %s is invalid, should be time", o.SplitBy)
+	}
+	if o.SplitBy != "" && o.SplitInterval < time.Second {
+		log.Fatalf("SplitInterval %s is invalid, should be at least 1s", o.SplitInterval)
+	}
+	if !ss.AnyOf(o.Direction, "", handler.DirectionInbound, handler.DirectionOutbound) {
+		log.Fatalf("Direction %s is invalid, should be inbound or outbound", o.Direction)
+	}
+	if !ss.AnyOf(o.ConnFormat, handler.ConnFormatDash, handler.ConnFormatArrow, handler.ConnFormatHash) {
+		log.Fatalf("ConnFormat %s is invalid, should be ip:port, ip:port->ip:port or hash", o.ConnFormat)
+	}
+	if !ss.AnyOf(o.TimestampBase, "", handler.TimestampOriginal, handler.TimestampNow, handler.TimestampFirst) {
+		log.Fatalf("TimestampBase %s is invalid, should be original, now or first", o.TimestampBase)
+	}
+	if o.Resolve != "" && o.ResolveInterval <= 0 {
+		log.Fatalf("ResolveInterval %s is invalid, should be positive", o.ResolveInterval)
+	}
+	if !o.Flush && o.FlushIntervalOut <= 0 {
+		log.Fatalf("FlushIntervalOut %s is invalid, should be positive", o.FlushIntervalOut)
+	}
+	if o.ProxyListen != "" && o.ProxyTarget == "" {
+		log.Fatalf("ProxyTarget is required when ProxyListen %s is set", o.ProxyListen)
+	}
+	if o.OutputRate != "" {
+		bps, err := handler.ParseRate(o.OutputRate)
+		if err != nil {
+			log.Fatalf("OutputRate %s is invalid, should be like 1MB/s: %v", o.OutputRate, err)
+		}
+		o.outputRate = bps
+	}
+	o.replayAfter = parseReplayTime("ReplayAfter", o.ReplayAfter)
+	o.replayBefore = parseReplayTime("ReplayBefore", o.ReplayBefore)
+	if o.Ramp != "" {
+		ramp, err := replay.ParseRamp(o.Ramp)
+		if err != nil {
+			log.Fatalf("Ramp %s is invalid: %v", o.Ramp, err)
+		}
+		ramp.MaxErrorRate = o.RampErrorRate
+		ramp.OnStop = func(error) { o.handlerOption.CtxCancel() }
+		o.ramp = ramp
+	}
+	for _, rule := range o.ReplaceBody {
+		r, err := replay.ParseBodyReplacer(rule)
+		if err != nil {
+			log.Fatalf("ReplaceBody %v", err)
+		}
+		o.replaceBody = append(o.replaceBody, r)
+	}
+	if o.Healthcheck != "" {
+		h, err := replay.ParseHealthCheck(o.Healthcheck, o.HealthcheckStatus)
+		if err != nil {
+			log.Fatalf("Healthcheck %v", err)
+		}
+		o.healthCheck = h
+	}
+
+	if o.MinTLS != "" {
+		v, err := replay.ParseTLSVersion(o.MinTLS)
+		if err != nil {
+			log.Fatalf("MinTLS %v", err)
+		}
+		o.minTLS = v
+	}
+	if o.FollowRedirects < 0 {
+		log.Fatalf("FollowRedirects %d is invalid, should be >= 0", o.FollowRedirects)
+	}
+	chaos := &replay.Chaos{Delay: o.ChaosDelay, DelayTime: o.ChaosDelayTime, Drop: o.ChaosDrop, Corrupt: o.ChaosCorrupt}
+	if err := replay.ValidateChaos(chaos); err != nil {
+		log.Fatalf("Chaos %v", err)
+	}
+	if o.ChaosDelay > 0 || o.ChaosDrop > 0 || o.ChaosCorrupt > 0 {
+		o.chaos = chaos
+	}
+
 	o.processDumpBody()
 }
 
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/bingoohuang/gg/pkg/flagparse"
+	"github.com/bingoohuang/gg/pkg/yaml"
+)
+
+// applyProfile resolves the named profile of -profile in the config file of -c, like:
+//
+//	profiles:
+//	  api-errors:
+//	    status: 500-599
+//	    method: POST
+//	    level: all
+//
+// The flags of the profile are inserted before the command line flags,
+// so that the profile overrides the config file, and is overridden by the explicit flags,
+// the ones of the profile given on the command line too are dropped, for the slice flags like -method accumulate.
+func applyProfile(args []string) []string {
+	name, _ := flagparse.FindFlag(args, "profile")
+	if name == "" {
+		return args
+	}
+
+	conf, _ := flagparse.FindFlag(args, "c")
+	if conf == "" {
+		log.Fatalf("-profile %s requires a config file by -c", name)
+	}
+
+	data, err := os.ReadFile(conf)
+	if err != nil {
+		log.Fatalf("read conf file %s failed: %v", conf, err)
+	}
+
+	var c struct {
+		Profiles map[string]map[string]any
+	}
+	if err := yaml.Unmarshal(data, &c); err != nil {
+		log.Fatalf("decode conf file %s failed: %v", conf, err)
+	}
+
+	profile, ok := c.Profiles[name]
+	if !ok {
+		log.Fatalf("profile %s is not found in conf file %s", name, conf)
+	}
+
+	for k := range commandLineFlags(args[1:]) {
+		delete(profile, k)
+	}
+	return append(append([]string{args[0]}, profileFlags(profile)...), args[1:]...)
+}
+
+// commandLineFlags returns the names of the flags in the args, like method of -method=GET or --method GET.
+func commandLineFlags(args []string) map[string]bool {
+	names := map[string]bool{}
+	for _, arg := range args {
+		if arg == "--" {
+			break
+		}
+		if name, ok := strings.CutPrefix(arg, "-"); ok && name != "" {
+			name, _, _ = strings.Cut(strings.TrimPrefix(name, "-"), "=")
+			names[name] = true
+		}
+	}
+	return names
+}
+
+// profileFlags converts the profile to flags in the sorted order of the flag names.
+func profileFlags(profile map[string]any) (flags []string) {
+	names := make([]string, 0, len(profile))
+	for k := range profile {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	for _, k := range names {
+		switch v := profile[k].(type) {
+		case []any: // like output: [stdout, dump.http]
+			for _, item := range v {
+				flags = append(flags, fmt.Sprintf("-%s=%v", k, item))
+			}
+		case bool: // -r counts the occurrences, so true is set without a value
+			if v {
+				flags = append(flags, "-"+k)
+			} else {
+				flags = append(flags, "-"+k+"=false")
+			}
+		default:
+			flags = append(flags, fmt.Sprintf("-%s=%v", k, v))
+		}
+	}
+
+	return flags
+}
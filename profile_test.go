@@ -0,0 +1,26 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyProfile(t *testing.T) {
+	conf := filepath.Join(t.TempDir(), "conf.yml")
+	assert.Nil(t, os.WriteFile(conf, []byte(`profiles:
+  api-errors:
+    status: 500-599
+    method: [POST, PUT]
+    level: all
+`), 0o600))
+
+	// the slice flag -method of the command line replaces the one of the profile, not accumulated
+	args := applyProfile([]string{"httpdump", "-c", conf, "-profile", "api-errors", "-method=GET", "--level", "url"})
+	assert.Equal(t, []string{"httpdump", "-status=500-599", "-c", conf, "-profile", "api-errors", "-method=GET", "--level", "url"}, args)
+
+	args = applyProfile([]string{"httpdump", "-c", conf, "-profile", "api-errors"})
+	assert.Equal(t, []string{"httpdump", "-level=all", "-method=POST", "-method=PUT", "-status=500-599", "-c", conf, "-profile", "api-errors"}, args)
+}
@@ -0,0 +1,50 @@
+package replay
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// bodyFileMarker is the marker in place of the request body dumped to the file by -dump-body,
+// like // dump body to file: dump.20240506.1.REQ size: 1024.
+var bodyFileMarker = regexp.MustCompile(`^\s*// dump body to file:\s*(.+?)\s*size:\s*\d+\s*$`)
+
+// bodyFileOf finds the body file referenced by the marker in the body of the raw request,
+// relative to the dir, ok is false if no marker.
+func bodyFileOf(data []byte, dir string) (file string, ok bool) {
+	pos := bytes.Index(data, []byte("\r\n\r\n"))
+	if pos < 0 {
+		return "", false
+	}
+
+	m := bodyFileMarker.FindSubmatch(data[pos+4:])
+	if m == nil {
+		return "", false
+	}
+	if file = string(m[1]); !filepath.IsAbs(file) {
+		file = filepath.Join(dir, file)
+	}
+	return file, true
+}
+
+// openBodyFile opens the body file referenced by the raw request, to stream the body from the disk.
+// The size of the file is returned as the content length.
+func openBodyFile(data []byte, dir string) (*os.File, int64, bool, error) {
+	file, ok := bodyFileOf(data, dir)
+	if !ok {
+		return nil, 0, false, nil
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, 0, true, err
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, 0, true, err
+	}
+	return f, stat.Size(), true, nil
+}
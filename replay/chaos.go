@@ -0,0 +1,72 @@
+package replay
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// Chaos injects the faults into the replayed requests for the resilience testing,
+// each fault on the percentage of the requests like 10 for 10%.
+type Chaos struct {
+	Delay     float64       // percentage of the requests to delay by DelayTime before sending
+	DelayTime time.Duration // the delay of the delayed requests
+	Drop      float64       // percentage of the requests to drop without sending
+	Corrupt   float64       // percentage of the requests with bodies to truncate to the half
+
+	roll func() float64 // rolls a number in [0, 100), random if nil
+}
+
+// ValidateChaos checks the percentages of the faults are in [0, 100].
+func ValidateChaos(c *Chaos) error {
+	for name, p := range map[string]float64{"delay": c.Delay, "drop": c.Drop, "corrupt": c.Corrupt} {
+		if p < 0 || p > 100 {
+			return fmt.Errorf("chaos %s %v is invalid, should be a percentage in [0, 100]", name, p)
+		}
+	}
+	return nil
+}
+
+// hit tells if the fault of the percentage p should be injected.
+func (c *Chaos) hit(p float64) bool {
+	if p <= 0 {
+		return false
+	}
+	if c.roll != nil {
+		return c.roll() < p
+	}
+	return rand.Float64()*100 < p
+}
+
+// dropped tells if the request should be dropped, logging the fault.
+func (c *Chaos) dropped(req *http.Request) bool {
+	if c == nil || !c.hit(c.Drop) {
+		return false
+	}
+	log.Printf("I! chaos dropped %s %s", req.Method, req.URL)
+	return true
+}
+
+// corrupt truncates the body of the request to the half, logging the fault, the requests without bodies are untouched.
+func (c *Chaos) corrupt(req *http.Request, body []byte) []byte {
+	if c == nil || len(body) == 0 || !c.hit(c.Corrupt) {
+		return body
+	}
+	log.Printf("I! chaos corrupted %s %s, body truncated from %d to %d bytes",
+		req.Method, req.URL, len(body), len(body)/2)
+	return body[:len(body)/2]
+}
+
+// delay sleeps DelayTime before sending the request, logging the fault.
+func (c *Chaos) delay(req *http.Request) {
+	if c == nil || c.DelayTime <= 0 || !c.hit(c.Delay) {
+		return
+	}
+	log.Printf("I! chaos delayed %s %s by %s", req.Method, req.URL, c.DelayTime)
+	select {
+	case <-time.After(c.DelayTime):
+	case <-req.Context().Done():
+	}
+}
@@ -0,0 +1,83 @@
+package replay
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// HealthCheck checks the replay target is up before replaying, to fail fast instead of replaying against a down backend.
+type HealthCheck struct {
+	Path   string   // like /healthz
+	Status []string // the expected status codes like 200, or the classes like 2xx
+}
+
+// ParseHealthCheck parses the health check of the path, expecting the status like 2xx or 200,204.
+func ParseHealthCheck(p, status string) (*HealthCheck, error) {
+	if !strings.HasPrefix(p, "/") {
+		return nil, fmt.Errorf("invalid health check path %s, should be like /healthz", p)
+	}
+
+	h := &HealthCheck{Path: p}
+	for _, s := range strings.Split(status, ",") {
+		s = strings.ToLower(strings.TrimSpace(s))
+		if _, err := strconv.Atoi(strings.ReplaceAll(s, "x", "0")); err != nil || len(s) != 3 {
+			return nil, fmt.Errorf("invalid health check status %s, should be like 2xx or 200,204", status)
+		}
+		h.Status = append(h.Status, s)
+	}
+	return h, nil
+}
+
+// Expects tells if the status code is expected.
+func (h *HealthCheck) Expects(code int) bool {
+	c := strconv.Itoa(code)
+	for _, s := range h.Status {
+		if len(c) == 3 && (s == c || s[1:] == "xx" && s[0] == c[0]) {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckHealth GETs the path of the HealthCheck on the replay target, and fails if it doesn't respond
+// the expected status in the timeout, nil if no HealthCheck.
+func (c *Config) CheckHealth(ctx context.Context) error {
+	v := c.CreateHTTPClientConfig()
+	if v == nil || v.BaseURL == nil || c.HealthCheck == nil {
+		return nil
+	}
+
+	v.report, v.Ramp, v.UseCookieJar, v.Chaos = nil, nil, false, nil
+	v.FollowRedirects = 10 // like the default policy of http.Client
+	client := v.NewHTTPClient()
+	u, err := url.Parse(c.HealthCheck.Path)
+	if err != nil {
+		return err
+	}
+	target := *v.BaseURL
+	target.Path = path.Join(target.Path, u.Path)
+	target.RawQuery = u.RawQuery
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return err
+	}
+	rsp, err := client.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("health check %s failed: %w", target.String(), err)
+	}
+	defer rsp.Body.Close()
+	_, _ = io.Copy(io.Discard, rsp.Body)
+
+	if !c.HealthCheck.Expects(rsp.StatusCode) {
+		return fmt.Errorf("health check %s responded status %d, expecting %s",
+			target.String(), rsp.StatusCode, strings.Join(c.HealthCheck.Status, ","))
+	}
+	return nil
+}
@@ -4,10 +4,14 @@ import (
 	"bufio"
 	"bytes"
 	"crypto/tls"
+	"fmt"
+	"io"
+	"log"
 	"net/http"
+	"net/http/cookiejar"
 	"net/url"
-	"path"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/bingoohuang/gg/pkg/rest"
@@ -18,6 +22,9 @@ import (
 type HTTPClient struct {
 	*http.Client
 	*HTTPClientConfig
+
+	report *csvReport
+	ramp   *rampRunner
 }
 
 type HTTPClientConfig struct {
@@ -26,8 +33,47 @@ type HTTPClientConfig struct {
 	InsecureVerify bool
 	BaseURL        *url.URL
 	Methods        string
+
+	// StripAcceptEncoding removes Accept-Encoding from the requests, so that the responses come back uncompressed.
+	StripAcceptEncoding bool
+	// report is the CSV report of the results with timings of the replayed requests, opened by the Config once.
+	report *csvReport
+	// UseCookieJar keeps the cookies set by the target, and sends them on the subsequent requests,
+	// overriding the captured cookies of the same names.
+	UseCookieJar bool
+	// After and Before are the time window of the recorded timestamps of the requests to replay, zero for unbounded.
+	After, Before time.Time
+	unknownTimes  int64 // the requests skipped by the window for their timestamps unknown
+	// ExpectContinueTimeout is the time to wait for the 100 Continue of the requests with Expect: 100-continue,
+	// before sending the bodies anyway, zero for the default of http.DefaultTransport.
+	ExpectContinueTimeout time.Duration
+	// BodyDir is the dir of the body files dumped by -dump-body, the request bodies referenced
+	// by the dump markers in the capture are streamed from the files, empty to send the bodies as is.
+	BodyDir string
+	// ReplaceBody are the substitutions applied in order on the request bodies, not streamed from BodyDir,
+	// the binary bodies are untouched unless ForceReplaceBody.
+	ReplaceBody      []*BodyReplacer
+	ForceReplaceBody bool
+	// Ramp paces the requests by the rate ramping up, and stops on the max error rate, nil for no pacing.
+	Ramp *Ramp
+	// Original replays the requests to their original hosts instead of the BaseURL,
+	// by OriginalScheme, or inferred from the port if empty.
+	Original       bool
+	OriginalScheme string
+	// Chaos injects the faults into the requests, nil for no faults.
+	Chaos *Chaos
+	// MinTLSVersion is the min TLS version to negotiate with the https targets, like tls.VersionTLS12, 0 for the default.
+	MinTLSVersion uint16
+	// FollowRedirects is the max number of the redirects to follow, 0 to return the redirect responses as captured.
+	FollowRedirects int
+	// HostHeader is the Host header to send independently of the target, for the virtual-host routing,
+	// HostPreserve to keep the captured one, empty for the host of the target.
+	HostHeader string
 }
 
+// HostPreserve is the HostHeader to keep the captured Host headers of the requests.
+const HostPreserve = "preserve"
+
 // NewHTTPClient returns new http client with check redirects policy
 func (c *HTTPClientConfig) NewHTTPClient() *HTTPClient {
 	if c.Timeout == 0 {
@@ -39,12 +85,46 @@ func (c *HTTPClientConfig) NewHTTPClient() *HTTPClient {
 			Timeout: c.Timeout,
 		},
 	}
+	client.Client.CheckRedirect = c.checkRedirect
 	if !c.InsecureVerify {
 		// clone to avoid modifying global default RoundTripper
 		t := http.DefaultTransport.(*http.Transport).Clone()
 		t.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
 		client.Client.Transport = t
 	}
+	if c.MinTLSVersion > 0 {
+		t, ok := client.Client.Transport.(*http.Transport)
+		if !ok {
+			t = http.DefaultTransport.(*http.Transport).Clone()
+		}
+		if t.TLSClientConfig == nil {
+			t.TLSClientConfig = &tls.Config{}
+		}
+		t.TLSClientConfig.MinVersion = c.MinTLSVersion
+		client.Client.Transport = t
+	}
+	if c.StripAcceptEncoding {
+		t, ok := client.Client.Transport.(*http.Transport)
+		if !ok {
+			t = http.DefaultTransport.(*http.Transport).Clone()
+		}
+		// avoid the transport requesting gzip by itself
+		t.DisableCompression = true
+		client.Client.Transport = t
+	}
+	if c.ExpectContinueTimeout > 0 {
+		t, ok := client.Client.Transport.(*http.Transport)
+		if !ok {
+			t = http.DefaultTransport.(*http.Transport).Clone()
+		}
+		t.ExpectContinueTimeout = c.ExpectContinueTimeout
+		client.Client.Transport = t
+	}
+	if c.UseCookieJar {
+		client.Client.Jar, _ = cookiejar.New(nil)
+	}
+	client.report = c.report
+	client.ramp = newRampRunner(c.Ramp)
 
 	return client
 }
@@ -55,10 +135,28 @@ type SendResponse struct {
 	ResponseBody []byte
 	StatusCode   int
 	Cost         time.Duration
+	Timings      Timings
+	Redirects    []RedirectHop // the redirects followed, by FollowRedirects
 }
 
 // Send sends a http request using client create by NewHTTPClient
 func (c *HTTPClient) Send(data []byte) (*SendResponse, error) {
+	return c.SendAt(data, time.Time{})
+}
+
+// SendAt sends a http request recorded at the timestamp, zero if unknown,
+// the request out of the time window of After and Before is skipped, like the unknown one.
+func (c *HTTPClient) SendAt(data []byte, timestamp time.Time) (*SendResponse, error) {
+	return c.sendTo(data, timestamp, "")
+}
+
+// sendTo sends a http request recorded at the timestamp on the connection to dst, empty if unknown,
+// which is the original host replayed to if the request has no Host.
+func (c *HTTPClient) sendTo(data []byte, timestamp time.Time, dst string) (*SendResponse, error) {
+	if !c.InWindow(timestamp) {
+		return nil, nil
+	}
+
 	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(data)))
 	if err != nil {
 		return nil, err
@@ -76,13 +174,51 @@ func (c *HTTPClient) Send(data []byte) (*SendResponse, error) {
 		return nil, nil
 	}
 
-	baseURL := *c.BaseURL
-	baseURL.Path = path.Join(baseURL.Path, req.URL.Path)
-	baseURL.RawPath = req.URL.RawPath
+	target, err := c.targetURL(req, dst)
+	if err != nil {
+		return nil, err
+	}
 
 	req.Header.Set("X-Goreplay-Output", "1")
-	req.Host = c.BaseURL.Host
-	req.URL = &baseURL
+	if c.StripAcceptEncoding {
+		req.Header.Del("Accept-Encoding")
+	}
+	streamed := false
+	if c.BodyDir != "" {
+		f, size, ok, err := openBodyFile(data, c.BodyDir)
+		if err != nil {
+			return nil, err
+		}
+		if ok { // closed by the client
+			req.Body, req.ContentLength, req.TransferEncoding = f, size, nil
+			streamed = true
+		}
+	}
+	if len(c.ReplaceBody) > 0 && !streamed {
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		body = replaceBody(body, c.ReplaceBody, c.ForceReplaceBody)
+		req.Body, req.ContentLength, req.TransferEncoding = io.NopCloser(bytes.NewReader(body)), int64(len(body)), nil
+	}
+
+	req.Host, req.URL = c.hostHeader(req.Host, target), target
+	if c.Chaos.dropped(req) {
+		_ = req.Body.Close() // not sent, like the file of BodyDir not closed by the client
+		return nil, nil
+	}
+	if c.Chaos != nil && c.Chaos.Corrupt > 0 && !streamed {
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		body = c.Chaos.corrupt(req, body)
+		req.Body, req.ContentLength, req.TransferEncoding = io.NopCloser(bytes.NewReader(body)), int64(len(body)), nil
+	}
+	if c.Client.Jar != nil {
+		dropJarCookies(req, c.Client.Jar.Cookies(req.URL))
+	}
 
 	// force connection to not be closed, which can affect the global client
 	req.Close = false
@@ -91,13 +227,24 @@ func (c *HTTPClient) Send(data []byte) (*SendResponse, error) {
 
 	rest.LogRequest(req, c.Verbose)
 
+	c.ramp.wait()
+	c.Chaos.delay(req)
+	var timings Timings
+	var redirects []RedirectHop
 	start := time.Now()
+	req = req.WithContext(withRedirects(withTimingTrace(req.Context(), &timings, start), &redirects))
 	rsp, err := c.Client.Do(req)
 	sendRsp := &SendResponse{
-		Method: req.Method,
-		URL:    req.URL.String(),
-		Cost:   time.Since(start),
+		Method:    req.Method,
+		URL:       req.URL.String(),
+		Cost:      time.Since(start),
+		Timings:   timings,
+		Redirects: redirects,
 	}
+	defer func() {
+		c.report.Write(sendRsp, err)
+		c.ramp.record(sendRsp, err)
+	}()
 
 	rest.LogResponse(rsp, c.Verbose)
 
@@ -108,3 +255,68 @@ func (c *HTTPClient) Send(data []byte) (*SendResponse, error) {
 
 	return sendRsp, err
 }
+
+// hostHeader returns the Host header to send the request captured with the host to the target, by HostHeader,
+// the host of the target if the captured one to preserve is absent.
+func (c *HTTPClientConfig) hostHeader(captured string, target *url.URL) string {
+	switch {
+	case c.HostHeader == HostPreserve && captured != "":
+		return captured
+	case c.HostHeader != "" && c.HostHeader != HostPreserve:
+		return c.HostHeader
+	default:
+		return target.Host
+	}
+}
+
+// InWindow tells if the timestamp is in the time window of After and Before, all are in if no window,
+// the zero timestamp unknown or unparsable is out of the window, warned on the first one.
+func (c *HTTPClientConfig) InWindow(timestamp time.Time) bool {
+	if c.After.IsZero() && c.Before.IsZero() {
+		return true
+	}
+	if timestamp.IsZero() {
+		if atomic.AddInt64(&c.unknownTimes, 1) == 1 {
+			log.Printf("W! the requests without the recorded timestamps are skipped by the replay time window")
+		}
+		return false
+	}
+
+	return (c.After.IsZero() || !timestamp.Before(c.After)) && (c.Before.IsZero() || timestamp.Before(c.Before))
+}
+
+// dropJarCookies removes the captured cookies with the same names as the jar ones,
+// which are added by the client when sending.
+func dropJarCookies(req *http.Request, jarCookies []*http.Cookie) {
+	if len(jarCookies) == 0 {
+		return
+	}
+
+	managed := make(map[string]bool, len(jarCookies))
+	for _, c := range jarCookies {
+		managed[c.Name] = true
+	}
+
+	captured := req.Cookies()
+	req.Header.Del("Cookie")
+	for _, c := range captured {
+		if !managed[c.Name] {
+			req.AddCookie(c)
+		}
+	}
+}
+
+// ParseTLSVersion parses the TLS version like 1.2 into the constant like tls.VersionTLS12.
+func ParseTLSVersion(v string) (uint16, error) {
+	switch v {
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("invalid TLS version %s, should be 1.0, 1.1, 1.2 or 1.3", v)
+}
@@ -0,0 +1,407 @@
+package replay
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHTTPClientStripAcceptEncoding(t *testing.T) {
+	var got string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("Accept-Encoding")
+	}))
+	defer server.Close()
+
+	base, _ := url.Parse(server.URL)
+	c := (&HTTPClientConfig{BaseURL: base, StripAcceptEncoding: true}).NewHTTPClient()
+	if _, err := c.Send([]byte("GET /x HTTP/1.1\r\nHost: a.b\r\nAccept-Encoding: gzip, zstd\r\n\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	if got != "" {
+		t.Errorf("Accept-Encoding %q should be stripped", got)
+	}
+}
+
+func TestHTTPClientCSVReport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	file := filepath.Join(t.TempDir(), "replay.csv")
+	base, _ := url.Parse(server.URL)
+	report := openCSVReport(file)
+	c := (&HTTPClientConfig{BaseURL: base, report: report}).NewHTTPClient()
+	rsp, err := c.Send([]byte("GET /x HTTP/1.1\r\nHost: a.b\r\n\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rsp.Timings.Connect <= 0 || rsp.Timings.TTFB <= 0 {
+		t.Errorf("timings %s should be traced", rsp.Timings)
+	}
+	report.release()
+
+	data, _ := os.ReadFile(file)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[1], ",GET,"+server.URL+"/x,201,") {
+		t.Errorf("unexpected csv report %q", data)
+	}
+}
+
+func TestCSVReportShared(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	// the replay outputs of the same file share the report, not to truncate the results of each other
+	file := filepath.Join(t.TempDir(), "replay.csv")
+	base, _ := url.Parse(server.URL)
+	reports := []*csvReport{openCSVReport(file), openCSVReport(file)}
+	if reports[0] != reports[1] {
+		t.Fatal("the report of the same file should be shared")
+	}
+
+	var wg sync.WaitGroup
+	for _, report := range reports {
+		c := (&HTTPClientConfig{BaseURL: base, report: report}).NewHTTPClient()
+		for i := 0; i < 5; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, _ = c.Send([]byte("GET /x HTTP/1.1\r\nHost: a.b\r\n\r\n"))
+			}()
+		}
+	}
+	wg.Wait()
+	reports[0].release()
+	if reports[1].closed {
+		t.Error("the report should be open until released by all")
+	}
+	reports[1].release()
+	reports[1].Write(&SendResponse{Method: "GET"}, nil) // dropped after closed
+
+	data, _ := os.ReadFile(file)
+	if lines := strings.Split(strings.TrimSpace(string(data)), "\n"); len(lines) != 11 {
+		t.Errorf("unexpected csv report %q", data)
+	}
+}
+
+func TestHTTPClientCookieJar(t *testing.T) {
+	var got []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/login" {
+			http.SetCookie(w, &http.Cookie{Name: "sid", Value: "new", Path: "/"})
+			return
+		}
+		got = append(got, r.Header.Get("Cookie"))
+	}))
+	defer server.Close()
+
+	base, _ := url.Parse(server.URL)
+	c := (&HTTPClientConfig{BaseURL: base, UseCookieJar: true}).NewHTTPClient()
+	for _, req := range []string{
+		"POST /login HTTP/1.1\r\nHost: a.b\r\nContent-Length: 0\r\n\r\n",
+		"GET /x HTTP/1.1\r\nHost: a.b\r\nCookie: sid=old; lang=en\r\n\r\n",
+	} {
+		if _, err := c.Send([]byte(req)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(got) != 1 || got[0] != "lang=en; sid=new" {
+		t.Errorf("unexpected cookies %q", got)
+	}
+}
+
+func TestHTTPClientExpectContinue(t *testing.T) {
+	var expect, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		expect = r.Header.Get("Expect")
+		data, _ := io.ReadAll(r.Body) // the server replies 100 Continue on reading the body
+		body = string(data)
+	}))
+	defer server.Close()
+
+	base, _ := url.Parse(server.URL)
+	c := (&HTTPClientConfig{BaseURL: base, ExpectContinueTimeout: 5 * time.Second}).NewHTTPClient()
+	if timeout := c.Client.Transport.(*http.Transport).ExpectContinueTimeout; timeout != 5*time.Second {
+		t.Errorf("unexpected ExpectContinueTimeout %s", timeout)
+	}
+
+	start := time.Now()
+	if _, err := c.Send([]byte("POST /x HTTP/1.1\r\nHost: a.b\r\nExpect: 100-continue\r\nContent-Length: 5\r\n\r\nhello")); err != nil {
+		t.Fatal(err)
+	}
+	if expect != "100-continue" || body != "hello" {
+		t.Errorf("unexpected Expect %q, body %q", expect, body)
+	}
+	if cost := time.Since(start); cost > time.Second {
+		t.Errorf("the body should be sent on 100 Continue, before the timeout, cost %s", cost)
+	}
+}
+
+func TestHTTPClientBodyDir(t *testing.T) {
+	var received string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received = fmt.Sprintf("%d %s", r.ContentLength, body)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "dump.20240506.1.REQ"), []byte("large upload"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	u, _ := url.Parse(server.URL)
+	client := (&HTTPClientConfig{BaseURL: u, BodyDir: dir}).NewHTTPClient()
+	data := "POST /upload HTTP/1.1\r\nHost: a.b\r\nContent-Length: 12\r\n\r\n\r\n// dump body to file: dump.20240506.1.REQ size: 12\r\n"
+	if _, err := client.Send([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+	if received != "12 large upload" {
+		t.Errorf("unexpected body received %q", received)
+	}
+
+	if _, err := client.Send([]byte(strings.ReplaceAll(data, "1.REQ", "2.REQ"))); err == nil {
+		t.Error("error expected for the missing body file")
+	}
+}
+
+func TestHTTPClientReplaceBody(t *testing.T) {
+	var got string
+	var gotLength int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		got, gotLength = string(body), r.ContentLength
+	}))
+	defer server.Close()
+
+	var replacers []*BodyReplacer
+	for _, rule := range []string{`s/prod-bucket/staging-bucket-1/g`, `s|"id":(\d+)|"id":"$1"|`, `s/A\/B/C/i`} {
+		r, err := ParseBodyReplacer(rule)
+		if err != nil {
+			t.Fatal(err)
+		}
+		replacers = append(replacers, r)
+	}
+
+	base, _ := url.Parse(server.URL)
+	c := (&HTTPClientConfig{BaseURL: base, ReplaceBody: replacers}).NewHTTPClient()
+	body := `{"id":1,"b":"prod-bucket","c":"prod-bucket","d":"a/b","id":2}`
+	req := fmt.Sprintf("POST /x HTTP/1.1\r\nHost: a.b\r\nContent-Length: %d\r\n\r\n%s", len(body), body)
+	if _, err := c.Send([]byte(req)); err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"id":"1","b":"staging-bucket-1","c":"staging-bucket-1","d":"C","id":2}`
+	if got != expected || gotLength != int64(len(expected)) {
+		t.Errorf("unexpected body %s, length %d", got, gotLength)
+	}
+
+	binary := "\xff\xfeprod-bucket"
+	if replaced := replaceBody([]byte(binary), replacers, false); string(replaced) != binary {
+		t.Errorf("binary body %q should be untouched", replaced)
+	}
+	if replaced := replaceBody([]byte(binary), replacers, true); string(replaced) != "\xff\xfestaging-bucket-1" {
+		t.Errorf("binary body %q should be replaced by force", replaced)
+	}
+
+	for _, rule := range []string{"x/a/b/", "s/a/b", "s/(/b/", "s/a/b/x"} {
+		if _, err := ParseBodyReplacer(rule); err == nil {
+			t.Errorf("rule %s should be invalid", rule)
+		}
+	}
+}
+
+func TestHTTPClientOriginal(t *testing.T) {
+	var got []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.Host+r.URL.RequestURI())
+	}))
+	defer server.Close()
+
+	host := strings.TrimPrefix(server.URL, "http://")
+	c := (&HTTPClientConfig{Original: true}).NewHTTPClient()
+	if _, err := c.Send([]byte("GET /x?a=1 HTTP/1.1\r\nHost: " + host + "\r\n\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.sendTo([]byte("GET /y HTTP/1.0\r\n\r\n"), time.Time{}, host); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Send([]byte("GET /z HTTP/1.0\r\n\r\n")); err == nil {
+		t.Error("the error of no original host expected")
+	}
+	if want := []string{host + "/x?a=1", host + "/y"}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	u, _ := c.targetURL(&http.Request{Host: "a.b:8443", URL: &url.URL{Path: "/"}}, "")
+	if u.String() != "https://a.b:8443/" {
+		t.Errorf("unexpected inferred url %s", u)
+	}
+	c.OriginalScheme = "http"
+	if u, _ = c.targetURL(&http.Request{Host: "a.b:8443", URL: &url.URL{Path: "/"}}, ""); u.String() != "http://a.b:8443/" {
+		t.Errorf("unexpected url %s by the scheme", u)
+	}
+
+	title := []byte("### #1 REQ 127.0.0.1:5000-127.0.0.1:8080 2024-05-06T07:08:09Z")
+	if dst := TitleDestination(title); dst != "127.0.0.1:8080" {
+		t.Errorf("unexpected destination %s", dst)
+	}
+}
+
+func TestSemaphoreKey(t *testing.T) {
+	title := []byte("### #1 REQ 127.0.0.1:5000-127.0.0.1:8080 2024-05-06T07:08:09Z")
+	c := &HTTPClientConfig{Original: true}
+	for _, tc := range []struct {
+		payload Msg
+		want    string
+	}{
+		{Msg{Data: []byte("GET /a HTTP/1.1\r\nHost: a.b:8080\r\n\r\n")}, "a.b:8080"},
+		{Msg{Data: []byte("GET /b HTTP/1.1\r\nHost: c.d\r\n\r\n")}, "c.d"},
+		{Msg{Title: title, Data: []byte("GET /c HTTP/1.0\r\n\r\n")}, "127.0.0.1:8080"},
+		{Msg{Data: []byte("GET /d HTTP/1.0\r\n\r\n")}, ""},
+		{Msg{Data: []byte("bad")}, ""},
+	} {
+		if got := c.semaphoreKey(tc.payload); got != tc.want {
+			t.Errorf("semaphore key of %q: got %q, want %q", tc.payload.Data, got, tc.want)
+		}
+	}
+
+	base, _ := url.Parse("http://e.f:9090/api")
+	if got := (&HTTPClientConfig{BaseURL: base}).semaphoreKey(Msg{Data: []byte("GET /a HTTP/1.1\r\nHost: a.b\r\n\r\n")}); got != "e.f:9090" {
+		t.Errorf("semaphore key of the base url: got %q", got)
+	}
+}
+
+func TestHTTPClientChaos(t *testing.T) {
+	var got []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		got = append(got, string(body))
+	}))
+	defer server.Close()
+
+	rolls := []float64{5, 50, 50}
+	chaos := &Chaos{Drop: 10, Corrupt: 60, Delay: 60, DelayTime: 50 * time.Millisecond, roll: func() float64 {
+		r := rolls[0]
+		rolls = rolls[1:]
+		return r
+	}}
+	base, _ := url.Parse(server.URL)
+	c := (&HTTPClientConfig{BaseURL: base, Chaos: chaos}).NewHTTPClient()
+	req := []byte("POST /x HTTP/1.1\r\nHost: a.b\r\nContent-Length: 8\r\n\r\n{\"a\":12}")
+	if rsp, err := c.Send(req); err != nil || rsp != nil {
+		t.Errorf("request should be dropped, got %v, %v", rsp, err)
+	}
+
+	rolls = []float64{50, 50, 50}
+	start := time.Now()
+	if _, err := c.Send(req); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0] != `{"a"` {
+		t.Errorf("unexpected corrupted bodies %q", got)
+	}
+	if cost := time.Since(start); cost < chaos.DelayTime {
+		t.Errorf("cost %s should be delayed by %s", cost, chaos.DelayTime)
+	}
+
+	if err := ValidateChaos(&Chaos{Drop: 101}); err == nil {
+		t.Error("chaos drop 101 should be invalid")
+	}
+}
+
+func TestHTTPClientFollowRedirects(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/a":
+			http.Redirect(w, r, "/b", http.StatusFound)
+		case "/b":
+			http.Redirect(w, r, "/c", http.StatusMovedPermanently)
+		}
+	}))
+	defer server.Close()
+
+	base, _ := url.Parse(server.URL)
+	req := []byte("GET /a HTTP/1.1\r\nHost: a.b\r\n\r\n")
+	rsp, err := (&HTTPClientConfig{BaseURL: base}).NewHTTPClient().Send(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rsp.StatusCode != http.StatusFound || len(rsp.Redirects) != 0 {
+		t.Errorf("redirect should not be followed by default, got %d %v", rsp.StatusCode, rsp.Redirects)
+	}
+
+	rsp, err = (&HTTPClientConfig{BaseURL: base, FollowRedirects: 1}).NewHTTPClient().Send(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []RedirectHop{{URL: server.URL + "/a", StatusCode: http.StatusFound}}
+	if rsp.StatusCode != http.StatusMovedPermanently || fmt.Sprint(rsp.Redirects) != fmt.Sprint(expected) {
+		t.Errorf("unexpected redirects %d %v", rsp.StatusCode, rsp.Redirects)
+	}
+
+	rsp, err = (&HTTPClientConfig{BaseURL: base, FollowRedirects: 5}).NewHTTPClient().Send(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain := " redirects: 302 " + server.URL + "/a -> 301 " + server.URL + "/b"
+	if rsp.StatusCode != http.StatusOK || redirectChain(rsp.Redirects) != chain {
+		t.Errorf("unexpected redirects %d %s", rsp.StatusCode, redirectChain(rsp.Redirects))
+	}
+}
+
+func TestHTTPClientMinTLSVersion(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = &tls.Config{MaxVersion: tls.VersionTLS12}
+	server.StartTLS()
+	defer server.Close()
+
+	base, _ := url.Parse(server.URL)
+	req := []byte("GET /x HTTP/1.1\r\nHost: a.b\r\n\r\n")
+	v, err := ParseTLSVersion("1.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (&HTTPClientConfig{BaseURL: base, MinTLSVersion: v}).NewHTTPClient().Send(req); err != nil {
+		t.Errorf("TLS 1.2 should be negotiated, got %v", err)
+	}
+
+	v, _ = ParseTLSVersion("1.3")
+	if _, err := (&HTTPClientConfig{BaseURL: base, MinTLSVersion: v}).NewHTTPClient().Send(req); err == nil {
+		t.Error("TLS 1.2 target should be refused by min TLS 1.3")
+	}
+
+	if _, err := ParseTLSVersion("1.4"); err == nil {
+		t.Error("TLS version 1.4 should be invalid")
+	}
+}
+
+func TestHTTPClientHostHeader(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Host))
+	}))
+	defer server.Close()
+
+	base, _ := url.Parse(server.URL)
+	for hostHeader, want := range map[string]string{"": base.Host, HostPreserve: "a.b", "vhost.example.com": "vhost.example.com"} {
+		rsp, err := (&HTTPClientConfig{BaseURL: base, HostHeader: hostHeader}).NewHTTPClient().
+			Send([]byte("GET /x HTTP/1.1\r\nHost: a.b\r\n\r\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(rsp.ResponseBody); got != want {
+			t.Errorf("Host header %q: got Host %s, want %s", hostHeader, got, want)
+		}
+	}
+}
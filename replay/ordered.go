@@ -0,0 +1,70 @@
+package replay
+
+import (
+	"bytes"
+	"sync"
+)
+
+// TitleConnection parses the captured connection in the title,
+// like 127.0.0.1:5000-127.0.0.1:8080 in ### #1 REQ 127.0.0.1:5000-127.0.0.1:8080 2024-05-06T07:08:09Z.
+func TitleConnection(title []byte) string {
+	if m := titleEndpoints.FindSubmatch(title); m != nil {
+		return string(m[1]) + "-" + string(m[2])
+	}
+	return ""
+}
+
+// connQueues replays the requests of the same captured connection one after another in their order,
+// and the ones of the different connections concurrently, for Config.Ordered.
+type connQueues struct {
+	lock   sync.Mutex
+	queues map[string][]queuedMsg // the requests pending per connection, present while its goroutine is replaying
+}
+
+// queuedMsg is a request pending in the queue of its connection, with the semaphore of its target host.
+type queuedMsg struct {
+	payload Msg
+	sem     chan struct{}
+}
+
+// replay queues the payload to its connection, the goroutine of the connection acquires the semaphore
+// of the target host just before replaying each one, so the requests queued hold no slots of the others.
+func (q *connQueues) replay(inflight *sync.WaitGroup, sem chan struct{}, client *HTTPClient, payload Msg, fail *failure) {
+	// the payload buffer is reused by the parser
+	payload = Msg{Title: bytes.Clone(payload.Title), Data: bytes.Clone(payload.Data)}
+	key := TitleConnection(payload.Title)
+
+	q.lock.Lock()
+	if q.queues == nil {
+		q.queues = make(map[string][]queuedMsg)
+	}
+	pending, running := q.queues[key]
+	q.queues[key] = append(pending, queuedMsg{payload: payload, sem: sem})
+	q.lock.Unlock()
+	if running {
+		return
+	}
+
+	inflight.Add(1)
+	go func() {
+		defer inflight.Done()
+		for {
+			q.lock.Lock()
+			pending := q.queues[key]
+			if len(pending) == 0 {
+				delete(q.queues, key)
+				q.lock.Unlock()
+				return
+			}
+			next := pending[0]
+			q.queues[key] = pending[1:]
+			q.lock.Unlock()
+
+			if fail.Err() == nil {
+				next.sem <- struct{}{}
+				_ = fail.check(replay(client, next.payload))
+				<-next.sem
+			}
+		}
+	}()
+}
@@ -0,0 +1,80 @@
+package replay
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+)
+
+// targetURL returns the url to replay the request to, on the BaseURL, or on its original host if Original,
+// from the Host header or the absolute request uri, else the connection destination dst.
+func (c *HTTPClientConfig) targetURL(req *http.Request, dst string) (*url.URL, error) {
+	if !c.Original {
+		u := *c.BaseURL
+		u.Path = path.Join(u.Path, req.URL.Path)
+		u.RawPath = req.URL.RawPath
+		return &u, nil
+	}
+
+	host := req.Host
+	if host == "" {
+		host = dst
+	}
+	if host == "" {
+		return nil, fmt.Errorf("no original host of %s %s to replay to", req.Method, req.URL)
+	}
+
+	scheme := c.OriginalScheme
+	if scheme == "" {
+		scheme = req.URL.Scheme
+	}
+	if scheme == "" {
+		scheme = schemeOf(host)
+	}
+	return &url.URL{Scheme: scheme, Host: host, Path: req.URL.Path, RawPath: req.URL.RawPath, RawQuery: req.URL.RawQuery}, nil
+}
+
+// schemeOf returns https if the port of the host suggests TLS, or http.
+func schemeOf(host string) string {
+	if _, port, _ := net.SplitHostPort(host); port == "443" || port == "8443" {
+		return "https"
+	}
+	return "http"
+}
+
+var titleEndpoints = regexp.MustCompile(`\s(\S+:\d+)-(\S+:\d+)(\s|$)`)
+
+// TitleDestination parses the destination of the connection in the title,
+// like 127.0.0.1:8080 in ### #1 REQ 127.0.0.1:5000-127.0.0.1:8080 2024-05-06T07:08:09Z.
+func TitleDestination(title []byte) string {
+	if m := titleEndpoints.FindSubmatch(title); m != nil {
+		return string(m[2])
+	}
+	return ""
+}
+
+// semaphoreKey returns the host to limit the concurrency of replaying the payload per,
+// the host of the BaseURL, or the original host of the request if Original.
+func (c *HTTPClientConfig) semaphoreKey(payload Msg) string {
+	if !c.Original {
+		if c.BaseURL == nil {
+			return ""
+		}
+		return c.BaseURL.Host
+	}
+
+	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(payload.Data)))
+	if err != nil {
+		return "" // fails to replay anyway
+	}
+	u, err := c.targetURL(req, TitleDestination(payload.Title))
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
@@ -0,0 +1,77 @@
+package replay
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+)
+
+// PairRecord is a captured request with its original response, stored as one JSON object per line.
+type PairRecord struct {
+	Timestamp      string
+	Src, Dst       string
+	Request        []byte // raw http request
+	Status         int
+	ResponseHeader http.Header
+	ResponseBody   []byte
+}
+
+// Compare compares the replayed response with the original one.
+func (p *PairRecord) Compare(r *SendResponse) string {
+	switch {
+	case r.StatusCode != p.Status:
+		return "DIFF status"
+	case !bytes.Equal(r.ResponseBody, p.ResponseBody):
+		return "DIFF body"
+	default:
+		return "SAME"
+	}
+}
+
+// replayPairs replays the requests in the pairs file, and compares the responses with the original ones.
+func (c *Config) replayPairs(r io.Reader) error {
+	v := c.CreateHTTPClientConfig()
+	if v == nil {
+		return nil
+	}
+
+	client := v.NewHTTPClient()
+	fail := c.failFast()
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+
+	for scanner.Scan() {
+		var p PairRecord
+		if err := json.Unmarshal(scanner.Bytes(), &p); err != nil {
+			log.Printf("E! failed to parse pair record, error: %v", err)
+			continue
+		}
+
+		timestamp, _ := TitleTime([]byte(p.Timestamp))
+		rsp, err := client.SendAt(p.Request, timestamp)
+		if err != nil {
+			log.Printf("E! Failed to replay, error %v", err)
+			if err = fail.check(err); err != nil {
+				return err
+			}
+			continue
+		}
+		if rsp != nil {
+			result := p.Compare(rsp)
+			log.Printf("Replay: %s %s cost: %s %s status: %d original: %d %s%s",
+				rsp.Method, rsp.URL, rsp.Cost, rsp.Timings, rsp.StatusCode, p.Status, result, redirectChain(rsp.Redirects))
+			if result != "SAME" {
+				mismatch := fmt.Errorf("%s %s %s, status: %d original: %d", result, rsp.Method, rsp.URL, rsp.StatusCode, p.Status)
+				if err = fail.check(mismatch); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return scanner.Err()
+}
@@ -38,7 +38,10 @@ func (o *Options) ReadPayloads(r io.Reader) error {
 	})
 
 	g.Go(func() error {
-		return o.ConsumePayloadLines(lines)
+		err := o.ConsumePayloadLines(lines)
+		for range lines { // drain the lines left after a failure, for the producer to finish
+		}
+		return err
 	})
 
 	return g.Wait()
@@ -97,7 +100,8 @@ func (o *Options) ConsumePayloadLines(ch <-chan []byte) error {
 				}
 			}
 			started = true
-			b = &msg{Title: last}
+			// the scanner reuses the buffer of the lines
+			b = &msg{Title: bytes.Clone(last)}
 			if o.IncludingStart {
 				b.Write(pack)
 			}
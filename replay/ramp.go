@@ -0,0 +1,138 @@
+package replay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// ErrRampStopped is the error replaying stopped on, when the error rate of the ramp exceeds the max.
+var ErrRampStopped = errors.New("ramp stopped")
+
+// rampMinSamples are the min results in a window to evaluate its error rate, to avoid the noise of a few.
+const rampMinSamples = 10
+
+// Ramp ramps the rate of the replayed requests up linearly from From to To rps over the Duration,
+// then keeps To, and stops replaying when the error rate in a second exceeds MaxErrorRate.
+type Ramp struct {
+	From, To     float64
+	Duration     time.Duration
+	MaxErrorRate float64 // like 0.05 for 5%, the errors are the failures to send and the 5xx responses
+
+	// OnStop is called when the ramp stops on the max error rate, like to cancel the capturing.
+	OnStop func(err error)
+}
+
+// ParseRamp parses the ramp like 10:1000:60s, from 10 to 1000 rps over 60s.
+func ParseRamp(s string) (*Ramp, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("invalid ramp %s, should be like 10:1000:60s", s)
+	}
+
+	from, err1 := strconv.ParseFloat(parts[0], 64)
+	to, err2 := strconv.ParseFloat(parts[1], 64)
+	duration, err3 := time.ParseDuration(parts[2])
+	if err := errors.Join(err1, err2, err3); err != nil {
+		return nil, fmt.Errorf("invalid ramp %s: %w", s, err)
+	}
+	if from <= 0 || to <= 0 || duration <= 0 {
+		return nil, fmt.Errorf("invalid ramp %s, the rates and duration should be positive", s)
+	}
+
+	return &Ramp{From: from, To: to, Duration: duration}, nil
+}
+
+// RateAt returns the target rate at the elapsed time since the ramp started.
+func (r *Ramp) RateAt(elapsed time.Duration) float64 {
+	progress := min(float64(elapsed)/float64(r.Duration), 1)
+	return r.From + (r.To-r.From)*progress
+}
+
+// rampRunner paces the requests by the ramp, and evaluates the error rate of their results every second.
+type rampRunner struct {
+	*Ramp
+	limiter *rate.Limiter
+
+	sync.Mutex
+	start, windowStart time.Time
+	sent, errs         int
+	cost               time.Duration
+	err                error
+}
+
+func newRampRunner(r *Ramp) *rampRunner {
+	if r == nil {
+		return nil
+	}
+	return &rampRunner{Ramp: r, limiter: rate.NewLimiter(rate.Limit(r.From), 1)}
+}
+
+// wait waits for the next request to send at the current rate of the ramp.
+func (r *rampRunner) wait() {
+	if r == nil {
+		return
+	}
+
+	r.Lock()
+	if r.start.IsZero() {
+		r.start, r.windowStart = time.Now(), time.Now()
+	}
+	r.limiter.SetLimit(rate.Limit(r.RateAt(time.Since(r.start))))
+	r.Unlock()
+
+	_ = r.limiter.Wait(context.Background())
+}
+
+// record records the result of a request, and evaluates the window if a second passed.
+func (r *rampRunner) record(rsp *SendResponse, err error) {
+	if r == nil || rsp == nil {
+		return
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	r.sent++
+	r.cost += rsp.Cost
+	if err != nil || rsp.StatusCode >= 500 {
+		r.errs++
+	}
+
+	if time.Since(r.windowStart) < time.Second {
+		return
+	}
+
+	rps := r.RateAt(time.Since(r.start))
+	errorRate := float64(r.errs) / float64(r.sent)
+	log.Printf("I! Ramp %.0f rps: %d sent, %d errors (%.1f%%), avg cost %s",
+		rps, r.sent, r.errs, errorRate*100, r.cost/time.Duration(r.sent))
+	if r.err == nil && r.sent >= rampMinSamples && errorRate > r.MaxErrorRate {
+		r.err = fmt.Errorf("%w: error rate %.1f%% exceeded %.1f%% at %.0f rps",
+			ErrRampStopped, errorRate*100, r.MaxErrorRate*100, rps)
+		log.Printf("I! %v", r.err)
+		if r.OnStop != nil {
+			r.OnStop(r.err)
+		}
+	}
+	r.windowStart, r.sent, r.errs, r.cost = time.Now(), 0, 0, 0
+}
+
+// Err returns the error the ramp stopped on, nil if not stopped.
+func (r *rampRunner) Err() error {
+	if r == nil {
+		return nil
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	return r.err
+}
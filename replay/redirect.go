@@ -0,0 +1,45 @@
+package replay
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// RedirectHop is a redirect response followed when replaying, by FollowRedirects.
+type RedirectHop struct {
+	URL        string // the URL of the request redirected
+	StatusCode int
+}
+
+type redirectsKey struct{}
+
+// withRedirects returns the context collecting the redirect hops followed by the request into hops.
+func withRedirects(ctx context.Context, hops *[]RedirectHop) context.Context {
+	return context.WithValue(ctx, redirectsKey{}, hops)
+}
+
+// checkRedirect follows at most FollowRedirects redirects, collecting the hops followed,
+// the last redirect response is returned as is beyond the limit.
+func (c *HTTPClientConfig) checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) > c.FollowRedirects {
+		return http.ErrUseLastResponse
+	}
+	if hops, ok := req.Context().Value(redirectsKey{}).(*[]RedirectHop); ok && req.Response != nil {
+		*hops = append(*hops, RedirectHop{URL: via[len(via)-1].URL.String(), StatusCode: req.Response.StatusCode})
+	}
+	return nil
+}
+
+// redirectChain formats the redirect hops like " redirects: 302 http://a.b/x -> 301 http://a.b/y", empty if none.
+func redirectChain(hops []RedirectHop) string {
+	if len(hops) == 0 {
+		return ""
+	}
+	chain := make([]string, len(hops))
+	for i, h := range hops {
+		chain[i] = fmt.Sprintf("%d %s", h.StatusCode, h.URL)
+	}
+	return " redirects: " + strings.Join(chain, " -> ")
+}
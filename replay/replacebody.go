@@ -0,0 +1,90 @@
+package replay
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// BodyReplacer is a sed-like substitution on the replayed request bodies, like s/prod-bucket/staging-bucket/g.
+type BodyReplacer struct {
+	re     *regexp.Regexp
+	repl   []byte
+	global bool
+}
+
+// ParseBodyReplacer parses the rule s/regexp/replacement/flags, the separator is the char after s,
+// escaped by \ in the regexp and replacement, the replacement may refer the groups like $1,
+// flags g to replace all the matches instead of the first one, i to match case-insensitively.
+func ParseBodyReplacer(rule string) (*BodyReplacer, error) {
+	if len(rule) < 2 || rule[0] != 's' {
+		return nil, fmt.Errorf("invalid replace rule %s, should be like s/old/new/g", rule)
+	}
+
+	sep := rule[1]
+	parts := splitUnescaped(rule[2:], sep)
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("invalid replace rule %s, should be like s/old/new/g", rule)
+	}
+
+	pattern, flags := parts[0], parts[2]
+	if strings.Contains(flags, "i") {
+		pattern = "(?i)" + pattern
+	}
+	if strings.Trim(flags, "gi") != "" {
+		return nil, fmt.Errorf("invalid replace rule %s, unknown flags %s", rule, flags)
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid replace rule %s: %w", rule, err)
+	}
+
+	return &BodyReplacer{re: re, repl: []byte(parts[1]), global: strings.Contains(flags, "g")}, nil
+}
+
+// splitUnescaped splits s by the sep not escaped by \, and unescapes the escaped sep.
+func splitUnescaped(s string, sep byte) []string {
+	var parts []string
+	var part strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && i+1 < len(s) && s[i+1] == sep:
+			part.WriteByte(sep)
+			i++
+		case s[i] == sep:
+			parts = append(parts, part.String())
+			part.Reset()
+		default:
+			part.WriteByte(s[i])
+		}
+	}
+	return append(parts, part.String())
+}
+
+// Replace replaces the first match in the body, or all if global.
+func (r *BodyReplacer) Replace(body []byte) []byte {
+	if r.global {
+		return r.re.ReplaceAll(body, r.repl)
+	}
+
+	match := r.re.FindSubmatchIndex(body)
+	if match == nil {
+		return body
+	}
+	replaced := append([]byte{}, body[:match[0]]...)
+	replaced = r.re.Expand(replaced, r.repl, body, match)
+	return append(replaced, body[match[1]:]...)
+}
+
+// replaceBody applies the replacers in order on the body, the binary one, not valid UTF-8, is untouched unless force.
+func replaceBody(body []byte, replacers []*BodyReplacer, force bool) []byte {
+	if !force && !utf8.Valid(body) {
+		return body
+	}
+
+	for _, r := range replacers {
+		body = r.Replace(body)
+	}
+	return body
+}
@@ -1,7 +1,9 @@
 package replay
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"io/fs"
 	"log"
 	"math/rand"
@@ -11,6 +13,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/bingoohuang/gg/pkg/rest"
@@ -24,17 +27,87 @@ type Config struct {
 	Replay         string
 	Method         string
 	Timeout        time.Duration
-	RedirectLimit  int
+	RedirectLimit  int // the max number of the redirects to follow, 0 to replay the redirect responses as captured
 	InsecureVerify bool
 	Poll           bool
 	Verbose        string
+	Pairs          bool
+
+	StripAcceptEncoding bool
+	CSV                 string // the file to report the replayed requests, shared by the Configs of the same file
+	UseCookieJar        bool
+
+	// ReplayAfter and ReplayBefore are the time window of the recorded timestamps of the requests to replay.
+	ReplayAfter, ReplayBefore time.Time
+
+	// PerHostConcurrency replays the requests concurrently, at most the number in flight
+	// to the same target host, shared among the replay outputs. 0 replays them one by one.
+	PerHostConcurrency int
+
+	// Ordered replays the requests of the same captured connection one after another in their order with
+	// PerHostConcurrency, concurrently only across the connections, for the session-dependent flows.
+	// The connections are told by the endpoints in the titles, the requests without them are replayed in order.
+	Ordered bool
+
+	// ExpectContinueTimeout is the time to wait for the 100 Continue of the requests with Expect: 100-continue.
+	ExpectContinueTimeout time.Duration
+
+	// FailFast stops replaying on the first error, or the first response mismatch of the pairs,
+	// then StartReplay returns the failure, and OnFail is called if set, like to cancel the capturing.
+	FailFast bool
+	OnFail   func(err error)
+
+	// BodyDir is the dir of the body files dumped by -dump-body, to stream the request bodies from.
+	BodyDir string
+
+	// Loop replays the requests of the file repeatedly, re-reading the file each iteration,
+	// N times, or until the ctx is done if negative. 0 replays them once.
+	Loop int
+
+	// Ramp paces the replaying by the rate ramping up, to find the capacity of the target, nil for no pacing.
+	// The replaying stops when the error rate exceeds the max of the ramp, then StartReplay returns nil.
+	Ramp *Ramp
+
+	// ReplaceBody are the substitutions on the request bodies, the binary ones only if ForceReplaceBody.
+	ReplaceBody      []*BodyReplacer
+	ForceReplaceBody bool
+
+	// HealthCheck is checked by CheckHealth before replaying, nil for no check.
+	HealthCheck *HealthCheck
+
+	// Original replays the requests to their original hosts, from the Host headers or the connection destinations,
+	// instead of Replay, by OriginalScheme, or https for the port 443/8443 else http if empty.
+	Original       bool
+	OriginalScheme string
+
+	// MinTLSVersion is the min TLS version to negotiate with the https targets, like tls.VersionTLS12, 0 for the default.
+	MinTLSVersion uint16
+
+	// HostHeader is the Host header to send independently of the target, HostPreserve to keep the captured one,
+	// empty for the host of the target.
+	HostHeader string
+
+	// Chaos injects the faults of delaying, dropping or corrupting into the replayed requests, nil for no faults.
+	Chaos *Chaos
 
 	ReplayN        int
 	ReplayFraction float64
+
+	report *csvReport // the report of CSV while replaying
 }
 
-func (c *Config) StartReplay(ctx context.Context, payloadCh <-chan string) error {
-	options := c.createParseOptions()
+func (c *Config) StartReplay(ctx context.Context, payloadCh <-chan string) (err error) {
+	c.report = openCSVReport(c.CSV)
+	defer c.report.release() // after the replaying in flight
+	options, wait := c.createParseOptions()
+	defer func() {
+		if failure := wait(); err == nil {
+			err = failure
+		}
+		if errors.Is(err, ErrRampStopped) {
+			err = nil
+		}
+	}()
 
 	if c.File != "" {
 		file := strings.ReplaceAll(c.File, ":tail", "")
@@ -45,15 +118,11 @@ func (c *Config) StartReplay(ctx context.Context, payloadCh <-chan string) error
 		c.Poll = file != c.File
 		c.File = file
 
-		if dir, e := os.Stat(c.File); e == nil && dir.IsDir() {
-			return c.processDir(options)
-		}
-
 		if tail {
 			return c.processTail(ctx, options)
 		}
 
-		return c.processGlob(options)
+		return c.processLoop(ctx, options)
 	}
 
 	for {
@@ -62,6 +131,9 @@ func (c *Config) StartReplay(ctx context.Context, payloadCh <-chan string) error
 			return nil
 		case payload := <-payloadCh:
 			if err := options.ReadPayloads(strings.NewReader(payload)); err != nil {
+				if c.FailFast || errors.Is(err, ErrRampStopped) {
+					return err
+				}
 				log.Printf("E! failed to read payloads, error: %v", err)
 			}
 		}
@@ -79,6 +151,28 @@ func (c *Config) processTail(ctx context.Context, parseOptions *Options) error {
 	return t.TailPayloads(ctx)
 }
 
+// processLoop processes the file, the dir or the glob files, repeated by Config.Loop.
+func (c *Config) processLoop(ctx context.Context, options *Options) error {
+	process := c.processGlob
+	if dir, e := os.Stat(c.File); e == nil && dir.IsDir() {
+		process = c.processDir
+	}
+
+	for i := 1; c.Loop < 0 || i <= max(c.Loop, 1); i++ {
+		if c.Loop != 0 {
+			log.Printf("Replay loop #%d", i)
+		}
+		if err := process(options); err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+	}
+
+	return nil
+}
+
 func (c *Config) processGlob(parseOptions *Options) error {
 	glob, err := globpath.Compile(c.File)
 	if err != nil {
@@ -88,6 +182,9 @@ func (c *Config) processGlob(parseOptions *Options) error {
 	for _, file := range glob.Match() {
 		log.Printf("Processing file %s", file)
 		if pe := c.processFile(file, parseOptions); pe != nil {
+			if c.FailFast || errors.Is(pe, ErrRampStopped) {
+				return pe
+			}
 			err = multierr.Append(err, pe)
 		}
 	}
@@ -114,17 +211,36 @@ func (c *Config) processFile(file string, options *Options) error {
 
 	defer f.Close()
 
+	if c.Pairs {
+		return c.replayPairs(f)
+	}
+
 	return options.ReadPayloads(f)
 }
 
-func (c *Config) createParseOptions() *Options {
+// createParseOptions creates the options to parse the payloads and replay them,
+// with the wait function to wait for the replaying in flight, which returns the failure of FailFast.
+func (c *Config) createParseOptions() (*Options, func() error) {
+	var inflight sync.WaitGroup
+	var queues connQueues
+	fail := c.failFast()
 	payloadHandler := func(Msg) error { return nil }
 	if v := c.CreateHTTPClientConfig(); v != nil {
 		client := v.NewHTTPClient()
 		payloadHandler = func(payload Msg) error {
 			n := c.ReplayN + ss.Ifi(rand.Float64() < c.ReplayFraction, 1, 0)
 			for i := 0; i < n; i++ {
-				if err := replay(client, payload); err != nil {
+				if err := fail.Err(); err != nil {
+					return err
+				}
+				if err := client.ramp.Err(); err != nil {
+					return err
+				}
+				if c.PerHostConcurrency > 0 && c.Ordered {
+					queues.replay(&inflight, hostSemaphore(client.semaphoreKey(payload), c.PerHostConcurrency), client, payload, fail)
+				} else if c.PerHostConcurrency > 0 {
+					replayLimited(&inflight, hostSemaphore(client.semaphoreKey(payload), c.PerHostConcurrency), client, payload, fail)
+				} else if err := fail.check(replay(client, payload)); err != nil {
 					return err
 				}
 			}
@@ -140,22 +256,129 @@ func (c *Config) createParseOptions() *Options {
 		},
 		IncludingStart: true,
 		Handler:        payloadHandler,
+	}, func() error {
+		inflight.Wait()
+		return fail.Err()
 	}
 }
 
+// failure records the first failure of replaying, if Config.FailFast.
+type failure struct {
+	enabled bool
+	onFail  func(err error)
+
+	lock sync.Mutex
+	err  error
+}
+
+func (c *Config) failFast() *failure { return &failure{enabled: c.FailFast, onFail: c.OnFail} }
+
+// check records the err as the failure if it is the first one, and returns the failure.
+// It always returns nil if fail fast is not enabled.
+func (f *failure) check(err error) error {
+	if err == nil || !f.enabled {
+		return nil
+	}
+
+	f.lock.Lock()
+	first := f.err == nil
+	if first {
+		f.err = err
+	}
+	f.lock.Unlock()
+
+	if first {
+		log.Printf("E! Replay stopped on the first failure: %v", err)
+		if f.onFail != nil {
+			f.onFail(err)
+		}
+	}
+	return f.Err()
+}
+
+// Err returns the first failure recorded.
+func (f *failure) Err() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return f.err
+}
+
+// hostSemaphores are the semaphores limiting the concurrent replaying per target host.
+var hostSemaphores sync.Map
+
+func hostSemaphore(host string, n int) chan struct{} {
+	sem, _ := hostSemaphores.LoadOrStore(host, make(chan struct{}, n))
+	return sem.(chan struct{})
+}
+
+// replayLimited replays the payload in a goroutine, after acquiring the semaphore of the target host.
+func replayLimited(inflight *sync.WaitGroup, sem chan struct{}, client *HTTPClient, payload Msg, fail *failure) {
+	// the payload buffer is reused by the parser
+	payload = Msg{Title: bytes.Clone(payload.Title), Data: bytes.Clone(payload.Data)}
+
+	sem <- struct{}{}
+	inflight.Add(1)
+	go func() {
+		defer inflight.Done()
+		defer func() { <-sem }()
+
+		if fail.Err() == nil {
+			_ = fail.check(replay(client, payload))
+		}
+	}()
+}
+
 const layout = `2006-01-02 15:04:05.000000`
 
+// replay replays the payload, and returns the error of sending.
 func replay(client *HTTPClient, payload Msg) error {
+	timestamp, _ := TitleTime(payload.Title)
+	if !client.InWindow(timestamp) {
+		return nil
+	}
+
 	logTitle(payload.Title, "", "")
-	if r, err := client.Send(payload.Data); err != nil {
+	r, err := client.sendTo(payload.Data, timestamp, TitleDestination(payload.Title))
+	if err != nil {
 		log.Printf("E! Failed to replay, error %v", err)
 	} else if r != nil {
-		log.Printf("Replay: %s %s cost: %s status: %d", r.Method, r.URL, r.Cost, r.StatusCode)
+		log.Printf("Replay: %s %s cost: %s %s status: %d%s",
+			r.Method, r.URL, r.Cost, r.Timings, r.StatusCode, redirectChain(r.Redirects))
 	}
-	return nil
+	return err
 }
 
-var timeUnixNano = regexp.MustCompile(`\d{19,}`)
+var (
+	timeUnixNano = regexp.MustCompile(`\d{19,}`)
+	timeRFC3339  = regexp.MustCompile(`\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}:\d{2})`)
+)
+
+// TitleTime parses the recorded timestamp in the title, in RFC3339(Nano) like the httpdump output,
+// or in unix nanoseconds like the goreplay output.
+func TitleTime(title []byte) (time.Time, bool) {
+	if found := timeRFC3339.Find(title); found != nil {
+		if t, err := time.Parse(time.RFC3339Nano, string(found)); err == nil {
+			return t, true
+		}
+	}
+	if found := timeUnixNano.Find(title); found != nil {
+		if nano, err := strconv.ParseInt(string(found), 10, 64); err == nil {
+			return time.Unix(0, nano), true
+		}
+	}
+
+	return time.Time{}, false
+}
+
+// ParseTime parses the time in RFC3339 like 2024-05-06T07:08:09+08:00, or in the local time like 2024-05-06 07:08:09.
+func ParseTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+
+	return time.ParseInLocation(time.DateTime, s, time.Local)
+}
 
 func logTitle(title []byte, method, uri string) {
 	if len(title) == 0 {
@@ -185,15 +408,37 @@ func logTitle(title []byte, method, uri string) {
 }
 
 func (c *Config) CreateHTTPClientConfig() *HTTPClientConfig {
-	if c.Replay == "" {
+	if c.Replay == "" && !c.Original {
 		return nil
 	}
 
+	var baseURL *url.URL
+	if c.Replay != "" {
+		baseURL = rest.FixURI(c.Replay, rest.WithFatalErr(true)).Data
+	}
 	return &HTTPClientConfig{
 		Timeout:        c.Timeout,
 		InsecureVerify: c.InsecureVerify,
-		BaseURL:        rest.FixURI(c.Replay, rest.WithFatalErr(true)).Data,
+		BaseURL:        baseURL,
 		Methods:        c.Method,
 		Verbose:        c.Verbose,
+
+		StripAcceptEncoding: c.StripAcceptEncoding,
+		report:              c.report,
+		UseCookieJar:        c.UseCookieJar,
+		After:               c.ReplayAfter,
+		Before:              c.ReplayBefore,
+
+		ExpectContinueTimeout: c.ExpectContinueTimeout,
+		BodyDir:               c.BodyDir,
+		Ramp:                  c.Ramp,
+		ReplaceBody:           c.ReplaceBody,
+		ForceReplaceBody:      c.ForceReplaceBody,
+		Original:              c.Original,
+		OriginalScheme:        c.OriginalScheme,
+		Chaos:                 c.Chaos,
+		FollowRedirects:       c.RedirectLimit,
+		MinTLSVersion:         c.MinTLSVersion,
+		HostHeader:            c.HostHeader,
 	}
 }
@@ -1,7 +1,398 @@
 package replay
 
-import "testing"
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
 
 func TestLogTitle(t *testing.T) {
 	logTitle([]byte(`1 fda9138b7f0000016ac0ad3e 1621835869410250000 0`), "POST", "/solr/demo")
 }
+
+func TestPerHostConcurrency(t *testing.T) {
+	var running int32
+	arrived, release := make(chan int32), make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		arrived <- atomic.AddInt32(&running, 1)
+		<-release
+		atomic.AddInt32(&running, -1)
+	}))
+	defer server.Close()
+
+	c := &Config{Replay: server.URL, ReplayN: 1, PerHostConcurrency: 2}
+	options, wait := c.createParseOptions()
+	payloads := strings.Repeat("GET /x HTTP/1.1\r\nHost: a.b\r\n\r\n", 6)
+	read := make(chan error, 1)
+	go func() { read <- options.ReadPayloads(strings.NewReader(payloads)) }()
+
+	// two requests run at first, then each one released lets the next one in
+	for i := 0; i < 6; i++ {
+		select {
+		case n := <-arrived:
+			if n > 2 {
+				t.Errorf("request %d arrived with %d running, expected at most 2", i+1, n)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("only %d requests arrived", i)
+		}
+		if i == 1 { // the semaphore of the host is full by the two
+			if sem := hostSemaphore(server.Listener.Addr().String(), 0); len(sem) != 2 || cap(sem) != 2 {
+				t.Errorf("semaphore %d/%d, expected 2/2", len(sem), cap(sem))
+			}
+		}
+		if i > 0 {
+			release <- struct{}{}
+		}
+	}
+	release <- struct{}{}
+	if err := <-read; err != nil {
+		t.Fatal(err)
+	}
+	if err := wait(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPerHostConcurrencyOriginal(t *testing.T) {
+	bStarted := make(chan struct{})
+	var overlapped int32
+	a := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select { // the request to the other host is replayed along with this one
+		case <-bStarted:
+			atomic.StoreInt32(&overlapped, 1)
+		case <-time.After(time.Second):
+		}
+	}))
+	defer a.Close()
+	b := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { close(bStarted) }))
+	defer b.Close()
+
+	c := &Config{Original: true, ReplayN: 1, PerHostConcurrency: 1}
+	options, wait := c.createParseOptions()
+	payloads := ""
+	for _, u := range []string{a.URL, b.URL} {
+		payloads += "GET /x HTTP/1.1\r\nHost: " + strings.TrimPrefix(u, "http://") + "\r\n\r\n"
+	}
+	if err := options.ReadPayloads(strings.NewReader(payloads)); err != nil {
+		t.Fatal(err)
+	}
+	wait()
+
+	if overlapped != 1 {
+		t.Error("the original hosts should be limited by their own semaphores")
+	}
+}
+
+func TestOrdered(t *testing.T) {
+	var lock sync.Mutex
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/a") {
+			time.Sleep(10 * time.Millisecond) // the later ones of the connection must wait
+		}
+		lock.Lock()
+		paths = append(paths, r.URL.Path)
+		lock.Unlock()
+	}))
+	defer server.Close()
+
+	c := &Config{Replay: server.URL, ReplayN: 1, PerHostConcurrency: 4, Ordered: true}
+	options, wait := c.createParseOptions()
+	payloads := ""
+	for _, p := range []string{"/a1", "/b1", "/a2", "/b2", "/a3"} {
+		conn := "127.0.0.1:5000-127.0.0.1:8080"
+		if p[1] == 'b' {
+			conn = "127.0.0.1:5001-127.0.0.1:8080"
+		}
+		payloads += "### #1 REQ " + conn + " 2024-05-06T07:08:09Z\nGET " + p + " HTTP/1.1\r\nHost: a.b\r\n\r\n"
+	}
+	if err := options.ReadPayloads(strings.NewReader(payloads)); err != nil {
+		t.Fatal(err)
+	}
+	wait()
+
+	var a []string
+	for _, p := range paths {
+		if strings.HasPrefix(p, "/a") {
+			a = append(a, p)
+		}
+	}
+	if len(paths) != 5 || strings.Join(a, ",") != "/a1,/a2,/a3" || paths[0] != "/b1" {
+		t.Errorf("unexpected replayed order %v", paths)
+	}
+}
+
+func TestOrderedQueuedHoldNoSlots(t *testing.T) {
+	var lock sync.Mutex
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/a") {
+			time.Sleep(20 * time.Millisecond)
+		}
+		lock.Lock()
+		paths = append(paths, r.URL.Path)
+		lock.Unlock()
+	}))
+	defer server.Close()
+
+	// the requests queued behind /a1 take no slots, /b1 of another connection is replayed along with /a1
+	c := &Config{Replay: server.URL, ReplayN: 1, PerHostConcurrency: 2, Ordered: true}
+	options, wait := c.createParseOptions()
+	payloads := ""
+	for _, p := range []string{"/a1", "/a2", "/a3", "/b1"} {
+		conn := "127.0.0.1:5000-127.0.0.1:8080"
+		if p[1] == 'b' {
+			conn = "127.0.0.1:5001-127.0.0.1:8080"
+		}
+		payloads += "### #1 REQ " + conn + " 2024-05-06T07:08:09Z\nGET " + p + " HTTP/1.1\r\nHost: a.b\r\n\r\n"
+	}
+	if err := options.ReadPayloads(strings.NewReader(payloads)); err != nil {
+		t.Fatal(err)
+	}
+	wait()
+
+	if strings.Join(paths, ",") != "/b1,/a1,/a2,/a3" {
+		t.Errorf("unexpected replayed order %v", paths)
+	}
+}
+
+func TestTitleConnection(t *testing.T) {
+	title := []byte("### #1 REQ 127.0.0.1:5000-127.0.0.1:8080 2024-05-06T07:08:09Z")
+	if c := TitleConnection(title); c != "127.0.0.1:5000-127.0.0.1:8080" {
+		t.Errorf("unexpected connection %s", c)
+	}
+	if c := TitleConnection([]byte("### #1 GET http://a.b/c")); c != "" {
+		t.Errorf("unexpected connection %s", c)
+	}
+}
+
+func TestTitleTime(t *testing.T) {
+	tm, ok := TitleTime([]byte("### #1 REQ 127.0.0.1:5000-127.0.0.1:8080 2024-05-06T07:08:09.123456789+08:00"))
+	if !ok || tm.UnixNano() != 1714950489123456789 {
+		t.Errorf("unexpected time %v", tm)
+	}
+	tm, ok = TitleTime([]byte("1 fda9138b7f0000016ac0ad3e 1621835869410250000 0"))
+	if !ok || tm.UnixNano() != 1621835869410250000 {
+		t.Errorf("unexpected time %v", tm)
+	}
+	if _, ok = TitleTime([]byte("### #1 GET http://a.b/c")); ok {
+		t.Error("no time expected")
+	}
+}
+
+func TestInWindow(t *testing.T) {
+	after, _ := ParseTime("2024-05-06T07:08:00+08:00")
+	before, _ := ParseTime("2024-05-06T07:09:00+08:00")
+	c := &HTTPClientConfig{After: after, Before: before}
+	for ts, expected := range map[string]bool{
+		"2024-05-06T07:07:59+08:00": false,
+		"2024-05-06T07:08:00+08:00": true,
+		"2024-05-06T07:08:30+08:00": true,
+		"2024-05-06T07:09:00+08:00": false,
+	} {
+		tm, _ := ParseTime(ts)
+		if c.InWindow(tm) != expected {
+			t.Errorf("InWindow(%s) should be %t", ts, expected)
+		}
+	}
+	if c.InWindow(time.Time{}) || c.unknownTimes != 1 {
+		t.Error("the unknown timestamp should be out of window")
+	}
+	if !(&HTTPClientConfig{}).InWindow(time.Time{}) {
+		t.Error("the unknown timestamp should be in without window")
+	}
+}
+
+func TestReplayWindowUnknownTime(t *testing.T) {
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+	}))
+	defer server.Close()
+
+	after, _ := ParseTime("2024-05-06T07:08:00Z")
+	c := &Config{Replay: server.URL, ReplayN: 1, ReplayAfter: after}
+	options, wait := c.createParseOptions()
+	payloads := "### #1 REQ 127.0.0.1:5000-127.0.0.1:8080 2024-05-06T07:08:09Z\nGET /in HTTP/1.1\r\nHost: a.b\r\n\r\n" +
+		"### #2 REQ 127.0.0.1:5000-127.0.0.1:8080 2024-05-06T07:07:09Z\nGET /before HTTP/1.1\r\nHost: a.b\r\n\r\n" +
+		"### #3 REQ 127.0.0.1:5000-127.0.0.1:8080 yesterday\nGET /unparsable HTTP/1.1\r\nHost: a.b\r\n\r\n" +
+		"GET /untitled HTTP/1.1\r\nHost: a.b\r\n\r\n"
+	if err := options.ReadPayloads(strings.NewReader(payloads)); err != nil {
+		t.Fatal(err)
+	}
+	if err := wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Join(paths, ",") != "/in" {
+		t.Errorf("unexpected replayed %v", paths)
+	}
+}
+
+func TestFailFast(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.Close() // all the requests fail
+
+	var failed error
+	c := &Config{Replay: server.URL, ReplayN: 1, FailFast: true, OnFail: func(err error) { failed = err }}
+	options, wait := c.createParseOptions()
+	payloads := strings.Repeat("GET /x HTTP/1.1\r\nHost: a.b\r\n\r\n", 3)
+	if err := options.ReadPayloads(strings.NewReader(payloads)); err == nil || err != failed {
+		t.Errorf("the first failure expected, got %v", err)
+	}
+	if err := wait(); err != failed {
+		t.Errorf("the failure expected from wait, got %v", err)
+	}
+
+	c.FailFast = false
+	options, wait = c.createParseOptions()
+	if err := options.ReadPayloads(strings.NewReader(payloads)); err != nil {
+		t.Errorf("no error expected without fail fast, got %v", err)
+	}
+	if err := wait(); err != nil {
+		t.Errorf("no error expected without fail fast, got %v", err)
+	}
+}
+
+func TestFailFastPairs(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("changed"))
+	}))
+	defer server.Close()
+
+	pairs := strings.Repeat(`{"Request":"R0VUIC94IEhUVFAvMS4xDQpIb3N0OiBhLmINCg0K","Status":200,"ResponseBody":"b3JpZ2luYWw="}`+"\n", 2)
+	c := &Config{Replay: server.URL, FailFast: true}
+	if err := c.replayPairs(strings.NewReader(pairs)); err == nil || !strings.Contains(err.Error(), "DIFF body") {
+		t.Errorf("DIFF body expected, got %v", err)
+	}
+
+	c.FailFast = false
+	if err := c.replayPairs(strings.NewReader(pairs)); err != nil {
+		t.Errorf("no error expected without fail fast, got %v", err)
+	}
+}
+
+func TestSenderSendAfterClose(t *testing.T) {
+	s := &Sender{ch: make(chan string, 1), done: make(chan struct{})}
+	_ = s.Close()
+	_ = s.Close()
+	s.Send("GET /x HTTP/1.1\r\n\r\n", true) // dropped, not panicking on the closed channel
+	if _, ok := <-s.ch; ok {
+		t.Error("no message expected after close")
+	}
+}
+
+func TestLoop(t *testing.T) {
+	var done int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&done, 1)
+	}))
+	defer server.Close()
+
+	file := filepath.Join(t.TempDir(), "requests.txt")
+	payloads := strings.Repeat("GET /x HTTP/1.1\r\nHost: a.b\r\n\r\n", 2)
+	if err := os.WriteFile(file, []byte(payloads), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := &Config{Replay: server.URL, ReplayN: 1, File: file, Loop: 3}
+	if err := c.StartReplay(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if done != 6 {
+		t.Errorf("done %d, expected 6 by 3 loops of 2 requests", done)
+	}
+}
+
+func TestRamp(t *testing.T) {
+	r, err := ParseRamp("10:1000:60s")
+	if err != nil || r.From != 10 || r.To != 1000 || r.Duration != time.Minute {
+		t.Fatalf("unexpected ramp %+v, error %v", r, err)
+	}
+	if rps := r.RateAt(30 * time.Second); rps != 505 {
+		t.Errorf("rate at 30s %f, expected 505", rps)
+	}
+	if rps := r.RateAt(2 * time.Minute); rps != 1000 {
+		t.Errorf("rate after the duration %f, expected 1000", rps)
+	}
+	for _, s := range []string{"10:1000", "a:1000:60s", "10:1000:0s"} {
+		if _, err := ParseRamp(s); err == nil {
+			t.Errorf("ramp %s should be invalid", s)
+		}
+	}
+
+	var sent int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&sent, 1) > 5 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	var stopped error
+	ramp := &Ramp{From: 20, To: 20, Duration: time.Second, MaxErrorRate: 0.5, OnStop: func(err error) { stopped = err }}
+	dir := t.TempDir()
+	file := filepath.Join(dir, "requests.http")
+	if err := os.WriteFile(file, []byte(strings.Repeat("GET /x HTTP/1.1\r\nHost: a.b\r\n\r\n", 100)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	c := &Config{Replay: server.URL, ReplayN: 1, File: file, Ramp: ramp}
+	if err := c.StartReplay(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if !errors.Is(stopped, ErrRampStopped) || sent >= 100 {
+		t.Errorf("the ramp should stop, stopped %v, sent %d", stopped, sent)
+	}
+}
+
+func TestCheckHealth(t *testing.T) {
+	var got string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.URL.RequestURI()
+		if r.URL.Path != "/base/healthz" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	h, err := ParseHealthCheck("/healthz?full=1", "2xx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &Config{Replay: server.URL + "/base", HealthCheck: h}
+	if err := c.CheckHealth(context.Background()); err != nil {
+		t.Errorf("healthy expected, got %v", err)
+	}
+	if got != "/base/healthz?full=1" {
+		t.Errorf("unexpected health check uri %s", got)
+	}
+
+	c.HealthCheck, _ = ParseHealthCheck("/down", "200,204")
+	if err := c.CheckHealth(context.Background()); err == nil || !strings.Contains(err.Error(), "status 503") {
+		t.Errorf("unhealthy expected, got %v", err)
+	}
+
+	server.Close()
+	c.HealthCheck = h
+	if err := c.CheckHealth(context.Background()); err == nil {
+		t.Error("unreachable expected")
+	}
+
+	for _, status := range []string{"", "2x", "abc", "2xx,1000"} {
+		if _, err := ParseHealthCheck("/healthz", status); err == nil {
+			t.Errorf("invalid status %q expected", status)
+		}
+	}
+	if _, err := ParseHealthCheck("healthz", "2xx"); err == nil {
+		t.Error("invalid path expected")
+	}
+}
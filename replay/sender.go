@@ -7,34 +7,71 @@ import (
 )
 
 type Sender struct {
-	ch chan string
+	ch   chan string
+	done chan struct{}
+	err  error
+
+	lock   sync.RWMutex
+	closed bool
 }
 
+// Close ends the messages to replay, the messages sent after it are dropped,
+// like by the handlers still running after the drain timeout on shutdown.
 func (ss *Sender) Close() error {
-	close(ss.ch)
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+
+	if !ss.closed {
+		ss.closed = true
+		close(ss.ch)
+	}
 	return nil
 }
 
+// ChanOccupancy reports the occupancy of the channel buffering messages to replay.
+func (ss *Sender) ChanOccupancy() (name string, length, capacity int) {
+	return "out-chan", len(ss.ch), cap(ss.ch)
+}
+
 func (ss *Sender) Send(msg string, countDiscards bool) {
 	if !countDiscards {
 		return
 	}
-	ss.ch <- msg
+
+	ss.lock.RLock()
+	defer ss.lock.RUnlock()
+
+	if ss.closed {
+		return
+	}
+	select {
+	case ss.ch <- msg:
+	case <-ss.done: // replaying stopped, like by fail fast
+	}
+}
+
+// Err returns the error replaying stopped on, available after the replaying is done.
+func (ss *Sender) Err() error {
+	select {
+	case <-ss.done:
+		return ss.err
+	default:
+		return nil
+	}
 }
 
-func CreateSender(ctx context.Context, wg *sync.WaitGroup, method, file, verbose, addr string, chanSize uint,
-	replayN int, replayFraction float64,
-) *Sender {
-	rc := Config{Method: method, File: file, Verbose: verbose, Replay: addr, ReplayN: replayN, ReplayFraction: replayFraction}
-	ch := make(chan string, chanSize)
+// CreateSender creates a Sender to replay the messages by the config rc.
+func CreateSender(ctx context.Context, wg *sync.WaitGroup, rc Config, chanSize uint) *Sender {
+	s := &Sender{ch: make(chan string, chanSize), done: make(chan struct{})}
 	wg.Add(1)
 
 	go func() {
 		defer wg.Done()
-		if err := rc.StartReplay(ctx, ch); err != nil {
-			log.Printf("E! start replay err: %v", err)
+		defer close(s.done)
+		if s.err = rc.StartReplay(ctx, s.ch); s.err != nil {
+			log.Printf("E! start replay err: %v", s.err)
 		}
 	}()
 
-	return &Sender{ch: ch}
+	return s
 }
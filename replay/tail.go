@@ -130,7 +130,11 @@ func (t *Tail) tailing(ctx context.Context, tailer *tail.Tail, lines chan []byte
 		case line, _ := <-tailer.Lines:
 			if line != nil {
 				if line.Text != "" {
-					lines <- []byte(line.Text + "\n")
+					select {
+					case lines <- []byte(line.Text + "\n"):
+					case <-ctx.Done():
+						return ctx.Err()
+					}
 				}
 
 				if line.Err != nil {
@@ -0,0 +1,170 @@
+package replay
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/csv"
+	"fmt"
+	"log"
+	"net/http/httptrace"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Timings are the fine-grained timings of a replayed request,
+// zero if the phase did not happen, like DNS and connect on a reused connection.
+type Timings struct {
+	DNS     time.Duration
+	Connect time.Duration
+	TLS     time.Duration
+	TTFB    time.Duration // time to first response byte since the request started
+}
+
+func (t Timings) String() string {
+	return fmt.Sprintf("dns: %s connect: %s tls: %s ttfb: %s", t.DNS, t.Connect, t.TLS, t.TTFB)
+}
+
+// withTimingTrace returns the context tracing the timings of the request started at start.
+func withTimingTrace(ctx context.Context, timings *Timings, start time.Time) context.Context {
+	var mu sync.Mutex // dialing may race in multiple goroutines
+	var dnsStart, connectStart, tlsStart time.Time
+	set := func(f func()) {
+		mu.Lock()
+		defer mu.Unlock()
+		f()
+	}
+
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		DNSStart:          func(httptrace.DNSStartInfo) { set(func() { dnsStart = time.Now() }) },
+		DNSDone:           func(httptrace.DNSDoneInfo) { set(func() { timings.DNS = time.Since(dnsStart) }) },
+		ConnectStart:      func(_, _ string) { set(func() { connectStart = time.Now() }) },
+		ConnectDone:       func(_, _ string, _ error) { set(func() { timings.Connect = time.Since(connectStart) }) },
+		TLSHandshakeStart: func() { set(func() { tlsStart = time.Now() }) },
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			set(func() { timings.TLS = time.Since(tlsStart) })
+		},
+		GotFirstResponseByte: func() { set(func() { timings.TTFB = time.Since(start) }) },
+	})
+}
+
+// csvReport writes the results of the replayed requests to a CSV file.
+type csvReport struct {
+	sync.Mutex
+	f      *os.File
+	w      *csv.Writer
+	closed bool
+
+	refs int // the replay outputs sharing the report, by csvReportsLock
+}
+
+// csvReports are the CSV reports opened by the files, shared among the replay outputs of the same file,
+// closed when released by all of them.
+var (
+	csvReportsLock sync.Mutex
+	csvReports     = map[string]*csvReport{}
+)
+
+// openCSVReport opens the report of the file once, shared by the callers, each releases it when done,
+// nil if file is empty or fails to create.
+func openCSVReport(file string) *csvReport {
+	if file == "" {
+		return nil
+	}
+
+	csvReportsLock.Lock()
+	defer csvReportsLock.Unlock()
+
+	r := csvReports[file]
+	if r == nil {
+		var err error
+		if r, err = newCSVReport(file); err != nil {
+			log.Printf("E! create replay csv report %s failed: %v", file, err)
+			return nil
+		}
+		csvReports[file] = r
+	}
+	r.refs++
+	return r
+}
+
+// release releases the report by a caller of openCSVReport, closed when released by all.
+func (r *csvReport) release() {
+	if r == nil {
+		return
+	}
+
+	csvReportsLock.Lock()
+	defer csvReportsLock.Unlock()
+
+	if r.refs--; r.refs == 0 {
+		for file, v := range csvReports {
+			if v == r {
+				delete(csvReports, file)
+			}
+		}
+		if err := r.close(); err != nil {
+			log.Printf("E! close replay csv report failed: %v", err)
+		}
+	}
+}
+
+func newCSVReport(file string) (*csvReport, error) {
+	f, err := os.Create(file)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &csvReport{f: f, w: csv.NewWriter(f)}
+	if err := r.w.Write([]string{"time", "method", "url", "status", "cost_ms", "dns_ms", "connect_ms", "tls_ms", "ttfb_ms", "error"}); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *csvReport) Write(rsp *SendResponse, err error) {
+	if r == nil || rsp == nil {
+		return
+	}
+
+	errMsg := ""
+	if err != nil {
+		errMsg = err.Error()
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	if r.closed {
+		return
+	}
+	t := rsp.Timings
+	_ = r.w.Write([]string{
+		time.Now().Format(time.RFC3339Nano), rsp.Method, rsp.URL, strconv.Itoa(rsp.StatusCode),
+		ms(rsp.Cost), ms(t.DNS), ms(t.Connect), ms(t.TLS), ms(t.TTFB), errMsg,
+	})
+	r.w.Flush()
+}
+
+// close flushes and closes the file, the results written after are dropped.
+func (r *csvReport) close() error {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	r.w.Flush()
+	err := r.w.Error()
+	if err1 := r.f.Close(); err == nil {
+		err = err1
+	}
+	return err
+}
+
+func ms(d time.Duration) string {
+	return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 3, 64)
+}
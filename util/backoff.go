@@ -0,0 +1,15 @@
+package util
+
+import "time"
+
+const (
+	// ReconnectMinBackoff and ReconnectMaxBackoff bound the interval between the attempts
+	// to reopen the live capture.
+	ReconnectMinBackoff = time.Second
+	ReconnectMaxBackoff = 30 * time.Second
+)
+
+// NextBackoff doubles the backoff of the last attempt, within ReconnectMinBackoff and ReconnectMaxBackoff.
+func NextBackoff(last time.Duration) time.Duration {
+	return min(max(2*last, ReconnectMinBackoff), ReconnectMaxBackoff)
+}
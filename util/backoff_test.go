@@ -0,0 +1,16 @@
+package util
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNextBackoff(t *testing.T) {
+	assert.Equal(t, time.Second, NextBackoff(0))
+	assert.Equal(t, 2*time.Second, NextBackoff(time.Second))
+	assert.Equal(t, 16*time.Second, NextBackoff(8*time.Second))
+	assert.Equal(t, 30*time.Second, NextBackoff(16*time.Second))
+	assert.Equal(t, 30*time.Second, NextBackoff(30*time.Second))
+}
@@ -100,6 +100,69 @@ func ParseResponseTitle(payload []byte) (code int, yes bool) {
 	return status, true
 }
 
+// TitleProto parses the protocol version from the title of an HTTP/1 request or response.
+func TitleProto(payload []byte) (major, minor int, ok bool) {
+	titleLen := bytes.Index(payload, CRLF)
+	if titleLen == -1 {
+		return 0, 0, false
+	}
+	title := SliceToString(payload[:titleLen])
+	if !strings.HasPrefix(title, "HTTP/") {
+		title = title[strings.LastIndexByte(title, ' ')+1:]
+	} else if len(title) >= VersionLen {
+		title = title[:VersionLen]
+	}
+
+	return http.ParseHTTPVersion(title)
+}
+
+// KeepAlive reports whether the connection persists after the HTTP/1 message in the payload.
+// HTTP/1.0 closes the connection unless "Connection: keep-alive", HTTP/1.1 persists unless "Connection: close".
+func KeepAlive(payload []byte) bool {
+	major, minor, ok := TitleProto(payload)
+	if !ok {
+		return true
+	}
+
+	conn := strings.ToLower(string(Header(payload, []byte("Connection"))))
+	if major == 1 && minor == 0 {
+		return strings.Contains(conn, "keep-alive")
+	}
+
+	return !strings.Contains(conn, "close")
+}
+
+// BodyUntilClose reports whether the body of the HTTP/1 response in the payload is delimited
+// by the connection close, that is, it has neither Content-Length nor chunked Transfer-Encoding
+// on a non-persistent connection.
+func BodyUntilClose(payload []byte) bool {
+	code, yes := ParseResponseTitle(payload)
+	if !yes || code < 200 || code == http.StatusNoContent || code == http.StatusNotModified {
+		return false
+	}
+	if len(Header(payload, []byte("Content-Length"))) > 0 || len(Header(payload, []byte("Transfer-Encoding"))) > 0 {
+		return false
+	}
+
+	return !KeepAlive(payload)
+}
+
+// IsInformational reports whether the status code is of an interim 1xx response preceding the final one,
+// except 101 Switching Protocols, which is final.
+func IsInformational(code int) bool {
+	return code >= 100 && code < 200 && code != http.StatusSwitchingProtocols
+}
+
+// InformationalLen returns the length of the complete 1xx informational response at the start of the payload,
+// like 100 Continue or 103 Early Hints, which has no body, or 0 if there is none.
+func InformationalLen(payload []byte) int {
+	if code, yes := ParseResponseTitle(payload); !yes || !IsInformational(code) {
+		return 0
+	}
+
+	return max(MIMEHeadersEndPos(payload), 0)
+}
+
 // SliceToString preferred for large body payload (zero allocation and faster)
 func SliceToString(buf []byte) string {
 	return *(*string)(unsafe.Pointer(&buf))
@@ -19,6 +19,31 @@ func TestParseRequestTitle(t *testing.T) {
 	assert.True(t, Http1EndHint(rb.Bytes()))
 }
 
+func TestKeepAlive(t *testing.T) {
+	assert.True(t, KeepAlive([]byte("GET / HTTP/1.1\r\nHost: a.b\r\n\r\n")))
+	assert.False(t, KeepAlive([]byte("GET / HTTP/1.1\r\nConnection: close\r\n\r\n")))
+	assert.False(t, KeepAlive([]byte("GET / HTTP/1.0\r\nHost: a.b\r\n\r\n")))
+	assert.True(t, KeepAlive([]byte("GET / HTTP/1.0\r\nConnection: Keep-Alive\r\n\r\n")))
+	assert.False(t, KeepAlive([]byte("HTTP/1.0 200 OK\r\nServer: x\r\n\r\n")))
+	assert.True(t, KeepAlive([]byte("HTTP/1.1 200 OK\r\nServer: x\r\n\r\n")))
+}
+
+func TestBodyUntilClose(t *testing.T) {
+	assert.True(t, BodyUntilClose([]byte("HTTP/1.0 200 OK\r\nServer: x\r\n\r\nbody")))
+	assert.True(t, BodyUntilClose([]byte("HTTP/1.1 200 OK\r\nConnection: close\r\n\r\nbody")))
+	assert.False(t, BodyUntilClose([]byte("HTTP/1.0 200 OK\r\nContent-Length: 4\r\n\r\nbody")))
+	assert.False(t, BodyUntilClose([]byte("HTTP/1.0 304 Not Modified\r\n\r\n")))
+	assert.False(t, BodyUntilClose([]byte("HTTP/1.1 200 OK\r\nServer: x\r\n\r\n")))
+}
+
+func TestInformationalLen(t *testing.T) {
+	assert.Equal(t, 25, InformationalLen([]byte("HTTP/1.1 100 Continue\r\n\r\nHTTP/1.1 200 OK\r\n\r\n")))
+	assert.Equal(t, 61, InformationalLen([]byte("HTTP/1.1 103 Early Hints\r\nLink: </style.css>; rel=preload\r\n\r\n")))
+	assert.Equal(t, 0, InformationalLen([]byte("HTTP/1.1 103 Early Hints\r\nLink: </style.css>")))
+	assert.Equal(t, 0, InformationalLen([]byte("HTTP/1.1 101 Switching Protocols\r\n\r\n")))
+	assert.Equal(t, 0, InformationalLen([]byte("HTTP/1.1 200 OK\r\n\r\n")))
+}
+
 func TestHeader(t *testing.T) {
 	var payload, val []byte
 	var headerStart int
@@ -16,3 +16,7 @@ func (i *IntSetFlag) Set(value string) error {
 func (i *IntSetFlag) Contains(value int) bool {
 	return (IntSet)(*i).Contains(value)
 }
+
+func (i *IntSetFlag) Matches(value int) bool {
+	return (IntSet)(*i).Matches(value)
+}
@@ -77,6 +77,11 @@ func (s IntSet) Contains(value int) bool {
 	return false
 }
 
+// Matches checks if this set is not empty and contains int value, unlike Contains which matches all for the empty set.
+func (s IntSet) Matches(value int) bool {
+	return len(s.ranges) > 0 && s.Contains(value)
+}
+
 // IntRange is a ange of int value.
 type IntRange struct {
 	Start, End int // inclusive
@@ -19,3 +19,13 @@ func TestParseIntSet(t *testing.T) {
 	assert.Equal(t, 1, intRange.ranges[1].Start)
 	assert.Equal(t, 2, intRange.ranges[1].End)
 }
+
+func TestIntSet_Matches(t *testing.T) {
+	assert.True(t, IntSet{}.Contains(200))
+	assert.False(t, IntSet{}.Matches(200))
+
+	intSet, err := ParseIntSet("200-299")
+	assert.NoError(t, err)
+	assert.True(t, intSet.Matches(204))
+	assert.False(t, intSet.Matches(500))
+}
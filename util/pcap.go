@@ -1,18 +1,22 @@
 package util
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/bingoohuang/gg/pkg/ss"
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
 	"github.com/google/gopacket/pcap"
+	"github.com/google/gopacket/pcapgo"
 )
 
 type Assembler interface {
@@ -21,7 +25,21 @@ type Assembler interface {
 	FinishAll()
 }
 
-func LoopPackets(ctx context.Context, packets chan gopacket.Packet, assembler Assembler, idle time.Duration) {
+// SourceSetter is the Assembler to tag the connections created by the packets assembled next with their capture source.
+type SourceSetter interface {
+	SetSource(source string)
+}
+
+// LoopPackets assembles the packets until the channel is closed or the ctx is done,
+// tagging the connections with the capture sources of the packets by source if not nil.
+func LoopPackets(ctx context.Context, packets chan gopacket.Packet, assembler Assembler, idle time.Duration,
+	source func(gopacket.Packet) string,
+) {
+	setter, _ := assembler.(SourceSetter)
+	if setter == nil {
+		source = nil
+	}
+
 	ticker := time.NewTicker(time.Second * 10)
 	defer ticker.Stop()
 	defer assembler.FinishAll()
@@ -39,6 +57,9 @@ func LoopPackets(ctx context.Context, packets chan gopacket.Packet, assembler As
 				continue
 			}
 
+			if source != nil {
+				setter.SetSource(source(p))
+			}
 			assembler.Assemble(n.NetworkFlow(), t.(*layers.TCP), p.Metadata().Timestamp)
 		case <-ticker.C:
 			// flush connections that haven't been activity in the idle time
@@ -50,7 +71,23 @@ func LoopPackets(ctx context.Context, packets chan gopacket.Packet, assembler As
 }
 
 func CreatePacketsChan(input, bpf, host, ips, ports string) (isPcapFil bool, pc chan gopacket.Packet, err error) {
+	if IsTap(input) {
+		packets, err := openTap(strings.TrimPrefix(input, TapScheme), bpfExpr(bpf, ips, ports))
+		if err != nil {
+			return false, nil, fmt.Errorf("open tap %v error: %w", input, err)
+		}
+		return false, packets, nil
+	}
+
 	if v, err := os.Stat(input); err == nil && !v.IsDir() {
+		if isPcapng(input) {
+			packets, err := openPcapng(input, bpfExpr(bpf, ips, ports))
+			if err != nil {
+				return false, nil, fmt.Errorf("open file %v error: %w", input, err)
+			}
+			return true, packets, nil
+		}
+
 		handle, err := pcap.OpenOffline(input) // read from pcap file
 		if err != nil {
 			return false, nil, fmt.Errorf("open file %v error: %w", input, err)
@@ -70,7 +107,8 @@ func CreatePacketsChan(input, bpf, host, ips, ports string) (isPcapFil bool, pc
 			return false, nil, fmt.Errorf("find device error: %w", err)
 		}
 
-		packetsSlice := make([]chan gopacket.Packet, len(interfaces))
+		packetsSlice := make([]chan gopacket.Packet, 0, len(interfaces))
+		indexes := make([]int, 0, len(interfaces))
 		for _, itf := range interfaces {
 			localPackets, err := OpenSingleDevice(itf.Name, bpf, ips, ports)
 			if err != nil {
@@ -79,12 +117,13 @@ func CreatePacketsChan(input, bpf, host, ips, ports string) (isPcapFil bool, pc
 			}
 			log.Printf("Open deive %s", itf.Name)
 			packetsSlice = append(packetsSlice, localPackets)
+			indexes = append(indexes, itf.Index)
 		}
 		if len(packetsSlice) == 0 {
 			return false, nil, fmt.Errorf("no device available")
 		}
 
-		return false, mergeChannel(packetsSlice), nil
+		return false, mergeChannel(packetsSlice, indexes), nil
 	}
 
 	// capture one device
@@ -92,6 +131,59 @@ func CreatePacketsChan(input, bpf, host, ips, ports string) (isPcapFil bool, pc
 	return true, packets, err
 }
 
+// pcapngMagic is the block type of the section header block starting a pcapng file.
+var pcapngMagic = []byte{0x0A, 0x0D, 0x0D, 0x0A}
+
+// isPcapng tells if the file is in pcapng format by its magic.
+func isPcapng(file string) bool {
+	f, err := os.Open(file)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	magic := make([]byte, len(pcapngMagic))
+	if _, err := io.ReadFull(f, magic); err != nil {
+		return false
+	}
+	return bytes.Equal(magic, pcapngMagic)
+}
+
+// openPcapng reads the packets from the pcapng file, filtered by the bpf expression.
+func openPcapng(file, bpf string) (chan gopacket.Packet, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := pcapgo.NewNgReader(f, pcapgo.DefaultNgReaderOptions)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	filter, err := pcap.NewBPF(r.LinkType(), 65536, bpf)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	log.Printf("BPF: %s", bpf)
+
+	packets := make(chan gopacket.Packet, 1000)
+	go func() {
+		defer f.Close()
+		defer close(packets)
+
+		for p := range gopacket.NewPacketSource(r, r.LinkType()).Packets() {
+			if filter.Matches(p.Metadata().CaptureInfo, p.Data()) {
+				packets <- p
+			}
+		}
+	}()
+
+	return packets, nil
+}
+
 func OpenSingleDevice(device, bpf, filterIps, filterPorts string) (localPackets chan gopacket.Packet, err error) {
 	defer func() {
 		if msg := recover(); msg != nil {
@@ -114,10 +206,58 @@ func OpenSingleDevice(device, bpf, filterIps, filterPorts string) (localPackets
 	if err = setDeviceFilter(handle, bpf, filterIps, filterPorts); err != nil {
 		return
 	}
-	localPackets = listenOneSource(handle)
+	localPackets = listenLive(handle, device, bpf, filterIps, filterPorts)
 	return
 }
 
+// listenLive reads the packets of the live capture on the device, the handle is reopened with backoff
+// when reading fails, like the interface going down by the VPN toggling or sleep, to keep the capture alive.
+func listenLive(handle *pcap.Handle, device, bpf, filterIps, filterPorts string) chan gopacket.Packet {
+	packets := make(chan gopacket.Packet, 1000)
+	go func() {
+		for {
+			err := readLive(handle, packets)
+			handle.Close()
+			log.Printf("W! capture on %s failed: %v, reconnecting", device, err)
+			handle = reopenLive(device, bpf, filterIps, filterPorts)
+		}
+	}()
+
+	return packets
+}
+
+// readLive reads the packets of the handle until it fails.
+func readLive(handle *pcap.Handle, packets chan gopacket.Packet) error {
+	ps := gopacket.NewPacketSource(handle, handle.LinkType())
+	for {
+		p, err := ps.NextPacket()
+		if errors.Is(err, pcap.NextErrorTimeoutExpired) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		packets <- p
+	}
+}
+
+// reopenLive reopens the live capture on the device with backoff, until it succeeds.
+func reopenLive(device, bpf, filterIps, filterPorts string) *pcap.Handle {
+	for backoff := NextBackoff(0); ; backoff = NextBackoff(backoff) {
+		time.Sleep(backoff)
+
+		handle, err := pcap.OpenLive(device, 65536, false, pcap.BlockForever)
+		if err == nil {
+			if err = setDeviceFilter(handle, bpf, filterIps, filterPorts); err == nil {
+				log.Printf("I! capture on %s reconnected", device)
+				return handle
+			}
+			handle.Close()
+		}
+		log.Printf("W! reopen capture on %s failed: %v, retry in %s", device, err, NextBackoff(backoff))
+	}
+}
+
 func listenOneSource(handle *pcap.Handle) chan gopacket.Packet {
 	ps := gopacket.NewPacketSource(handle, handle.LinkType())
 	return ps.Packets()
@@ -125,12 +265,15 @@ func listenOneSource(handle *pcap.Handle) chan gopacket.Packet {
 
 // set packet capture filter, by ip and port
 func setDeviceFilter(handle *pcap.Handle, bpf, filterIps, filterPorts string) error {
-	setter := func(expr string) (err error) {
-		log.Printf("BPF: %s", expr)
-		return handle.SetBPFFilter(expr)
-	}
+	expr := bpfExpr(bpf, filterIps, filterPorts)
+	log.Printf("BPF: %s", expr)
+	return handle.SetBPFFilter(expr)
+}
+
+// bpfExpr returns the customized bpf, or the bpf expression filtering by ip and port.
+func bpfExpr(bpf, filterIps, filterPorts string) string {
 	if bpf != "" {
-		return setter(bpf)
+		return bpf
 	}
 
 	bpf = "tcp"
@@ -205,11 +348,7 @@ func setDeviceFilter(handle *pcap.Handle, bpf, filterIps, filterPorts string) er
 		bpf += " and (" + portr + ")"
 	}
 
-	if bpf != "" {
-		return setter(bpf)
-	}
-
-	return nil
+	return bpf
 }
 
 func ListInterfaces(host string) (ifacesHasAddr []net.Interface, err error) {
@@ -245,6 +384,20 @@ func ListInterfaces(host string) (ifacesHasAddr []net.Interface, err error) {
 	return
 }
 
+// LocalIPs returns the ips of the local interfaces.
+func LocalIPs() (map[string]bool, error) {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, err
+	}
+
+	ips := make(map[string]bool, len(addrs))
+	for _, addr := range addrs {
+		ips[cutMask(addr)] = true
+	}
+	return ips, nil
+}
+
 func cutMask(addr net.Addr) string {
 	mask := addr.String()
 	for i, v := range mask {
@@ -255,15 +408,41 @@ func cutMask(addr net.Addr) string {
 	return mask
 }
 
-// adapter multi channels to one channel. used to aggregate multi devices data
-func mergeChannel(channels []chan gopacket.Packet) chan gopacket.Packet {
+// adapter multi channels to one channel. used to aggregate multi devices data,
+// the packets are stamped with the indexes of their interfaces for PacketSource.
+func mergeChannel(channels []chan gopacket.Packet, indexes []int) chan gopacket.Packet {
 	channel := make(chan gopacket.Packet)
-	for _, ch := range channels {
-		go func(c chan gopacket.Packet) {
+	for i, ch := range channels {
+		go func(c chan gopacket.Packet, index int) {
 			for packet := range c {
+				packet.Metadata().InterfaceIndex = index
 				channel <- packet
 			}
-		}(ch)
+		}(ch, indexes[i])
 	}
 	return channel
 }
+
+// PacketSource returns the func naming the capture source of the packets created by CreatePacketsChan,
+// the interface name of the packets merged from the interfaces of the host for the input any,
+// or else the input itself, like the pcap file or the device name.
+// The func is not safe for concurrent use.
+func PacketSource(input, host string) func(gopacket.Packet) string {
+	if _, err := os.Stat(input); err == nil || input != "any" || host == "" {
+		return func(gopacket.Packet) string { return input }
+	}
+
+	names := map[int]string{}
+	return func(p gopacket.Packet) string {
+		index := p.Metadata().InterfaceIndex
+		name, ok := names[index]
+		if !ok {
+			name = input
+			if itf, err := net.InterfaceByIndex(index); err == nil {
+				name = itf.Name
+			}
+			names[index] = name
+		}
+		return name
+	}
+}
@@ -0,0 +1,72 @@
+package util
+
+import (
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreatePacketsChanPcapng(t *testing.T) {
+	assert.True(t, isPcapng("../testdata/http-basic-auth.pcapng"))
+	assert.False(t, isPcapng("../testdata/http-basic-auth.pcap"))
+
+	isFile, packets, err := CreatePacketsChan("../testdata/http-basic-auth.pcapng", "", "", "", "")
+	require.Nil(t, err)
+	assert.True(t, isFile)
+
+	n := 0
+	for range packets {
+		n++
+	}
+	assert.Equal(t, 10, n)
+
+	_, packets, err = CreatePacketsChan("../testdata/http-basic-auth.pcapng", "", "", "", "8080")
+	require.Nil(t, err)
+	for range packets {
+		t.Fatal("no packets on port 8080 expected")
+	}
+}
+
+func TestPacketSource(t *testing.T) {
+	file := "../testdata/http-basic-auth.pcapng"
+	assert.Equal(t, file, PacketSource(file, "")(nil))
+	assert.Equal(t, "eth0", PacketSource("eth0", "")(nil))
+}
+
+func TestCreatePacketsChanTap(t *testing.T) {
+	data, err := os.ReadFile("../testdata/http-basic-auth.pcapng")
+	assert.Nil(t, err)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		_, _ = conn.Write(data)
+		conn.Close()
+	}()
+
+	input := TapScheme + l.Addr().String()
+	assert.True(t, IsTap(input))
+	isFile, packets, err := CreatePacketsChan(input, "", "", "", "")
+	assert.Nil(t, err)
+	assert.False(t, isFile)
+	for i := 0; i < 10; i++ {
+		select {
+		case <-packets:
+		case <-time.After(3 * time.Second):
+			t.Fatalf("only %d packets read from the tap", i)
+		}
+	}
+	assert.Equal(t, input, PacketSource(input, "")(nil))
+
+	// the invalid BPF fails before dialing, not redialed forever
+	_, _, err = CreatePacketsChan(input, "tcp port nope", "", "", "")
+	assert.NotNil(t, err)
+}
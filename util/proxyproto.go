@@ -0,0 +1,72 @@
+package util
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"strconv"
+	"strings"
+)
+
+var (
+	// proxyV1Prefix is the prefix of the PROXY protocol v1 header, like PROXY TCP4 1.2.3.4 5.6.7.8 5678 80\r\n
+	proxyV1Prefix = []byte("PROXY ")
+	// proxyV2Signature is the signature of the PROXY protocol v2 binary header.
+	proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+const (
+	// ProxyV1MaxLen is the max length of the PROXY protocol v1 header.
+	ProxyV1MaxLen = 107
+	// ProxyV2HeaderLen is the length of the fixed part of the PROXY protocol v2 header.
+	ProxyV2HeaderLen = 16
+)
+
+// HasProxyProtocol reports whether the payload starts like a PROXY protocol v1/v2 header.
+func HasProxyProtocol(payload []byte) bool {
+	return bytes.HasPrefix(payload, proxyV1Prefix) || bytes.HasPrefix(payload, proxyV2Signature)
+}
+
+// ProxyV2Len returns the length of the PROXY protocol v2 header declared in its fixed part, the TLVs included,
+// or 0 if the payload does not start with the fixed part of a v2 header.
+func ProxyV2Len(payload []byte) int {
+	if len(payload) < ProxyV2HeaderLen || !bytes.HasPrefix(payload, proxyV2Signature) {
+		return 0
+	}
+	return ProxyV2HeaderLen + int(binary.BigEndian.Uint16(payload[14:16]))
+}
+
+// ParseProxyProtocol parses the PROXY protocol v1/v2 header at the start of the payload,
+// returns the length of the header and the original client address in it, or 0 if not found or incomplete.
+func ParseProxyProtocol(payload []byte) (n int, client string) {
+	switch {
+	case bytes.HasPrefix(payload, proxyV1Prefix):
+		end := bytes.Index(payload, CRLF)
+		if end < 0 || end+2 > ProxyV1MaxLen {
+			return 0, ""
+		}
+		// PROXY TCP4 src dst sport dport, or PROXY UNKNOWN
+		if fields := strings.Fields(string(payload[:end])); len(fields) == 6 {
+			client = net.JoinHostPort(fields[2], fields[4])
+		}
+		return end + 2, client
+	case bytes.HasPrefix(payload, proxyV2Signature):
+		if n = ProxyV2Len(payload); n == 0 || len(payload) < n {
+			return 0, ""
+		}
+		addr := payload[ProxyV2HeaderLen:n]
+		switch payload[13] >> 4 { // address family
+		case 1: // AF_INET
+			if len(addr) >= 12 {
+				client = net.JoinHostPort(net.IP(addr[:4]).String(), strconv.Itoa(int(binary.BigEndian.Uint16(addr[8:10]))))
+			}
+		case 2: // AF_INET6
+			if len(addr) >= 36 {
+				client = net.JoinHostPort(net.IP(addr[:16]).String(), strconv.Itoa(int(binary.BigEndian.Uint16(addr[32:34]))))
+			}
+		}
+		return n, client
+	default:
+		return 0, ""
+	}
+}
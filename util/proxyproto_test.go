@@ -0,0 +1,32 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseProxyProtocol(t *testing.T) {
+	payload := []byte("PROXY TCP4 192.168.0.1 10.0.0.1 56324 80\r\nGET / HTTP/1.1\r\n\r\n")
+	assert.True(t, HasProxyProtocol(payload))
+	n, client := ParseProxyProtocol(payload)
+	assert.Equal(t, "GET / HTTP/1.1\r\n\r\n", string(payload[n:]))
+	assert.Equal(t, "192.168.0.1:56324", client)
+
+	n, client = ParseProxyProtocol([]byte("PROXY UNKNOWN\r\nGET / HTTP/1.1\r\n\r\n"))
+	assert.Equal(t, 15, n)
+	assert.Equal(t, "", client)
+
+	n, _ = ParseProxyProtocol([]byte("PROXY TCP4 192.168.0.1"))
+	assert.Equal(t, 0, n)
+
+	v2 := append([]byte("\r\n\r\n\x00\r\nQUIT\n"), 0x21, 0x11, 0, 12, 192, 168, 0, 1, 10, 0, 0, 1, 0xdc, 0x04, 0, 80)
+	n, client = ParseProxyProtocol(append(v2, "GET / HTTP/1.1\r\n\r\n"...))
+	assert.Equal(t, 28, n)
+	assert.Equal(t, "192.168.0.1:56324", client)
+	assert.Equal(t, 28, ProxyV2Len(v2[:ProxyV2HeaderLen]))
+	assert.Equal(t, 0, ProxyV2Len(v2[:ProxyV2HeaderLen-1]))
+
+	n, _ = ParseProxyProtocol([]byte("GET / HTTP/1.1\r\n\r\n"))
+	assert.Equal(t, 0, n)
+}
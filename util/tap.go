@@ -0,0 +1,130 @@
+package util
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+	"github.com/google/gopacket/pcapgo"
+)
+
+// TapScheme prefixes the input of the pcap stream from a network tap or mirror over a socket, like tcp://10.0.0.1:9000
+// for the remote tcpdump -w - | nc -lk 9000.
+const TapScheme = "tcp://"
+
+// IsTap tells if the input is a pcap stream over a socket.
+func IsTap(input string) bool {
+	return strings.HasPrefix(input, TapScheme)
+}
+
+// tapReader reads the packets of the pcap or pcapng stream.
+type tapReader interface {
+	gopacket.PacketDataSource
+	LinkType() layers.LinkType
+}
+
+// openTap reads the pcap or pcapng stream of the socket at the addr, filtered by the bpf expression,
+// the connection is redialed with backoff when it fails or ends, like the live capture.
+func openTap(addr, bpf string) (chan gopacket.Packet, error) {
+	filter, err := newTapFilter(bpf)
+	if err != nil {
+		return nil, fmt.Errorf("compile BPF %q: %w", bpf, err)
+	}
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("BPF: %s", bpf)
+
+	packets := make(chan gopacket.Packet, 1000)
+	go func() {
+		for {
+			err := readTap(conn, filter, packets)
+			conn.Close()
+			log.Printf("W! tap %s failed: %v, reconnecting", addr, err)
+			conn = redialTap(addr)
+		}
+	}()
+
+	return packets, nil
+}
+
+// readTap reads the packets of the stream on the conn until it fails or ends.
+func readTap(conn net.Conn, filter *tapFilter, packets chan gopacket.Packet) error {
+	buf := bufio.NewReaderSize(conn, 65536)
+	var r tapReader
+	var err error
+	if magic, _ := buf.Peek(len(pcapngMagic)); bytes.Equal(magic, pcapngMagic) {
+		r, err = pcapgo.NewNgReader(buf, pcapgo.DefaultNgReaderOptions)
+	} else {
+		r, err = pcapgo.NewReader(buf)
+	}
+	if err != nil {
+		return fmt.Errorf("read pcap header: %w", err)
+	}
+
+	bpf, err := filter.compile(r.LinkType())
+	if err != nil {
+		return err
+	}
+
+	ps := gopacket.NewPacketSource(r, r.LinkType())
+	for {
+		p, err := ps.NextPacket()
+		if err != nil {
+			return err
+		}
+		if bpf.Matches(p.Metadata().CaptureInfo, p.Data()) {
+			packets <- p
+		}
+	}
+}
+
+// tapFilter is the BPF expression compiled by the link types of the streams, the stream redialed may differ.
+type tapFilter struct {
+	expr     string
+	compiled map[layers.LinkType]*pcap.BPF
+}
+
+// newTapFilter compiles the BPF expression for Ethernet, the usual link type, to check it before dialing.
+func newTapFilter(expr string) (*tapFilter, error) {
+	f := &tapFilter{expr: expr, compiled: map[layers.LinkType]*pcap.BPF{}}
+	if _, err := f.compile(layers.LinkTypeEthernet); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// compile returns the BPF compiled for the link type, compiled once each.
+func (f *tapFilter) compile(linkType layers.LinkType) (*pcap.BPF, error) {
+	if bpf, ok := f.compiled[linkType]; ok {
+		return bpf, nil
+	}
+	bpf, err := pcap.NewBPF(linkType, 65536, f.expr)
+	if err != nil {
+		return nil, err
+	}
+	f.compiled[linkType] = bpf
+	return bpf, nil
+}
+
+// redialTap redials the socket at the addr with backoff, until it succeeds.
+func redialTap(addr string) net.Conn {
+	for backoff := NextBackoff(0); ; backoff = NextBackoff(backoff) {
+		time.Sleep(backoff)
+
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			log.Printf("I! tap %s reconnected", addr)
+			return conn
+		}
+		log.Printf("W! redial tap %s failed: %v, retry in %s", addr, err, NextBackoff(backoff))
+	}
+}
@@ -0,0 +1,138 @@
+package util
+
+import "encoding/binary"
+
+const (
+	// TLSRecordHeaderLen is the length of the TLS record header, type(1) version(2) length(2).
+	TLSRecordHeaderLen = 5
+	// TLSMaxRecordLen is the max length of a TLS record, with the header.
+	TLSMaxRecordLen = TLSRecordHeaderLen + 1<<14
+
+	tlsRecordHandshake   = 0x16
+	tlsClientHello       = 0x01
+	tlsServerHello       = 0x02
+	tlsExtensionSNI      = 0x0000
+	tlsExtensionVersions = 0x002b
+	tlsSNIHostNameType   = 0x00
+	tlsClientHelloMinLen = TLSRecordHeaderLen + 4 + 2 + 32 // handshake header, version and random
+)
+
+// IsTLSClientHello reports whether the payload starts like a TLS handshake record carrying a ClientHello.
+func IsTLSClientHello(payload []byte) bool {
+	return len(payload) >= TLSRecordHeaderLen+1 &&
+		payload[0] == tlsRecordHandshake && payload[1] == 0x03 && payload[5] == tlsClientHello
+}
+
+// IsTLSServerHello reports whether the payload starts like a TLS handshake record carrying a ServerHello.
+func IsTLSServerHello(payload []byte) bool {
+	return len(payload) >= TLSRecordHeaderLen+1 &&
+		payload[0] == tlsRecordHandshake && payload[1] == 0x03 && payload[5] == tlsServerHello
+}
+
+// TLSRecordLen returns the length of the TLS record, with the header, at the start of the payload.
+func TLSRecordLen(payload []byte) int {
+	if len(payload) < TLSRecordHeaderLen {
+		return 0
+	}
+	return TLSRecordHeaderLen + int(binary.BigEndian.Uint16(payload[3:5]))
+}
+
+// ParseSNI parses the server name indication in the TLS ClientHello at the start of the payload.
+// The ClientHello may be truncated, like the first packet of a large one, the extensions
+// are walked as far as the payload goes. Empty is returned if not found.
+func ParseSNI(payload []byte) string {
+	if !IsTLSClientHello(payload) || len(payload) < tlsClientHelloMinLen {
+		return ""
+	}
+	if n := TLSRecordLen(payload); n < len(payload) {
+		payload = payload[:n]
+	}
+
+	p := payload[tlsClientHelloMinLen:]
+	// skip session id(1-byte length), cipher suites(2-byte length), compression methods(1-byte length)
+	for _, lenBytes := range []int{1, 2, 1} {
+		if p = skipVector(p, lenBytes); p == nil {
+			return ""
+		}
+	}
+	if len(p) < 2 {
+		return ""
+	}
+
+	// extensions: type(2) length(2) data
+	for p = p[2:]; len(p) >= 4; {
+		typ, size := binary.BigEndian.Uint16(p), int(binary.BigEndian.Uint16(p[2:]))
+		p = p[4:]
+		if typ != tlsExtensionSNI {
+			if size > len(p) {
+				return ""
+			}
+			p = p[size:]
+			continue
+		}
+
+		// server name list(2-byte length): name type(1) host name(2-byte length)
+		if len(p) < 5 || p[2] != tlsSNIHostNameType {
+			return ""
+		}
+		if n := int(binary.BigEndian.Uint16(p[3:])); len(p) >= 5+n {
+			return string(p[5 : 5+n])
+		}
+		return ""
+	}
+
+	return ""
+}
+
+// ParseServerHello parses the negotiated version and cipher suite in the TLS ServerHello
+// at the start of the payload, which are sent in clear even in TLS 1.3.
+// The version is the one selected by the supported_versions extension if present.
+func ParseServerHello(payload []byte) (version, cipher uint16, ok bool) {
+	if !IsTLSServerHello(payload) || len(payload) < tlsClientHelloMinLen {
+		return 0, 0, false
+	}
+	if n := TLSRecordLen(payload); n < len(payload) {
+		payload = payload[:n]
+	}
+
+	version = binary.BigEndian.Uint16(payload[TLSRecordHeaderLen+4:])
+	// skip session id(1-byte length), then cipher suite(2) and compression method(1)
+	p := skipVector(payload[tlsClientHelloMinLen:], 1)
+	if len(p) < 3 {
+		return 0, 0, false
+	}
+	cipher = binary.BigEndian.Uint16(p)
+	if p = p[3:]; len(p) < 2 {
+		return version, cipher, true // no extensions
+	}
+
+	// extensions: type(2) length(2) data
+	for p = p[2:]; len(p) >= 4; {
+		typ, size := binary.BigEndian.Uint16(p), int(binary.BigEndian.Uint16(p[2:]))
+		if p = p[4:]; size > len(p) {
+			break
+		}
+		if typ == tlsExtensionVersions && size == 2 {
+			version = binary.BigEndian.Uint16(p)
+			break
+		}
+		p = p[size:]
+	}
+
+	return version, cipher, true
+}
+
+// skipVector skips the vector prefixed by its length in lenBytes bytes, returns nil if truncated.
+func skipVector(p []byte, lenBytes int) []byte {
+	if len(p) < lenBytes {
+		return nil
+	}
+	n := 0
+	for _, b := range p[:lenBytes] {
+		n = n<<8 | int(b)
+	}
+	if len(p) < lenBytes+n {
+		return nil
+	}
+	return p[lenBytes+n:]
+}
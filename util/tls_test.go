@@ -0,0 +1,73 @@
+package util
+
+import (
+	"crypto/tls"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func clientHello(t *testing.T, serverName string) []byte {
+	c, s := net.Pipe()
+	defer s.Close()
+
+	go func() {
+		defer c.Close()
+		_ = tls.Client(c, &tls.Config{ServerName: serverName, InsecureSkipVerify: true}).Handshake()
+	}()
+
+	buf := make([]byte, TLSMaxRecordLen)
+	n := 0
+	for n < TLSRecordHeaderLen || n < TLSRecordLen(buf[:n]) {
+		m, err := s.Read(buf[n:])
+		assert.Nil(t, err)
+		n += m
+	}
+	return buf[:n]
+}
+
+func TestParseSNI(t *testing.T) {
+	hello := clientHello(t, "www.example.com")
+	assert.True(t, IsTLSClientHello(hello))
+	assert.Equal(t, len(hello), TLSRecordLen(hello))
+	assert.Equal(t, "www.example.com", ParseSNI(hello))
+
+	// no SNI for the ip address
+	hello = clientHello(t, "127.0.0.1")
+	assert.True(t, IsTLSClientHello(hello))
+	assert.Equal(t, "", ParseSNI(hello))
+
+	assert.False(t, IsTLSClientHello([]byte("GET / HTTP/1.1\r\n\r\n")))
+	assert.Equal(t, "", ParseSNI([]byte{0x16, 0x03, 0x01, 0x00, 0x10, 0x01}))
+}
+
+func TestParseServerHello(t *testing.T) {
+	body := []byte{0x03, 0x03}                        // legacy version TLS 1.2
+	body = append(body, make([]byte, 32)...)          // random
+	body = append(body, 0x00)                         // session id
+	body = append(body, 0x13, 0x01, 0x00)             // cipher suite TLS_AES_128_GCM_SHA256, compression method
+	ext := []byte{0x00, 0x2b, 0x00, 0x02, 0x03, 0x04} // supported_versions TLS 1.3
+	body = append(append(body, 0x00, byte(len(ext))), ext...)
+
+	hello := append([]byte{0x02, 0x00, 0x00, byte(len(body))}, body...)
+	record := append([]byte{0x16, 0x03, 0x03, 0x00, byte(len(hello))}, hello...)
+
+	assert.True(t, IsTLSServerHello(record))
+	assert.False(t, IsTLSClientHello(record))
+	version, cipher, ok := ParseServerHello(record)
+	assert.True(t, ok)
+	assert.Equal(t, uint16(tls.VersionTLS13), version)
+	assert.Equal(t, tls.TLS_AES_128_GCM_SHA256, cipher)
+
+	// TLS 1.2 without extensions
+	record = record[:len(record)-len(ext)-2]
+	record[4] -= byte(len(ext) + 2)
+	record[8] -= byte(len(ext) + 2)
+	version, _, ok = ParseServerHello(record)
+	assert.True(t, ok)
+	assert.Equal(t, uint16(tls.VersionTLS12), version)
+
+	_, _, ok = ParseServerHello(clientHello(t, "www.example.com"))
+	assert.False(t, ok)
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/bingoohuang/gg/pkg/ss"
+	"github.com/bingoohuang/gg/pkg/v"
+	"github.com/google/gopacket/pcap"
+)
+
+// VersionInfo prints version information, with the versions of gopacket and libpcap.
+// The version, build time and git commit are set by -ldflags in Makefile, like
+// -X github.com/bingoohuang/gg/pkg/v.GitCommit=master-ffd23d3, or fall back to dev.
+func (App) VersionInfo() string {
+	revision, gopacket := "dev", "dev"
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range info.Settings {
+			if s.Key == "vcs.revision" {
+				revision = s.Value
+			}
+		}
+		for _, dep := range info.Deps {
+			if dep.Path == "github.com/google/gopacket" {
+				gopacket = dep.Version
+			}
+		}
+	}
+
+	return fmt.Sprintf("version : %s\n", ss.Or(v.AppVersion, "dev")) +
+		fmt.Sprintf("build   : %s\n", ss.Or(v.BuildTime, "dev")) +
+		fmt.Sprintf("git     : %s\n", ss.Or(v.GitCommit, revision)) +
+		fmt.Sprintf("go      : %s\n", ss.Or(v.GoVersion, runtime.Version())) +
+		fmt.Sprintf("gopacket: %s\n", gopacket) +
+		fmt.Sprintf("libpcap : %s", pcap.Version())
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/bingoohuang/gg/pkg/v"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVersionInfo(t *testing.T) {
+	defer func(version, commit string) { v.AppVersion, v.GitCommit = version, commit }(v.AppVersion, v.GitCommit)
+
+	// the defaults without -ldflags
+	v.AppVersion, v.GitCommit = "", ""
+	lines := strings.Split(App{}.VersionInfo(), "\n")
+	if assert.Len(t, lines, 6) {
+		assert.Equal(t, "version : dev", lines[0])
+		assert.True(t, strings.HasPrefix(lines[2], "git     : "), lines[2])
+		assert.True(t, strings.HasPrefix(lines[4], "gopacket: "), lines[4])
+		assert.True(t, strings.HasPrefix(lines[5], "libpcap : "), lines[5])
+	}
+
+	// embedded by -ldflags
+	v.AppVersion, v.GitCommit = "1.2.3", "master-ffd23d3"
+	info := App{}.VersionInfo()
+	assert.Contains(t, info, "version : 1.2.3\n")
+	assert.Contains(t, info, "git     : master-ffd23d3\n")
+}